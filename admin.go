@@ -0,0 +1,170 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/jhurwich/trendy/stock"
+)
+
+// body for creating a key, Expires and the limits are optional
+type createKeyRequest struct {
+	Name    string
+	Scopes  []string
+	Expires *time.Time
+	keyLimits
+}
+
+// a key's limits, zero uses the server's default
+type keyLimits struct {
+	RateLimit    float64
+	Burst        int
+	DailyQuota   int
+	MonthlyQuota int
+}
+
+func (l keyLimits) validate() error {
+	if l.RateLimit < 0 || l.Burst < 0 || l.DailyQuota < 0 || l.MonthlyQuota < 0 {
+		return fmt.Errorf("Limits can't be negative [%+v]", l)
+	}
+	return nil
+}
+
+func (l keyLimits) apply(apiKey *stock.APIKey) {
+	apiKey.RateLimit, apiKey.Burst, apiKey.DailyQuota, apiKey.MonthlyQuota = l.RateLimit, l.Burst, l.DailyQuota, l.MonthlyQuota
+}
+
+// a key along with its secret, only ever returned when the secret is generated
+type keyWithSecret struct {
+	*stock.APIKey
+	Secret string
+}
+
+var validScopes = map[string]bool{stock.ScopeRead: true, stock.ScopeAdmin: true, stock.ScopeMetrics: true}
+
+// body is a JSON createKeyRequest, responds with the new key and its secret
+func CreateKey(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var req createKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Could not parse key request as JSON [%v]", err), http.StatusBadRequest)
+		return
+	}
+	if req.Name == "" || len(req.Scopes) == 0 {
+		http.Error(w, "Key request must include a Name and Scopes", http.StatusBadRequest)
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !validScopes[scope] {
+			http.Error(w, fmt.Sprintf("Unknown scope [%s]", scope), http.StatusBadRequest)
+			return
+		}
+	}
+	if err := req.keyLimits.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	apiKey, secret, err := stock.NewAPIKey(req.Name, req.Scopes, req.Expires)
+	if err == nil {
+		req.keyLimits.apply(apiKey)
+		err = stock.DB.InsertAPIKey(apiKey)
+	}
+	if err != nil {
+		http.Error(w, "Could not create key", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusCreated, keyWithSecret{apiKey, secret})
+}
+
+// responds with every key, secrets are never included
+func ListKeys(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	keys, err := stock.DB.GetAPIKeys()
+	if err != nil {
+		http.Error(w, "Could not list keys", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, keys)
+}
+
+// ps includes "key" param, responds with the key and its new secret. The old
+// secret stops working immediately.
+func RotateKey(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	apiKey, err := stock.DB.GetAPIKey(ps.ByName("key"))
+	if err == stock.ErrKeyNotFound {
+		http.Error(w, fmt.Sprintf("No such key [%s]", ps.ByName("key")), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Could not look up key", http.StatusInternalServerError)
+		return
+	}
+
+	secret, err := apiKey.Rotate()
+	if err == nil {
+		err = stock.DB.UpdateAPIKeySecret(apiKey)
+	}
+	if err != nil {
+		http.Error(w, "Could not rotate key", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, keyWithSecret{apiKey, secret})
+}
+
+// ps includes "key" param, body is the JSON keyLimits to replace the key's
+// limits with. Responds with the key, limits take effect on its next request.
+func UpdateKeyLimits(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var limits keyLimits
+	if err := json.NewDecoder(r.Body).Decode(&limits); err != nil {
+		http.Error(w, fmt.Sprintf("Could not parse limits as JSON [%v]", err), http.StatusBadRequest)
+		return
+	}
+	if err := limits.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	apiKey, err := stock.DB.GetAPIKey(ps.ByName("key"))
+	if err == stock.ErrKeyNotFound {
+		http.Error(w, fmt.Sprintf("No such key [%s]", ps.ByName("key")), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Could not look up key", http.StatusInternalServerError)
+		return
+	}
+
+	limits.apply(apiKey)
+	if err := stock.DB.UpdateAPIKeyLimits(apiKey); err != nil {
+		http.Error(w, "Could not update key", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, apiKey)
+}
+
+// ps includes "key" param, the key is rejected from now on
+func RevokeKey(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	err := stock.DB.RevokeAPIKey(ps.ByName("key"), time.Now().UTC())
+	if err == stock.ErrKeyNotFound {
+		http.Error(w, fmt.Sprintf("No such key [%s]", ps.ByName("key")), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Could not revoke key", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, "Error generating JSON response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
@@ -0,0 +1,10 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+// Package api holds the OpenAPI document describing the server's routes. The
+// client package is generated from it and the server serves it as is.
+package api
+
+import _ "embed"
+
+//go:embed openapi.yaml
+var Spec []byte
@@ -0,0 +1,122 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/jhurwich/trendy/stock"
+)
+
+// headers that carry credentials, unchanged from when static restgate keys were used
+const (
+	authKeyHeader    = "X-Auth-Key"
+	authSecretHeader = "X-Auth-Secret"
+)
+
+type contextKey int
+
+const (
+	apiKeyContextKey contextKey = iota
+	rateLimiterContextKey
+)
+
+// paths that orchestrators probe without credentials, and the api's description
+var publicPaths = map[string]bool{"/healthz": true, "/readyz": true, "/openapi.yaml": true}
+
+// Authenticate is negroni middleware that rejects requests without a valid,
+// unexpired and unrevoked api key. The key is stored in the request context for
+// handlers, see RequestAPIKey. Public paths pass through without a key.
+func Authenticate(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	if publicPaths[r.URL.Path] {
+		next(w, r)
+		return
+	}
+
+	apiKey, failure := checkCredentials(r.Header.Get(authKeyHeader), r.Header.Get(authSecretHeader))
+	if failure != nil {
+		authError(w, failure.status, failure.message)
+		return
+	}
+
+	next(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey, apiKey)))
+}
+
+// why credentials were refused, status is the http status to respond with
+type authFailure struct {
+	status  int
+	message string
+}
+
+// checkCredentials returns the api key for key and secret if it's valid,
+// unexpired and unrevoked. It's shared by the REST and gRPC apis.
+func checkCredentials(key string, secret string) (*stock.APIKey, *authFailure) {
+	if key == "" || secret == "" {
+		return nil, &authFailure{http.StatusUnauthorized, "No Key Or Secret"}
+	}
+
+	apiKey, err := stock.DB.GetAPIKey(key)
+	if err == stock.ErrKeyNotFound {
+		return nil, &authFailure{http.StatusUnauthorized, "Unauthorized Access"}
+	} else if err != nil {
+		return nil, &authFailure{http.StatusInternalServerError, "Could not look up key"}
+	}
+
+	switch apiKey.Check(secret, time.Now()) {
+	case nil:
+		return apiKey, nil
+	case stock.ErrKeyExpired:
+		return nil, &authFailure{http.StatusUnauthorized, "Key Expired"}
+	case stock.ErrKeyRevoked:
+		return nil, &authFailure{http.StatusUnauthorized, "Key Revoked"}
+	default:
+		return nil, &authFailure{http.StatusUnauthorized, "Unauthorized Access"}
+	}
+}
+
+// RequestAPIKey returns the key a request was authenticated with, nil if none
+func RequestAPIKey(r *http.Request) *stock.APIKey {
+	apiKey, _ := r.Context().Value(apiKeyContextKey).(*stock.APIKey)
+	return apiKey
+}
+
+// RequireScope wraps a route so that it's forbidden to keys without scope
+func RequireScope(scope string, handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		apiKey := RequestAPIKey(r)
+		if apiKey == nil || !apiKey.HasScope(scope) {
+			authError(w, http.StatusForbidden, "Key Lacks Scope: "+scope)
+			return
+		}
+		handle(w, r, ps)
+	}
+}
+
+func authError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+// the credentials used for local development, created if missing
+const devKey, devSecret = "key", "secret"
+
+// EnsureDevKey stores the local development key with every scope if it doesn't exist
+func EnsureDevKey() error {
+	_, err := stock.DB.GetAPIKey(devKey)
+	if err != stock.ErrKeyNotFound {
+		return err
+	}
+	return stock.DB.InsertAPIKey(&stock.APIKey{
+		Key:        devKey,
+		SecretHash: stock.HashSecret(devSecret),
+		Name:       "local development",
+		Scopes:     []string{stock.ScopeRead, stock.ScopeAdmin, stock.ScopeMetrics},
+		Created:    time.Now().UTC(),
+	})
+}
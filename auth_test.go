@@ -0,0 +1,215 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/jhurwich/trendy/config"
+	"github.com/jhurwich/trendy/stock"
+	"github.com/jhurwich/trendy/testhelpers"
+)
+
+func TestRequireScope(t *testing.T) {
+	t.Parallel()
+	handle := RequireScope(stock.ScopeAdmin, func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	var tests = []struct {
+		apiKey   *stock.APIKey
+		expected int
+	}{
+		{nil, http.StatusForbidden},
+		{&stock.APIKey{Scopes: []string{stock.ScopeRead}}, http.StatusForbidden},
+		{&stock.APIKey{Scopes: []string{stock.ScopeRead, stock.ScopeAdmin}}, http.StatusTeapot},
+	}
+	for _, test := range tests {
+		r, _ := http.NewRequest("GET", "/admin/keys", nil)
+		if test.apiKey != nil {
+			r = r.WithContext(context.WithValue(r.Context(), apiKeyContextKey, test.apiKey))
+		}
+		w := httptest.NewRecorder()
+		handle(w, r, nil)
+		if w.Code != test.expected {
+			t.Errorf("Expected %d for key %+v, got %d", test.expected, test.apiKey, w.Code)
+		}
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	_, discard := testhelpers.UseStore(t)
+	defer discard()
+
+	// a valid key, one that has expired and one that has been revoked, all with the same secret
+	past := time.Now().UTC().Add(-time.Hour)
+	for _, apiKey := range []*stock.APIKey{
+		{Key: "valid", Scopes: []string{stock.ScopeRead}},
+		{Key: "expired", Scopes: []string{stock.ScopeRead}, Expires: &past},
+		{Key: "revoked", Scopes: []string{stock.ScopeRead}, Revoked: &past},
+	} {
+		apiKey.SecretHash, apiKey.Created = stock.HashSecret("secret"), past
+		if err := stock.DB.InsertAPIKey(apiKey); err != nil {
+			t.Fatalf("Could not store key: %v", err)
+		}
+	}
+
+	var tests = []struct {
+		path     string
+		key      string
+		secret   string
+		expected int
+		message  string
+	}{
+		{"/stock/GOOG", "", "", http.StatusUnauthorized, "No Key Or Secret"},
+		{"/stock/GOOG", "valid", "", http.StatusUnauthorized, "No Key Or Secret"},
+		{"/stock/GOOG", "", "secret", http.StatusUnauthorized, "No Key Or Secret"},
+		{"/stock/GOOG", "missing", "secret", http.StatusUnauthorized, "Unauthorized Access"},
+		{"/stock/GOOG", "valid", "wrong", http.StatusUnauthorized, "Unauthorized Access"},
+		{"/stock/GOOG", "expired", "secret", http.StatusUnauthorized, "Key Expired"},
+		{"/stock/GOOG", "revoked", "secret", http.StatusUnauthorized, "Key Revoked"},
+		{"/stock/GOOG", "valid", "secret", http.StatusTeapot, ""},
+		{"/healthz", "", "", http.StatusTeapot, ""}, // public paths need no key
+	}
+	for _, test := range tests {
+		r, _ := http.NewRequest("GET", test.path, nil)
+		if test.key != "" {
+			r.Header.Set(authKeyHeader, test.key)
+		}
+		if test.secret != "" {
+			r.Header.Set(authSecretHeader, test.secret)
+		}
+		w := httptest.NewRecorder()
+		var authenticated *stock.APIKey
+		Authenticate(w, r, func(w http.ResponseWriter, r *http.Request) {
+			authenticated = RequestAPIKey(r)
+			w.WriteHeader(http.StatusTeapot)
+		})
+
+		if w.Code != test.expected || !strings.Contains(w.Body.String(), test.message) {
+			t.Errorf("Expected %d %q for %s with key %q and secret %q, got %d %s", test.expected, test.message, test.path, test.key, test.secret, w.Code, w.Body.String())
+		}
+		if w.Code == http.StatusTeapot && test.key != "" && (authenticated == nil || authenticated.Key != test.key) {
+			t.Errorf("Expected key %q in the request context, got %+v", test.key, authenticated)
+		}
+	}
+}
+
+func TestKeyHandlers(t *testing.T) {
+	_, discard := testhelpers.UseStore(t)
+	defer discard()
+	ts := NewTrendyServer(&config.Config{Local: true})
+
+	request := func(method string, path string, body string, key string, secret string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set(authKeyHeader, key)
+		r.Header.Set(authSecretHeader, secret)
+		w := httptest.NewRecorder()
+		ts.ServeHTTP(w, r)
+		return w
+	}
+	decode := func(w *httptest.ResponseRecorder) (created struct {
+		Key    string
+		Secret string
+		Name   string
+		Scopes []string
+	}) {
+		if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+			t.Fatalf("Could not parse key response %s: %v", w.Body.String(), err)
+		}
+		return created
+	}
+
+	// invalid requests are refused
+	for _, body := range []string{`{"Name": "reader"`, `{"Name": "reader"}`, `{"Scopes": ["read"]}`, `{"Name": "reader", "Scopes": ["write"]}`} {
+		if w := request("POST", "/admin/keys", body, devKey, devSecret); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 creating a key with %s, got %d", body, w.Code)
+		}
+	}
+
+	// a created key authenticates with its secret, which is only returned once
+	w := request("POST", "/admin/keys", `{"Name": "reader", "Scopes": ["read"]}`, devKey, devSecret)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201 creating a key, got %d %s", w.Code, w.Body.String())
+	}
+	created := decode(w)
+	if created.Key == "" || created.Secret == "" || created.Name != "reader" || len(created.Scopes) != 1 || created.Scopes[0] != stock.ScopeRead {
+		t.Errorf("Unexpected created key %+v", created)
+	}
+	if _, failure := checkCredentials(created.Key, created.Secret); failure != nil {
+		t.Errorf("Expected the created key to authenticate, got %+v", failure)
+	}
+	if w := request("GET", "/admin/keys", "", devKey, devSecret); w.Code != http.StatusOK || strings.Contains(w.Body.String(), created.Secret) {
+		t.Errorf("Expected keys to be listed without secrets, got %d %s", w.Code, w.Body.String())
+	}
+
+	// limits can be set when a key is created and replaced later, never negative
+	w = request("POST", "/admin/keys", `{"Name": "limited", "Scopes": ["read"], "RateLimit": 2.5, "Burst": 5, "DailyQuota": 100, "MonthlyQuota": 1000}`, devKey, devSecret)
+	limited, _ := stock.DB.GetAPIKey(decode(w).Key)
+	if w.Code != http.StatusCreated || limited == nil || limited.RateLimit != 2.5 || limited.Burst != 5 || limited.DailyQuota != 100 || limited.MonthlyQuota != 1000 {
+		t.Errorf("Expected a key created with its limits, got %d %+v", w.Code, limited)
+	}
+	if w := request("POST", "/admin/keys", `{"Name": "limited", "Scopes": ["read"], "DailyQuota": -1}`, devKey, devSecret); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 creating a key with a negative quota, got %d", w.Code)
+	}
+	if w := request("PUT", "/admin/keys/"+limited.Key+"/limits", `{"Burst": 10}`, devKey, devSecret); w.Code != http.StatusOK {
+		t.Errorf("Expected 200 updating limits, got %d %s", w.Code, w.Body.String())
+	}
+	if updated, _ := stock.DB.GetAPIKey(limited.Key); updated.Burst != 10 || updated.RateLimit != 0 || updated.DailyQuota != 0 {
+		t.Errorf("Expected the limits replaced, got %+v", updated)
+	}
+	if w := request("PUT", "/admin/keys/"+limited.Key+"/limits", `{"RateLimit": -0.5}`, devKey, devSecret); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 updating a key with a negative rate, got %d", w.Code)
+	}
+	if w := request("PUT", "/admin/keys/missing/limits", `{"Burst": 10}`, devKey, devSecret); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 updating a missing key's limits, got %d", w.Code)
+	}
+
+	// a key without the admin scope can't manage keys
+	if w := request("POST", "/admin/keys", `{"Name": "other", "Scopes": ["admin"]}`, created.Key, created.Secret); w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 creating a key with a read key, got %d", w.Code)
+	}
+	if w := request("DELETE", "/admin/keys/"+devKey, "", created.Key, created.Secret); w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 revoking a key with a read key, got %d", w.Code)
+	}
+
+	// rotating replaces the secret, the old one stops working
+	w = request("POST", "/admin/keys/"+created.Key+"/rotate", "", devKey, devSecret)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 rotating a key, got %d %s", w.Code, w.Body.String())
+	}
+	rotated := decode(w)
+	if rotated.Key != created.Key || rotated.Secret == "" || rotated.Secret == created.Secret {
+		t.Errorf("Expected a new secret for the same key, got %+v", rotated)
+	}
+	if _, failure := checkCredentials(created.Key, created.Secret); failure == nil {
+		t.Errorf("Expected the old secret to be refused after rotating")
+	}
+	if _, failure := checkCredentials(created.Key, rotated.Secret); failure != nil {
+		t.Errorf("Expected the new secret to authenticate, got %+v", failure)
+	}
+
+	// revoking refuses the key from then on
+	if w := request("DELETE", "/admin/keys/"+created.Key, "", devKey, devSecret); w.Code != http.StatusNoContent {
+		t.Errorf("Expected 204 revoking a key, got %d", w.Code)
+	}
+	if _, failure := checkCredentials(created.Key, rotated.Secret); failure == nil || failure.message != "Key Revoked" {
+		t.Errorf("Expected the revoked key to be refused, got %+v", failure)
+	}
+
+	// keys that don't exist can't be rotated or revoked
+	if w := request("POST", "/admin/keys/missing/rotate", "", devKey, devSecret); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 rotating a missing key, got %d", w.Code)
+	}
+	if w := request("DELETE", "/admin/keys/missing", "", devKey, devSecret); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 revoking a missing key, got %d", w.Code)
+	}
+}
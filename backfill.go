@@ -0,0 +1,86 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/jhurwich/trendy/stock"
+)
+
+type BackfillFlags struct {
+	Symbols *string
+	Start   *string
+	End     *string
+	Name    *string
+}
+
+// days per provider request and requests at once during a backfill, Markit
+// throttles clients that send many requests a second
+const (
+	backfillChunkDays = 365
+	backfillWorkers   = 2
+)
+
+// RunBackfill loads history for the symbols in opts, writing progress to out.
+// An error is returned if the options are invalid, any chunk failed or ctx
+// was done before every chunk was fetched.
+func RunBackfill(ctx context.Context, opts BackfillFlags, out io.Writer) error {
+	symbols := parseSymbols(*opts.Symbols)
+	if len(symbols) == 0 {
+		return errors.New("Must provide symbols to backfill")
+	}
+
+	start, err := time.Parse("2006-01-02", *opts.Start)
+	if err != nil {
+		return fmt.Errorf("Could not parse backfill-start as time. must be YYYY-MM-DD [%s]", *opts.Start)
+	}
+	end := time.Now().UTC()
+	if *opts.End != "" {
+		end, err = time.Parse("2006-01-02", *opts.End)
+		if err != nil {
+			return fmt.Errorf("Could not parse backfill-end as time. must be YYYY-MM-DD [%s]", *opts.End)
+		}
+	}
+
+	// without a name the checkpoints are keyed by the request itself
+	name := *opts.Name
+	if name == "" {
+		name = fmt.Sprintf("%s:%s:%s", strings.Join(symbols, ","), stock.TimeForSQL(start), stock.TimeForSQL(end))
+	}
+
+	backfill := stock.Backfill{
+		Name:      name,
+		Symbols:   symbols,
+		Start:     start,
+		End:       end,
+		ChunkDays: backfillChunkDays,
+		Workers:   backfillWorkers,
+		Progress: func(p stock.Progress) {
+			status := "ok"
+			if p.Err != nil {
+				status = p.Err.Error()
+			}
+			fmt.Fprintf(out, "%s %d/%d %s to %s: %s\n", p.Symbol, p.Done, p.Total, stock.TimeForSQL(p.Chunk.Start), stock.TimeForSQL(p.Chunk.End), status)
+		},
+	}
+	results, err := backfill.Run(ctx)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, result := range results {
+		fmt.Fprintf(out, "%s: %d of %d chunks complete, %d failed\n", result.Symbol, result.Completed, result.Total, len(result.Failed))
+		failed += len(result.Failed)
+	}
+	if failed > 0 {
+		return fmt.Errorf("Backfill %s had %d failed chunks, rerun to retry them", name, failed)
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/jhurwich/trendy/chart"
+	"github.com/jhurwich/trendy/stock"
+)
+
+const (
+	defaultChartWidth  = 800
+	defaultChartHeight = 400
+	maxChartDays       = 3660 // about ten years, so a chart is never of all history
+	maxChartIndicators = 5
+)
+
+// GetChartSVG renders the stock's span as an SVG chart
+func GetChartSVG(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	serveChart(w, r, ps, "image/svg+xml", (*chart.Chart).WriteSVG)
+}
+
+// GetChartPNG renders the stock's span as a PNG chart
+func GetChartPNG(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	serveChart(w, r, ps, "image/png", (*chart.Chart).WritePNG)
+}
+
+// a chart request's url parameters
+type chartRequest struct {
+	start      time.Time
+	end        time.Time
+	indicators []chartIndicator
+	trend      bool
+	chart      chart.Chart
+}
+
+type chartIndicator struct {
+	kind   string
+	window int
+}
+
+func serveChart(w http.ResponseWriter, r *http.Request, ps httprouter.Params, contentType string, write func(*chart.Chart, io.Writer) error) {
+	s := stock.NewStock(ps.ByName("symbol"))
+	req, err := parseChartRequest(r.URL.Query(), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c := req.chart
+	c.Title = s.Symbol
+
+	if err := req.fill(r.Context(), s, &c); err != nil && r.Context().Err() != nil {
+		// the client went away or the write timeout passed, this is only for the logs
+		http.Error(w, fmt.Sprintf("Request abandoned: %v", r.Context().Err()), http.StatusServiceUnavailable)
+		return
+	} else if errors.Is(err, stock.ErrSymbolNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if errors.Is(err, stock.ErrDelisted) {
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	} else if err != nil {
+		errStr := fmt.Sprintf("Could not get range for provided stock over start to end [%s:%s-%s]", s.Symbol, stock.TimeForSQL(req.start), stock.TimeForSQL(req.end))
+		http.Error(w, errStr, http.StatusInternalServerError)
+		return
+	}
+
+	// render before writing anything so that errors can still be reported
+	var body bytes.Buffer
+	if err := write(&c, &body); err != nil {
+		http.Error(w, fmt.Sprintf("Error rendering chart for stock [%s]", s.Symbol), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write(body.Bytes())
+}
+
+// fill c with the span, overlays and trend the request asked for. A symbol
+// without data is charted as such rather than failing.
+func (req *chartRequest) fill(ctx context.Context, s *stock.Stock, c *chart.Chart) error {
+	span, err := s.RangeContext(ctx, req.start, req.end)
+	if errors.Is(err, stock.ErrNoData) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	c.Span = span
+
+	for _, indicator := range req.indicators {
+		points, err := s.IndicatorContext(ctx, indicator.kind, indicator.window, req.start, req.end)
+		if err != nil {
+			return err
+		}
+		c.Overlays = append(c.Overlays, chart.Overlay{Name: fmt.Sprintf("%s %d", indicator.kind, indicator.window), Span: points})
+	}
+
+	if req.trend {
+		trend, err := s.AnalyzeContext(ctx, req.start, req.end)
+		if err == nil {
+			c.Trend = &trend
+		} else if err != stock.ErrTooFewMeasures {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseChartRequest reads start and end as YYYY-MM-DD, end defaulting to today
+// and start to six months before it, style, width, height, theme,
+// indicators as kind:window pairs like sma:50,ema:20, and trend and volume
+func parseChartRequest(query url.Values, now time.Time) (*chartRequest, error) {
+	req := &chartRequest{chart: chart.Chart{Style: chart.Line, Width: defaultChartWidth, Height: defaultChartHeight, Theme: chart.Themes["light"]}}
+	var err error
+
+	end := query.Get("end")
+	if end == "" {
+		end = now.In(newYork).Format("2006-01-02")
+	}
+	if req.end, err = parseDay("end", end); err != nil {
+		return nil, err
+	}
+	if start := query.Get("start"); start != "" {
+		if req.start, err = parseDay("start", start); err != nil {
+			return nil, err
+		}
+	} else {
+		req.start = req.end.AddDate(0, -6, 0)
+	}
+	if !req.start.Before(req.end) {
+		return nil, fmt.Errorf("Start must be before end [%s-%s]", stock.TimeForSQL(req.start), stock.TimeForSQL(req.end))
+	}
+	if req.end.Sub(req.start) > maxChartDays*24*time.Hour {
+		return nil, fmt.Errorf("Charts can cover at most %d days [%s-%s]", maxChartDays, stock.TimeForSQL(req.start), stock.TimeForSQL(req.end))
+	}
+
+	if style := query.Get("style"); style != "" {
+		req.chart.Style = chart.Style(style)
+		if req.chart.Style != chart.Line && req.chart.Style != chart.Candlestick {
+			return nil, fmt.Errorf("%v [%s]", chart.ErrStyle, style)
+		}
+	}
+	for _, size := range []struct {
+		name  string
+		value *int
+	}{{"width", &req.chart.Width}, {"height", &req.chart.Height}} {
+		value := query.Get(size.name)
+		if value == "" {
+			continue
+		}
+		if *size.value, err = strconv.Atoi(value); err != nil || *size.value < chart.MinSize || *size.value > chart.MaxSize {
+			return nil, fmt.Errorf("%s must be between %d and %d [%s]", size.name, chart.MinSize, chart.MaxSize, value)
+		}
+	}
+	if theme := query.Get("theme"); theme != "" {
+		var ok bool
+		if req.chart.Theme, ok = chart.Themes[theme]; !ok {
+			return nil, fmt.Errorf("Unknown theme, must be light or dark [%s]", theme)
+		}
+	}
+
+	if indicators := query.Get("indicators"); indicators != "" {
+		for _, indicator := range strings.Split(indicators, ",") {
+			kind, window, _ := strings.Cut(strings.TrimSpace(indicator), ":")
+			kind = strings.ToUpper(kind)
+			if kind != stock.IndicatorSMA && kind != stock.IndicatorEMA {
+				return nil, fmt.Errorf("Unknown indicator, must be sma or ema [%s]", indicator)
+			}
+			n, err := strconv.Atoi(window)
+			if err != nil || n <= 0 || n > maxIndicatorWindow {
+				return nil, fmt.Errorf("Indicator window must be between 1 and %d [%s]", maxIndicatorWindow, indicator)
+			}
+			req.indicators = append(req.indicators, chartIndicator{kind: kind, window: n})
+		}
+		if len(req.indicators) > maxChartIndicators {
+			return nil, fmt.Errorf("At most %d indicators can be charted, got %d", maxChartIndicators, len(req.indicators))
+		}
+	}
+
+	for _, flag := range []struct {
+		name  string
+		value *bool
+	}{{"trend", &req.trend}, {"volume", &req.chart.Volume}} {
+		value := query.Get(flag.name)
+		if value == "" {
+			continue
+		}
+		if *flag.value, err = strconv.ParseBool(value); err != nil {
+			return nil, fmt.Errorf("%s must be true or false [%s]", flag.name, value)
+		}
+	}
+	return req, nil
+}
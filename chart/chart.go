@@ -0,0 +1,361 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+// Package chart renders spans as SVG or PNG images, for places like email and
+// chat where charts can't be drawn with JavaScript
+package chart
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/jhurwich/trendy/stock"
+)
+
+// Style is how the span's measures are drawn
+type Style string
+
+const (
+	Line        Style = "line"        // the closes joined up
+	Candlestick Style = "candlestick" // each day's open, high, low and close
+)
+
+// Theme is the colors a chart is drawn in. Overlays are colored in turn.
+type Theme struct {
+	Background color.RGBA
+	Text       color.RGBA
+	Grid       color.RGBA
+	Line       color.RGBA
+	Up         color.RGBA
+	Down       color.RGBA
+	Trend      color.RGBA
+	Overlays   []color.RGBA
+}
+
+// Themes by name, a chart with no Theme is drawn in "light"
+var Themes = map[string]Theme{
+	"light": {
+		Background: color.RGBA{0xff, 0xff, 0xff, 0xff},
+		Text:       color.RGBA{0x33, 0x33, 0x33, 0xff},
+		Grid:       color.RGBA{0xe5, 0xe5, 0xe5, 0xff},
+		Line:       color.RGBA{0x1f, 0x77, 0xb4, 0xff},
+		Up:         color.RGBA{0x2c, 0xa0, 0x2c, 0xff},
+		Down:       color.RGBA{0xd6, 0x27, 0x28, 0xff},
+		Trend:      color.RGBA{0x7f, 0x7f, 0x7f, 0xff},
+		Overlays:   []color.RGBA{{0xff, 0x7f, 0x0e, 0xff}, {0x94, 0x67, 0xbd, 0xff}, {0x17, 0xbe, 0xcf, 0xff}},
+	},
+	"dark": {
+		Background: color.RGBA{0x1e, 0x1e, 0x1e, 0xff},
+		Text:       color.RGBA{0xdd, 0xdd, 0xdd, 0xff},
+		Grid:       color.RGBA{0x3a, 0x3a, 0x3a, 0xff},
+		Line:       color.RGBA{0x4e, 0xa8, 0xde, 0xff},
+		Up:         color.RGBA{0x4c, 0xc3, 0x5a, 0xff},
+		Down:       color.RGBA{0xf0, 0x5a, 0x5a, 0xff},
+		Trend:      color.RGBA{0xb0, 0xb0, 0xb0, 0xff},
+		Overlays:   []color.RGBA{{0xff, 0xa6, 0x4d, 0xff}, {0xc3, 0x9b, 0xe8, 0xff}, {0x4d, 0xd8, 0xe6, 0xff}},
+	},
+}
+
+// the bounds on a chart's Width and Height in pixels
+const (
+	MinSize = 100
+	MaxSize = 2000
+)
+
+// ErrSize is returned for a chart too small to lay out or too large to render
+var ErrSize = fmt.Errorf("Chart width and height must be between %d and %d", MinSize, MaxSize)
+
+// ErrStyle is returned for a Style that isn't Line or Candlestick
+var ErrStyle = errors.New("Chart style must be line or candlestick")
+
+// Overlay is a series drawn over the span, like a moving average. Its
+// measures are matched to the span's by day.
+type Overlay struct {
+	Name string
+	Span stock.Span
+}
+
+// Chart of a span, with optional overlays, trend line and volume pane
+type Chart struct {
+	Title    string
+	Span     stock.Span
+	Style    Style // Line if empty
+	Overlays []Overlay
+	Trend    *stock.Trend // drawn from its Start to End if set
+	Volume   bool         // add a pane of daily volume below the prices
+	Width    int
+	Height   int
+	Theme    Theme
+}
+
+// the room around the plot for the title, price labels and date labels
+const (
+	marginTop    = 24
+	marginRight  = 64
+	marginBottom = 20
+	marginLeft   = 8
+	paneGap      = 8
+	charWidth    = 7 // the width of a character in basicfont's 7x13, SVG text is sized to match
+)
+
+type anchor int
+
+const (
+	anchorStart anchor = iota
+	anchorMiddle
+	anchorEnd
+)
+
+// canvas is what a chart is drawn on, the origin is the top left and y is the
+// text's baseline
+type canvas interface {
+	rect(x, y, w, h float64, fill color.RGBA)
+	polyline(xs, ys []float64, stroke color.RGBA, width float64)
+	text(x, y float64, s string, fill color.RGBA, a anchor)
+}
+
+func (c *Chart) validate() error {
+	if c.Width < MinSize || c.Width > MaxSize || c.Height < MinSize || c.Height > MaxSize {
+		return ErrSize
+	}
+	if c.Style != "" && c.Style != Line && c.Style != Candlestick {
+		return ErrStyle
+	}
+	return nil
+}
+
+// draw the chart on cv
+func (c *Chart) draw(cv canvas) {
+	theme := c.Theme
+	if theme.Background.A == 0 {
+		theme = Themes["light"]
+	}
+	width, height := float64(c.Width), float64(c.Height)
+	cv.rect(0, 0, width, height, theme.Background)
+
+	// title then a legend of everything drawn over the span
+	x := float64(marginLeft)
+	if c.Title != "" {
+		cv.text(x, 16, c.Title, theme.Text, anchorStart)
+		x += float64(len(c.Title)*charWidth + 16)
+	}
+	for i, overlay := range c.Overlays {
+		cv.text(x, 16, overlay.Name, overlayColor(theme, i), anchorStart)
+		x += float64(len(overlay.Name)*charWidth + 12)
+	}
+	if c.Trend != nil {
+		cv.text(x, 16, "Trend", theme.Trend, anchorStart)
+	}
+
+	left, right := float64(marginLeft), width-marginRight
+	top, bottom := float64(marginTop), height-marginBottom
+	priceBottom := bottom
+	if c.Volume {
+		priceBottom = bottom - (bottom-top)/4 - paneGap
+	}
+
+	span := append(stock.Span{}, c.Span...)
+	sort.Sort(span)
+	if len(span) == 0 {
+		cv.text((left+right)/2, (top+bottom)/2, "No data", theme.Text, anchorMiddle)
+		return
+	}
+
+	// x by index so weekends and holidays don't leave gaps
+	slot := (right - left) / float64(len(span))
+	xAt := func(i int) float64 { return left + (float64(i)+0.5)*slot }
+	index := map[string]int{}
+	for i, measure := range span {
+		index[stock.TimeForSQL(measure.Time)] = i
+	}
+
+	low, high := c.priceRange(span, index)
+	ticks, decimals := niceTicks(low, high, int((priceBottom-top)/40)+1)
+	low, high = math.Min(low, ticks[0]), math.Max(high, ticks[len(ticks)-1])
+	yAt := func(v float64) float64 { return priceBottom - (v-low)/(high-low)*(priceBottom-top) }
+	for _, tick := range ticks {
+		y := yAt(tick)
+		cv.rect(left, y, right-left, 1, theme.Grid)
+		cv.text(right+6, y+4, strconv.FormatFloat(tick, 'f', decimals, 64), theme.Text, anchorStart)
+	}
+
+	// dates under the plot, as many as fit
+	label := "2006-01-02"
+	labelWidth := float64(len(label) * charWidth)
+	every := int(math.Ceil((labelWidth + 16) / slot))
+	for i := 0; i < len(span); i += every {
+		x := math.Min(math.Max(xAt(i), labelWidth/2), width-labelWidth/2) // kept inside the image
+		cv.text(x, height-6, span[i].Time.Format(label), theme.Text, anchorMiddle)
+	}
+
+	if c.Style == Candlestick {
+		body := math.Max(1, slot*0.6)
+		for i, measure := range span {
+			if !measure.HasBar() {
+				// no open, high or low to draw, mark the close
+				cv.rect(xAt(i)-body/2, yAt(float64(measure.Value)), body, 1, theme.Line)
+				continue
+			}
+			fill := theme.Up
+			if measure.Value < measure.Open {
+				fill = theme.Down
+			}
+			cv.polyline([]float64{xAt(i), xAt(i)}, []float64{yAt(float64(measure.High)), yAt(float64(measure.Low))}, fill, 1)
+			o, v := float64(measure.Open), float64(measure.Value)
+			bodyTop, bodyBottom := yAt(math.Max(o, v)), yAt(math.Min(o, v))
+			cv.rect(xAt(i)-body/2, bodyTop, body, math.Max(1, bodyBottom-bodyTop), fill)
+		}
+	} else {
+		xs, ys := make([]float64, len(span)), make([]float64, len(span))
+		for i, measure := range span {
+			xs[i], ys[i] = xAt(i), yAt(float64(measure.Value))
+		}
+		cv.polyline(xs, ys, theme.Line, 1.5)
+	}
+
+	for i, overlay := range c.Overlays {
+		xs, ys := []float64{}, []float64{}
+		for _, measure := range overlay.Span {
+			if j, ok := index[stock.TimeForSQL(measure.Time)]; ok {
+				xs, ys = append(xs, xAt(j)), append(ys, yAt(float64(measure.Value)))
+			}
+		}
+		cv.polyline(xs, ys, overlayColor(theme, i), 1.5)
+	}
+
+	if c.Trend != nil {
+		xs, ys := []float64{}, []float64{}
+		for i, measure := range span {
+			if measure.Time.Before(c.Trend.Start) || measure.Time.After(c.Trend.End) {
+				continue
+			}
+			days := measure.Time.Sub(c.Trend.Start).Hours() / 24
+			xs, ys = append(xs, xAt(i)), append(ys, yAt(c.Trend.Intercept+c.Trend.SlopePerDay*days))
+		}
+		if len(xs) > 1 {
+			cv.polyline([]float64{xs[0], xs[len(xs)-1]}, []float64{ys[0], ys[len(ys)-1]}, theme.Trend, 1.5)
+		}
+	}
+
+	if c.Volume {
+		c.drawVolume(cv, theme, span, xAt, slot, left, right, priceBottom+paneGap, bottom)
+	}
+}
+
+// the volume pane between top and bottom, up days colored by the close against the previous close
+func (c *Chart) drawVolume(cv canvas, theme Theme, span stock.Span, xAt func(int) float64, slot float64, left, right, top, bottom float64) {
+	cv.rect(left, top, right-left, 1, theme.Grid)
+	var most int64
+	for _, measure := range span {
+		if measure.Volume > most {
+			most = measure.Volume
+		}
+	}
+	if most == 0 {
+		cv.text((left+right)/2, (top+bottom)/2+4, "No volume", theme.Text, anchorMiddle)
+		return
+	}
+	cv.text(right+6, top+12, formatVolume(most), theme.Text, anchorStart)
+	bar := math.Max(1, slot*0.6)
+	for i, measure := range span {
+		fill := theme.Up
+		if i > 0 && measure.Value < span[i-1].Value {
+			fill = theme.Down
+		}
+		h := float64(measure.Volume) / float64(most) * (bottom - top - 2)
+		cv.rect(xAt(i)-bar/2, bottom-h, bar, h, fill)
+	}
+}
+
+// the lowest and highest prices drawn, padded so lines don't touch the edges
+func (c *Chart) priceRange(span stock.Span, index map[string]int) (float64, float64) {
+	low, high := math.Inf(1), math.Inf(-1)
+	add := func(v float64) {
+		low, high = math.Min(low, v), math.Max(high, v)
+	}
+	for _, measure := range span {
+		add(float64(measure.Value))
+		if c.Style == Candlestick && measure.HasBar() {
+			add(float64(measure.Low))
+			add(float64(measure.High))
+		}
+	}
+	for _, overlay := range c.Overlays {
+		for _, measure := range overlay.Span {
+			if _, ok := index[stock.TimeForSQL(measure.Time)]; ok {
+				add(float64(measure.Value))
+			}
+		}
+	}
+	if c.Trend != nil {
+		add(c.Trend.Intercept)
+		add(c.Trend.Intercept + c.Trend.SlopePerDay*c.Trend.End.Sub(c.Trend.Start).Hours()/24)
+	}
+	if low == high {
+		return low - 1, high + 1
+	}
+	pad := (high - low) * 0.05
+	return low - pad, high + pad
+}
+
+// at most n round values covering low to high, with the decimals to print them with
+func niceTicks(low float64, high float64, n int) ([]float64, int) {
+	if n < 2 {
+		n = 2
+	}
+	raw := (high - low) / float64(n-1)
+	magnitude := math.Pow(10, math.Floor(math.Log10(raw)))
+	step := magnitude * 10
+	for _, m := range []float64{1, 2, 2.5, 5} {
+		if raw <= m*magnitude {
+			step = m * magnitude
+			break
+		}
+	}
+	decimals := 0
+	if step < 1 {
+		decimals = int(math.Ceil(-math.Log10(step)))
+		if step*math.Pow(10, float64(decimals)) != math.Trunc(step*math.Pow(10, float64(decimals))) {
+			decimals++ // 2.5s need one more
+		}
+	}
+	ticks := []float64{}
+	for v := math.Floor(low/step) * step; v <= high+step/2; v += step {
+		if v >= low-step/2 {
+			ticks = append(ticks, v)
+		}
+	}
+	if len(ticks) < 2 {
+		ticks = []float64{low, high}
+	}
+	return ticks, decimals
+}
+
+func formatVolume(v int64) string {
+	switch {
+	case v >= 1e9:
+		return strconv.FormatFloat(float64(v)/1e9, 'f', 1, 64) + "B"
+	case v >= 1e6:
+		return strconv.FormatFloat(float64(v)/1e6, 'f', 1, 64) + "M"
+	case v >= 1e3:
+		return strconv.FormatFloat(float64(v)/1e3, 'f', 1, 64) + "K"
+	}
+	return strconv.FormatInt(v, 10)
+}
+
+func overlayColor(theme Theme, i int) color.RGBA {
+	if len(theme.Overlays) == 0 {
+		return theme.Line
+	}
+	return theme.Overlays[i%len(theme.Overlays)]
+}
+
+// write the buffered image to w, so a failed render writes nothing
+func writeAll(w io.Writer, b []byte) error {
+	_, err := w.Write(b)
+	return err
+}
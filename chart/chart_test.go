@@ -0,0 +1,137 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package chart_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"image/png"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jhurwich/trendy/chart"
+	"github.com/jhurwich/trendy/stock"
+)
+
+func testChart() *chart.Chart {
+	g := stock.Generator{Seed: 1, Start: time.Date(2015, time.January, 1, 0, 0, 0, 0, time.UTC), Price: 100,
+		Regimes: []stock.Regime{{Day: 0, Process: stock.GBM{Drift: 0.1, Volatility: 0.2}}}}
+	span, _ := g.Generate(120)
+	sma, _ := span.SMA(20)
+	trend, _ := span.Trend()
+	return &chart.Chart{
+		Title:    "TEST <&>",
+		Span:     span,
+		Style:    chart.Candlestick,
+		Overlays: []chart.Overlay{{Name: "SMA 20", Span: sma}},
+		Trend:    &trend,
+		Volume:   true,
+		Width:    800,
+		Height:   400,
+		Theme:    chart.Themes["dark"],
+	}
+}
+
+func TestWriteSVG(t *testing.T) {
+	t.Parallel()
+	for _, style := range []chart.Style{chart.Line, chart.Candlestick} {
+		c := testChart()
+		c.Style = style
+		var buf bytes.Buffer
+		if err := c.WriteSVG(&buf); err != nil {
+			t.Fatalf("%s: unexpected error %v", style, err)
+		}
+
+		// well formed, with the title escaped
+		d := xml.NewDecoder(bytes.NewReader(buf.Bytes()))
+		for {
+			if _, err := d.Token(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("%s: invalid SVG %v\n%s", style, err, buf.String())
+			}
+		}
+		svg := buf.String()
+		for _, expected := range []string{`width="800" height="400"`, "TEST &lt;&amp;&gt;", "SMA 20", "Trend", `fill="#1e1e1e"`} {
+			if !strings.Contains(svg, expected) {
+				t.Errorf("%s: expected the SVG to contain %s", style, expected)
+			}
+		}
+	}
+
+	// charts without data or volume say so rather than drawing nothing
+	var buf bytes.Buffer
+	c := &chart.Chart{Width: 200, Height: 100, Volume: true}
+	if err := c.WriteSVG(&buf); err != nil || !strings.Contains(buf.String(), "No data") {
+		t.Errorf("Expected an empty chart to say so, got %v\n%s", err, buf.String())
+	}
+	buf.Reset()
+	c.Span = stock.Span{{Time: time.Date(2015, time.June, 1, 0, 0, 0, 0, time.UTC), Value: 1}, {Time: time.Date(2015, time.June, 2, 0, 0, 0, 0, time.UTC), Value: 2}}
+	if err := c.WriteSVG(&buf); err != nil || !strings.Contains(buf.String(), "No volume") {
+		t.Errorf("Expected a chart of closes to say it has no volume, got %v\n%s", err, buf.String())
+	}
+}
+
+func TestWritePNG(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	c := testChart()
+	if err := c.WritePNG(&buf); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatalf("Invalid PNG %v", err)
+	}
+	if bounds := img.Bounds(); bounds.Dx() != 800 || bounds.Dy() != 400 {
+		t.Errorf("Expected 800x400, got %v", bounds)
+	}
+
+	// the background is the theme's and something is drawn over it
+	background := chart.Themes["dark"].Background
+	r, g, b, _ := img.At(0, 0).RGBA()
+	if uint8(r>>8) != background.R || uint8(g>>8) != background.G || uint8(b>>8) != background.B {
+		t.Errorf("Expected the background %v, got %v", background, img.At(0, 0))
+	}
+	drawn := 0
+	for y := 0; y < 400; y++ {
+		for x := 0; x < 800; x++ {
+			if r2, g2, b2, _ := img.At(x, y).RGBA(); r2 != r || g2 != g || b2 != b {
+				drawn++
+			}
+		}
+	}
+	if drawn < 800*400/100 {
+		t.Errorf("Expected the chart to be drawn, only %d pixels differ from the background", drawn)
+	}
+}
+
+func TestChartErrors(t *testing.T) {
+	t.Parallel()
+	var tests = []struct {
+		width, height int
+		style         chart.Style
+		expected      error
+	}{
+		{chart.MinSize - 1, 400, chart.Line, chart.ErrSize},
+		{800, chart.MaxSize + 1, chart.Line, chart.ErrSize},
+		{800, 400, "pie", chart.ErrStyle},
+		{800, 400, "", nil},
+	}
+	for _, test := range tests {
+		c := testChart()
+		c.Width, c.Height, c.Style = test.width, test.height, test.style
+		var buf bytes.Buffer
+		if err := c.WriteSVG(&buf); err != test.expected {
+			t.Errorf("Expected %v for %dx%d %q, got %v", test.expected, test.width, test.height, test.style, err)
+		}
+		if err := c.WritePNG(&buf); err != test.expected {
+			t.Errorf("Expected %v for %dx%d %q PNG, got %v", test.expected, test.width, test.height, test.style, err)
+		}
+		if test.expected != nil && buf.Len() != 0 {
+			t.Errorf("Expected nothing written for %v, got %d bytes", test.expected, buf.Len())
+		}
+	}
+}
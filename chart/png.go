@@ -0,0 +1,96 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package chart
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/image/vector"
+)
+
+// WritePNG writes the chart as a PNG image
+func (c *Chart) WritePNG(w io.Writer) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	cv := &pngCanvas{img: image.NewRGBA(image.Rect(0, 0, c.Width, c.Height))}
+	c.draw(cv)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, cv.img); err != nil {
+		return err
+	}
+	return writeAll(w, buf.Bytes())
+}
+
+type pngCanvas struct {
+	img *image.RGBA
+	z   vector.Rasterizer
+}
+
+func (cv *pngCanvas) rect(x, y, w, h float64, fill color.RGBA) {
+	r := image.Rect(int(math.Round(x)), int(math.Round(y)), int(math.Round(x+w)), int(math.Round(y+h)))
+	if r.Dx() == 0 {
+		r.Max.X++
+	}
+	if r.Dy() == 0 {
+		r.Max.Y++
+	}
+	draw.Draw(cv.img, r, image.NewUniform(fill), image.Point{}, draw.Over)
+}
+
+// each segment is filled as its own quad, overlapping quads in one path could
+// cancel out where they wind in opposite directions
+func (cv *pngCanvas) polyline(xs, ys []float64, stroke color.RGBA, width float64) {
+	src := image.NewUniform(stroke)
+	for i := 1; i < len(xs); i++ {
+		x0, y0, x1, y1 := xs[i-1], ys[i-1], xs[i], ys[i]
+		dx, dy := x1-x0, y1-y0
+		length := math.Hypot(dx, dy)
+		if length == 0 {
+			continue
+		}
+		// half the width across the segment, and a little along it so joins overlap
+		nx, ny := -dy/length*width/2, dx/length*width/2
+		ex, ey := dx/length*width/4, dy/length*width/4
+		x0, y0, x1, y1 = x0-ex, y0-ey, x1+ex, y1+ey
+
+		// rasterize only the segment's bounds, not the whole image
+		bounds := image.Rect(
+			int(math.Floor(math.Min(x0, x1)-math.Abs(nx)))-1, int(math.Floor(math.Min(y0, y1)-math.Abs(ny)))-1,
+			int(math.Ceil(math.Max(x0, x1)+math.Abs(nx)))+1, int(math.Ceil(math.Max(y0, y1)+math.Abs(ny)))+1,
+		).Intersect(cv.img.Bounds())
+		if bounds.Empty() {
+			continue
+		}
+		ox, oy := float64(bounds.Min.X), float64(bounds.Min.Y)
+		cv.z.Reset(bounds.Dx(), bounds.Dy())
+		cv.z.MoveTo(float32(x0+nx-ox), float32(y0+ny-oy))
+		cv.z.LineTo(float32(x1+nx-ox), float32(y1+ny-oy))
+		cv.z.LineTo(float32(x1-nx-ox), float32(y1-ny-oy))
+		cv.z.LineTo(float32(x0-nx-ox), float32(y0-ny-oy))
+		cv.z.ClosePath()
+		cv.z.Draw(cv.img, bounds, src, image.Point{})
+	}
+}
+
+func (cv *pngCanvas) text(x, y float64, s string, fill color.RGBA, a anchor) {
+	d := font.Drawer{Dst: cv.img, Src: image.NewUniform(fill), Face: basicfont.Face7x13}
+	width := d.MeasureString(s).Round()
+	switch a {
+	case anchorMiddle:
+		x -= float64(width) / 2
+	case anchorEnd:
+		x -= float64(width)
+	}
+	d.Dot = fixed.P(int(math.Round(x)), int(math.Round(y)))
+	d.DrawString(s)
+}
@@ -0,0 +1,62 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package chart
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"strconv"
+)
+
+// WriteSVG writes the chart as an SVG document
+func (c *Chart) WriteSVG(w io.Writer) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	cv := &svgCanvas{}
+	fmt.Fprintf(&cv.buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="monospace" font-size="12">`+"\n", c.Width, c.Height, c.Width, c.Height)
+	c.draw(cv)
+	cv.buf.WriteString("</svg>\n")
+	return writeAll(w, cv.buf.Bytes())
+}
+
+type svgCanvas struct {
+	buf bytes.Buffer
+}
+
+func (cv *svgCanvas) rect(x, y, w, h float64, fill color.RGBA) {
+	fmt.Fprintf(&cv.buf, `<rect x="%s" y="%s" width="%s" height="%s" fill="%s"/>`+"\n", num(x), num(y), num(w), num(h), hex(fill))
+}
+
+func (cv *svgCanvas) polyline(xs, ys []float64, stroke color.RGBA, width float64) {
+	if len(xs) < 2 {
+		return
+	}
+	cv.buf.WriteString(`<polyline points="`)
+	for i := range xs {
+		if i > 0 {
+			cv.buf.WriteByte(' ')
+		}
+		cv.buf.WriteString(num(xs[i]) + "," + num(ys[i]))
+	}
+	fmt.Fprintf(&cv.buf, `" fill="none" stroke="%s" stroke-width="%s" stroke-linejoin="round"/>`+"\n", hex(stroke), num(width))
+}
+
+func (cv *svgCanvas) text(x, y float64, s string, fill color.RGBA, a anchor) {
+	fmt.Fprintf(&cv.buf, `<text x="%s" y="%s" fill="%s" text-anchor="%s">`, num(x), num(y), hex(fill), [...]string{"start", "middle", "end"}[a])
+	xml.EscapeText(&cv.buf, []byte(s))
+	cv.buf.WriteString("</text>\n")
+}
+
+// coordinates to a tenth of a pixel
+func num(v float64) string {
+	return strconv.FormatFloat(math.Round(v*10)/10, 'f', -1, 64)
+}
+
+func hex(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
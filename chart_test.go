@@ -0,0 +1,110 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package main
+
+import (
+	"bytes"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jhurwich/trendy/config"
+	"github.com/jhurwich/trendy/stock"
+	"github.com/jhurwich/trendy/testhelpers"
+)
+
+func TestParseChartRequest(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2015, time.July, 1, 16, 0, 0, 0, time.UTC)
+
+	req, err := parseChartRequest(url.Values{}, now)
+	if err != nil {
+		t.Fatalf("Unexpected error for the defaults: %v", err)
+	}
+	if stock.TimeForSQL(req.start) != "2015-01-01" || stock.TimeForSQL(req.end) != "2015-07-01" ||
+		req.chart.Width != defaultChartWidth || req.chart.Height != defaultChartHeight || req.chart.Style != "line" || req.trend || req.chart.Volume {
+		t.Errorf("Unexpected defaults %+v", req)
+	}
+
+	req, err = parseChartRequest(url.Values{"indicators": {"sma:50, EMA:20"}, "trend": {"true"}, "volume": {"1"}, "width": {"1200"}}, now)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(req.indicators) != 2 || req.indicators[0] != (chartIndicator{stock.IndicatorSMA, 50}) || req.indicators[1] != (chartIndicator{stock.IndicatorEMA, 20}) ||
+		!req.trend || !req.chart.Volume || req.chart.Width != 1200 {
+		t.Errorf("Unexpected request %+v", req)
+	}
+
+	var invalid = []url.Values{
+		{"start": {"June"}},
+		{"start": {"2015-07-01"}, "end": {"2015-06-01"}},
+		{"start": {"1990-01-01"}},
+		{"style": {"pie"}},
+		{"width": {"wide"}},
+		{"height": {"5000"}},
+		{"theme": {"solarized"}},
+		{"indicators": {"rsi:14"}},
+		{"indicators": {"sma"}},
+		{"indicators": {"sma:0"}},
+		{"indicators": {"sma:1,sma:2,sma:3,sma:4,sma:5,sma:6"}},
+		{"trend": {"maybe"}},
+	}
+	for _, query := range invalid {
+		if _, err := parseChartRequest(query, now); err == nil {
+			t.Errorf("Expected an error for %v", query)
+		}
+	}
+}
+
+func TestGetChart(t *testing.T) {
+	_, discard := testhelpers.UseStore(t)
+	defer discard()
+	defaultProvider := stock.DefaultProvider
+	stock.DefaultProvider = stock.SyntheticProvider{}
+	defer func() { stock.DefaultProvider = defaultProvider }()
+	server := NewTrendyServer(&config.Config{Local: true})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		r.Header.Set(authKeyHeader, devKey)
+		r.Header.Set(authSecretHeader, devSecret)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get("/stock/GOOG/chart.svg?start=2015-01-01&end=2015-06-30&style=candlestick&indicators=sma:20&trend=true&volume=true")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/svg+xml" {
+		t.Fatalf("Expected an SVG, got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	for _, expected := range []string{"<svg", "GOOG", "SMA 20", "Trend"} {
+		if !strings.Contains(w.Body.String(), expected) {
+			t.Errorf("Expected the chart to contain %s", expected)
+		}
+	}
+	if strings.Contains(w.Body.String(), "No volume") {
+		t.Errorf("Expected the synthetic bars' volume to be charted")
+	}
+
+	w = get("/stock/GOOG/chart.png?start=2015-01-01&end=2015-06-30&width=300&height=200")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("Expected a PNG, got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	if img, err := png.Decode(bytes.NewReader(w.Body.Bytes())); err != nil || img.Bounds().Dx() != 300 || img.Bounds().Dy() != 200 {
+		t.Errorf("Expected a 300x200 PNG, got %v", err)
+	}
+
+	// before the synthetic history begins there's nothing to chart, but still a chart
+	w = get("/stock/GOOG/chart.svg?start=1995-01-01&end=1995-06-30")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "No data") {
+		t.Errorf("Expected a chart of no data, got %d", w.Code)
+	}
+
+	if w = get("/stock/GOOG/chart.png?theme=neon"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected %d for an unknown theme, got %d", http.StatusBadRequest, w.Code)
+	}
+}
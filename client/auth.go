@@ -0,0 +1,20 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package client
+
+import (
+	"context"
+	"net/http"
+)
+
+// WithAPIKey authenticates every request with the api key and its secret, for
+// example
+//
+//	c, err := client.NewClientWithResponses("https://trendy.example.com", client.WithAPIKey(key, secret))
+func WithAPIKey(key string, secret string) ClientOption {
+	return WithRequestEditorFn(func(ctx context.Context, req *http.Request) error {
+		req.Header.Set("X-Auth-Key", key)
+		req.Header.Set("X-Auth-Secret", secret)
+		return nil
+	})
+}
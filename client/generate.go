@@ -0,0 +1,9 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+// Package client is a typed Go client for the server, generated from
+// api/openapi.yaml with oapi-codegen. Regenerate it after changing the spec:
+//
+//	go generate ./client
+package client
+
+//go:generate go run github.com/oapi-codegen/oapi-codegen/v2/cmd/oapi-codegen@v2.5.1 -config oapi-codegen.yaml ../api/openapi.yaml
@@ -0,0 +1,68 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cache lifetimes for stock responses, historic data rarely changes but a range
+// that includes today can pick up new measures at any time
+const (
+	historicMaxAge = 24 * time.Hour
+	currentMaxAge  = time.Minute
+)
+
+// SetCacheHeaders writes ETag, Last-Modified and Cache-Control for a response.
+// lastModified is omitted when zero, includesToday shortens the cache lifetime.
+func SetCacheHeaders(w http.ResponseWriter, etag string, lastModified time.Time, includesToday bool) {
+	w.Header().Set("ETag", etag)
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	maxAge := historicMaxAge
+	if includesToday {
+		maxAge = currentMaxAge
+	}
+	w.Header().Set("Cache-Control", "private, max-age="+strconv.Itoa(int(maxAge.Seconds())))
+}
+
+// NotModified reports whether the request's conditional headers match the
+// current representation, in which case a 304 should be sent instead of a body.
+// If-None-Match takes precedence over If-Modified-Since when both are present.
+func NotModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/") // weak comparison
+			if candidate == "*" || candidate == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !lastModified.IsZero() {
+		since, err := http.ParseTime(ims)
+		if err != nil {
+			return false
+		}
+		// http dates only have second precision
+		return !lastModified.Truncate(time.Second).After(since)
+	}
+	return false
+}
+
+// IncludesToday reports whether a range ending at end may still change, the zero
+// time is treated as an open ended range
+func IncludesToday(end time.Time, now time.Time) bool {
+	if end.IsZero() {
+		return true
+	}
+	y, m, d := now.In(end.Location()).Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, end.Location())
+	return !end.Before(today)
+}
@@ -0,0 +1,76 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNotModified(t *testing.T) {
+	t.Parallel()
+	etag := `"abc123-json"`
+	modified := time.Date(2015, time.June, 1, 12, 30, 15, 500, time.UTC)
+
+	var tests = []struct {
+		ifNoneMatch     string
+		ifModifiedSince string
+		lastModified    time.Time
+		expected        bool
+	}{
+		{"", "", modified, false},
+		{`"abc123-json"`, "", modified, true},
+		{`W/"abc123-json"`, "", modified, true},
+		{`"other", "abc123-json"`, "", modified, true},
+		{`*`, "", modified, true},
+		{`"abc123-csv"`, "", modified, false},
+		{`"abc123-csv"`, modified.Format(http.TimeFormat), modified, false}, // If-None-Match wins
+		{"", modified.Format(http.TimeFormat), modified, true},
+		{"", modified.Add(time.Hour).Format(http.TimeFormat), modified, true},
+		{"", modified.Add(-time.Hour).Format(http.TimeFormat), modified, false},
+		{"", modified.Format(http.TimeFormat), time.Time{}, false},
+		{"", "not a date", modified, false},
+	}
+
+	for _, test := range tests {
+		r, _ := http.NewRequest("GET", "/stock/GOOG", nil)
+		if test.ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", test.ifNoneMatch)
+		}
+		if test.ifModifiedSince != "" {
+			r.Header.Set("If-Modified-Since", test.ifModifiedSince)
+		}
+		if result := NotModified(r, etag, test.lastModified); result != test.expected {
+			t.Errorf("NotModified returned %t, expected %t for %+v", result, test.expected, test)
+		}
+	}
+}
+
+func TestSetCacheHeaders(t *testing.T) {
+	t.Parallel()
+	loc, _ := time.LoadLocation("America/New_York")
+	now := time.Date(2015, time.June, 10, 15, 0, 0, 0, loc)
+
+	var tests = []struct {
+		end          time.Time
+		cacheControl string
+	}{
+		{time.Time{}, "private, max-age=60"},
+		{time.Date(2015, time.June, 10, 0, 0, 0, 0, loc), "private, max-age=60"},
+		{time.Date(2015, time.June, 12, 0, 0, 0, 0, loc), "private, max-age=60"},
+		{time.Date(2015, time.June, 9, 0, 0, 0, 0, loc), "private, max-age=86400"},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		SetCacheHeaders(w, `"etag"`, now, IncludesToday(test.end, now))
+		if cc := w.Header().Get("Cache-Control"); cc != test.cacheControl {
+			t.Errorf("Expected Cache-Control `%s` for range ending %s, got `%s`", test.cacheControl, test.end, cc)
+		}
+		if w.Header().Get("ETag") != `"etag"` || w.Header().Get("Last-Modified") != now.UTC().Format(http.TimeFormat) {
+			t.Errorf("Missing validators in headers: %+v", w.Header())
+		}
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package config_test
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jhurwich/trendy/config"
+)
+
+// load with the given environment and command line arguments
+func load(env map[string]string, args ...string) (*config.Config, error) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	config.RegisterFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	return config.Load(func(name string) string { return env[name] }, fs)
+}
+
+func writeFile(t *testing.T, dir string, name string, contents string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadLayers(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cert := writeFile(t, dir, "cert.pem", "")
+	key := writeFile(t, dir, "key.pem", "")
+	file := writeFile(t, dir, "trendy.json", `{
+		"Listen": ":9000",
+		"Database": {"DSN": "postgres://file", "MaxOpenConns": 20, "ConnMaxLifetime": "1h"},
+		"TLS": {"CertFile": "`+cert+`", "KeyFile": "`+key+`"},
+		"Provider": {"Timeout": "5s"}
+	}`)
+
+	// defaults, then the file, then the environment, then flags
+	cfg, err := load(map[string]string{
+		"TRENDY_CONFIG":               file,
+		"TRENDY_DB_DSN":               "postgres://env",
+		"TRENDY_DB_MAX_IDLE_CONNS":    "5",
+		"TRENDY_LISTEN":               ":9001",
+		"TRENDY_PROVIDER_MAX_RETRIES": "4",
+	}, "-listen", ":9002", "-provider-burst", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name     string
+		got      interface{}
+		expected interface{}
+	}{
+		{"default provider", cfg.Provider.Name, config.MarkitProvider},
+		{"file max open conns", cfg.Database.MaxOpenConns, 20},
+		{"file lifetime", cfg.Database.ConnMaxLifetime.Duration, time.Hour},
+		{"file tls", cfg.TLS.CertFile, cert},
+		{"env overrides file dsn", cfg.Database.DSN, "postgres://env"},
+		{"env overrides default idle conns", cfg.Database.MaxIdleConns, 5},
+		{"flag overrides env listen", cfg.Listen, ":9002"},
+		{"default shutdown timeout", cfg.Timeouts.Shutdown.Duration, 30 * time.Second},
+		{"default provider rate", cfg.Provider.Rate, 10.0},
+		{"file provider timeout", cfg.Provider.Timeout.Duration, 5 * time.Second},
+		{"env provider retries", cfg.Provider.MaxRetries, 4},
+		{"flag provider burst", cfg.Provider.Burst, 3},
+	}
+	for _, test := range tests {
+		if test.got != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, test.got)
+		}
+	}
+}
+
+func TestLoadLocal(t *testing.T) {
+	t.Parallel()
+
+	// local mode needs no tls and uses the local database by default
+	cfg, err := load(nil, "-local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Database.DSN != config.LocalDSN || cfg.Listen != ":8080" || !cfg.AllowUnlisted {
+		t.Errorf("Expected local defaults, got %+v", cfg)
+	}
+
+	// the demo provider keeps everything in memory unless told otherwise
+	cfg, err = load(map[string]string{"TRENDY_PROVIDER": "demo"}, "-local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Database.DSN != "" {
+		t.Errorf("Expected no database for the demo, got %s", cfg.Database.DSN)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	misspelled := writeFile(t, dir, "misspelled.json", `{"Databse": {"DSN": "postgres://file"}}`)
+
+	var tests = []struct {
+		env      map[string]string
+		args     []string
+		expected []string // substrings of the error
+	}{
+		{nil, nil, []string{"DSN is required", "TLS certificate and key files are required"}},
+		{nil, []string{"-local", "-listen", "8080"}, []string{"listen address [8080] must be host:port"}},
+		{nil, []string{"-local", "-provider", "yahoo"}, []string{"unknown provider [yahoo]"}},
+		{nil, []string{"-local", "-tls-cert", "cert.pem"}, []string{"both a certificate and a key"}},
+		{nil, []string{"-local", "-tls-cert", "missing.pem", "-tls-key", "missing.pem"}, []string{"missing.pem"}},
+		{nil, []string{"-local", "-db-max-open-conns", "2", "-db-max-idle-conns", "4"}, []string{"max idle connections 4 is more than max open 2"}},
+		{map[string]string{"TRENDY_DB_MAX_OPEN_CONNS": "many"}, []string{"-local"}, []string{"Invalid TRENDY_DB_MAX_OPEN_CONNS [many]"}},
+		{nil, []string{"-local", "-redirect-listen", ":80"}, []string{"redirecting to https needs TLS"}},
+		{nil, []string{"-local", "-grpc-listen", "9090"}, []string{"gRPC listen address [9090] must be host:port"}},
+		{nil, []string{"-local", "-grpc-listen", ":8080"}, []string{"gRPC listen address [:8080] must differ"}},
+		{nil, []string{"-local", "-shutdown-timeout", "-1s"}, []string{"timeouts can't be negative"}},
+		{nil, []string{"-local", "-provider-max-retries", "-1"}, []string{"provider timeout, retries and backoff can't be negative"}},
+		{nil, []string{"-local", "-provider-rate", "0"}, []string{"provider rate 0 and burst 10 must be positive"}},
+		{nil, []string{"-local", "-db-conn-max-lifetime", "forever"}, []string{"Invalid -db-conn-max-lifetime [forever]"}},
+		{nil, []string{"-local", "-log-level", "verbose", "-log-format", "xml"}, []string{"unknown log level [verbose]", "unknown log format [xml]"}},
+		{nil, []string{"-local", "-config", misspelled}, []string{"unknown field \"Databse\""}},
+		{nil, []string{"-local", "-config", filepath.Join(dir, "missing.json")}, []string{"Could not read configuration file"}},
+	}
+	for _, test := range tests {
+		_, err := load(test.env, test.args...)
+		if err == nil {
+			t.Errorf("Expected an error for %v %v", test.env, test.args)
+			continue
+		}
+		for _, expected := range test.expected {
+			if !strings.Contains(err.Error(), expected) {
+				t.Errorf("Expected error for %v %v to include %q, got: %v", test.env, test.args, expected, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,239 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow/go/arrow"
+	"github.com/apache/arrow/go/arrow/array"
+	"github.com/apache/arrow/go/arrow/ipc"
+	"github.com/apache/arrow/go/arrow/memory"
+
+	"github.com/jhurwich/trendy/stock"
+)
+
+// Format is a serialization of a stock's Span that GetStock can respond with
+type Format int
+
+const (
+	JSON Format = iota
+	CSV
+	NDJSON
+	Arrow
+)
+
+var formatNames = map[string]Format{
+	"json":   JSON,
+	"csv":    CSV,
+	"ndjson": NDJSON,
+	"arrow":  Arrow,
+}
+
+var formatContentTypes = map[string]Format{
+	"application/json":                    JSON,
+	"text/csv":                            CSV,
+	"application/x-ndjson":                NDJSON,
+	"application/vnd.apache.arrow.stream": Arrow,
+}
+
+func (f Format) ContentType() string {
+	for contentType, format := range formatContentTypes {
+		if format == f {
+			return contentType
+		}
+	}
+	return "application/json"
+}
+
+func (f Format) String() string {
+	for name, format := range formatNames {
+		if format == f {
+			return name
+		}
+	}
+	return "json"
+}
+
+var ErrUnsupportedFormat = errors.New("Unsupported format")
+
+// NegotiateFormat picks the response format for a request. The "format" url
+// parameter wins if present, otherwise the Accept header is used in order of
+// preference. JSON is the default when neither asks for anything specific.
+func NegotiateFormat(r *http.Request) (Format, error) {
+	if name := r.URL.Query().Get("format"); name != "" {
+		format, ok := formatNames[strings.ToLower(name)]
+		if !ok {
+			return JSON, ErrUnsupportedFormat
+		}
+		return format, nil
+	}
+
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return JSON, nil
+	}
+
+	// walk the accepted types from most to least preferred, q=0 means not acceptable
+	bestFormat, bestQ, found := JSON, 0.0, false
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if qStr, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(qStr, 64); err != nil {
+				continue
+			}
+		}
+		if q <= 0 || (found && q <= bestQ) {
+			continue
+		}
+
+		if format, ok := formatContentTypes[mediaType]; ok {
+			bestFormat, bestQ, found = format, q, true
+		} else if mediaType == "*/*" || mediaType == "application/*" {
+			bestFormat, bestQ, found = JSON, q, true
+		}
+	}
+
+	if !found {
+		return JSON, ErrUnsupportedFormat
+	}
+	return bestFormat, nil
+}
+
+// WriteStock serializes the stock's Span in the given format
+func WriteStock(w io.Writer, s *stock.Stock, format Format) error {
+	switch format {
+	case CSV:
+		return writeCSV(w, s)
+	case NDJSON:
+		return writeNDJSON(w, s)
+	case Arrow:
+		return writeArrow(w, s)
+	}
+
+	json, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(json)
+	return err
+}
+
+// CSV has a header row followed by one row per measure, dates as YYYY-MM-DD.
+// The open, high, low and volume are empty for measures without a bar.
+func writeCSV(w io.Writer, s *stock.Stock) error {
+	price := func(value float32) string {
+		return strconv.FormatFloat(float64(value), 'f', -1, 32)
+	}
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"symbol", "date", "value", "open", "high", "low", "volume"})
+	for _, measure := range s.Span {
+		row := []string{s.Symbol, stock.TimeForSQL(measure.Time), price(measure.Value), "", "", "", ""}
+		if measure.HasBar() {
+			row[3], row[4], row[5] = price(measure.Open), price(measure.High), price(measure.Low)
+		}
+		if measure.Volume != 0 {
+			row[6] = strconv.FormatInt(measure.Volume, 10)
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// symbolMeasure is a measure with its symbol, as NDJSON lines and stream events
+// are written
+type symbolMeasure struct {
+	Symbol string
+	Time   time.Time
+	Value  float32
+	Open   float32 `json:",omitempty"`
+	High   float32 `json:",omitempty"`
+	Low    float32 `json:",omitempty"`
+	Volume int64   `json:",omitempty"`
+}
+
+func newSymbolMeasure(symbol string, m stock.Measure) symbolMeasure {
+	return symbolMeasure{symbol, m.Time, m.Value, m.Open, m.High, m.Low, m.Volume}
+}
+
+// NDJSON writes each measure as its own JSON object on its own line
+func writeNDJSON(w io.Writer, s *stock.Stock) error {
+	enc := json.NewEncoder(w) // Encode terminates each value with a newline
+	for _, measure := range s.Span {
+		if err := enc.Encode(newSymbolMeasure(s.Symbol, measure)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// the bar's columns are null for measures without one
+var arrowSchema = arrow.NewSchema([]arrow.Field{
+	{Name: "symbol", Type: arrow.BinaryTypes.String},
+	{Name: "date", Type: arrow.FixedWidthTypes.Date32},
+	{Name: "value", Type: arrow.PrimitiveTypes.Float32},
+	{Name: "open", Type: arrow.PrimitiveTypes.Float32, Nullable: true},
+	{Name: "high", Type: arrow.PrimitiveTypes.Float32, Nullable: true},
+	{Name: "low", Type: arrow.PrimitiveTypes.Float32, Nullable: true},
+	{Name: "volume", Type: arrow.PrimitiveTypes.Int64, Nullable: true},
+}, nil)
+
+// Arrow writes the span as a single record batch in an Arrow IPC stream
+func writeArrow(w io.Writer, s *stock.Stock) error {
+	builder := array.NewRecordBuilder(memory.NewGoAllocator(), arrowSchema)
+	defer builder.Release()
+
+	symbols := builder.Field(0).(*array.StringBuilder)
+	dates := builder.Field(1).(*array.Date32Builder)
+	values := builder.Field(2).(*array.Float32Builder)
+	bar := []*array.Float32Builder{
+		builder.Field(3).(*array.Float32Builder),
+		builder.Field(4).(*array.Float32Builder),
+		builder.Field(5).(*array.Float32Builder),
+	}
+	volumes := builder.Field(6).(*array.Int64Builder)
+	for _, measure := range s.Span {
+		// date32 is days since the unix epoch
+		y, m, d := measure.Time.Date()
+		days := time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Unix() / int64(24*time.Hour/time.Second)
+
+		symbols.Append(s.Symbol)
+		dates.Append(arrow.Date32(days))
+		values.Append(measure.Value)
+		for i, price := range []float32{measure.Open, measure.High, measure.Low} {
+			if measure.HasBar() {
+				bar[i].Append(price)
+			} else {
+				bar[i].AppendNull()
+			}
+		}
+		if measure.Volume != 0 {
+			volumes.Append(measure.Volume)
+		} else {
+			volumes.AppendNull()
+		}
+	}
+
+	record := builder.NewRecord()
+	defer record.Release()
+
+	writer := ipc.NewWriter(w, ipc.WithSchema(arrowSchema))
+	if err := writer.Write(record); err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
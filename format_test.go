@@ -0,0 +1,135 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/arrow/array"
+	"github.com/apache/arrow/go/arrow/ipc"
+
+	"github.com/jhurwich/trendy/stock"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	t.Parallel()
+	var tests = []struct {
+		query       string
+		accept      string
+		expected    Format
+		expectError bool
+	}{
+		{"", "", JSON, false},
+		{"", "*/*", JSON, false},
+		{"", "application/json", JSON, false},
+		{"", "text/csv", CSV, false},
+		{"", "application/x-ndjson", NDJSON, false},
+		{"", "application/vnd.apache.arrow.stream", Arrow, false},
+		{"", "text/html, text/csv;q=0.5, application/json;q=0.9", JSON, false},
+		{"", "text/csv;q=0, */*;q=0.1", JSON, false},
+		{"", "text/html", JSON, true},
+		{"?format=csv", "application/json", CSV, false},
+		{"?format=NDJSON", "", NDJSON, false},
+		{"?format=arrow", "", Arrow, false},
+		{"?format=xml", "", JSON, true},
+	}
+
+	for _, test := range tests {
+		r, _ := http.NewRequest("GET", "/stock/GOOG"+test.query, nil)
+		if test.accept != "" {
+			r.Header.Set("Accept", test.accept)
+		}
+
+		format, err := NegotiateFormat(r)
+		if test.expectError {
+			if err == nil {
+				t.Errorf("Expected error negotiating format but got %s [query:%s accept:%s]", format, test.query, test.accept)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error negotiating format [query:%s accept:%s]: %v", test.query, test.accept, err)
+		} else if format != test.expected {
+			t.Errorf("Negotiated wrong format, expected %s got %s [query:%s accept:%s]", test.expected, format, test.query, test.accept)
+		}
+	}
+}
+
+func TestWriteStock(t *testing.T) {
+	t.Parallel()
+	s := stock.NewStock("GOOG")
+	s.Span = formatTestSpan
+
+	// CSV
+	var b bytes.Buffer
+	if err := WriteStock(&b, s, CSV); err != nil {
+		t.Fatal(err)
+	}
+	expectedCSV := "symbol,date,value,open,high,low,volume\nGOOG,2015-06-01,1.5,1.25,1.75,1,165902897\nGOOG,2015-06-02,1.25,,,,\nGOOG,2015-06-03,2,,,,300\n"
+	if b.String() != expectedCSV {
+		t.Errorf("Unexpected CSV, expected:\n%s\ngot:\n%s\n", expectedCSV, b.String())
+	}
+
+	// NDJSON, each line should decode to one measure
+	b.Reset()
+	if err := WriteStock(&b, s, NDJSON); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != len(s.Span) {
+		t.Fatalf("Expected %d NDJSON lines, got %d:\n%s", len(s.Span), len(lines), b.String())
+	}
+	for i, line := range lines {
+		m := stock.Measure{}
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Errorf("Could not decode NDJSON line `%s`: %v", line, err)
+		} else if !m.Equal(s.Span[i]) || m.Open != s.Span[i].Open || m.High != s.Span[i].High || m.Low != s.Span[i].Low || m.Volume != s.Span[i].Volume {
+			t.Errorf("NDJSON line %d decoded to %+v, expected %+v", i, m, s.Span[i])
+		}
+	}
+
+	// Arrow, read the stream back and compare columns
+	b.Reset()
+	if err := WriteStock(&b, s, Arrow); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := ipc.NewReader(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Release()
+	if !reader.Next() {
+		t.Fatal("Expected a record batch in the Arrow stream")
+	}
+	record := reader.Record()
+	if int(record.NumRows()) != len(s.Span) {
+		t.Fatalf("Expected %d Arrow rows, got %d", len(s.Span), record.NumRows())
+	}
+	dates := record.Column(1).(*array.Date32)
+	values := record.Column(2).(*array.Float32)
+	opens, highs, lows := record.Column(3).(*array.Float32), record.Column(4).(*array.Float32), record.Column(5).(*array.Float32)
+	volumes := record.Column(6).(*array.Int64)
+	for i, measure := range s.Span {
+		day := time.Unix(int64(dates.Value(i))*24*60*60, 0).UTC()
+		if stock.TimeForSQL(day) != stock.TimeForSQL(measure.Time) || values.Value(i) != measure.Value {
+			t.Errorf("Arrow row %d is {%s %f}, expected %+v", i, day, values.Value(i), measure)
+		}
+		if measure.HasBar() != opens.IsValid(i) || (measure.HasBar() && (opens.Value(i) != measure.Open || highs.Value(i) != measure.High || lows.Value(i) != measure.Low)) {
+			t.Errorf("Arrow row %d has bar {%f %f %f} valid %t, expected %+v", i, opens.Value(i), highs.Value(i), lows.Value(i), opens.IsValid(i), measure)
+		}
+		if (measure.Volume != 0) != volumes.IsValid(i) || volumes.Value(i) != measure.Volume {
+			t.Errorf("Arrow row %d has volume %d valid %t, expected %d", i, volumes.Value(i), volumes.IsValid(i), measure.Volume)
+		}
+	}
+}
+
+var formatTestSpan stock.Span = (stock.Span)([]stock.Measure{
+	{Time: time.Date(2015, time.June, 1, 12, 0, 0, 0, time.UTC), Value: 1.5, Open: 1.25, High: 1.75, Low: 1, Volume: 165902897},
+	{Time: time.Date(2015, time.June, 2, 12, 0, 0, 0, time.UTC), Value: 1.25},
+	{Time: time.Date(2015, time.June, 3, 12, 0, 0, 0, time.UTC), Value: 2, Volume: 300},
+})
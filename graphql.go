@@ -0,0 +1,469 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/jhurwich/trendy/stock"
+)
+
+// MaxQueryCost bounds how much history a GraphQL query can ask for, see queryCost
+var MaxQueryCost = 20000
+
+const (
+	maxIndicatorWindow = 500     // measures averaged by an indicator
+	maxGraphQLBody     = 1 << 16 // bytes in a GraphQL request
+)
+
+// the GraphQL schema, queries resolve with the stock package like GetStock
+//
+//	type Query {
+//		stock(symbol: String!): Stock!
+//		stocks(symbols: [String!]!): [Stock!]!
+//	}
+//	type Stock {
+//		symbol: String!
+//		bars(start: String!, end: String): [Bar!]
+//		indicator(kind: Indicator!, window: Int!, start: String!, end: String): [Point!]
+//		trend(start: String!, end: String): Trend
+//	}
+//	type Bar {
+//		time: DateTime!
+//		close: Float!
+//		open: Float
+//		high: Float
+//		low: Float
+//		volume: Float
+//	}
+//
+// start and end are YYYY-MM-DD in New York, end defaults to today. Fields that
+// need a range are nullable so that one failing doesn't fail the whole query.
+var graphQLSchema = newGraphQLSchema()
+
+func newGraphQLSchema() graphql.Schema {
+	// open, high and low are null unless the measure has a full bar, volume
+	// unless it's known, as they're left out of REST responses
+	barField := func(resolve func(stock.Measure) float32) *graphql.Field {
+		return &graphql.Field{Type: graphql.Float, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			if measure := p.Source.(stock.Measure); measure.HasBar() {
+				return resolve(measure), nil
+			}
+			return nil, nil
+		}}
+	}
+	barType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Bar",
+		Description: "A day's close, with its open, high, low and volume when they're known",
+		Fields: graphql.Fields{
+			"time": &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(stock.Measure).Time, nil
+			}},
+			"close": &graphql.Field{Type: graphql.NewNonNull(graphql.Float), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(stock.Measure).Value, nil
+			}},
+			"open": barField(func(m stock.Measure) float32 { return m.Open }),
+			"high": barField(func(m stock.Measure) float32 { return m.High }),
+			"low":  barField(func(m stock.Measure) float32 { return m.Low }),
+			"volume": &graphql.Field{Type: graphql.Float, Description: "shares traded, a Float as volumes can exceed an Int", Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				if volume := p.Source.(stock.Measure).Volume; volume != 0 {
+					return volume, nil
+				}
+				return nil, nil
+			}},
+		},
+	})
+	pointType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Point",
+		Description: "An indicator's value on a day",
+		Fields: graphql.Fields{
+			"time": &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(stock.Measure).Time, nil
+			}},
+			"value": &graphql.Field{Type: graphql.NewNonNull(graphql.Float), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(stock.Measure).Value, nil
+			}},
+		},
+	})
+	trendField := func(resolve func(stock.Trend) interface{}) *graphql.Field {
+		return &graphql.Field{Type: graphql.NewNonNull(graphql.Float), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return resolve(p.Source.(stock.Trend)), nil
+		}}
+	}
+	trendType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Trend",
+		Description: "The least squares line through the closes in a range",
+		Fields: graphql.Fields{
+			"start": &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(stock.Trend).Start, nil
+			}},
+			"end": &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(stock.Trend).End, nil
+			}},
+			"slopePerDay": trendField(func(t stock.Trend) interface{} { return t.SlopePerDay }),
+			"intercept":   trendField(func(t stock.Trend) interface{} { return t.Intercept }),
+			"rSquared":    trendField(func(t stock.Trend) interface{} { return t.RSquared }),
+			"change":      trendField(func(t stock.Trend) interface{} { return t.Change }),
+		},
+	})
+	indicatorEnum := graphql.NewEnum(graphql.EnumConfig{
+		Name: "Indicator",
+		Values: graphql.EnumValueConfigMap{
+			stock.IndicatorSMA: &graphql.EnumValueConfig{Value: stock.IndicatorSMA, Description: "simple moving average"},
+			stock.IndicatorEMA: &graphql.EnumValueConfig{Value: stock.IndicatorEMA, Description: "exponential moving average"},
+		},
+	})
+
+	rangeArgs := func(more graphql.FieldConfigArgument) graphql.FieldConfigArgument {
+		args := graphql.FieldConfigArgument{
+			"start": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String), Description: "first day, YYYY-MM-DD in New York"},
+			"end":   &graphql.ArgumentConfig{Type: graphql.String, Description: "last day, YYYY-MM-DD in New York, today if not given"},
+		}
+		for name, arg := range more {
+			args[name] = arg
+		}
+		return args
+	}
+	stockType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Stock",
+		Fields: graphql.Fields{
+			"symbol": &graphql.Field{Type: graphql.NewNonNull(graphql.String), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(*stock.Stock).Symbol, nil
+			}},
+			"bars": &graphql.Field{
+				Type: graphql.NewList(graphql.NewNonNull(barType)),
+				Args: rangeArgs(nil),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					s := p.Source.(*stock.Stock)
+					start, end, err := rangeArgValues(p.Args, time.Now())
+					if err != nil {
+						return nil, err
+					}
+					return resolveRange(p, s, start, end)
+				},
+			},
+			"indicator": &graphql.Field{
+				Type: graphql.NewList(graphql.NewNonNull(pointType)),
+				Args: rangeArgs(graphql.FieldConfigArgument{
+					"kind":   &graphql.ArgumentConfig{Type: graphql.NewNonNull(indicatorEnum)},
+					"window": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int), Description: "measures averaged"},
+				}),
+				Resolve: resolveIndicator,
+			},
+			"trend": &graphql.Field{
+				Type: trendType,
+				Args: rangeArgs(nil),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					s := p.Source.(*stock.Stock)
+					start, end, err := rangeArgValues(p.Args, time.Now())
+					if err != nil {
+						return nil, err
+					}
+					span, err := resolveRange(p, s, start, end)
+					if err != nil {
+						return nil, err
+					}
+					return span.Trend()
+				},
+			},
+		},
+	})
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"stock": &graphql.Field{
+				Type: graphql.NewNonNull(stockType),
+				Args: graphql.FieldConfigArgument{"symbol": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)}},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return stock.NewStock(p.Args["symbol"].(string)), nil
+				},
+			},
+			"stocks": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(stockType))),
+				Args: graphql.FieldConfigArgument{"symbols": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String)))}},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					stocks := []*stock.Stock{}
+					for _, sym := range p.Args["symbols"].([]interface{}) {
+						stocks = append(stocks, stock.NewStock(sym.(string)))
+					}
+					return stocks, nil
+				},
+			},
+		},
+	})
+
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{Query: query})
+	if err != nil {
+		panic(err)
+	}
+	return schema
+}
+
+// the indicator's points from start to end. The measures from far enough
+// before start that the first point averages a full window are fetched too.
+func resolveIndicator(p graphql.ResolveParams) (interface{}, error) {
+	s := p.Source.(*stock.Stock)
+	start, end, err := rangeArgValues(p.Args, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	window := p.Args["window"].(int)
+	if window > maxIndicatorWindow {
+		return nil, fmt.Errorf("Indicator window must be at most %d [%d]", maxIndicatorWindow, window)
+	}
+
+	points, err := s.IndicatorContext(p.Context, p.Args["kind"].(string), window, start, end)
+	if err != nil && p.Context.Err() != nil {
+		return nil, fmt.Errorf("Request abandoned: %v", p.Context.Err())
+	} else if err == stock.ErrWindow || errors.Is(err, stock.ErrSymbolNotFound) || errors.Is(err, stock.ErrDelisted) {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("Could not get range for provided stock over start to end [%s:%s-%s]", s.Symbol, stock.TimeForSQL(start), stock.TimeForSQL(end))
+	}
+	return points, nil
+}
+
+func resolveRange(p graphql.ResolveParams, s *stock.Stock, start time.Time, end time.Time) (stock.Span, error) {
+	span, err := s.RangeContext(p.Context, start, end)
+	if err != nil && p.Context.Err() != nil {
+		return nil, fmt.Errorf("Request abandoned: %v", p.Context.Err())
+	} else if errors.Is(err, stock.ErrSymbolNotFound) || errors.Is(err, stock.ErrDelisted) {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("Could not get range for provided stock over start to end [%s:%s-%s]", s.Symbol, stock.TimeForSQL(start), stock.TimeForSQL(end))
+	}
+	return span, nil
+}
+
+// the start and end arguments as days in New York, end is now's day if not given
+func rangeArgValues(args map[string]interface{}, now time.Time) (time.Time, time.Time, error) {
+	start, _ := args["start"].(string)
+	end, _ := args["end"].(string)
+	startTime, err := parseDay("start", start)
+	if err != nil {
+		return startTime, startTime, err
+	}
+	if end == "" {
+		end = now.In(newYork).Format("2006-01-02")
+	}
+	endTime, err := parseDay("end", end)
+	return startTime, endTime, err
+}
+
+var newYork, _ = time.LoadLocation("America/New_York")
+
+// a YYYY-MM-DD day in New York, as GetStock parses start and end
+func parseDay(name string, value string) (time.Time, error) {
+	t, err := time.ParseInLocation("2006-01-02", value, newYork)
+	if err != nil {
+		return t, fmt.Errorf("Could not parse %s as time. must be YYYY-MM-DD [%s]", name, value)
+	}
+	return t, nil
+}
+
+// a GraphQL request body
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// body is a JSON graphQLRequest
+// responds with the GraphQL result as JSON, including the query's cost in its
+// extensions. Queries costing more than MaxQueryCost aren't executed, and a
+// query is counted against the key's rate limit and quotas as the requests it
+// makes, see queryCost.
+func PostGraphQL(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var request graphQLRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxGraphQLBody)).Decode(&request); err != nil {
+		http.Error(w, fmt.Sprintf("Could not decode GraphQL request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if request.Query == "" {
+		http.Error(w, "Must provide a query", http.StatusBadRequest)
+		return
+	}
+
+	cost, requests := queryCost(request.Query, request.OperationName, request.Variables, time.Now())
+	var result *graphql.Result
+	if cost > MaxQueryCost {
+		result = &graphql.Result{Errors: []gqlerrors.FormattedError{{
+			Message: fmt.Sprintf("Query cost %d exceeds the limit of %d, ask for fewer symbols or shorter ranges", cost, MaxQueryCost),
+		}}}
+	} else {
+		// RateLimiter has already counted the first
+		if !admitMore(w, r, requests-1) {
+			return
+		}
+		result = graphql.Do(graphql.Params{
+			Schema:         graphQLSchema,
+			RequestString:  request.Query,
+			OperationName:  request.OperationName,
+			VariableValues: request.Variables,
+			Context:        r.Context(),
+		})
+	}
+	result.Extensions = map[string]interface{}{"cost": cost, "maxCost": MaxQueryCost}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// queryCost is the days of history a query asks for, known before it's run.
+// Each bars, indicator and trend field costs a point per day in its range,
+// indicators also the days fetched for their window, and each stock a point,
+// all multiplied by the number of symbols in a stocks field. requests is
+// what a REST client would have made for the same data, a request for each
+// symbol's bars, indicator and trend fields, and at least one per symbol.
+// Queries that can't be parsed cost nothing, running them reports why.
+func queryCost(query string, operationName string, variables map[string]interface{}, now time.Time) (cost int, requests int) {
+	doc, err := parser.Parse(parser.ParseParams{Source: source.NewSource(&source.Source{Body: []byte(query)})})
+	if err != nil {
+		return 0, 0
+	}
+	c := costCounter{fragments: map[string]*ast.FragmentDefinition{}, now: now}
+	var operation *ast.OperationDefinition
+	for _, definition := range doc.Definitions {
+		switch definition := definition.(type) {
+		case *ast.FragmentDefinition:
+			c.fragments[definition.Name.Value] = definition
+		case *ast.OperationDefinition:
+			if operationName == "" || (definition.Name != nil && definition.Name.Value == operationName) {
+				operation = definition
+			}
+		}
+	}
+	if operation == nil {
+		return 0, 0
+	}
+	// variables that aren't given take their defaults, as they will when run
+	c.variables = map[string]interface{}{}
+	for name, value := range variables {
+		c.variables[name] = value
+	}
+	for _, definition := range operation.VariableDefinitions {
+		if _, given := c.variables[definition.Variable.Name.Value]; !given && definition.DefaultValue != nil {
+			c.variables[definition.Variable.Name.Value] = c.value(definition.DefaultValue)
+		}
+	}
+
+	c.fields(operation.SelectionSet, map[string]bool{}, func(field *ast.Field) {
+		symbols := 0
+		switch field.Name.Value {
+		case "stock":
+			symbols = 1
+		case "stocks":
+			switch list := c.argument(field, "symbols").(type) {
+			case []interface{}:
+				symbols = len(list)
+			case string: // a single symbol is coerced to a list of one
+				symbols = 1
+			}
+		}
+		stockCost, stockRequests := 1, 0
+		c.fields(field.SelectionSet, map[string]bool{}, func(field *ast.Field) {
+			switch field.Name.Value {
+			case "bars", "trend":
+				stockCost += c.days(field)
+				stockRequests++
+			case "indicator":
+				window, _ := c.argument(field, "window").(int)
+				if window > maxIndicatorWindow {
+					window = maxIndicatorWindow
+				}
+				stockCost += c.days(field) + stock.IndicatorLookback(window)
+				stockRequests++
+			}
+		})
+		cost += symbols * stockCost
+		requests += symbols * maxInt(stockRequests, 1)
+	})
+	return cost, requests
+}
+
+type costCounter struct {
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]interface{}
+	now       time.Time
+}
+
+// call fn for each field selected by set, including those in fragments
+func (c costCounter) fields(set *ast.SelectionSet, spread map[string]bool, fn func(*ast.Field)) {
+	if set == nil {
+		return
+	}
+	for _, selection := range set.Selections {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			fn(selection)
+		case *ast.InlineFragment:
+			c.fields(selection.SelectionSet, spread, fn)
+		case *ast.FragmentSpread:
+			// fragment cycles are invalid, but they haven't been validated yet
+			name := selection.Name.Value
+			if fragment, ok := c.fragments[name]; ok && !spread[name] {
+				spread[name] = true
+				c.fields(fragment.SelectionSet, spread, fn)
+				delete(spread, name)
+			}
+		}
+	}
+}
+
+// days in the field's range, at least one
+func (c costCounter) days(field *ast.Field) int {
+	start, _ := c.argument(field, "start").(string)
+	end, _ := c.argument(field, "end").(string)
+	startTime, endTime, err := rangeArgValues(map[string]interface{}{"start": start, "end": end}, c.now)
+	if err != nil || !endTime.After(startTime) {
+		return 1
+	}
+	return int(endTime.Sub(startTime).Hours()/24) + 1
+}
+
+// the value of a field's argument as a string, int or list, nil if it isn't given
+func (c costCounter) argument(field *ast.Field, name string) interface{} {
+	for _, arg := range field.Arguments {
+		if arg.Name.Value == name {
+			return c.value(arg.Value)
+		}
+	}
+	return nil
+}
+
+func (c costCounter) value(value ast.Value) interface{} {
+	switch value := value.(type) {
+	case *ast.Variable:
+		switch v := c.variables[value.Name.Value].(type) {
+		case float64: // JSON numbers
+			return int(v)
+		default:
+			return v
+		}
+	case *ast.StringValue:
+		return value.Value
+	case *ast.IntValue:
+		i, _ := strconv.Atoi(value.Value)
+		return i
+	case *ast.ListValue:
+		list := []interface{}{}
+		for _, v := range value.Values {
+			list = append(list, c.value(v))
+		}
+		return list
+	}
+	return nil
+}
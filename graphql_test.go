@@ -0,0 +1,172 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/graphql-go/graphql"
+
+	"github.com/jhurwich/trendy/config"
+	"github.com/jhurwich/trendy/stock"
+	"github.com/jhurwich/trendy/testhelpers"
+)
+
+func TestQueryCost(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2015, time.July, 1, 12, 0, 0, 0, time.UTC)
+	var tests = []struct {
+		query     string
+		variables map[string]interface{}
+		expected  int
+	}{
+		{`{ stock(symbol: "GOOG") { symbol } }`, nil, 1},
+		{`{ stock(symbol: "GOOG") { bars(start: "2015-06-01", end: "2015-06-30") { close } } }`, nil, 1 + 30},
+		// end defaults to today
+		{`{ stock(symbol: "GOOG") { bars(start: "2015-06-01") { close } } }`, nil, 1 + 31},
+		{`{ stocks(symbols: ["GOOG", "MSFT"]) { bars(start: "2015-06-01", end: "2015-06-30") { close } trend(start: "2015-06-01", end: "2015-06-30") { slopePerDay } } }`, nil, 2 * (1 + 30 + 30)},
+		{`{ stock(symbol: "GOOG") { indicator(kind: SMA, window: 50, start: "2015-06-01", end: "2015-06-30") { value } } }`, nil, 1 + 30 + stock.IndicatorLookback(50)},
+		{`{ stock(symbol: "GOOG") { indicator(kind: SMA, window: 100000, start: "2015-06-01", end: "2015-06-30") { value } } }`, nil, 1 + 30 + stock.IndicatorLookback(maxIndicatorWindow)},
+		// aliases and fragments are counted
+		{`{ a: stock(symbol: "GOOG") { ...June } b: stock(symbol: "MSFT") { ... on Stock { ...June } } } fragment June on Stock { bars(start: "2015-06-01", end: "2015-06-30") { close } }`, nil, 2 * (1 + 30)},
+		{`query ($symbols: [String!]!, $start: String!) { stocks(symbols: $symbols) { bars(start: $start, end: "2015-06-30") { close } } }`,
+			map[string]interface{}{"symbols": []interface{}{"GOOG", "MSFT", "AAPL"}, "start": "2015-06-21"}, 3 * (1 + 10)},
+		{`query ($window: Int!) { stock(symbol: "GOOG") { indicator(kind: EMA, window: $window, start: "2015-06-30", end: "2015-06-30") { value } } }`,
+			map[string]interface{}{"window": float64(20)}, 1 + 1 + stock.IndicatorLookback(20)},
+		// variables that aren't given cost their defaults
+		{`query ($start: String = "2015-06-01") { stock(symbol: "GOOG") { bars(start: $start, end: "2015-06-30") { close } } }`, nil, 1 + 30},
+		{`query ($start: String = "2015-06-01") { stock(symbol: "GOOG") { bars(start: $start, end: "2015-06-30") { close } } }`,
+			map[string]interface{}{"start": "2015-06-21"}, 1 + 10},
+		// a single symbol is a list of one
+		{`{ stocks(symbols: "GOOG") { bars(start: "2015-06-01", end: "2015-06-30") { close } } }`, nil, 1 + 30},
+		// cycles aren't followed, running the query reports them
+		{`{ stock(symbol: "GOOG") { ...A } } fragment A on Stock { ...B } fragment B on Stock { ...A }`, nil, 1},
+		{`{ stock(symbol: `, nil, 0},
+	}
+	for _, test := range tests {
+		if cost, _ := queryCost(test.query, "", test.variables, now); cost != test.expected {
+			t.Errorf("Expected cost %d for %s, got %d", test.expected, test.query, cost)
+		}
+	}
+}
+
+func TestPostGraphQL(t *testing.T) {
+	_, discard := testhelpers.UseStore(t)
+	defer discard()
+	defaultProvider := stock.DefaultProvider
+	stock.DefaultProvider = stock.SyntheticProvider{}
+	defer func() { stock.DefaultProvider = defaultProvider }()
+	server := NewTrendyServer(&config.Config{Local: true})
+
+	post := func(body string) (int, map[string]interface{}) {
+		r := httptest.NewRequest("POST", "/graphql", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set(authKeyHeader, devKey)
+		r.Header.Set(authSecretHeader, devSecret)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		result := map[string]interface{}{}
+		json.Unmarshal(w.Body.Bytes(), &result)
+		return w.Code, result
+	}
+
+	// closes, a moving average and the trend for two symbols in one round trip
+	code, result := post(`{"query": "query ($symbols: [String!]!) { stocks(symbols: $symbols) { symbol bars(start: \"2015-06-01\", end: \"2015-06-30\") { time close } indicator(kind: SMA, window: 5, start: \"2015-06-01\", end: \"2015-06-30\") { time value } trend(start: \"2015-06-01\", end: \"2015-06-30\") { slopePerDay } } }", "variables": {"symbols": ["AAPL", "MSFT"]}}`)
+	if code != http.StatusOK || result["errors"] != nil {
+		t.Fatalf("Expected a result, got %d %v", code, result)
+	}
+	stocks := result["data"].(map[string]interface{})["stocks"].([]interface{})
+	if len(stocks) != 2 {
+		t.Fatalf("Expected two stocks, got %v", stocks)
+	}
+	for i, symbol := range []string{"AAPL", "MSFT"} {
+		s := stocks[i].(map[string]interface{})
+		bars, points := s["bars"].([]interface{}), s["indicator"].([]interface{})
+		if s["symbol"] != symbol || len(bars) == 0 {
+			t.Errorf("Expected bars for %s, got %v", symbol, s)
+			continue
+		}
+		// the window before the range is fetched so there's a point for every bar
+		if len(points) != len(bars) || points[0].(map[string]interface{})["time"] != bars[0].(map[string]interface{})["time"] {
+			t.Errorf("Expected an SMA point for each of %s's %d bars, got %d", symbol, len(bars), len(points))
+		}
+		span, _ := stock.NewStock(symbol).Range(time.Date(2015, time.June, 1, 4, 0, 0, 0, time.UTC), time.Date(2015, time.June, 30, 4, 0, 0, 0, time.UTC))
+		trend, _ := span.Trend()
+		if slope := s["trend"].(map[string]interface{})["slopePerDay"]; slope != trend.SlopePerDay {
+			t.Errorf("Expected %s's slope %v, got %v", symbol, trend.SlopePerDay, slope)
+		}
+	}
+	if cost := result["extensions"].(map[string]interface{})["cost"]; cost != float64(2*(1+30+30+stock.IndicatorLookback(5)+30)) {
+		t.Errorf("Unexpected cost %v", cost)
+	}
+
+	// bars have their open, high, low and volume, null when they're unknown
+	code, result = post(`{"query": "{ stock(symbol: \"GOOG\") { bars(start: \"2015-06-01\", end: \"2015-06-05\") { close open high low volume } } }"}`)
+	if code != http.StatusOK || result["errors"] != nil {
+		t.Fatalf("Expected a result, got %d %v", code, result)
+	}
+	span, _ := stock.NewStock("GOOG").Range(time.Date(2015, time.June, 1, 4, 0, 0, 0, time.UTC), time.Date(2015, time.June, 5, 4, 0, 0, 0, time.UTC))
+	bars := result["data"].(map[string]interface{})["stock"].(map[string]interface{})["bars"].([]interface{})
+	float := func(v interface{}) float32 { f, _ := v.(float64); return float32(f) }
+	if first := bars[0].(map[string]interface{}); len(bars) != len(span) || float(first["open"]) != span[0].Open || float(first["high"]) != span[0].High ||
+		float(first["low"]) != span[0].Low || first["volume"] != float64(span[0].Volume) {
+		t.Errorf("Expected bars like %+v, got %v", span[0], bars)
+	}
+	source := graphql.ResolveParams{Source: stock.Measure{Value: 1.5}}
+	for _, name := range []string{"open", "high", "low", "volume"} {
+		if value, _ := graphQLSchema.Type("Bar").(*graphql.Object).Fields()[name].Resolve(source); value != nil {
+			t.Errorf("Expected a null %s without a bar, got %v", name, value)
+		}
+	}
+
+	// a failed field doesn't fail the others
+	code, result = post(`{"query": "{ stock(symbol: \"GOOG\") { symbol trend(start: \"2015-06-01\", end: \"2015-06-01\") { slopePerDay } } }"}`)
+	if code != http.StatusOK || result["errors"] == nil || result["data"].(map[string]interface{})["stock"].(map[string]interface{})["symbol"] != "GOOG" {
+		t.Errorf("Expected the symbol and an error for a one day trend, got %d %v", code, result)
+	}
+
+	// unbounded history is refused before anything is fetched
+	code, result = post(`{"query": "{ stock(symbol: \"GOOG\") { bars(start: \"1900-01-01\") { close } } }"}`)
+	if errs, _ := result["errors"].([]interface{}); code != http.StatusOK || result["data"] != nil || len(errs) != 1 ||
+		!strings.Contains(errs[0].(map[string]interface{})["message"].(string), "exceeds the limit") {
+		t.Errorf("Expected the query to be refused for its cost, got %d %v", code, result)
+	}
+
+	// a query is counted as the requests it makes, each symbol's range fields
+	limitedKey, limitedSecret, err := stock.NewAPIKey("graphql limited", []string{stock.ScopeRead}, nil)
+	limitedKey.RateLimit, limitedKey.Burst, limitedKey.DailyQuota = 1, 100, 10
+	if err != nil || stock.DB.InsertAPIKey(limitedKey) != nil {
+		t.Fatalf("Could not create a key: %v", err)
+	}
+	var limitTests = []struct {
+		query string
+		code  int
+		usage int
+	}{
+		{`{"query": "{ stocks(symbols: [\"AAPL\", \"MSFT\", \"GOOG\"]) { bars(start: \"2015-06-01\", end: \"2015-06-30\") { close } trend(start: \"2015-06-01\", end: \"2015-06-30\") { slopePerDay } } }"}`, http.StatusOK, 6},
+		{`{"query": "{ stock(symbol: \"AAPL\") { symbol } }"}`, http.StatusOK, 7},
+		// the first request is counted before the query is read
+		{`{"query": "{ stocks(symbols: [\"AAPL\", \"MSFT\", \"GOOG\", \"IBM\"]) { bars(start: \"2015-06-01\", end: \"2015-06-30\") { close } } }"}`, http.StatusTooManyRequests, 8},
+	}
+	for _, test := range limitTests {
+		r := httptest.NewRequest("POST", "/graphql", strings.NewReader(test.query))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set(authKeyHeader, limitedKey.Key)
+		r.Header.Set(authSecretHeader, limitedSecret)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		usage, _ := stock.DB.GetUsage(limitedKey.Key, time.Now().UTC().Format("2006-01-02"))
+		if w.Code != test.code || usage != test.usage {
+			t.Errorf("Expected %d with usage %d for %s, got %d with %d", test.code, test.usage, test.query, w.Code, usage)
+		}
+	}
+
+	if code, _ := post(`not json`); code != http.StatusBadRequest {
+		t.Errorf("Expected %d for a body that isn't JSON, got %d", http.StatusBadRequest, code)
+	}
+}
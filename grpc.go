@@ -0,0 +1,392 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/jhurwich/trendy/rpc"
+	"github.com/jhurwich/trendy/stock"
+)
+
+// metadata keys, gRPC lowercases them
+var (
+	authKeyMetadata    = strings.ToLower(authKeyHeader)
+	authSecretMetadata = strings.ToLower(authSecretHeader)
+	requestIDMetadata  = strings.ToLower(requestIDHeader)
+)
+
+const (
+	maxBatch         = 100 // requests in one batch call
+	batchConcurrency = 8   // requests in a batch served at once
+)
+
+// NewGRPCServer serves the Trendy gRPC service, over TLS with certs'
+// certificate unless certs is nil. Calls are authenticated and rate limited
+// like REST requests, the buckets are separate but quotas are shared. A batch
+// is counted as each of its requests.
+func NewGRPCServer(certs *CertReloader) *grpc.Server {
+	interceptor := &grpcInterceptor{limiter: NewRateLimiter()}
+	options := []grpc.ServerOption{
+		grpc.UnaryInterceptor(interceptor.unary),
+		grpc.StreamInterceptor(interceptor.stream),
+	}
+	if certs != nil {
+		options = append(options, grpc.Creds(credentials.NewTLS(&tls.Config{GetCertificate: certs.GetCertificate, MinVersion: tls.VersionTLS12})))
+	}
+	server := grpc.NewServer(options...)
+	rpc.RegisterTrendyServer(server, trendyService{})
+	return server
+}
+
+// grpcInterceptor does for gRPC calls what RequestLogger, Authenticate,
+// RequireScope and RateLimiter do for REST requests
+type grpcInterceptor struct {
+	limiter *RateLimiter
+}
+
+func (i *grpcInterceptor) unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	ctx, err := i.admit(ctx, callCost(req))
+	var resp interface{}
+	if err == nil {
+		err = recovered(ctx, info.FullMethod, func() (err error) {
+			resp, err = handler(ctx, req)
+			return err
+		})
+	}
+	logCall(ctx, info.FullMethod, start, err)
+	return resp, err
+}
+
+func (i *grpcInterceptor) stream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	ctx, err := i.admit(ss.Context(), 1)
+	if err == nil {
+		err = recovered(ctx, info.FullMethod, func() error {
+			return handler(srv, contextStream{ss, ctx})
+		})
+	}
+	logCall(ctx, info.FullMethod, start, err)
+	return err
+}
+
+// recovered calls fn, turning a panic into an Internal error that's logged
+// with its stack, as negroni.Recovery does for REST requests
+func recovered(ctx context.Context, method string, fn func() error) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			slog.ErrorContext(ctx, "Call panicked", "method", method, "panic", p, "stack", string(debug.Stack()))
+			err = status.Error(codes.Internal, "Internal Server Error")
+		}
+	}()
+	return fn()
+}
+
+// callCost is the requests a call is counted as against the rate limit and
+// quotas, a batch counts each of its requests as GetBars and GetTrend would
+func callCost(req interface{}) int {
+	n := 1
+	switch req := req.(type) {
+	case *rpc.BatchGetBarsRequest:
+		n = len(req.Requests)
+	case *rpc.BatchGetTrendsRequest:
+		n = len(req.Requests)
+	}
+	return maxInt(n, 1)
+}
+
+// admit a call counted as n requests, returning its context with the request
+// id and api key or the status error it's refused with
+func (i *grpcInterceptor) admit(ctx context.Context, n int) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	first := func(key string) string {
+		if values := md.Get(key); len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+
+	id := first(requestIDMetadata)
+	if !validRequestID.MatchString(id) {
+		id = newRequestID()
+	}
+	ctx = stock.WithRequestID(ctx, id)
+	grpc.SetHeader(ctx, metadata.Pairs(requestIDMetadata, id))
+
+	apiKey, failure := checkCredentials(first(authKeyMetadata), first(authSecretMetadata))
+	if failure != nil {
+		code := codes.Unauthenticated
+		if failure.status == http.StatusInternalServerError {
+			code = codes.Internal
+		}
+		return ctx, status.Error(code, failure.message)
+	}
+	if !apiKey.HasScope(stock.ScopeRead) {
+		return ctx, status.Error(codes.PermissionDenied, "Key Lacks Scope: "+stock.ScopeRead)
+	}
+	// refused before it's counted, only a batch can be more than one request
+	if n > maxBatch {
+		return ctx, status.Errorf(codes.InvalidArgument, "At most %d requests can be batched, got %d", maxBatch, n)
+	}
+
+	_, refused, err := i.limiter.admit(apiKey, n, time.Now().UTC())
+	if err != nil {
+		return ctx, status.Error(codes.Internal, "Could not record usage")
+	}
+	if refused != nil && refused.exceedsBurst {
+		return ctx, status.Error(codes.InvalidArgument, refused.message)
+	}
+	if refused != nil {
+		grpc.SetTrailer(ctx, metadata.Pairs("retry-after", strconv.Itoa(int(math.Ceil(refused.retryAfter.Seconds())))))
+		return ctx, status.Error(codes.ResourceExhausted, refused.message)
+	}
+	return context.WithValue(ctx, apiKeyContextKey, apiKey), nil
+}
+
+// a server stream with the context given by the interceptor
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s contextStream) Context() context.Context {
+	return s.ctx
+}
+
+func logCall(ctx context.Context, method string, start time.Time, err error) {
+	code := status.Code(err)
+	level := slog.LevelInfo
+	switch code {
+	case codes.Internal, codes.Unknown, codes.Unavailable:
+		level = slog.LevelError
+	}
+	slog.Log(ctx, level, "Call served",
+		"method", method,
+		"code", code.String(),
+		"duration", time.Since(start))
+}
+
+// trendyService implements the gRPC api with the stock package, as the REST handlers do
+type trendyService struct {
+	rpc.UnimplementedTrendyServer
+}
+
+func (trendyService) GetBars(ctx context.Context, req *rpc.GetBarsRequest) (*rpc.Bars, error) {
+	s, startTime, endTime, err := parseRangeRequest(req.Symbol, req.Start, req.End)
+	if err != nil {
+		return nil, err
+	}
+	span, err := s.RangeContext(ctx, startTime, endTime)
+	if err != nil {
+		return nil, rangeError(ctx, s, err)
+	}
+
+	bars := &rpc.Bars{Symbol: s.Symbol, Bars: make([]*rpc.Bar, 0, len(span))}
+	for _, measure := range span {
+		bars.Bars = append(bars.Bars, newBar(s.Symbol, measure))
+	}
+	return bars, nil
+}
+
+func (t trendyService) BatchGetBars(ctx context.Context, req *rpc.BatchGetBarsRequest) (*rpc.BatchGetBarsResponse, error) {
+	results := make([]*rpc.BarsResult, len(req.Requests))
+	errs := forEachRequest(ctx, rpc.Trendy_BatchGetBars_FullMethodName, len(req.Requests), func(i int) error {
+		bars, err := t.GetBars(ctx, req.Requests[i])
+		if err == nil {
+			results[i] = &rpc.BarsResult{Result: &rpc.BarsResult_Bars{Bars: bars}}
+		}
+		return err
+	})
+	for i, err := range errs {
+		if err != nil {
+			results[i] = &rpc.BarsResult{Result: &rpc.BarsResult_Error{Error: status.Convert(err).Message()}}
+		}
+	}
+	if ctx.Err() != nil {
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+	return &rpc.BatchGetBarsResponse{Results: results}, nil
+}
+
+func (trendyService) GetTrend(ctx context.Context, req *rpc.GetTrendRequest) (*rpc.Trend, error) {
+	s, startTime, endTime, err := parseRangeRequest(req.Symbol, req.Start, req.End)
+	if err != nil {
+		return nil, err
+	}
+	trend, err := s.AnalyzeContext(ctx, startTime, endTime)
+	if err == stock.ErrTooFewMeasures {
+		return nil, status.Errorf(codes.FailedPrecondition, "%v [%s:%s-%s]", err, s.Symbol, req.Start, req.End)
+	} else if err != nil {
+		return nil, rangeError(ctx, s, err)
+	}
+
+	return &rpc.Trend{
+		Symbol:      s.Symbol,
+		Start:       timestamppb.New(trend.Start),
+		End:         timestamppb.New(trend.End),
+		SlopePerDay: trend.SlopePerDay,
+		Intercept:   trend.Intercept,
+		RSquared:    trend.RSquared,
+		Change:      trend.Change,
+	}, nil
+}
+
+func (t trendyService) BatchGetTrends(ctx context.Context, req *rpc.BatchGetTrendsRequest) (*rpc.BatchGetTrendsResponse, error) {
+	results := make([]*rpc.TrendResult, len(req.Requests))
+	errs := forEachRequest(ctx, rpc.Trendy_BatchGetTrends_FullMethodName, len(req.Requests), func(i int) error {
+		trend, err := t.GetTrend(ctx, req.Requests[i])
+		if err == nil {
+			results[i] = &rpc.TrendResult{Result: &rpc.TrendResult_Trend{Trend: trend}}
+		}
+		return err
+	})
+	for i, err := range errs {
+		if err != nil {
+			results[i] = &rpc.TrendResult{Result: &rpc.TrendResult_Error{Error: status.Convert(err).Message()}}
+		}
+	}
+	if ctx.Err() != nil {
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+	return &rpc.BatchGetTrendsResponse{Results: results}, nil
+}
+
+// StreamBars sends the updates published to streamBroker, like GetStream,
+// until the client cancels or the server shuts down
+func (trendyService) StreamBars(req *rpc.StreamBarsRequest, stream rpc.Trendy_StreamBarsServer) error {
+	symbols := parseSymbols(strings.Join(req.Symbols, ","))
+	if len(symbols) == 0 {
+		return status.Error(codes.InvalidArgument, "Must provide symbols to stream")
+	}
+	for _, symbol := range symbols {
+		if err := stock.CheckSymbol(stream.Context(), symbol); errors.Is(err, stock.ErrSymbolNotFound) {
+			return status.Error(codes.NotFound, err.Error())
+		} else if err != nil {
+			return status.Error(codes.Internal, "Could not check symbols")
+		}
+	}
+
+	sub := streamBroker.Subscribe(symbols, streamBuffer)
+	defer sub.Close()
+
+	// headers are otherwise held until the first update
+	if err := stream.SendHeader(nil); err != nil {
+		return err
+	}
+
+	closed := streamsClosed()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-closed:
+			return nil
+		case update, ok := <-sub.C:
+			if !ok {
+				return nil
+			}
+			if dropped := sub.Dropped(); dropped > 0 {
+				if err := stream.Send(&rpc.StreamBarsResponse{Event: &rpc.StreamBarsResponse_Dropped{Dropped: int64(dropped)}}); err != nil {
+					return err
+				}
+			}
+			if err := stream.Send(&rpc.StreamBarsResponse{Event: &rpc.StreamBarsResponse_Bar{Bar: newBar(update.Symbol, update.Measure)}}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// the bar for a measure, open, high, low and volume are unset when unknown as
+// they're left out of REST responses
+func newBar(symbol string, measure stock.Measure) *rpc.Bar {
+	bar := &rpc.Bar{Symbol: symbol, Time: timestamppb.New(measure.Time), Close: measure.Value}
+	if measure.HasBar() {
+		bar.Open, bar.High, bar.Low = proto.Float32(measure.Open), proto.Float32(measure.High), proto.Float32(measure.Low)
+	}
+	if measure.Volume != 0 {
+		bar.Volume = proto.Int64(measure.Volume)
+	}
+	return bar
+}
+
+// the stock and times for a request, start and end are YYYY-MM-DD in New York as for GetStock
+func parseRangeRequest(symbol string, start string, end string) (*stock.Stock, time.Time, time.Time, error) {
+	var startTime, endTime time.Time
+	symbol = strings.TrimSpace(symbol)
+	if symbol == "" {
+		return nil, startTime, endTime, status.Error(codes.InvalidArgument, "Must provide a symbol")
+	}
+	var err error
+	if start != "" {
+		if startTime, err = parseDay("start", start); err != nil {
+			return nil, startTime, endTime, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	if end != "" {
+		if endTime, err = parseDay("end", end); err != nil {
+			return nil, startTime, endTime, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	return stock.NewStock(symbol), startTime, endTime, nil
+}
+
+// the status for an error getting a range for s
+func rangeError(ctx context.Context, s *stock.Stock, err error) error {
+	if ctx.Err() != nil {
+		return status.FromContextError(ctx.Err()).Err()
+	}
+	if errors.Is(err, stock.ErrSymbolNotFound) {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	if errors.Is(err, stock.ErrDelisted) {
+		return status.Error(codes.OutOfRange, err.Error())
+	}
+	if errors.Is(err, stock.ErrNoData) {
+		return status.Errorf(codes.NotFound, "No data for stock [%s]", s.Symbol)
+	}
+	if err == stock.ErrCircuitOpen {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	return status.Errorf(codes.Internal, "Could not get range for stock [%s]", s.Symbol)
+}
+
+// call fn for each index below n, batchConcurrency at a time, returning the
+// error for each. A panic in fn is the error for that index alone.
+func forEachRequest(ctx context.Context, method string, n int, fn func(i int) error) []error {
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, batchConcurrency)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			errs[i] = recovered(ctx, method, func() error { return fn(i) })
+		}(i)
+	}
+	wg.Wait()
+	return errs
+}
@@ -0,0 +1,239 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/jhurwich/trendy/rpc"
+	"github.com/jhurwich/trendy/stock"
+	"github.com/jhurwich/trendy/testhelpers"
+)
+
+func TestGRPC(t *testing.T) {
+	_, discard := testhelpers.UseStore(t)
+	defer discard()
+	defaultProvider := stock.DefaultProvider
+	stock.DefaultProvider = stock.SyntheticProvider{}
+	defer func() { stock.DefaultProvider = defaultProvider }()
+
+	readKey, readSecret, err := stock.NewAPIKey("grpc", []string{stock.ScopeRead}, nil)
+	if err != nil || stock.DB.InsertAPIKey(readKey) != nil {
+		t.Fatalf("Could not create a key: %v", err)
+	}
+	adminKey, adminSecret, err := stock.NewAPIKey("grpc admin", []string{stock.ScopeAdmin}, nil)
+	if err != nil || stock.DB.InsertAPIKey(adminKey) != nil {
+		t.Fatalf("Could not create a key: %v", err)
+	}
+
+	listener := bufconn.Listen(1 << 20)
+	server := NewGRPCServer(nil)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := rpc.NewTrendyClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	withKey := func(key string, secret string) context.Context {
+		return metadata.AppendToOutgoingContext(ctx, authKeyMetadata, key, authSecretMetadata, secret)
+	}
+	authed := withKey(readKey.Key, readSecret)
+	june := &rpc.GetBarsRequest{Symbol: "GOOG", Start: "2015-06-01", End: "2015-06-30"}
+
+	// credentials are checked like the REST api's
+	var authTests = []struct {
+		ctx  context.Context
+		code codes.Code
+	}{
+		{ctx, codes.Unauthenticated},
+		{withKey(readKey.Key, "wrong"), codes.Unauthenticated},
+		{withKey(adminKey.Key, adminSecret), codes.PermissionDenied},
+	}
+	for _, test := range authTests {
+		if _, err := client.GetBars(test.ctx, june); status.Code(err) != test.code {
+			t.Errorf("Expected %s, got %v", test.code, err)
+		}
+	}
+
+	// the same bars as GetStock, with the request id returned in the header
+	var header metadata.MD
+	bars, err := client.GetBars(metadata.AppendToOutgoingContext(authed, requestIDMetadata, "grpc-test"), june, grpc.Header(&header))
+	if err != nil {
+		t.Fatalf("Unexpected error getting bars: %v", err)
+	}
+	span, _ := stock.NewStock("GOOG").Range(time.Date(2015, time.June, 1, 4, 0, 0, 0, time.UTC), time.Date(2015, time.June, 30, 4, 0, 0, 0, time.UTC))
+	if bars.Symbol != "GOOG" || len(bars.Bars) != len(span) || len(span) == 0 {
+		t.Errorf("Expected %d bars for GOOG, got %d for %s", len(span), len(bars.Bars), bars.Symbol)
+	} else if first := bars.Bars[0]; first.Close != span[0].Value || !first.Time.AsTime().Equal(span[0].Time) ||
+		first.GetOpen() != span[0].Open || first.GetHigh() != span[0].High || first.GetLow() != span[0].Low || first.GetVolume() != span[0].Volume {
+		t.Errorf("Expected first bar %+v, got %v", span[0], first)
+	}
+	if ids := header.Get(requestIDMetadata); len(ids) != 1 || ids[0] != "grpc-test" {
+		t.Errorf("Expected the request id in the header, got %v", ids)
+	}
+
+	if _, err := client.GetBars(authed, &rpc.GetBarsRequest{Symbol: "GOOG", Start: "June"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected %s for a bad start, got %v", codes.InvalidArgument, err)
+	}
+
+	// a bad request in a batch doesn't fail the others
+	batch, err := client.BatchGetBars(authed, &rpc.BatchGetBarsRequest{Requests: []*rpc.GetBarsRequest{june, {Symbol: ""}}})
+	if err != nil {
+		t.Fatalf("Unexpected error getting a batch: %v", err)
+	}
+	if len(batch.Results) != 2 || batch.Results[0].GetBars() == nil || batch.Results[1].GetError() == "" {
+		t.Errorf("Expected bars then an error, got %v", batch.Results)
+	}
+
+	// a batch that's too large is refused before it's counted, even within the burst
+	largeKey, largeSecret, err := stock.NewAPIKey("grpc large", []string{stock.ScopeRead}, nil)
+	largeKey.RateLimit, largeKey.Burst = 1, 1000
+	if err != nil || stock.DB.InsertAPIKey(largeKey) != nil {
+		t.Fatalf("Could not create a key: %v", err)
+	}
+	if _, err := client.BatchGetBars(withKey(largeKey.Key, largeSecret), &rpc.BatchGetBarsRequest{Requests: make([]*rpc.GetBarsRequest, maxBatch+1)}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected %s for a batch that's too large, got %v", codes.InvalidArgument, err)
+	}
+	if usage, _ := stock.DB.GetUsage(largeKey.Key, time.Now().UTC().Format("2006-01-02")); usage != 0 {
+		t.Errorf("Expected a batch that's too large not to be counted, got usage %d", usage)
+	}
+
+	// a batch is counted as each of its requests against the rate limit and quotas
+	limitedKey, limitedSecret, err := stock.NewAPIKey("grpc limited", []string{stock.ScopeRead}, nil)
+	limitedKey.RateLimit, limitedKey.Burst, limitedKey.DailyQuota = 0.001, 3, 4
+	if err != nil || stock.DB.InsertAPIKey(limitedKey) != nil {
+		t.Fatalf("Could not create a key: %v", err)
+	}
+	limited := withKey(limitedKey.Key, limitedSecret)
+	batchOf := func(n int) *rpc.BatchGetBarsRequest {
+		req := &rpc.BatchGetBarsRequest{}
+		for i := 0; i < n; i++ {
+			req.Requests = append(req.Requests, june)
+		}
+		return req
+	}
+	var limitTests = []struct {
+		n     int
+		code  codes.Code
+		usage int
+	}{
+		{4, codes.InvalidArgument, 0}, // more than the burst
+		{2, codes.OK, 2},
+		{2, codes.ResourceExhausted, 2}, // one token left
+		{1, codes.OK, 3},
+	}
+	for _, test := range limitTests {
+		_, err := client.BatchGetBars(limited, batchOf(test.n))
+		usage, _ := stock.DB.GetUsage(limitedKey.Key, time.Now().UTC().Format("2006-01-02"))
+		if status.Code(err) != test.code || usage != test.usage {
+			t.Errorf("Expected %s with usage %d for a batch of %d, got %v with %d", test.code, test.usage, test.n, err, usage)
+		}
+	}
+	limitedKey.Burst = 10
+	if err := stock.DB.UpdateAPIKeyLimits(limitedKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.BatchGetBars(limited, batchOf(2)); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Expected %s for a batch over the daily quota, got %v", codes.ResourceExhausted, err)
+	}
+	if _, err := client.GetBars(limited, june); err != nil {
+		t.Errorf("Expected the last request of the quota admitted, got %v", err)
+	}
+
+	trend, err := client.GetTrend(authed, &rpc.GetTrendRequest{Symbol: "GOOG", Start: "2015-06-01", End: "2015-06-30"})
+	if err != nil {
+		t.Fatalf("Unexpected error getting trend: %v", err)
+	}
+	expected, _ := span.Trend()
+	if trend.SlopePerDay != expected.SlopePerDay || trend.RSquared != expected.RSquared || !trend.Start.AsTime().Equal(expected.Start) {
+		t.Errorf("Expected trend %+v, got %v", expected, trend)
+	}
+	trends, err := client.BatchGetTrends(authed, &rpc.BatchGetTrendsRequest{Requests: []*rpc.GetTrendRequest{{Symbol: "GOOG", Start: "2015-06-01", End: "2015-06-01"}}})
+	if err != nil || len(trends.Results) != 1 || trends.Results[0].GetError() == "" {
+		t.Errorf("Expected an error for a trend of one day, got %v %v", trends, err)
+	}
+
+	// unknown symbols aren't streamed once listings are loaded
+	stock.LoadListings(ctx, []stock.Listing{{Symbol: "GOOG", Name: "Alphabet Inc Class C"}, {Symbol: "GRPCTEST", Name: "gRPC Test"}})
+	bogus, err := client.StreamBars(authed, &rpc.StreamBarsRequest{Symbols: []string{"GRPCTEST", "BOGUS"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bogus.Recv(); status.Code(err) != codes.NotFound {
+		t.Errorf("Expected %s for an unknown symbol, got %v", codes.NotFound, err)
+	}
+
+	// streams are published to by the same broker as GetStream
+	stream, err := client.StreamBars(authed, &rpc.StreamBarsRequest{Symbols: []string{"GRPCTEST"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Header(); err != nil {
+		t.Fatal(err)
+	}
+	measure := stock.Measure{Time: time.Date(2015, time.June, 1, 12, 0, 0, 0, time.UTC), Value: 1.5}
+	streamBroker.Publish(stock.Update{Symbol: "GRPCTEST", Measure: measure})
+	event, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bar := event.GetBar(); bar == nil || bar.Symbol != "GRPCTEST" || bar.Close != 1.5 || !bar.Time.AsTime().Equal(measure.Time) {
+		t.Errorf("Expected a bar for the published measure, got %v", event)
+	} else if bar.Open != nil || bar.Volume != nil {
+		t.Errorf("Expected only a close for a measure without a bar, got %v", bar)
+	}
+
+	// shutting down ends the stream
+	closeStreams()
+	if _, err := stream.Recv(); err != io.EOF {
+		t.Errorf("Expected the stream to end cleanly on shutdown, got %v", err)
+	}
+}
+
+func TestGRPCRecovery(t *testing.T) {
+	_, discard := testhelpers.UseStore(t)
+	defer discard()
+	key, secret, err := stock.NewAPIKey("grpc", []string{stock.ScopeRead}, nil)
+	if err != nil || stock.DB.InsertAPIKey(key) != nil {
+		t.Fatalf("Could not create a key: %v", err)
+	}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(authKeyMetadata, key.Key, authSecretMetadata, secret))
+	interceptor := &grpcInterceptor{limiter: NewRateLimiter()}
+
+	// a panicking handler fails its call, not the server
+	panicking := func(ctx context.Context, req interface{}) (interface{}, error) { panic("handler bug") }
+	info := &grpc.UnaryServerInfo{FullMethod: rpc.Trendy_GetBars_FullMethodName}
+	if _, err := interceptor.unary(ctx, &rpc.GetBarsRequest{}, info, panicking); status.Code(err) != codes.Internal {
+		t.Errorf("Expected %s for a panicking handler, got %v", codes.Internal, err)
+	}
+
+	// and a panic in a batch fails only its request
+	errs := forEachRequest(ctx, rpc.Trendy_BatchGetBars_FullMethodName, 3, func(i int) error {
+		if i == 1 {
+			panic("request bug")
+		}
+		return nil
+	})
+	if errs[0] != nil || status.Code(errs[1]) != codes.Internal || errs[2] != nil {
+		t.Errorf("Expected only the panicking request to fail, got %v", errs)
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/jhurwich/trendy/stock"
+)
+
+// every symbol is refused until listings are loaded, unless unlisted symbols are allowed
+var errNoListings = errors.New("No listings loaded, every symbol is refused, load them with -load-symbols or set -allow-unlisted")
+
+// how long /readyz waits on the database before reporting it unreachable
+var readinessTimeout = 2 * time.Second
+
+var (
+	started              = time.Now().UTC()
+	scheduler            *stock.Scheduler // set in main when there's a watchlist
+	notModifiedResponses int64            // conditional requests answered 304 by GetStock
+)
+
+// responds 200 whenever the process is serving, it checks nothing else
+func GetHealth(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	writeJSON(w, http.StatusOK, map[string]string{"Status": "ok"})
+}
+
+// Check is the result of one readiness check
+type Check struct {
+	Name  string
+	OK    bool
+	Error string `json:",omitempty"`
+}
+
+// responds 200 if every readiness check passes, 503 otherwise, with the checks
+func GetReady(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	checks := ReadinessChecks(r.Context(), time.Now())
+	ready := true
+	for _, check := range checks {
+		ready = ready && check.OK
+	}
+	status := http.StatusOK
+	if !ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, struct {
+		Ready  bool
+		Checks []Check
+	}{ready, checks})
+}
+
+// ReadinessChecks are whether the database is reachable, its migrations are
+// current, listings are loaded unless unlisted symbols are allowed and, when
+// Markit is the provider, its circuit breaker isn't open.
+// The database has until readinessTimeout to answer so a hung one can't hang
+// the probe.
+func ReadinessChecks(ctx context.Context, now time.Time) []Check {
+	checks := []Check{}
+	add := func(name string, err error) {
+		check := Check{Name: name, OK: err == nil}
+		if err != nil {
+			check.Error = err.Error()
+		}
+		checks = append(checks, check)
+	}
+
+	if stock.DB == nil {
+		add("database", fmt.Errorf("Not configured"))
+		return checks
+	}
+	pingCtx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+	add("database", stock.DB.PingContext(pingCtx))
+
+	version, err := stock.DB.MigrationVersion()
+	if err == nil && version < stock.SchemaVersion {
+		err = fmt.Errorf("Database is at migration %d, expected %d", version, stock.SchemaVersion)
+	}
+	add("migrations", err)
+
+	if !stock.AllowUnlisted {
+		loaded, err := stock.ListingsLoaded(ctx)
+		if err == nil && !loaded {
+			err = errNoListings
+		}
+		add("symbols", err)
+	}
+
+	if _, ok := stock.DefaultProvider.(stock.MarkitProvider); ok {
+		err = nil
+		if stock.MarkitClient.Breaker.State(now) == stock.BreakerOpen {
+			err = stock.ErrCircuitOpen
+		}
+		add("provider", err)
+	}
+	return checks
+}
+
+// what the process is and how it's doing, for operators
+type debugStatus struct {
+	Build       buildInfo
+	Started     time.Time
+	Uptime      string
+	Cache       stock.CacheStats
+	NotModified int64
+	Pool        *sql.DBStats           `json:",omitempty"` // only for Postgres
+	Scheduler   *stock.SchedulerStatus `json:",omitempty"` // only with a watchlist
+	Provider    providerStatus
+	Migrations  migrationStatus
+}
+
+type buildInfo struct {
+	GoVersion string
+	Path      string
+	Version   string
+	Revision  string `json:",omitempty"`
+	Time      string `json:",omitempty"`
+	Modified  bool
+}
+
+type providerStatus struct {
+	Name    string
+	Breaker string
+}
+
+type migrationStatus struct {
+	Expected int
+	Current  int
+	Error    string `json:",omitempty"`
+}
+
+// responds with a debugStatus as JSON
+func GetDebugStatus(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	now := time.Now().UTC()
+	status := debugStatus{
+		Build:       readBuildInfo(),
+		Started:     started,
+		Uptime:      now.Sub(started).Round(time.Second).String(),
+		Cache:       stock.ReadCacheStats(),
+		NotModified: atomic.LoadInt64(&notModifiedResponses),
+		Provider: providerStatus{
+			Name:    fmt.Sprintf("%T", stock.DefaultProvider),
+			Breaker: stock.MarkitClient.Breaker.State(now),
+		},
+		Migrations: migrationStatus{Expected: stock.SchemaVersion},
+	}
+
+	if db, ok := stock.DB.(*stock.StockDB); ok {
+		stats := db.Stats()
+		status.Pool = &stats
+	}
+	if scheduler != nil {
+		schedulerStatus := scheduler.Status()
+		status.Scheduler = &schedulerStatus
+	}
+	if stock.DB != nil {
+		version, err := stock.DB.MigrationVersion()
+		status.Migrations.Current = version
+		if err != nil {
+			status.Migrations.Error = err.Error()
+		}
+	}
+
+	writeJSON(w, http.StatusOK, status)
+}
+
+func readBuildInfo() buildInfo {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return buildInfo{}
+	}
+	build := buildInfo{GoVersion: info.GoVersion, Path: info.Main.Path, Version: info.Main.Version}
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			build.Revision = setting.Value
+		case "vcs.time":
+			build.Time = setting.Value
+		case "vcs.modified":
+			build.Modified = setting.Value == "true"
+		}
+	}
+	return build
+}
@@ -0,0 +1,132 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jhurwich/trendy/config"
+	"github.com/jhurwich/trendy/stock"
+	"github.com/jhurwich/trendy/testhelpers"
+)
+
+// a store that hasn't had the latest migration
+type staleStore struct {
+	*stock.MemoryStore
+}
+
+func (s staleStore) MigrationVersion() (int, error) {
+	return stock.SchemaVersion - 1, nil
+}
+
+// a store whose database never answers
+type hungStore struct {
+	*stock.MemoryStore
+}
+
+func (s hungStore) PingContext(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	_, discard := testhelpers.UseStore(t)
+	defer discard()
+	ts := NewTrendyServer(&config.Config{Local: true})
+
+	get := func(path string, authenticated bool) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("GET", path, nil)
+		if authenticated {
+			r.Header.Set("X-Auth-Key", "key")
+			r.Header.Set("X-Auth-Secret", "secret")
+		}
+		w := httptest.NewRecorder()
+		ts.ServeHTTP(w, r)
+		return w
+	}
+
+	// probes need no credentials
+	if w := get("/healthz", false); w.Code != http.StatusOK {
+		t.Errorf("Expected /healthz to be %d without credentials, got %d", http.StatusOK, w.Code)
+	}
+	if w := get("/readyz", false); w.Code != http.StatusOK {
+		t.Errorf("Expected /readyz to be %d without credentials, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+
+	// an open circuit makes the server unready
+	breaker := stock.MarkitClient.Breaker
+	stock.MarkitClient.Breaker = &stock.Breaker{Threshold: 1, Cooldown: time.Hour}
+	stock.MarkitClient.Breaker.Failure(time.Now())
+	w := get("/readyz", false)
+	stock.MarkitClient.Breaker = breaker
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected /readyz to be %d with the circuit open, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+	var readiness struct {
+		Ready  bool
+		Checks []Check
+	}
+	json.Unmarshal(w.Body.Bytes(), &readiness)
+	for _, check := range readiness.Checks {
+		if check.OK != (check.Name != "provider") {
+			t.Errorf("Expected only the provider check to fail, got %+v", readiness.Checks)
+		}
+	}
+
+	// and so does a database that hasn't been migrated
+	store := stock.DB
+	stock.DB = staleStore{stock.NewMemoryStore()}
+	w = get("/readyz", false)
+	stock.DB = store
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected /readyz to be %d before migrating, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+
+	// and no listings, unless unlisted symbols are allowed
+	stock.AllowUnlisted = false
+	w = get("/readyz", false)
+	stock.AllowUnlisted = true
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "No listings loaded") {
+		t.Errorf("Expected /readyz to be %d without listings, got %d: %s", http.StatusServiceUnavailable, w.Code, w.Body)
+	}
+	stock.LoadListings(context.Background(), []stock.Listing{{Symbol: "GOOG", Name: "Alphabet Inc Class C"}})
+	stock.AllowUnlisted = false
+	w = get("/readyz", false)
+	stock.AllowUnlisted = true
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected /readyz to be %d with listings, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+
+	// and a database that doesn't answer in time
+	timeout := readinessTimeout
+	readinessTimeout = 10 * time.Millisecond
+	stock.DB = hungStore{stock.NewMemoryStore()}
+	w = get("/readyz", false)
+	stock.DB = store
+	readinessTimeout = timeout
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected /readyz to be %d when the database hangs, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+
+	// diagnostics are for admins only
+	if w := get("/debug/status", false); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected /debug/status to need credentials, got %d", w.Code)
+	}
+	w = get("/debug/status", true)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected /debug/status to be %d for an admin, got %d", http.StatusOK, w.Code)
+	}
+	var status debugStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Build.GoVersion == "" || status.Started.IsZero() || status.Migrations.Current != stock.SchemaVersion || status.Provider.Breaker != stock.BreakerClosed {
+		t.Errorf("Unexpected status: %s", w.Body)
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+// Package limit provides a token bucket rate limiter
+package limit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Bucket holds up to Burst tokens and refills at Rate tokens per second. Each
+// allowed event takes one token, events are refused while the bucket is empty.
+type Bucket struct {
+	Rate  float64
+	Burst int
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket returns a full bucket
+func NewBucket(rate float64, burst int) *Bucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Bucket{Rate: rate, Burst: burst, tokens: float64(burst)}
+}
+
+// refill tokens for the time elapsed since the last call, b.mu must be held
+func (b *Bucket) refill(now time.Time) {
+	if !b.last.IsZero() && now.After(b.last) {
+		b.tokens = math.Min(float64(b.Burst), b.tokens+now.Sub(b.last).Seconds()*b.Rate)
+	}
+	if now.After(b.last) {
+		b.last = now
+	}
+}
+
+// Allow takes a token if one is available at now. It returns whether the event
+// is allowed, the whole tokens remaining and how long until the next token.
+func (b *Bucket) Allow(now time.Time) (bool, int, time.Duration) {
+	return b.AllowN(now, 1)
+}
+
+// AllowN is Allow for an event that takes n tokens, none are taken unless all
+// n are available. The wait returned is until n tokens are, an event of more
+// than Burst tokens is never allowed.
+func (b *Bucket) AllowN(now time.Time, n int) (bool, int, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+
+	allowed := b.tokens >= float64(n)
+	if allowed {
+		b.tokens -= float64(n)
+	}
+	return allowed, int(b.tokens), b.untilTokens(n)
+}
+
+// Reserve takes a token whether or not one is available and returns how long
+// the caller must wait before acting on it, for callers that queue rather than refuse
+func (b *Bucket) Reserve(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+
+	b.tokens--
+	if b.tokens >= 0 || b.Rate <= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.Rate * float64(time.Second))
+}
+
+// time until the bucket holds n whole tokens, b.mu must be held
+func (b *Bucket) untilTokens(n int) time.Duration {
+	if b.tokens >= float64(n) || b.Rate <= 0 {
+		return 0
+	}
+	return time.Duration((float64(n) - b.tokens) / b.Rate * float64(time.Second))
+}
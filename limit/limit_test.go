@@ -0,0 +1,85 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package limit_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jhurwich/trendy/limit"
+)
+
+func TestBucketAllow(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2015, time.June, 1, 12, 0, 0, 0, time.UTC)
+	b := limit.NewBucket(2, 3) // 2 per second, burst of 3
+
+	// the burst is available immediately
+	for i := 2; i >= 0; i-- {
+		allowed, remaining, _ := b.Allow(now)
+		if !allowed || remaining != i {
+			t.Errorf("Expected allowed with %d remaining, got %t with %d", i, allowed, remaining)
+		}
+	}
+
+	// then events are refused until a token refills
+	allowed, remaining, wait := b.Allow(now)
+	if allowed || remaining != 0 || wait != 500*time.Millisecond {
+		t.Errorf("Expected refused with 500ms wait, got %t %d %s", allowed, remaining, wait)
+	}
+	if allowed, _, _ := b.Allow(now.Add(499 * time.Millisecond)); allowed {
+		t.Errorf("Expected refused before a token refills")
+	}
+	if allowed, _, _ := b.Allow(now.Add(500 * time.Millisecond)); !allowed {
+		t.Errorf("Expected allowed once a token refills")
+	}
+
+	// refilling never exceeds the burst
+	later := now.Add(time.Hour)
+	b.Allow(later)
+	if _, remaining, _ := b.Allow(later); remaining != 1 {
+		t.Errorf("Expected bucket capped at burst, %d remaining after two events", remaining)
+	}
+
+	// time going backwards doesn't add tokens
+	if _, remaining, _ := b.Allow(now); remaining != 0 {
+		t.Errorf("Expected no refill for an earlier time, %d remaining", remaining)
+	}
+}
+
+func TestBucketAllowN(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2015, time.June, 1, 12, 0, 0, 0, time.UTC)
+	b := limit.NewBucket(2, 5) // 2 per second, burst of 5
+
+	if allowed, remaining, _ := b.AllowN(now, 3); !allowed || remaining != 2 {
+		t.Errorf("Expected 3 tokens allowed with 2 remaining, got %t with %d", allowed, remaining)
+	}
+
+	// no tokens are taken for a refused event
+	allowed, remaining, wait := b.AllowN(now, 3)
+	if allowed || remaining != 2 || wait != 500*time.Millisecond {
+		t.Errorf("Expected refused with 2 remaining and 500ms wait, got %t %d %s", allowed, remaining, wait)
+	}
+	if allowed, _, _ := b.AllowN(now.Add(500*time.Millisecond), 3); !allowed {
+		t.Errorf("Expected allowed once enough tokens refill")
+	}
+
+	// more than the burst is never allowed
+	if allowed, _, _ := b.AllowN(now.Add(time.Hour), 6); allowed {
+		t.Errorf("Expected refused for more tokens than the burst")
+	}
+}
+
+func TestBucketReserve(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2015, time.June, 1, 12, 0, 0, 0, time.UTC)
+	b := limit.NewBucket(10, 1)
+
+	var expected = []time.Duration{0, 100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond}
+	for _, e := range expected {
+		if wait := b.Reserve(now); wait != e {
+			t.Errorf("Expected reservation wait of %s, got %s", e, wait)
+		}
+	}
+}
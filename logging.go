@@ -0,0 +1,81 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/codegangsta/negroni"
+
+	"github.com/jhurwich/trendy/config"
+	"github.com/jhurwich/trendy/stock"
+)
+
+// SetupLogging makes the default logger write records at the configured level
+// and above to w, each with the request_id of its context if it has one
+func SetupLogging(cfg *config.Config, w io.Writer) error {
+	level, err := cfg.Log.SlogLevel()
+	if err != nil {
+		return err
+	}
+	options := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler = slog.NewJSONHandler(w, options)
+	if cfg.Log.Format == config.LogText {
+		handler = slog.NewTextHandler(w, options)
+	}
+	slog.SetDefault(slog.New(stock.NewContextHandler(handler)))
+	return nil
+}
+
+const requestIDHeader = "X-Request-ID"
+
+// ids given by clients or proxies are kept if they look like ids
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// RequestLogger is negroni middleware that gives every request an id, passed
+// through its context to the stock package and returned in the X-Request-ID
+// header, then logs the request once it's been served
+type RequestLogger struct{}
+
+func (l RequestLogger) ServeHTTP(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	start := time.Now()
+	id := r.Header.Get(requestIDHeader)
+	if !validRequestID.MatchString(id) {
+		id = newRequestID()
+	}
+	w.Header().Set(requestIDHeader, id)
+	r = r.WithContext(stock.WithRequestID(r.Context(), id))
+
+	next(w, r)
+
+	status, size := http.StatusOK, 0
+	if rw, ok := w.(negroni.ResponseWriter); ok {
+		if rw.Status() != 0 {
+			status = rw.Status()
+		}
+		size = rw.Size()
+	}
+	level := slog.LevelInfo
+	if status >= http.StatusInternalServerError {
+		level = slog.LevelError
+	}
+	slog.Log(r.Context(), level, "Request served",
+		"method", r.Method,
+		"path", r.URL.Path,
+		"status", status,
+		"bytes", size,
+		"duration", time.Since(start),
+		"remote", r.RemoteAddr)
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,73 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jhurwich/trendy/config"
+	"github.com/jhurwich/trendy/stock"
+	"github.com/jhurwich/trendy/testhelpers"
+)
+
+func TestRequestLogging(t *testing.T) {
+	_, discard := testhelpers.UseStore(t)
+	defer discard()
+	defaultProvider := stock.DefaultProvider
+	stock.DefaultProvider = stock.SyntheticProvider{}
+	defer func() { stock.DefaultProvider = defaultProvider }()
+
+	var logs bytes.Buffer
+	defaultLogger := slog.Default()
+	defer slog.SetDefault(defaultLogger)
+	if err := SetupLogging(&config.Config{Log: config.Log{Level: "debug", Format: config.LogJSON}}, &logs); err != nil {
+		t.Fatal(err)
+	}
+	ts := NewTrendyServer(&config.Config{Local: true})
+
+	get := func(id string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/stock/GOOG?start=2015-06-01&end=2015-06-30", nil)
+		r.Header.Set("X-Auth-Key", "key")
+		r.Header.Set("X-Auth-Secret", "secret")
+		if id != "" {
+			r.Header.Set(requestIDHeader, id)
+		}
+		w := httptest.NewRecorder()
+		ts.ServeHTTP(w, r)
+		return w
+	}
+
+	// an id from the client is kept and logged by every layer the request reaches
+	w := get("trace-1")
+	if w.Code != http.StatusOK || w.Header().Get(requestIDHeader) != "trace-1" {
+		t.Fatalf("Expected %d with the client's request id, got %d %q", http.StatusOK, w.Code, w.Header().Get(requestIDHeader))
+	}
+	messages := map[string]bool{}
+	for _, line := range bytes.Split(bytes.TrimSpace(logs.Bytes()), []byte("\n")) {
+		var record map[string]interface{}
+		if err := json.Unmarshal(line, &record); err != nil {
+			t.Fatalf("Expected JSON records, got %s", line)
+		}
+		if record["request_id"] == "trace-1" {
+			messages[record["msg"].(string)] = true
+		}
+	}
+	for _, expected := range []string{"Store lookup", "Provider fetch", "Store insert", "Range served", "Request served"} {
+		if !messages[expected] {
+			t.Errorf("Expected %q to be logged with the request id, got %v", expected, messages)
+		}
+	}
+
+	// otherwise one is made up
+	for _, id := range []string{"", "not an id"} {
+		w = get(id)
+		if generated := w.Header().Get(requestIDHeader); generated == "" || generated == id {
+			t.Errorf("Expected a new request id for %q, got %q", id, generated)
+		}
+	}
+}
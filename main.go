@@ -3,22 +3,40 @@
 package main
 
 import (
-	"encoding/json"
+	"bytes"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/codegangsta/negroni"
 	"github.com/julienschmidt/httprouter"
-	"github.com/pjebs/restgate"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/unrolled/secure"
 
+	"github.com/jhurwich/trendy/config"
+	"github.com/jhurwich/trendy/limit"
 	"github.com/jhurwich/trendy/stock"
 )
 
+// Flags choose what the app does when run, its configuration comes from the config package
 type Flags struct {
-	Local *bool
+	Simulate  *bool
+	Watchlist *string
+	Backfill  BackfillFlags
+	CreateKey *string
+	Symbols   *string
+	Changes   *string
+	FX        *string
 }
 
 var flags Flags
@@ -33,81 +51,292 @@ type TrendyRouter struct {
 	httprouter.Router
 }
 
-func NewTrendyServer(flags Flags) TrendyServer {
+func NewTrendyServer(cfg *config.Config) TrendyServer {
 	server := TrendyServer{*negroni.New()}
 
-	// initialize the database
-	if *flags.Local {
-		stock.DB.Setup(stock.Local)
-	} else {
-		stock.DB.Setup(stock.Production)
-	}
+	// setup router, requests are dispatched using httprouter package
+	router := NewTrendyRouter()
 
-	// app manages request handling middleware, we use negroni package
-	server.Use(negroni.NewRecovery())
-	server.Use(negroni.NewLogger())
+	// count and time every request, including those rejected by later middleware
+	server.Use(NewMetrics(routes()))
+
+	// app manages request handling middleware, we use negroni package. Requests
+	// are given ids and logged before recovery so that panics are logged too.
+	server.Use(RequestLogger{})
+	recovery := negroni.NewRecovery()
+	recovery.Logger = slog.NewLogLogger(slog.Default().Handler(), slog.LevelError)
+	server.Use(recovery)
 
 	// use secure middleware package to only receive https connections
 	secureMiddleware := secure.New(secure.Options{
 		SSLRedirect:          true,
-		SSLTemporaryRedirect: false,        // false indicates using 301 (perm) redirect instead of 302 (temp)
-		SSLHost:              "",           // "localhost:8443" This is optional in production. The default behavior is to just redirect the request to the HTTPS protocol. Example: http://github.com/some_page would be redirected
-		IsDevelopment:        *flags.Local, // if running locally we run in development mode (SSL rqmt relaxed etc.)
+		SSLTemporaryRedirect: false,          // false indicates using 301 (perm) redirect instead of 302 (temp)
+		SSLHost:              cfg.PublicHost, // "localhost:8443" This is optional in production. The default behavior is to just redirect the request to the HTTPS protocol. Example: http://github.com/some_page would be redirected
+		IsDevelopment:        cfg.Local,      // if running locally we run in development mode (SSL rqmt relaxed etc.)
 	})
 	server.Use(negroni.HandlerFunc(secureMiddleware.HandlerFuncWithNext))
 
-	// protect all app routes using RestGate with static X-Auth-Key and Secret
-	// if local we assume dev environment with HTTPS reqmt off and debug on
-	key, secret := "key", "secret" // TODO implement real key and secret
-	restgateConfig := restgate.Config{
-		Key:                []string{key},
-		Secret:             []string{secret},
-		Debug:              *flags.Local,
-		HTTPSProtectionOff: *flags.Local,
+	// protect all app routes with X-Auth-Key and Secret checked against stored api keys
+	// if local we assume dev environment and make sure the dev key exists
+	if cfg.Local {
+		if err := EnsureDevKey(); err != nil {
+			panic(err)
+		}
 	}
-	server.Use(restgate.New("X-Auth-Key", "X-Auth-Secret", restgate.Static, restgateConfig))
+	server.Use(negroni.HandlerFunc(Authenticate))
 
-	// setup router, requests are dispatched using httprouter package
-	router := NewTrendyRouter()
+	// limit each key's request rate and quotas once we know who it is
+	server.Use(NewRateLimiter())
 
 	server.UseHandler(&router)
 
 	return server
 }
 
+// initialize the database from cfg, demos without a database keep everything in memory
+func SetupDB(cfg *config.Config) error {
+	if cfg.Database.DSN == "" {
+		stock.DB = stock.NewMemoryStore()
+		return nil
+	}
+
+	db, err := stock.Connect(cfg.Database.DSN)
+	if err != nil {
+		return fmt.Errorf("Could not connect to the database: %v", err)
+	}
+	db.SetMaxOpenConns(cfg.Database.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.Database.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.Database.ConnMaxLifetime.Duration)
+	stock.DB = db // the global db is never closed
+	return nil
+}
+
+// use the provider from cfg, demo data lets the server run with no external provider
+func SetupProvider(cfg *config.Config) {
+	if cfg.Provider.Name == config.DemoProvider {
+		stock.DefaultProvider = stock.SyntheticProvider{}
+		return
+	}
+	stock.MarkitClient = &stock.ProviderClient{
+		HTTPClient:  &http.Client{Timeout: cfg.Provider.Timeout.Duration},
+		MaxRetries:  cfg.Provider.MaxRetries,
+		BaseBackoff: cfg.Provider.BaseBackoff.Duration,
+		MaxBackoff:  cfg.Provider.MaxBackoff.Duration,
+		Limiter:     limit.NewBucket(cfg.Provider.Rate, cfg.Provider.Burst),
+		Breaker:     stock.MarkitClient.Breaker,
+	}
+}
+
+// a route served by the router, every one is documented in api/openapi.yaml
+type route struct {
+	method    string
+	path      string
+	handle    httprouter.Handle
+	streaming bool // the response stays open, exempt from the write timeout
+}
+
+func routes() []route {
+	metrics := promhttp.Handler()
+	return []route{
+		{"GET", "/stock/:symbol", RequireScope(stock.ScopeRead, GetStock), false},
+		{"GET", "/stock/:symbol/chart.svg", RequireScope(stock.ScopeRead, GetChartSVG), false},
+		{"GET", "/stock/:symbol/chart.png", RequireScope(stock.ScopeRead, GetChartPNG), false},
+		{"GET", "/stream", RequireScope(stock.ScopeRead, GetStream), true},
+		{"GET", "/symbols/search", RequireScope(stock.ScopeRead, GetSymbolSearch), false},
+		{"POST", "/graphql", RequireScope(stock.ScopeRead, PostGraphQL), false},
+		{"GET", "/healthz", GetHealth, false},
+		{"GET", "/readyz", GetReady, false},
+		{"GET", "/debug/status", RequireScope(stock.ScopeAdmin, GetDebugStatus), false},
+		{"GET", "/metrics", RequireScope(stock.ScopeMetrics, func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { metrics.ServeHTTP(w, r) }), false},
+		{"GET", "/admin/keys", RequireScope(stock.ScopeAdmin, ListKeys), false},
+		{"POST", "/admin/keys", RequireScope(stock.ScopeAdmin, CreateKey), false},
+		{"POST", "/admin/keys/:key/rotate", RequireScope(stock.ScopeAdmin, RotateKey), false},
+		{"PUT", "/admin/keys/:key/limits", RequireScope(stock.ScopeAdmin, UpdateKeyLimits), false},
+		{"DELETE", "/admin/keys/:key", RequireScope(stock.ScopeAdmin, RevokeKey), false},
+		{"GET", "/openapi.yaml", GetOpenAPI, false},
+	}
+}
+
 func NewTrendyRouter() TrendyRouter {
 	router := TrendyRouter{*httprouter.New()}
 
 	//	Routes:
-	// 		GET 	.../stock/<symbol>		GetStock()
-	// TODO	POST	.../dev/add/<symbol>	AddStock()
-	router.GET("/stock/:symbol", GetStock)
+	// 		GET 	.../stock/<symbol>				GetStock()
+	// 		GET 	.../stock/<symbol>/chart.svg	GetChartSVG()
+	// 		GET 	.../stock/<symbol>/chart.png	GetChartPNG()
+	// 		GET 	.../stream?symbols=				GetStream()
+	// 		GET 	.../symbols/search?q=			GetSymbolSearch()
+	// 		POST 	.../graphql						PostGraphQL()
+	// 		GET 	.../healthz						GetHealth()
+	// 		GET 	.../readyz						GetReady()
+	// 		GET 	.../debug/status				GetDebugStatus()
+	// 		GET 	.../metrics						promhttp.Handler()
+	// 		GET 	.../admin/keys					ListKeys()
+	// 		POST 	.../admin/keys					CreateKey()
+	// 		POST 	.../admin/keys/<key>/rotate		RotateKey()
+	// 		PUT 	.../admin/keys/<key>/limits		UpdateKeyLimits()
+	// 		DELETE 	.../admin/keys/<key>			RevokeKey()
+	// 		GET 	.../openapi.yaml				GetOpenAPI()
+	// TODO	POST	.../dev/add/<symbol>			AddStock()
+	for _, route := range routes() {
+		router.Handle(route.method, route.path, route.handle)
+	}
 	return router
 }
 
 func main() {
-	flags = Flags{Local: flag.Bool("local", false, "is the app running locally?")}
+	flags = Flags{
+		Simulate:  flag.Bool("simulate", false, "stream simulated prices instead of polling the provider"),
+		Watchlist: flag.String("watchlist", "", "comma separated symbols to refresh after each market close"),
+		Backfill: BackfillFlags{
+			Symbols: flag.String("backfill", "", "comma separated symbols to backfill, runs the backfill and exits"),
+			Start:   flag.String("backfill-start", "", "first day to backfill as YYYY-MM-DD"),
+			End:     flag.String("backfill-end", "", "last day to backfill as YYYY-MM-DD, defaults to today"),
+			Name:    flag.String("backfill-name", "", "checkpoint name, rerun with the same name to resume"),
+		},
+		CreateKey: flag.String("create-admin-key", "", "create an admin api key with this name, print it and exit"),
+		Symbols:   flag.String("load-symbols", "", "listing CSV to load into the symbol registry at startup, unknown symbols are refused"),
+		Changes:   flag.String("load-symbol-changes", "", "symbol change CSV to load at startup, ranges across a change are served from both symbols"),
+		FX:        flag.String("load-fx", "", "exchange rate CSV of from,to,date,rate rows to load at startup, prices are converted with stored rates only"),
+	}
+	config.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 
-	server := NewTrendyServer(flags)
-	if *flags.Local {
-		server.Run(":8080")
-	} else {
-		// TODO server.Run(<prod port>)
+	cfg, err := config.Load(os.Getenv, flag.CommandLine)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err := SetupLogging(cfg, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err := SetupDB(cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	SetupProvider(cfg)
+	stock.AllowUnlisted = cfg.AllowUnlisted
+
+	if *flags.Symbols != "" {
+		n, err := LoadSymbols(context.Background(), *flags.Symbols)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		slog.Info("Symbols loaded", "file", *flags.Symbols, "listings", n)
+	}
+	if *flags.Changes != "" {
+		n, err := LoadSymbolChanges(context.Background(), *flags.Changes)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		slog.Info("Symbol changes loaded", "file", *flags.Changes, "changes", n)
+	}
+	if *flags.FX != "" {
+		n, err := LoadFX(context.Background(), *flags.FX)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		slog.Info("Exchange rates loaded", "file", *flags.FX, "rates", n)
+	}
+	if loaded, err := stock.ListingsLoaded(context.Background()); err == nil && !loaded {
+		if stock.AllowUnlisted {
+			slog.Warn("No listings loaded, every symbol is served until they are")
+		} else {
+			slog.Warn(errNoListings.Error())
+		}
+	}
+
+	// bootstrap the first admin key, others can be managed through .../admin/keys
+	if *flags.CreateKey != "" {
+		apiKey, secret, err := stock.NewAPIKey(*flags.CreateKey, []string{stock.ScopeRead, stock.ScopeAdmin}, nil)
+		if err == nil {
+			err = stock.DB.InsertAPIKey(apiKey)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Printf("X-Auth-Key: %s\nX-Auth-Secret: %s\n", apiKey.Key, secret)
+		return
+	}
+
+	if *flags.Backfill.Symbols != "" {
+		// interrupting abandons the chunks in flight, rerunning resumes them
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+		err := RunBackfill(ctx, flags.Backfill, os.Stdout)
+		stop()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	server := NewTrendyServer(cfg)
+
+	// canceled when shutdown starts
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// start the feed that publishes to /stream subscribers, it only does work
+	// for symbols that have subscribers
+	var feed stock.Feed = &stock.Poller{Interval: time.Minute, Lookback: 7 * 24 * time.Hour}
+	if *flags.Simulate {
+		feed = &stock.SimulatedFeed{Interval: time.Second, Seed: time.Now().UnixNano()}
+	}
+	go feed.Run(ctx, streamBroker)
+
+	// refresh the watchlist after every market close so it's already in the database
+	if watchlist := parseSymbols(*flags.Watchlist); len(watchlist) > 0 {
+		scheduler = &stock.Scheduler{
+			Watchlist:   watchlist,
+			Concurrency: 4,
+			Retries:     3,
+			RetryDelay:  time.Minute,
+			Delay:       30 * time.Minute,
+			Lookback:    7 * 24 * time.Hour,
+		}
+		go scheduler.Run(ctx)
+	}
+
+	// serve until SIGTERM or interrupt, SIGHUP reloads the TLS certificate
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	err = Serve(cfg, &server, signals, cancel)
+	cancel()
+	if db, ok := stock.DB.(io.Closer); ok {
+		db.Close()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 }
 
 // ps includes "symbol" param
-// queryValues may include "start" and "end", as YYYY-MM-DD, and requested optional "fields"
+// queryValues may include "start" and "end", as YYYY-MM-DD, and requested optional "fields",
+// "format" (json, csv, ndjson or arrow), format can also be requested with Accept, and
+// "currency" to convert prices to at each day's exchange rate
 func GetStock(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	// additional fields as url parameters
 	queryValues := r.URL.Query()
 
+	format, err := NegotiateFormat(r)
+	if err != nil {
+		errStr := fmt.Sprintf("Could not provide requested format, must be one of json, csv, ndjson or arrow [format=%s, Accept=%s]", queryValues.Get("format"), r.Header.Get("Accept"))
+		http.Error(w, errStr, http.StatusNotAcceptable)
+		return
+	}
+
 	// TODO make start and end optional
 	// parse start and end times if provided
 	start, end := queryValues.Get("start"), queryValues.Get("end")
 	var startTime, endTime time.Time
-	loc, err := time.LoadLocation("America/New_York")
+	loc, _ := time.LoadLocation("America/New_York")
 	if start != "" {
 		startTime, err = time.ParseInLocation("2006-01-02", start, loc)
 		if err != nil {
@@ -130,21 +359,84 @@ func GetStock(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		// TODO handle optional fields
 	}
 
-	stock := stock.NewStock(ps.ByName("symbol"))
-	span, err := stock.Range(startTime, endTime)
-	if err != nil {
+	currency := strings.ToUpper(queryValues.Get("currency"))
+	if currency != "" && !stock.ValidCurrency(currency) {
+		errStr := fmt.Sprintf("Could not parse currency, must be a three letter code like USD [%s]", queryValues.Get("currency"))
+		http.Error(w, errStr, http.StatusBadRequest)
+		return
+	}
+
+	s := stock.NewStock(ps.ByName("symbol"))
+	span, err := s.RangeContext(r.Context(), startTime, endTime)
+	if err != nil && r.Context().Err() != nil {
+		// the client went away or the write timeout passed, this is only for the logs
+		http.Error(w, fmt.Sprintf("Request abandoned: %v", r.Context().Err()), http.StatusServiceUnavailable)
+		return
+	} else if errors.Is(err, stock.ErrSymbolNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if errors.Is(err, stock.ErrDelisted) {
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	} else if err != nil {
 		errStr := fmt.Sprintf("Could not get range for provided stock over start to end [%s:%s-%s]", ps.ByName("symbol"), start, end)
 		http.Error(w, errStr, http.StatusInternalServerError)
 		return
 	}
-	stock.Span = span // override memoized span
 
-	json, err := json.Marshal(stock)
+	// prices are in the symbol's currency unless another is asked for
+	from, err := s.CurrencyContext(r.Context())
+	if err != nil {
+		errStr := fmt.Sprintf("Could not get currency for stock [%s]", ps.ByName("symbol"))
+		http.Error(w, errStr, http.StatusInternalServerError)
+		return
+	}
+	if currency != "" && currency != from {
+		span, err = stock.Convert(r.Context(), span, from, currency)
+		if errors.Is(err, stock.ErrNoRate) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			errStr := fmt.Sprintf("Could not convert stock from %s to %s [%s]", from, currency, ps.ByName("symbol"))
+			http.Error(w, errStr, http.StatusInternalServerError)
+			return
+		}
+		s.Currency = currency
+	}
+	s.Span = span // override memoized span
+
+	// answer conditional requests, the etag differs per format since the bodies do
+	lastModified, err := stock.DB.LastModifiedContext(r.Context(), s)
+	if err == nil && currency != "" && currency != from {
+		// converted prices also change when the rates do
+		var ratesModified time.Time
+		ratesModified, err = stock.FXLastModified(r.Context(), from, currency)
+		if ratesModified.After(lastModified) {
+			lastModified = ratesModified
+		}
+	}
+	if err != nil {
+		errStr := fmt.Sprintf("Could not get last modification for stock [%s]", ps.ByName("symbol"))
+		http.Error(w, errStr, http.StatusInternalServerError)
+		return
+	}
+	etag := fmt.Sprintf(`"%s-%s"`, span.Hash(), format)
+	w.Header().Set("Vary", "Accept")
+	SetCacheHeaders(w, etag, lastModified, IncludesToday(endTime, time.Now()))
+	if NotModified(r, etag, lastModified) {
+		atomic.AddInt64(&notModifiedResponses, 1)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	// serialize before writing anything so that errors can still be reported
+	var body bytes.Buffer
+	err = WriteStock(&body, s, format)
 	if err != nil {
-		errStr := fmt.Sprintf("Error generating JSON response for stock over start to end [%s:%s-%s]", ps.ByName("symbol"), start, end)
+		errStr := fmt.Sprintf("Error generating %s response for stock over start to end [%s:%s-%s]", format, ps.ByName("symbol"), start, end)
 		http.Error(w, errStr, http.StatusInternalServerError)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(json)
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Write(body.Bytes())
 }
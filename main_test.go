@@ -3,27 +3,43 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"math"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/jhurwich/trendy/config"
 	"github.com/jhurwich/trendy/stock"
 	"github.com/jhurwich/trendy/testhelpers"
 )
 
 func TestGetStockIntegration(t *testing.T) {
-	// start a new server so we can access it's ServeHTTP method
-	trueVal := true
-	ts := NewTrendyServer(Flags{Local: &trueVal})
-
-	// test has db impact, setup test db
-	tdb := stock.DB.Setup(stock.TestLocal)
+	// fake Markit with the test server rather than reaching the real one
+	defaultProvider := stock.DefaultProvider
+	defer func() { stock.DefaultProvider = defaultProvider }()
 
 	// run default test set for Markit
 	testdata := testhelpers.MarkitTestData
 	for _, test := range testdata {
+		// test has db impact, each case uses a store of its own
+		_, discard := testhelpers.UseStore(t)
+
+		// start a new server so we can access it's ServeHTTP method
+		ts := NewTrendyServer(&config.Config{Local: true})
+
+		unusedRequest, err := stock.NewMarkitChartAPIRequest(stock.NewStock(test.Sym), test.StartDate, test.EndDate)
+		if err != nil {
+			t.Errorf("Could not create a MarkitChartAPIRequest: %v", err)
+		}
+		markit := httptest.NewServer(&testhelpers.TestServer{
+			Status: http.StatusOK, RequestUrl: unusedRequest.Url, TestData: testdata, T: t,
+		})
+		stock.DefaultProvider = stock.MarkitProvider{Url: markit.URL}
+
 		// .../stock/<test.Sym>?start=<test.StartDate>&end=<test.EndDate>, dates as YYYY-MM-DD
 		path := strings.Join([]string{`/stock/`, test.Sym, `?start=`, test.StartDate.Format("2006-01-02"), `&end=`, test.EndDate.Format("2006-01-02")}, "")
 		t.Logf("path: `%s`\n", path)
@@ -51,12 +67,10 @@ func TestGetStockIntegration(t *testing.T) {
 				t.Errorf("Attempted access with key/secret but failed, expected success.\n>> Status:%d for %s", w.Code, path)
 			}
 
-			// record the changes we make so they can be reversed, all measures in span will be inserted
-			td := testhelpers.TearDown{}
 			expectedSpan := test.ExpectedMarkitResponse.GetSpan()
-			td = td.TrackSpanInsert(test.Sym, &expectedSpan, tdb, t)
 
 			expectedStock := stock.NewStock(test.Sym)
+			expectedStock.Currency = test.ExpectedMarkitResponse.GetCurrency()
 			expectedStock.Span = expectedSpan
 			expectedJson, err := json.Marshal(expectedStock)
 			if err != nil {
@@ -68,17 +82,118 @@ func TestGetStockIntegration(t *testing.T) {
 				t.Errorf("(%d) Got unexpected response from server, expected:\n%s\ngot:\n%s\n", w.Code, expectedJson, b)
 			}
 
-			// teardown changes
-			err = td.TearDown(tdb, t)
-			if err != nil {
-				t.Error(err)
-			}
-
 		} else {
 			// expect error
 			if w.Code == http.StatusOK {
 				t.Errorf("Attempted access expecting failure but got success.\n>> Status:%d for %s", w.Code, path)
 			}
 		}
+		markit.Close()
+		discard()
+	}
+}
+
+func TestGetStockCurrency(t *testing.T) {
+	_, discard := testhelpers.UseStore(t)
+	defer discard()
+	defaultProvider := stock.DefaultProvider
+	stock.DefaultProvider = stock.SyntheticProvider{}
+	defer func() { stock.DefaultProvider = defaultProvider }()
+	server := NewTrendyServer(&config.Config{Local: true})
+	ctx := context.Background()
+
+	// SAP is priced in euros, with a dollar rate stored for each day
+	stock.LoadListings(ctx, []stock.Listing{{Symbol: "SAP", Name: "SAP SE", Currency: "EUR"}})
+	rates := stock.NewStock(stock.FXSymbol("EUR", "USD"))
+	for day := time.Date(2015, time.May, 25, 0, 0, 0, 0, time.UTC); day.Before(time.Date(2015, time.July, 1, 0, 0, 0, 0, time.UTC)); day = day.AddDate(0, 0, 1) {
+		rates.Span = append(rates.Span, stock.Measure{Time: day, Value: 1.5})
+	}
+	if err := stock.DB.InsertContext(ctx, rates, &rates.Span); err != nil {
+		t.Fatalf("Could not store rates: %v", err)
+	}
+
+	get := func(path string) (int, stock.Stock) {
+		r := httptest.NewRequest("GET", path, nil)
+		r.Header.Set(authKeyHeader, devKey)
+		r.Header.Set(authSecretHeader, devSecret)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		s := stock.Stock{}
+		json.Unmarshal(w.Body.Bytes(), &s)
+		return w.Code, s
+	}
+
+	code, eur := get("/stock/SAP?start=2015-06-01&end=2015-06-30")
+	if code != http.StatusOK || eur.Currency != "EUR" || len(eur.Span) == 0 {
+		t.Fatalf("Expected SAP in euros, got %d %s with %d measures", code, eur.Currency, len(eur.Span))
+	}
+	code, usd := get("/stock/SAP?start=2015-06-01&end=2015-06-30&currency=usd")
+	if code != http.StatusOK || usd.Currency != "USD" || len(usd.Span) != len(eur.Span) {
+		t.Fatalf("Expected SAP in dollars, got %d %s with %d measures", code, usd.Currency, len(usd.Span))
+	}
+	for i := range usd.Span {
+		if math.Abs(float64(usd.Span[i].Value-eur.Span[i].Value*1.5)) > 1e-3 {
+			t.Errorf("Expected %v converted at 1.5, got %v", eur.Span[i].Value, usd.Span[i].Value)
+		}
+	}
+
+	// converted prices are modified when the rates are, not only when the prices are
+	conditional := func(since string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/stock/SAP?start=2015-06-01&end=2015-06-30&currency=USD", nil)
+		r.Header.Set(authKeyHeader, devKey)
+		r.Header.Set(authSecretHeader, devSecret)
+		r.Header.Set("If-Modified-Since", since)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w
+	}
+	since := conditional("").Header().Get("Last-Modified")
+	if code := conditional(since).Code; code != http.StatusNotModified {
+		t.Errorf("Expected %d before the rates change, got %d", http.StatusNotModified, code)
+	}
+	time.Sleep(time.Second) // Last-Modified is to the second
+	if err := stock.LoadRates(ctx, []stock.Rate{{From: "EUR", To: "USD", Date: time.Date(2015, time.June, 15, 0, 0, 0, 0, time.UTC), Rate: 1.6}}); err != nil {
+		t.Fatalf("Could not load a rate: %v", err)
+	}
+	if w := conditional(since); w.Code != http.StatusOK || w.Header().Get("Last-Modified") == since {
+		t.Errorf("Expected %d with a later Last-Modified once a rate changes, got %d %s", http.StatusOK, w.Code, w.Header().Get("Last-Modified"))
+	}
+
+	// no rate to convert with is not found, a malformed currency is a bad request
+	stock.DefaultProvider = noDataProvider{}
+	if code, _ := get("/stock/SAP?start=2015-06-01&end=2015-06-30&currency=GBP"); code != http.StatusNotFound {
+		t.Errorf("Expected %d without a rate, got %d", http.StatusNotFound, code)
+	}
+	if code, _ := get("/stock/SAP?currency=euros"); code != http.StatusBadRequest {
+		t.Errorf("Expected %d for a malformed currency, got %d", http.StatusBadRequest, code)
+	}
+}
+
+// a provider without data for anything
+type noDataProvider struct{}
+
+func (noDataProvider) Fetch(s *stock.Stock, startDate time.Time, endDate time.Time) (stock.Span, error) {
+	return nil, stock.ErrNoData
+}
+
+func TestSetupProvider(t *testing.T) {
+	markitClient, defaultProvider := stock.MarkitClient, stock.DefaultProvider
+	defer func() { stock.MarkitClient, stock.DefaultProvider = markitClient, defaultProvider }()
+
+	cfg := config.Default()
+	cfg.Provider.Timeout.Duration, cfg.Provider.MaxRetries, cfg.Provider.Rate, cfg.Provider.Burst = 3*time.Second, 5, 2, 4
+	SetupProvider(cfg)
+	client := stock.MarkitClient
+	if client.HTTPClient.Timeout != 3*time.Second || client.MaxRetries != 5 || client.BaseBackoff != cfg.Provider.BaseBackoff.Duration || client.Limiter.Rate != 2 || client.Limiter.Burst != 4 {
+		t.Errorf("Expected the Markit client built from the configuration, got %+v", client)
+	}
+	if client.Breaker != markitClient.Breaker {
+		t.Errorf("Expected the breaker to be kept so health checks see it")
+	}
+
+	cfg.Provider.Name = config.DemoProvider
+	SetupProvider(cfg)
+	if _, ok := stock.DefaultProvider.(stock.SyntheticProvider); !ok {
+		t.Errorf("Expected synthetic data for the demo provider, got %T", stock.DefaultProvider)
 	}
 }
@@ -0,0 +1,84 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/codegangsta/negroni"
+	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "trendy",
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "Requests served by route, method and status.",
+	}, []string{"route", "method", "status"})
+
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "trendy",
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Duration of requests by route, method and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+)
+
+func init() {
+	prometheus.MustRegister(httpRequests, httpRequestDuration)
+}
+
+// Metrics is negroni middleware that counts and times every request by the
+// route it matched, so /stock/GOOG and /stock/AAPL are both /stock/:symbol
+type Metrics struct {
+	patterns *httprouter.Router // each route's handle records its pattern, see route
+}
+
+// NewMetrics for the given routes, requests are matched to them before later
+// middleware runs so requests it rejects are counted by route too
+func NewMetrics(routes []route) *Metrics {
+	patterns := httprouter.New()
+	for _, rt := range routes {
+		pattern := rt.path
+		patterns.Handle(rt.method, rt.path, func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+			w.(*patternRecorder).pattern = pattern
+		})
+	}
+	return &Metrics{patterns: patterns}
+}
+
+// the ResponseWriter given to a pattern handle, nothing is written to it
+type patternRecorder struct {
+	http.ResponseWriter
+	pattern string
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	start := time.Now()
+	next(w, r)
+
+	status := http.StatusOK
+	if rw, ok := w.(negroni.ResponseWriter); ok && rw.Status() != 0 {
+		status = rw.Status()
+	}
+	labels := prometheus.Labels{"route": m.route(r), "method": r.Method, "status": strconv.Itoa(status)}
+	httpRequests.With(labels).Inc()
+	httpRequestDuration.With(labels).Observe(time.Since(start).Seconds())
+}
+
+// the pattern of the route matching r, "unmatched" if there isn't one so
+// arbitrary paths can't create arbitrarily many series
+func (m *Metrics) route(r *http.Request) string {
+	handle, ps, _ := m.patterns.Lookup(r.Method, r.URL.Path)
+	if handle == nil {
+		return "unmatched"
+	}
+	recorder := &patternRecorder{}
+	handle(recorder, r, ps)
+	return recorder.pattern
+}
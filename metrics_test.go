@@ -0,0 +1,75 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/jhurwich/trendy/config"
+	"github.com/jhurwich/trendy/testhelpers"
+)
+
+func TestMetrics(t *testing.T) {
+	_, discard := testhelpers.UseStore(t)
+	defer discard()
+	ts := NewTrendyServer(&config.Config{Local: true})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		ts.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	// requests are counted by route pattern, including those rejected by auth
+	unauthorized := httpRequests.WithLabelValues("/stock/:symbol", "GET", "401")
+	before := testutil.ToFloat64(unauthorized)
+	get("/stock/GOOG")
+	get("/stock/AAPL")
+	if after := testutil.ToFloat64(unauthorized); after != before+2 {
+		t.Errorf("Expected 2 more unauthorized /stock/:symbol requests, got %v", after-before)
+	}
+
+	// paths without a route share one series
+	unmatched := httpRequests.WithLabelValues("unmatched", "GET", "401")
+	before = testutil.ToFloat64(unmatched)
+	get("/no/such/path")
+	if after := testutil.ToFloat64(unmatched); after != before+1 {
+		t.Errorf("Expected the unrouted request to be counted as unmatched, got %v", after-before)
+	}
+
+	// parameters are labelled by the route's pattern even when their values look like its path
+	for _, test := range []struct{ method, path, pattern string }{
+		{"GET", "/stock/stock", "/stock/:symbol"},
+		{"POST", "/admin/keys/keys/rotate", "/admin/keys/:key/rotate"},
+	} {
+		counter := httpRequests.WithLabelValues(test.pattern, test.method, "401")
+		before := testutil.ToFloat64(counter)
+		ts.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(test.method, test.path, nil))
+		if after := testutil.ToFloat64(counter); after != before+1 {
+			t.Errorf("Expected %s %s to be counted as %s", test.method, test.path, test.pattern)
+		}
+	}
+
+	// they're served to prometheus with a key that has the metrics scope
+	if w := get("/metrics"); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected /metrics to be %d without credentials, got %d", http.StatusUnauthorized, w.Code)
+	}
+	r := httptest.NewRequest("GET", "/metrics", nil)
+	r.Header.Set(authKeyHeader, devKey)
+	r.Header.Set(authSecretHeader, devSecret)
+	w := httptest.NewRecorder()
+	ts.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected /metrics to be %d with the metrics scope, got %d", http.StatusOK, w.Code)
+	}
+	for _, name := range []string{"trendy_http_requests_total", "trendy_http_request_duration_seconds", "trendy_cache_ranges_total"} {
+		if !strings.Contains(w.Body.String(), name) {
+			t.Errorf("Expected %s in /metrics", name)
+		}
+	}
+}
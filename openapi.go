@@ -0,0 +1,18 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package main
+
+import (
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/jhurwich/trendy/api"
+)
+
+// responds with the OpenAPI document describing every route, the client
+// package is generated from the same document
+func GetOpenAPI(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(api.Spec)
+}
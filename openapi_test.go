@@ -0,0 +1,222 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers/legacy"
+	"github.com/oapi-codegen/runtime/types"
+
+	"github.com/jhurwich/trendy/api"
+	"github.com/jhurwich/trendy/client"
+	"github.com/jhurwich/trendy/config"
+	"github.com/jhurwich/trendy/stock"
+	"github.com/jhurwich/trendy/testhelpers"
+)
+
+func init() {
+	// bodies the validator only needs to see as strings
+	for _, contentType := range []string{"text/csv", "application/x-ndjson", "application/vnd.apache.arrow.stream", "image/svg+xml", "image/png"} {
+		openapi3filter.RegisterBodyDecoder(contentType, openapi3filter.FileBodyDecoder)
+	}
+}
+
+func loadSpec(t *testing.T) *openapi3.T {
+	doc, err := openapi3.NewLoader().LoadFromData(api.Spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.Validate(context.Background()); err != nil {
+		t.Fatalf("Invalid OpenAPI document: %v", err)
+	}
+	return doc
+}
+
+// every route is documented and everything documented is routed
+func TestOpenAPIRoutes(t *testing.T) {
+	doc := loadSpec(t)
+	documented := []string{}
+	for path, item := range doc.Paths.Map() {
+		for method := range item.Operations() {
+			path = strings.NewReplacer("{", ":", "}", "").Replace(path)
+			documented = append(documented, method+" "+path)
+		}
+	}
+	routed := []string{}
+	for _, route := range routes() {
+		routed = append(routed, route.method+" "+route.path)
+	}
+	sort.Strings(documented)
+	sort.Strings(routed)
+	if strings.Join(documented, "\n") != strings.Join(routed, "\n") {
+		t.Errorf("Routes and api/openapi.yaml differ, routed:\n%s\ndocumented:\n%s", strings.Join(routed, "\n"), strings.Join(documented, "\n"))
+	}
+}
+
+// requests and the handlers' responses to them match the document
+func TestOpenAPIResponses(t *testing.T) {
+	_, discard := testhelpers.UseStore(t)
+	defer discard()
+	defaultProvider := stock.DefaultProvider
+	stock.DefaultProvider = stock.SyntheticProvider{}
+	defer func() { stock.DefaultProvider = defaultProvider }()
+	// the dev key with room for every request below in one burst
+	stock.DB.InsertAPIKey(&stock.APIKey{Key: devKey, SecretHash: stock.HashSecret(devSecret), Scopes: []string{stock.ScopeRead, stock.ScopeAdmin, stock.ScopeMetrics}, Created: time.Now().UTC(), Burst: 100})
+	ts := NewTrendyServer(&config.Config{Local: true})
+
+	doc := loadSpec(t)
+	router, err := legacy.NewRouter(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	apiKey, _, err := stock.NewAPIKey("spec", []string{stock.ScopeRead}, nil)
+	if err != nil || stock.DB.InsertAPIKey(apiKey) != nil {
+		t.Fatalf("Could not create a key: %v", err)
+	}
+
+	var tests = []struct {
+		method        string
+		path          string
+		body          string
+		authenticated bool
+		status        int
+	}{
+		{"GET", "/stock/GOOG?start=2015-06-01&end=2015-06-30", "", true, http.StatusOK},
+		{"GET", "/stock/GOOG?start=2015-06-01&end=2015-06-30&format=csv", "", true, http.StatusOK},
+		{"GET", "/stock/GOOG?start=2015-06-01&end=2015-06-30&format=ndjson", "", true, http.StatusOK},
+		{"GET", "/stock/GOOG?start=2015-06-01&end=2015-06-30&format=arrow", "", true, http.StatusOK},
+		{"GET", "/stock/GOOG?format=xml", "", true, http.StatusNotAcceptable},
+		{"GET", "/stock/GOOG?start=2015-06-01&end=2015-06-30&currency=usd", "", true, http.StatusOK},
+		{"GET", "/stock/GOOG?currency=dollars", "", true, http.StatusBadRequest},
+		{"GET", "/stock/GOOG", "", false, http.StatusUnauthorized},
+		{"GET", "/stock/GOOG/chart.svg?start=2015-06-01&end=2015-06-30&style=candlestick&indicators=sma:5&trend=true&volume=true", "", true, http.StatusOK},
+		{"GET", "/stock/GOOG/chart.png?start=2015-06-01&end=2015-06-30&theme=dark", "", true, http.StatusOK},
+		{"GET", "/stock/GOOG/chart.svg?width=5", "", true, http.StatusBadRequest},
+		{"GET", "/stream", "", true, http.StatusBadRequest},
+		{"GET", "/symbols/search?q=goog", "", true, http.StatusOK},
+		{"GET", "/symbols/search", "", true, http.StatusBadRequest},
+		{"POST", "/graphql", `{"query": "{ stock(symbol: \"GOOG\") { bars(start: \"2015-06-01\", end: \"2015-06-30\") { time close } } }"}`, true, http.StatusOK},
+		{"POST", "/graphql", `{"query": "{ stock(symbol: \"GOOG\") { trend(start: \"1900-01-01\") { slopePerDay } } }"}`, true, http.StatusOK},
+		{"POST", "/graphql", `{}`, true, http.StatusBadRequest},
+		{"GET", "/healthz", "", false, http.StatusOK},
+		{"GET", "/readyz", "", false, http.StatusOK},
+		{"GET", "/metrics", "", false, http.StatusUnauthorized},
+		{"GET", "/metrics", "", true, http.StatusOK},
+		{"GET", "/openapi.yaml", "", false, http.StatusOK},
+		{"GET", "/debug/status", "", true, http.StatusOK},
+		{"GET", "/admin/keys", "", true, http.StatusOK},
+		{"POST", "/admin/keys", `{"Name": "other", "Scopes": ["read"]}`, true, http.StatusCreated},
+		{"POST", "/admin/keys", `{"Name": "other"}`, true, http.StatusBadRequest},
+		{"POST", "/admin/keys/" + apiKey.Key + "/rotate", "", true, http.StatusOK},
+		{"PUT", "/admin/keys/" + apiKey.Key + "/limits", `{"RateLimit": 5, "DailyQuota": 1000}`, true, http.StatusOK},
+		{"PUT", "/admin/keys/" + apiKey.Key + "/limits", `{"Burst": -1}`, true, http.StatusBadRequest},
+		{"DELETE", "/admin/keys/" + apiKey.Key, "", true, http.StatusNoContent},
+		{"DELETE", "/admin/keys/missing", "", true, http.StatusNotFound},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, "https://localhost:8080"+test.path, strings.NewReader(test.body))
+		if test.body != "" {
+			r.Header.Set("Content-Type", "application/json")
+		}
+		if test.authenticated {
+			r.Header.Set("X-Auth-Key", "key")
+			r.Header.Set("X-Auth-Secret", "secret")
+		}
+
+		route, pathParams, err := router.FindRoute(r)
+		if err != nil {
+			t.Errorf("%s %s isn't documented: %v", test.method, test.path, err)
+			continue
+		}
+		input := &openapi3filter.RequestValidationInput{
+			Request:    r,
+			PathParams: pathParams,
+			Route:      route,
+			Options:    &openapi3filter.Options{AuthenticationFunc: openapi3filter.NoopAuthenticationFunc, IncludeResponseStatus: true},
+		}
+		if test.status < 400 {
+			if err := openapi3filter.ValidateRequest(context.Background(), input); err != nil {
+				t.Errorf("%s %s doesn't match the document: %v", test.method, test.path, err)
+			}
+			r.Body = io.NopCloser(strings.NewReader(test.body)) // validating read it
+		}
+
+		w := httptest.NewRecorder()
+		ts.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("Expected %d for %s %s, got %d: %s", test.status, test.method, test.path, w.Code, w.Body)
+			continue
+		}
+		err = openapi3filter.ValidateResponse(context.Background(), &openapi3filter.ResponseValidationInput{
+			RequestValidationInput: input,
+			Status:                 w.Code,
+			Header:                 w.Header(),
+			Body:                   io.NopCloser(bytes.NewReader(w.Body.Bytes())),
+			Options:                input.Options,
+		})
+		if err != nil {
+			t.Errorf("Response to %s %s doesn't match the document: %v", test.method, test.path, err)
+		}
+	}
+}
+
+// the generated client works against the server
+func TestClient(t *testing.T) {
+	_, discard := testhelpers.UseStore(t)
+	defer discard()
+	defaultProvider := stock.DefaultProvider
+	stock.DefaultProvider = stock.SyntheticProvider{}
+	defer func() { stock.DefaultProvider = defaultProvider }()
+	server := NewTrendyServer(&config.Config{Local: true})
+	ts := httptest.NewServer(&server)
+	defer ts.Close()
+
+	c, err := client.NewClientWithResponses(ts.URL, client.WithAPIKey("key", "secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	start := types.Date{Time: time.Date(2015, time.June, 1, 0, 0, 0, 0, time.UTC)}
+	end := types.Date{Time: time.Date(2015, time.June, 30, 0, 0, 0, 0, time.UTC)}
+	params := &client.GetStockParams{Start: &start, End: &end}
+	got, err := c.GetStockWithResponse(ctx, "GOOG", params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.JSON200 == nil || got.JSON200.Symbol != "GOOG" || got.JSON200.Span == nil || len(*got.JSON200.Span) == 0 {
+		t.Fatalf("Expected GOOG's measures, got %d: %s", got.StatusCode(), got.Body)
+	}
+
+	created, err := c.CreateKeyWithResponse(ctx, client.CreateKeyRequest{Name: "client", Scopes: []client.CreateKeyRequestScopes{"read"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.JSON201 == nil || created.JSON201.Secret == "" {
+		t.Fatalf("Expected a new key and secret, got %d: %s", created.StatusCode(), created.Body)
+	}
+
+	// the new key authenticates, and after it's revoked it doesn't
+	other, _ := client.NewClientWithResponses(ts.URL, client.WithAPIKey(created.JSON201.Key, created.JSON201.Secret))
+	if got, err := other.GetStockWithResponse(ctx, "GOOG", params); err != nil || got.JSON200 == nil {
+		t.Errorf("Expected the new key to read GOOG, got %v %v", got.StatusCode(), err)
+	}
+	if revoked, err := c.RevokeKeyWithResponse(ctx, created.JSON201.Key); err != nil || revoked.StatusCode() != http.StatusNoContent {
+		t.Fatalf("Expected the key to be revoked, got %v", err)
+	}
+	if got, err := other.GetStockWithResponse(ctx, "GOOG", params); err != nil || got.JSON401 == nil {
+		t.Errorf("Expected the revoked key to be unauthorized, got %v %v", got.StatusCode(), err)
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jhurwich/trendy/limit"
+	"github.com/jhurwich/trendy/stock"
+)
+
+// limits for keys that don't set their own, a quota of zero is unlimited
+var (
+	DefaultRateLimit    float64 = 10
+	DefaultBurst                = 20
+	DefaultDailyQuota           = 0
+	DefaultMonthlyQuota         = 0
+)
+
+// RateLimiter is negroni middleware, after Authenticate, that limits each api
+// key with a token bucket and with daily and monthly quotas. Buckets are kept
+// in memory, usage counts for the quotas are kept in the database.
+type RateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*limit.Bucket
+}
+
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{buckets: map[string]*limit.Bucket{}}
+}
+
+func (rl *RateLimiter) ServeHTTP(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	apiKey := RequestAPIKey(r)
+	if apiKey == nil {
+		next(w, r) // nothing to count against
+		return
+	}
+
+	limits, refused, err := rl.admit(apiKey, 1, time.Now().UTC())
+	writeRateLimits(w, limits)
+	if err != nil {
+		http.Error(w, "Could not record usage", http.StatusInternalServerError)
+		return
+	}
+	if refused != nil {
+		tooManyRequests(w, refused.retryAfter, refused.message)
+		return
+	}
+
+	next(w, r.WithContext(context.WithValue(r.Context(), rateLimiterContextKey, rl)))
+}
+
+// admitMore counts n requests by r's key beyond the one RateLimiter counted,
+// for handlers that do the work of several requests. If they're refused the
+// response is written and false returned.
+func admitMore(w http.ResponseWriter, r *http.Request, n int) bool {
+	rl, _ := r.Context().Value(rateLimiterContextKey).(*RateLimiter)
+	apiKey := RequestAPIKey(r)
+	if rl == nil || apiKey == nil || n <= 0 {
+		return true
+	}
+
+	limits, refused, err := rl.admit(apiKey, n, time.Now().UTC())
+	writeRateLimits(w, limits)
+	if err != nil {
+		http.Error(w, "Could not record usage", http.StatusInternalServerError)
+		return false
+	}
+	if refused != nil && refused.exceedsBurst {
+		http.Error(w, refused.message, http.StatusBadRequest)
+		return false
+	}
+	if refused != nil {
+		tooManyRequests(w, refused.retryAfter, refused.message)
+		return false
+	}
+	return true
+}
+
+func writeRateLimits(w http.ResponseWriter, limits []rateLimit) {
+	for _, l := range limits {
+		w.Header().Set("X-RateLimit-"+l.name+"Limit", strconv.Itoa(l.limit))
+		w.Header().Set("X-RateLimit-"+l.name+"Remaining", strconv.Itoa(l.remaining))
+		w.Header().Set("X-RateLimit-"+l.name+"Reset", strconv.FormatInt(l.reset.Unix(), 10))
+	}
+}
+
+// a limit checked for a request, name is empty for the rate limit and like
+// "Daily-" for quotas
+type rateLimit struct {
+	name      string
+	limit     int
+	remaining int
+	reset     time.Time
+}
+
+// why a request was refused and when to try again. A batch larger than the
+// key's burst is never admitted, however long the client waits.
+type refusal struct {
+	retryAfter   time.Duration
+	message      string
+	exceedsBurst bool
+}
+
+// admit n requests by apiKey at now, counting them against the key's token
+// bucket and quotas. A batch of requests is admitted or refused as a whole.
+// The limits checked are returned whether or not the requests were refused,
+// so they can be reported to the client.
+func (rl *RateLimiter) admit(apiKey *stock.APIKey, n int, now time.Time) ([]rateLimit, *refusal, error) {
+	// token bucket first, it's cheap and protects the database from bursts
+	bucket := rl.bucket(apiKey)
+	if n > bucket.Burst {
+		return nil, &refusal{message: fmt.Sprintf("Batch of %d requests exceeds the key's burst of %d", n, bucket.Burst), exceedsBurst: true}, nil
+	}
+	allowed, remaining, wait := bucket.AllowN(now, n)
+	limits := []rateLimit{{"", bucket.Burst, remaining, now.Add(wait)}}
+	if !allowed {
+		return limits, &refusal{retryAfter: wait, message: "Rate limit exceeded"}, nil
+	}
+
+	var quotas = []struct {
+		name   string
+		quota  int
+		period string
+		reset  time.Time
+	}{
+		{"Daily", orDefault(apiKey.DailyQuota, DefaultDailyQuota), now.Format("2006-01-02"), time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)},
+		{"Monthly", orDefault(apiKey.MonthlyQuota, DefaultMonthlyQuota), now.Format("2006-01"), time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	// every quota is checked before any usage is counted, so refused requests
+	// aren't counted. Concurrent requests can overshoot a quota by a few.
+	for _, q := range quotas {
+		if q.quota <= 0 {
+			continue
+		}
+		count, err := stock.DB.GetUsage(apiKey.Key, q.period)
+		if err != nil {
+			return limits, nil, err
+		}
+		if count+n > q.quota {
+			limits = append(limits, rateLimit{q.name + "-", q.quota, maxInt(q.quota-count, 0), q.reset})
+			return limits, &refusal{retryAfter: q.reset.Sub(now), message: fmt.Sprintf("%s quota of %d requests exceeded", q.name, q.quota)}, nil
+		}
+	}
+
+	// admitted requests are always counted so they can be reported on, even without a quota
+	for _, q := range quotas {
+		count, err := stock.DB.IncrementUsage(apiKey.Key, q.period, n)
+		if err != nil {
+			return limits, nil, err
+		}
+		if q.quota > 0 {
+			limits = append(limits, rateLimit{q.name + "-", q.quota, maxInt(q.quota-count, 0), q.reset})
+		}
+	}
+	return limits, nil, nil
+}
+
+// bucket for the key, replaced if the key's limits have changed
+func (rl *RateLimiter) bucket(apiKey *stock.APIKey) *limit.Bucket {
+	rate := apiKey.RateLimit
+	if rate <= 0 {
+		rate = DefaultRateLimit
+	}
+	burst := orDefault(apiKey.Burst, DefaultBurst)
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	bucket, ok := rl.buckets[apiKey.Key]
+	if !ok || bucket.Rate != rate || bucket.Burst != burst {
+		bucket = limit.NewBucket(rate, burst)
+		rl.buckets[apiKey.Key] = bucket
+	}
+	return bucket
+}
+
+func tooManyRequests(w http.ResponseWriter, retryAfter time.Duration, message string) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	http.Error(w, message, http.StatusTooManyRequests)
+}
+
+func orDefault(value int, def int) int {
+	if value <= 0 {
+		return def
+	}
+	return value
+}
+
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
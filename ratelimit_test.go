@@ -0,0 +1,125 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jhurwich/trendy/stock"
+	"github.com/jhurwich/trendy/testhelpers"
+)
+
+func TestRateLimiterBucket(t *testing.T) {
+	t.Parallel()
+	rl := NewRateLimiter()
+
+	// keys without limits use the defaults
+	defaultKey := &stock.APIKey{Key: "default"}
+	bucket := rl.bucket(defaultKey)
+	if bucket.Rate != DefaultRateLimit || bucket.Burst != DefaultBurst {
+		t.Errorf("Expected default limits, got rate %f burst %d", bucket.Rate, bucket.Burst)
+	}
+	if rl.bucket(defaultKey) != bucket {
+		t.Errorf("Expected the same bucket for repeated requests by a key")
+	}
+
+	// keys with their own limits get their own bucket, replaced when limits change
+	customKey := &stock.APIKey{Key: "custom", RateLimit: 1, Burst: 2}
+	custom := rl.bucket(customKey)
+	if custom == bucket || custom.Rate != 1 || custom.Burst != 2 {
+		t.Errorf("Expected a separate bucket with custom limits, got rate %f burst %d", custom.Rate, custom.Burst)
+	}
+	customKey.Burst = 5
+	if changed := rl.bucket(customKey); changed == custom || changed.Burst != 5 {
+		t.Errorf("Expected a new bucket after limits changed, got burst %d", changed.Burst)
+	}
+}
+
+// serve a request by apiKey through rl, returning the response
+func serveLimited(rl *RateLimiter, apiKey *stock.APIKey) *httptest.ResponseRecorder {
+	r, _ := http.NewRequest("GET", "/stock/GOOG", nil)
+	r = r.WithContext(context.WithValue(r.Context(), apiKeyContextKey, apiKey))
+	w := httptest.NewRecorder()
+	rl.ServeHTTP(w, r, func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	return w
+}
+
+func TestRateLimiterServeHTTP(t *testing.T) {
+	_, discard := testhelpers.UseStore(t)
+	defer discard()
+	rl := NewRateLimiter()
+
+	// a burst of two that refills very slowly, the third request is refused
+	apiKey := &stock.APIKey{Key: "bursty", RateLimit: 0.01, Burst: 2}
+	for i, remaining := range []string{"1", "0"} {
+		w := serveLimited(rl, apiKey)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected request %d within the burst to be admitted, got %d", i, w.Code)
+		}
+		if w.Header().Get("X-RateLimit-Limit") != "2" || w.Header().Get("X-RateLimit-Remaining") != remaining {
+			t.Errorf("Expected limit 2 with %s remaining, got %v", remaining, w.Header())
+		}
+		if reset, err := strconv.ParseInt(w.Header().Get("X-RateLimit-Reset"), 10, 64); err != nil || reset < time.Now().Unix() {
+			t.Errorf("Expected a reset time, got %q", w.Header().Get("X-RateLimit-Reset"))
+		}
+		if w.Header().Get("X-RateLimit-Daily-Limit") != "" {
+			t.Errorf("Expected no quota headers without a quota, got %v", w.Header())
+		}
+	}
+	w := serveLimited(rl, apiKey)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Errorf("Expected 429 after the burst, got %d %v", w.Code, w.Header())
+	}
+	if retry, err := strconv.Atoi(w.Header().Get("Retry-After")); err != nil || retry < 1 || retry > 100 {
+		t.Errorf("Expected to retry within the 100s a token takes, got %q", w.Header().Get("Retry-After"))
+	}
+
+	// a daily quota of three, the fourth request is refused until tomorrow
+	apiKey = &stock.APIKey{Key: "daily", DailyQuota: 3}
+	for _, remaining := range []string{"2", "1", "0"} {
+		w := serveLimited(rl, apiKey)
+		if w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Daily-Limit") != "3" || w.Header().Get("X-RateLimit-Daily-Remaining") != remaining {
+			t.Errorf("Expected to be admitted with %s of 3 daily requests remaining, got %d %v", remaining, w.Code, w.Header())
+		}
+	}
+	now := time.Now().UTC()
+	tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 2; i++ {
+		w := serveLimited(rl, apiKey)
+		if w.Code != http.StatusTooManyRequests || w.Header().Get("X-RateLimit-Daily-Remaining") != "0" {
+			t.Errorf("Expected 429 once the daily quota is used, got %d %v", w.Code, w.Header())
+		}
+		if w.Header().Get("X-RateLimit-Daily-Reset") != strconv.FormatInt(tomorrow.Unix(), 10) {
+			t.Errorf("Expected the daily quota to reset at %s, got %v", tomorrow, w.Header())
+		}
+		if retry, err := strconv.Atoi(w.Header().Get("Retry-After")); err != nil || float64(retry) < tomorrow.Sub(time.Now()).Seconds()-5 {
+			t.Errorf("Expected to retry tomorrow, got %q", w.Header().Get("Retry-After"))
+		}
+	}
+	if count, _ := stock.DB.GetUsage("daily", now.Format("2006-01-02")); count != 3 {
+		t.Errorf("Expected only the 3 admitted requests counted, got %d", count)
+	}
+
+	// a request refused by the monthly quota isn't counted against the day either
+	apiKey = &stock.APIKey{Key: "monthly", DailyQuota: 10, MonthlyQuota: 1}
+	serveLimited(rl, apiKey)
+	if w := serveLimited(rl, apiKey); w.Code != http.StatusTooManyRequests || w.Header().Get("X-RateLimit-Monthly-Remaining") != "0" {
+		t.Errorf("Expected 429 once the monthly quota is used, got %d %v", w.Code, w.Header())
+	}
+	if count, _ := stock.DB.GetUsage("monthly", now.Format("2006-01-02")); count != 1 {
+		t.Errorf("Expected the refused request not to be counted for the day, got %d", count)
+	}
+
+	// requests without a key aren't limited
+	r, _ := http.NewRequest("GET", "/healthz", nil)
+	w = httptest.NewRecorder()
+	rl.ServeHTTP(w, r, func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	if w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Limit") != "" {
+		t.Errorf("Expected a request without a key to pass unlimited, got %d %v", w.Code, w.Header())
+	}
+}
@@ -0,0 +1,6 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+// Package rpc is the gRPC api, generated from trendy.proto
+package rpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative trendy.proto
@@ -0,0 +1,1007 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.9
+// 	protoc        v5.29.3
+// source: trendy.proto
+
+package rpc
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type GetBarsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Symbol        string                 `protobuf:"bytes,1,opt,name=symbol,proto3" json:"symbol,omitempty"`
+	Start         string                 `protobuf:"bytes,2,opt,name=start,proto3" json:"start,omitempty"`
+	End           string                 `protobuf:"bytes,3,opt,name=end,proto3" json:"end,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetBarsRequest) Reset() {
+	*x = GetBarsRequest{}
+	mi := &file_trendy_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetBarsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetBarsRequest) ProtoMessage() {}
+
+func (x *GetBarsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_trendy_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetBarsRequest.ProtoReflect.Descriptor instead.
+func (*GetBarsRequest) Descriptor() ([]byte, []int) {
+	return file_trendy_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *GetBarsRequest) GetSymbol() string {
+	if x != nil {
+		return x.Symbol
+	}
+	return ""
+}
+
+func (x *GetBarsRequest) GetStart() string {
+	if x != nil {
+		return x.Start
+	}
+	return ""
+}
+
+func (x *GetBarsRequest) GetEnd() string {
+	if x != nil {
+		return x.End
+	}
+	return ""
+}
+
+type Bar struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Symbol        string                 `protobuf:"bytes,1,opt,name=symbol,proto3" json:"symbol,omitempty"`
+	Time          *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=time,proto3" json:"time,omitempty"`
+	Close         float32                `protobuf:"fixed32,3,opt,name=close,proto3" json:"close,omitempty"`
+	Open          *float32               `protobuf:"fixed32,4,opt,name=open,proto3,oneof" json:"open,omitempty"`
+	High          *float32               `protobuf:"fixed32,5,opt,name=high,proto3,oneof" json:"high,omitempty"`
+	Low           *float32               `protobuf:"fixed32,6,opt,name=low,proto3,oneof" json:"low,omitempty"`
+	Volume        *int64                 `protobuf:"varint,7,opt,name=volume,proto3,oneof" json:"volume,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Bar) Reset() {
+	*x = Bar{}
+	mi := &file_trendy_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Bar) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Bar) ProtoMessage() {}
+
+func (x *Bar) ProtoReflect() protoreflect.Message {
+	mi := &file_trendy_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Bar.ProtoReflect.Descriptor instead.
+func (*Bar) Descriptor() ([]byte, []int) {
+	return file_trendy_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Bar) GetSymbol() string {
+	if x != nil {
+		return x.Symbol
+	}
+	return ""
+}
+
+func (x *Bar) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+func (x *Bar) GetClose() float32 {
+	if x != nil {
+		return x.Close
+	}
+	return 0
+}
+
+func (x *Bar) GetOpen() float32 {
+	if x != nil && x.Open != nil {
+		return *x.Open
+	}
+	return 0
+}
+
+func (x *Bar) GetHigh() float32 {
+	if x != nil && x.High != nil {
+		return *x.High
+	}
+	return 0
+}
+
+func (x *Bar) GetLow() float32 {
+	if x != nil && x.Low != nil {
+		return *x.Low
+	}
+	return 0
+}
+
+func (x *Bar) GetVolume() int64 {
+	if x != nil && x.Volume != nil {
+		return *x.Volume
+	}
+	return 0
+}
+
+type Bars struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Symbol        string                 `protobuf:"bytes,1,opt,name=symbol,proto3" json:"symbol,omitempty"`
+	Bars          []*Bar                 `protobuf:"bytes,2,rep,name=bars,proto3" json:"bars,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Bars) Reset() {
+	*x = Bars{}
+	mi := &file_trendy_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Bars) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Bars) ProtoMessage() {}
+
+func (x *Bars) ProtoReflect() protoreflect.Message {
+	mi := &file_trendy_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Bars.ProtoReflect.Descriptor instead.
+func (*Bars) Descriptor() ([]byte, []int) {
+	return file_trendy_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Bars) GetSymbol() string {
+	if x != nil {
+		return x.Symbol
+	}
+	return ""
+}
+
+func (x *Bars) GetBars() []*Bar {
+	if x != nil {
+		return x.Bars
+	}
+	return nil
+}
+
+type BatchGetBarsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Requests      []*GetBarsRequest      `protobuf:"bytes,1,rep,name=requests,proto3" json:"requests,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchGetBarsRequest) Reset() {
+	*x = BatchGetBarsRequest{}
+	mi := &file_trendy_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchGetBarsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchGetBarsRequest) ProtoMessage() {}
+
+func (x *BatchGetBarsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_trendy_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchGetBarsRequest.ProtoReflect.Descriptor instead.
+func (*BatchGetBarsRequest) Descriptor() ([]byte, []int) {
+	return file_trendy_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *BatchGetBarsRequest) GetRequests() []*GetBarsRequest {
+	if x != nil {
+		return x.Requests
+	}
+	return nil
+}
+
+type BarsResult struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Result:
+	//
+	//	*BarsResult_Bars
+	//	*BarsResult_Error
+	Result        isBarsResult_Result `protobuf_oneof:"result"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BarsResult) Reset() {
+	*x = BarsResult{}
+	mi := &file_trendy_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BarsResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BarsResult) ProtoMessage() {}
+
+func (x *BarsResult) ProtoReflect() protoreflect.Message {
+	mi := &file_trendy_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BarsResult.ProtoReflect.Descriptor instead.
+func (*BarsResult) Descriptor() ([]byte, []int) {
+	return file_trendy_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *BarsResult) GetResult() isBarsResult_Result {
+	if x != nil {
+		return x.Result
+	}
+	return nil
+}
+
+func (x *BarsResult) GetBars() *Bars {
+	if x != nil {
+		if x, ok := x.Result.(*BarsResult_Bars); ok {
+			return x.Bars
+		}
+	}
+	return nil
+}
+
+func (x *BarsResult) GetError() string {
+	if x != nil {
+		if x, ok := x.Result.(*BarsResult_Error); ok {
+			return x.Error
+		}
+	}
+	return ""
+}
+
+type isBarsResult_Result interface {
+	isBarsResult_Result()
+}
+
+type BarsResult_Bars struct {
+	Bars *Bars `protobuf:"bytes,1,opt,name=bars,proto3,oneof"`
+}
+
+type BarsResult_Error struct {
+	Error string `protobuf:"bytes,2,opt,name=error,proto3,oneof"`
+}
+
+func (*BarsResult_Bars) isBarsResult_Result() {}
+
+func (*BarsResult_Error) isBarsResult_Result() {}
+
+type BatchGetBarsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Results       []*BarsResult          `protobuf:"bytes,1,rep,name=results,proto3" json:"results,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchGetBarsResponse) Reset() {
+	*x = BatchGetBarsResponse{}
+	mi := &file_trendy_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchGetBarsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchGetBarsResponse) ProtoMessage() {}
+
+func (x *BatchGetBarsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_trendy_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchGetBarsResponse.ProtoReflect.Descriptor instead.
+func (*BatchGetBarsResponse) Descriptor() ([]byte, []int) {
+	return file_trendy_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *BatchGetBarsResponse) GetResults() []*BarsResult {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
+type GetTrendRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Symbol        string                 `protobuf:"bytes,1,opt,name=symbol,proto3" json:"symbol,omitempty"`
+	Start         string                 `protobuf:"bytes,2,opt,name=start,proto3" json:"start,omitempty"`
+	End           string                 `protobuf:"bytes,3,opt,name=end,proto3" json:"end,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetTrendRequest) Reset() {
+	*x = GetTrendRequest{}
+	mi := &file_trendy_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetTrendRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetTrendRequest) ProtoMessage() {}
+
+func (x *GetTrendRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_trendy_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetTrendRequest.ProtoReflect.Descriptor instead.
+func (*GetTrendRequest) Descriptor() ([]byte, []int) {
+	return file_trendy_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *GetTrendRequest) GetSymbol() string {
+	if x != nil {
+		return x.Symbol
+	}
+	return ""
+}
+
+func (x *GetTrendRequest) GetStart() string {
+	if x != nil {
+		return x.Start
+	}
+	return ""
+}
+
+func (x *GetTrendRequest) GetEnd() string {
+	if x != nil {
+		return x.End
+	}
+	return ""
+}
+
+type Trend struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Symbol        string                 `protobuf:"bytes,1,opt,name=symbol,proto3" json:"symbol,omitempty"`
+	Start         *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=start,proto3" json:"start,omitempty"`
+	End           *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=end,proto3" json:"end,omitempty"`
+	SlopePerDay   float64                `protobuf:"fixed64,4,opt,name=slope_per_day,json=slopePerDay,proto3" json:"slope_per_day,omitempty"`
+	Intercept     float64                `protobuf:"fixed64,5,opt,name=intercept,proto3" json:"intercept,omitempty"`
+	RSquared      float64                `protobuf:"fixed64,6,opt,name=r_squared,json=rSquared,proto3" json:"r_squared,omitempty"`
+	Change        float64                `protobuf:"fixed64,7,opt,name=change,proto3" json:"change,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Trend) Reset() {
+	*x = Trend{}
+	mi := &file_trendy_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Trend) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Trend) ProtoMessage() {}
+
+func (x *Trend) ProtoReflect() protoreflect.Message {
+	mi := &file_trendy_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Trend.ProtoReflect.Descriptor instead.
+func (*Trend) Descriptor() ([]byte, []int) {
+	return file_trendy_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *Trend) GetSymbol() string {
+	if x != nil {
+		return x.Symbol
+	}
+	return ""
+}
+
+func (x *Trend) GetStart() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Start
+	}
+	return nil
+}
+
+func (x *Trend) GetEnd() *timestamppb.Timestamp {
+	if x != nil {
+		return x.End
+	}
+	return nil
+}
+
+func (x *Trend) GetSlopePerDay() float64 {
+	if x != nil {
+		return x.SlopePerDay
+	}
+	return 0
+}
+
+func (x *Trend) GetIntercept() float64 {
+	if x != nil {
+		return x.Intercept
+	}
+	return 0
+}
+
+func (x *Trend) GetRSquared() float64 {
+	if x != nil {
+		return x.RSquared
+	}
+	return 0
+}
+
+func (x *Trend) GetChange() float64 {
+	if x != nil {
+		return x.Change
+	}
+	return 0
+}
+
+type BatchGetTrendsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Requests      []*GetTrendRequest     `protobuf:"bytes,1,rep,name=requests,proto3" json:"requests,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchGetTrendsRequest) Reset() {
+	*x = BatchGetTrendsRequest{}
+	mi := &file_trendy_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchGetTrendsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchGetTrendsRequest) ProtoMessage() {}
+
+func (x *BatchGetTrendsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_trendy_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchGetTrendsRequest.ProtoReflect.Descriptor instead.
+func (*BatchGetTrendsRequest) Descriptor() ([]byte, []int) {
+	return file_trendy_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *BatchGetTrendsRequest) GetRequests() []*GetTrendRequest {
+	if x != nil {
+		return x.Requests
+	}
+	return nil
+}
+
+type TrendResult struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Result:
+	//
+	//	*TrendResult_Trend
+	//	*TrendResult_Error
+	Result        isTrendResult_Result `protobuf_oneof:"result"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TrendResult) Reset() {
+	*x = TrendResult{}
+	mi := &file_trendy_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TrendResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TrendResult) ProtoMessage() {}
+
+func (x *TrendResult) ProtoReflect() protoreflect.Message {
+	mi := &file_trendy_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TrendResult.ProtoReflect.Descriptor instead.
+func (*TrendResult) Descriptor() ([]byte, []int) {
+	return file_trendy_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *TrendResult) GetResult() isTrendResult_Result {
+	if x != nil {
+		return x.Result
+	}
+	return nil
+}
+
+func (x *TrendResult) GetTrend() *Trend {
+	if x != nil {
+		if x, ok := x.Result.(*TrendResult_Trend); ok {
+			return x.Trend
+		}
+	}
+	return nil
+}
+
+func (x *TrendResult) GetError() string {
+	if x != nil {
+		if x, ok := x.Result.(*TrendResult_Error); ok {
+			return x.Error
+		}
+	}
+	return ""
+}
+
+type isTrendResult_Result interface {
+	isTrendResult_Result()
+}
+
+type TrendResult_Trend struct {
+	Trend *Trend `protobuf:"bytes,1,opt,name=trend,proto3,oneof"`
+}
+
+type TrendResult_Error struct {
+	Error string `protobuf:"bytes,2,opt,name=error,proto3,oneof"`
+}
+
+func (*TrendResult_Trend) isTrendResult_Result() {}
+
+func (*TrendResult_Error) isTrendResult_Result() {}
+
+type BatchGetTrendsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Results       []*TrendResult         `protobuf:"bytes,1,rep,name=results,proto3" json:"results,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchGetTrendsResponse) Reset() {
+	*x = BatchGetTrendsResponse{}
+	mi := &file_trendy_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchGetTrendsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchGetTrendsResponse) ProtoMessage() {}
+
+func (x *BatchGetTrendsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_trendy_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchGetTrendsResponse.ProtoReflect.Descriptor instead.
+func (*BatchGetTrendsResponse) Descriptor() ([]byte, []int) {
+	return file_trendy_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *BatchGetTrendsResponse) GetResults() []*TrendResult {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
+type StreamBarsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Symbols       []string               `protobuf:"bytes,1,rep,name=symbols,proto3" json:"symbols,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StreamBarsRequest) Reset() {
+	*x = StreamBarsRequest{}
+	mi := &file_trendy_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamBarsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamBarsRequest) ProtoMessage() {}
+
+func (x *StreamBarsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_trendy_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamBarsRequest.ProtoReflect.Descriptor instead.
+func (*StreamBarsRequest) Descriptor() ([]byte, []int) {
+	return file_trendy_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *StreamBarsRequest) GetSymbols() []string {
+	if x != nil {
+		return x.Symbols
+	}
+	return nil
+}
+
+type StreamBarsResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Event:
+	//
+	//	*StreamBarsResponse_Bar
+	//	*StreamBarsResponse_Dropped
+	Event         isStreamBarsResponse_Event `protobuf_oneof:"event"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StreamBarsResponse) Reset() {
+	*x = StreamBarsResponse{}
+	mi := &file_trendy_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamBarsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamBarsResponse) ProtoMessage() {}
+
+func (x *StreamBarsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_trendy_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamBarsResponse.ProtoReflect.Descriptor instead.
+func (*StreamBarsResponse) Descriptor() ([]byte, []int) {
+	return file_trendy_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *StreamBarsResponse) GetEvent() isStreamBarsResponse_Event {
+	if x != nil {
+		return x.Event
+	}
+	return nil
+}
+
+func (x *StreamBarsResponse) GetBar() *Bar {
+	if x != nil {
+		if x, ok := x.Event.(*StreamBarsResponse_Bar); ok {
+			return x.Bar
+		}
+	}
+	return nil
+}
+
+func (x *StreamBarsResponse) GetDropped() int64 {
+	if x != nil {
+		if x, ok := x.Event.(*StreamBarsResponse_Dropped); ok {
+			return x.Dropped
+		}
+	}
+	return 0
+}
+
+type isStreamBarsResponse_Event interface {
+	isStreamBarsResponse_Event()
+}
+
+type StreamBarsResponse_Bar struct {
+	Bar *Bar `protobuf:"bytes,1,opt,name=bar,proto3,oneof"`
+}
+
+type StreamBarsResponse_Dropped struct {
+	Dropped int64 `protobuf:"varint,2,opt,name=dropped,proto3,oneof"`
+}
+
+func (*StreamBarsResponse_Bar) isStreamBarsResponse_Event() {}
+
+func (*StreamBarsResponse_Dropped) isStreamBarsResponse_Event() {}
+
+var File_trendy_proto protoreflect.FileDescriptor
+
+const file_trendy_proto_rawDesc = "" +
+	"\n" +
+	"\ftrendy.proto\x12\ttrendy.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"P\n" +
+	"\x0eGetBarsRequest\x12\x16\n" +
+	"\x06symbol\x18\x01 \x01(\tR\x06symbol\x12\x14\n" +
+	"\x05start\x18\x02 \x01(\tR\x05start\x12\x10\n" +
+	"\x03end\x18\x03 \x01(\tR\x03end\"\xee\x01\n" +
+	"\x03Bar\x12\x16\n" +
+	"\x06symbol\x18\x01 \x01(\tR\x06symbol\x12.\n" +
+	"\x04time\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\x04time\x12\x14\n" +
+	"\x05close\x18\x03 \x01(\x02R\x05close\x12\x17\n" +
+	"\x04open\x18\x04 \x01(\x02H\x00R\x04open\x88\x01\x01\x12\x17\n" +
+	"\x04high\x18\x05 \x01(\x02H\x01R\x04high\x88\x01\x01\x12\x15\n" +
+	"\x03low\x18\x06 \x01(\x02H\x02R\x03low\x88\x01\x01\x12\x1b\n" +
+	"\x06volume\x18\a \x01(\x03H\x03R\x06volume\x88\x01\x01B\a\n" +
+	"\x05_openB\a\n" +
+	"\x05_highB\x06\n" +
+	"\x04_lowB\t\n" +
+	"\a_volume\"B\n" +
+	"\x04Bars\x12\x16\n" +
+	"\x06symbol\x18\x01 \x01(\tR\x06symbol\x12\"\n" +
+	"\x04bars\x18\x02 \x03(\v2\x0e.trendy.v1.BarR\x04bars\"L\n" +
+	"\x13BatchGetBarsRequest\x125\n" +
+	"\brequests\x18\x01 \x03(\v2\x19.trendy.v1.GetBarsRequestR\brequests\"U\n" +
+	"\n" +
+	"BarsResult\x12%\n" +
+	"\x04bars\x18\x01 \x01(\v2\x0f.trendy.v1.BarsH\x00R\x04bars\x12\x16\n" +
+	"\x05error\x18\x02 \x01(\tH\x00R\x05errorB\b\n" +
+	"\x06result\"G\n" +
+	"\x14BatchGetBarsResponse\x12/\n" +
+	"\aresults\x18\x01 \x03(\v2\x15.trendy.v1.BarsResultR\aresults\"Q\n" +
+	"\x0fGetTrendRequest\x12\x16\n" +
+	"\x06symbol\x18\x01 \x01(\tR\x06symbol\x12\x14\n" +
+	"\x05start\x18\x02 \x01(\tR\x05start\x12\x10\n" +
+	"\x03end\x18\x03 \x01(\tR\x03end\"\xf6\x01\n" +
+	"\x05Trend\x12\x16\n" +
+	"\x06symbol\x18\x01 \x01(\tR\x06symbol\x120\n" +
+	"\x05start\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\x05start\x12,\n" +
+	"\x03end\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\x03end\x12\"\n" +
+	"\rslope_per_day\x18\x04 \x01(\x01R\vslopePerDay\x12\x1c\n" +
+	"\tintercept\x18\x05 \x01(\x01R\tintercept\x12\x1b\n" +
+	"\tr_squared\x18\x06 \x01(\x01R\brSquared\x12\x16\n" +
+	"\x06change\x18\a \x01(\x01R\x06change\"O\n" +
+	"\x15BatchGetTrendsRequest\x126\n" +
+	"\brequests\x18\x01 \x03(\v2\x1a.trendy.v1.GetTrendRequestR\brequests\"Y\n" +
+	"\vTrendResult\x12(\n" +
+	"\x05trend\x18\x01 \x01(\v2\x10.trendy.v1.TrendH\x00R\x05trend\x12\x16\n" +
+	"\x05error\x18\x02 \x01(\tH\x00R\x05errorB\b\n" +
+	"\x06result\"J\n" +
+	"\x16BatchGetTrendsResponse\x120\n" +
+	"\aresults\x18\x01 \x03(\v2\x16.trendy.v1.TrendResultR\aresults\"-\n" +
+	"\x11StreamBarsRequest\x12\x18\n" +
+	"\asymbols\x18\x01 \x03(\tR\asymbols\"]\n" +
+	"\x12StreamBarsResponse\x12\"\n" +
+	"\x03bar\x18\x01 \x01(\v2\x0e.trendy.v1.BarH\x00R\x03bar\x12\x1a\n" +
+	"\adropped\x18\x02 \x01(\x03H\x00R\adroppedB\a\n" +
+	"\x05event2\xee\x02\n" +
+	"\x06Trendy\x125\n" +
+	"\aGetBars\x12\x19.trendy.v1.GetBarsRequest\x1a\x0f.trendy.v1.Bars\x12O\n" +
+	"\fBatchGetBars\x12\x1e.trendy.v1.BatchGetBarsRequest\x1a\x1f.trendy.v1.BatchGetBarsResponse\x128\n" +
+	"\bGetTrend\x12\x1a.trendy.v1.GetTrendRequest\x1a\x10.trendy.v1.Trend\x12U\n" +
+	"\x0eBatchGetTrends\x12 .trendy.v1.BatchGetTrendsRequest\x1a!.trendy.v1.BatchGetTrendsResponse\x12K\n" +
+	"\n" +
+	"StreamBars\x12\x1c.trendy.v1.StreamBarsRequest\x1a\x1d.trendy.v1.StreamBarsResponse0\x01B$Z\"github.com/jhurwich/trendy/rpc;rpcb\x06proto3"
+
+var (
+	file_trendy_proto_rawDescOnce sync.Once
+	file_trendy_proto_rawDescData []byte
+)
+
+func file_trendy_proto_rawDescGZIP() []byte {
+	file_trendy_proto_rawDescOnce.Do(func() {
+		file_trendy_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_trendy_proto_rawDesc), len(file_trendy_proto_rawDesc)))
+	})
+	return file_trendy_proto_rawDescData
+}
+
+var file_trendy_proto_msgTypes = make([]protoimpl.MessageInfo, 13)
+var file_trendy_proto_goTypes = []any{
+	(*GetBarsRequest)(nil),         // 0: trendy.v1.GetBarsRequest
+	(*Bar)(nil),                    // 1: trendy.v1.Bar
+	(*Bars)(nil),                   // 2: trendy.v1.Bars
+	(*BatchGetBarsRequest)(nil),    // 3: trendy.v1.BatchGetBarsRequest
+	(*BarsResult)(nil),             // 4: trendy.v1.BarsResult
+	(*BatchGetBarsResponse)(nil),   // 5: trendy.v1.BatchGetBarsResponse
+	(*GetTrendRequest)(nil),        // 6: trendy.v1.GetTrendRequest
+	(*Trend)(nil),                  // 7: trendy.v1.Trend
+	(*BatchGetTrendsRequest)(nil),  // 8: trendy.v1.BatchGetTrendsRequest
+	(*TrendResult)(nil),            // 9: trendy.v1.TrendResult
+	(*BatchGetTrendsResponse)(nil), // 10: trendy.v1.BatchGetTrendsResponse
+	(*StreamBarsRequest)(nil),      // 11: trendy.v1.StreamBarsRequest
+	(*StreamBarsResponse)(nil),     // 12: trendy.v1.StreamBarsResponse
+	(*timestamppb.Timestamp)(nil),  // 13: google.protobuf.Timestamp
+}
+var file_trendy_proto_depIdxs = []int32{
+	13, // 0: trendy.v1.Bar.time:type_name -> google.protobuf.Timestamp
+	1,  // 1: trendy.v1.Bars.bars:type_name -> trendy.v1.Bar
+	0,  // 2: trendy.v1.BatchGetBarsRequest.requests:type_name -> trendy.v1.GetBarsRequest
+	2,  // 3: trendy.v1.BarsResult.bars:type_name -> trendy.v1.Bars
+	4,  // 4: trendy.v1.BatchGetBarsResponse.results:type_name -> trendy.v1.BarsResult
+	13, // 5: trendy.v1.Trend.start:type_name -> google.protobuf.Timestamp
+	13, // 6: trendy.v1.Trend.end:type_name -> google.protobuf.Timestamp
+	6,  // 7: trendy.v1.BatchGetTrendsRequest.requests:type_name -> trendy.v1.GetTrendRequest
+	7,  // 8: trendy.v1.TrendResult.trend:type_name -> trendy.v1.Trend
+	9,  // 9: trendy.v1.BatchGetTrendsResponse.results:type_name -> trendy.v1.TrendResult
+	1,  // 10: trendy.v1.StreamBarsResponse.bar:type_name -> trendy.v1.Bar
+	0,  // 11: trendy.v1.Trendy.GetBars:input_type -> trendy.v1.GetBarsRequest
+	3,  // 12: trendy.v1.Trendy.BatchGetBars:input_type -> trendy.v1.BatchGetBarsRequest
+	6,  // 13: trendy.v1.Trendy.GetTrend:input_type -> trendy.v1.GetTrendRequest
+	8,  // 14: trendy.v1.Trendy.BatchGetTrends:input_type -> trendy.v1.BatchGetTrendsRequest
+	11, // 15: trendy.v1.Trendy.StreamBars:input_type -> trendy.v1.StreamBarsRequest
+	2,  // 16: trendy.v1.Trendy.GetBars:output_type -> trendy.v1.Bars
+	5,  // 17: trendy.v1.Trendy.BatchGetBars:output_type -> trendy.v1.BatchGetBarsResponse
+	7,  // 18: trendy.v1.Trendy.GetTrend:output_type -> trendy.v1.Trend
+	10, // 19: trendy.v1.Trendy.BatchGetTrends:output_type -> trendy.v1.BatchGetTrendsResponse
+	12, // 20: trendy.v1.Trendy.StreamBars:output_type -> trendy.v1.StreamBarsResponse
+	16, // [16:21] is the sub-list for method output_type
+	11, // [11:16] is the sub-list for method input_type
+	11, // [11:11] is the sub-list for extension type_name
+	11, // [11:11] is the sub-list for extension extendee
+	0,  // [0:11] is the sub-list for field type_name
+}
+
+func init() { file_trendy_proto_init() }
+func file_trendy_proto_init() {
+	if File_trendy_proto != nil {
+		return
+	}
+	file_trendy_proto_msgTypes[1].OneofWrappers = []any{}
+	file_trendy_proto_msgTypes[4].OneofWrappers = []any{
+		(*BarsResult_Bars)(nil),
+		(*BarsResult_Error)(nil),
+	}
+	file_trendy_proto_msgTypes[9].OneofWrappers = []any{
+		(*TrendResult_Trend)(nil),
+		(*TrendResult_Error)(nil),
+	}
+	file_trendy_proto_msgTypes[12].OneofWrappers = []any{
+		(*StreamBarsResponse_Bar)(nil),
+		(*StreamBarsResponse_Dropped)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_trendy_proto_rawDesc), len(file_trendy_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   13,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_trendy_proto_goTypes,
+		DependencyIndexes: file_trendy_proto_depIdxs,
+		MessageInfos:      file_trendy_proto_msgTypes,
+	}.Build()
+	File_trendy_proto = out.File
+	file_trendy_proto_goTypes = nil
+	file_trendy_proto_depIdxs = nil
+}
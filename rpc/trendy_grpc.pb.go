@@ -0,0 +1,277 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.29.3
+// source: trendy.proto
+
+package rpc
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Trendy_GetBars_FullMethodName        = "/trendy.v1.Trendy/GetBars"
+	Trendy_BatchGetBars_FullMethodName   = "/trendy.v1.Trendy/BatchGetBars"
+	Trendy_GetTrend_FullMethodName       = "/trendy.v1.Trendy/GetTrend"
+	Trendy_BatchGetTrends_FullMethodName = "/trendy.v1.Trendy/BatchGetTrends"
+	Trendy_StreamBars_FullMethodName     = "/trendy.v1.Trendy/StreamBars"
+)
+
+// TrendyClient is the client API for Trendy service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type TrendyClient interface {
+	GetBars(ctx context.Context, in *GetBarsRequest, opts ...grpc.CallOption) (*Bars, error)
+	BatchGetBars(ctx context.Context, in *BatchGetBarsRequest, opts ...grpc.CallOption) (*BatchGetBarsResponse, error)
+	GetTrend(ctx context.Context, in *GetTrendRequest, opts ...grpc.CallOption) (*Trend, error)
+	BatchGetTrends(ctx context.Context, in *BatchGetTrendsRequest, opts ...grpc.CallOption) (*BatchGetTrendsResponse, error)
+	StreamBars(ctx context.Context, in *StreamBarsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[StreamBarsResponse], error)
+}
+
+type trendyClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewTrendyClient(cc grpc.ClientConnInterface) TrendyClient {
+	return &trendyClient{cc}
+}
+
+func (c *trendyClient) GetBars(ctx context.Context, in *GetBarsRequest, opts ...grpc.CallOption) (*Bars, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Bars)
+	err := c.cc.Invoke(ctx, Trendy_GetBars_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *trendyClient) BatchGetBars(ctx context.Context, in *BatchGetBarsRequest, opts ...grpc.CallOption) (*BatchGetBarsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(BatchGetBarsResponse)
+	err := c.cc.Invoke(ctx, Trendy_BatchGetBars_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *trendyClient) GetTrend(ctx context.Context, in *GetTrendRequest, opts ...grpc.CallOption) (*Trend, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Trend)
+	err := c.cc.Invoke(ctx, Trendy_GetTrend_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *trendyClient) BatchGetTrends(ctx context.Context, in *BatchGetTrendsRequest, opts ...grpc.CallOption) (*BatchGetTrendsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(BatchGetTrendsResponse)
+	err := c.cc.Invoke(ctx, Trendy_BatchGetTrends_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *trendyClient) StreamBars(ctx context.Context, in *StreamBarsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[StreamBarsResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Trendy_ServiceDesc.Streams[0], Trendy_StreamBars_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[StreamBarsRequest, StreamBarsResponse]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Trendy_StreamBarsClient = grpc.ServerStreamingClient[StreamBarsResponse]
+
+// TrendyServer is the server API for Trendy service.
+// All implementations must embed UnimplementedTrendyServer
+// for forward compatibility.
+type TrendyServer interface {
+	GetBars(context.Context, *GetBarsRequest) (*Bars, error)
+	BatchGetBars(context.Context, *BatchGetBarsRequest) (*BatchGetBarsResponse, error)
+	GetTrend(context.Context, *GetTrendRequest) (*Trend, error)
+	BatchGetTrends(context.Context, *BatchGetTrendsRequest) (*BatchGetTrendsResponse, error)
+	StreamBars(*StreamBarsRequest, grpc.ServerStreamingServer[StreamBarsResponse]) error
+	mustEmbedUnimplementedTrendyServer()
+}
+
+// UnimplementedTrendyServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedTrendyServer struct{}
+
+func (UnimplementedTrendyServer) GetBars(context.Context, *GetBarsRequest) (*Bars, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetBars not implemented")
+}
+func (UnimplementedTrendyServer) BatchGetBars(context.Context, *BatchGetBarsRequest) (*BatchGetBarsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchGetBars not implemented")
+}
+func (UnimplementedTrendyServer) GetTrend(context.Context, *GetTrendRequest) (*Trend, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetTrend not implemented")
+}
+func (UnimplementedTrendyServer) BatchGetTrends(context.Context, *BatchGetTrendsRequest) (*BatchGetTrendsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchGetTrends not implemented")
+}
+func (UnimplementedTrendyServer) StreamBars(*StreamBarsRequest, grpc.ServerStreamingServer[StreamBarsResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamBars not implemented")
+}
+func (UnimplementedTrendyServer) mustEmbedUnimplementedTrendyServer() {}
+func (UnimplementedTrendyServer) testEmbeddedByValue()                {}
+
+// UnsafeTrendyServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to TrendyServer will
+// result in compilation errors.
+type UnsafeTrendyServer interface {
+	mustEmbedUnimplementedTrendyServer()
+}
+
+func RegisterTrendyServer(s grpc.ServiceRegistrar, srv TrendyServer) {
+	// If the following call pancis, it indicates UnimplementedTrendyServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Trendy_ServiceDesc, srv)
+}
+
+func _Trendy_GetBars_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetBarsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TrendyServer).GetBars(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Trendy_GetBars_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TrendyServer).GetBars(ctx, req.(*GetBarsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Trendy_BatchGetBars_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchGetBarsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TrendyServer).BatchGetBars(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Trendy_BatchGetBars_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TrendyServer).BatchGetBars(ctx, req.(*BatchGetBarsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Trendy_GetTrend_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetTrendRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TrendyServer).GetTrend(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Trendy_GetTrend_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TrendyServer).GetTrend(ctx, req.(*GetTrendRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Trendy_BatchGetTrends_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchGetTrendsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TrendyServer).BatchGetTrends(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Trendy_BatchGetTrends_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TrendyServer).BatchGetTrends(ctx, req.(*BatchGetTrendsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Trendy_StreamBars_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamBarsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TrendyServer).StreamBars(m, &grpc.GenericServerStream[StreamBarsRequest, StreamBarsResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Trendy_StreamBarsServer = grpc.ServerStreamingServer[StreamBarsResponse]
+
+// Trendy_ServiceDesc is the grpc.ServiceDesc for Trendy service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Trendy_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "trendy.v1.Trendy",
+	HandlerType: (*TrendyServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetBars",
+			Handler:    _Trendy_GetBars_Handler,
+		},
+		{
+			MethodName: "BatchGetBars",
+			Handler:    _Trendy_BatchGetBars_Handler,
+		},
+		{
+			MethodName: "GetTrend",
+			Handler:    _Trendy_GetTrend_Handler,
+		},
+		{
+			MethodName: "BatchGetTrends",
+			Handler:    _Trendy_BatchGetTrends_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamBars",
+			Handler:       _Trendy_StreamBars_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "trendy.proto",
+}
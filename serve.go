@@ -0,0 +1,259 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"google.golang.org/grpc"
+
+	"github.com/jhurwich/trendy/config"
+)
+
+// Serve handler as configured until a signal other than SIGHUP arrives on
+// signals, then shut down gracefully. Over TLS the certificate is reloaded on
+// SIGHUP, and when its files change. With a RedirectListen address plain http
+// is also served there, the secure middleware in handler redirects it to https.
+// With a GRPCListen address the gRPC api is served there, over TLS if https is.
+// In-flight requests and calls are given cfg.Timeouts.Shutdown to finish.
+// stopBackground is called as shutdown starts, to cancel work like refreshes
+// and polls that isn't serving a request.
+func Serve(cfg *config.Config, handler http.Handler, signals <-chan os.Signal, stopBackground context.CancelFunc) error {
+	var certs *CertReloader
+	if cfg.TLS.CertFile != "" {
+		var err error
+		if certs, err = NewCertReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile); err != nil {
+			return err
+		}
+	}
+
+	servers := []*http.Server{newHTTPServer(cfg, cfg.Listen, handler)}
+	listeners := []net.Listener{}
+	if certs != nil && cfg.RedirectListen != "" {
+		servers = append(servers, newHTTPServer(cfg, cfg.RedirectListen, handler))
+	}
+	for _, server := range servers {
+		l, err := net.Listen("tcp", server.Addr)
+		if err != nil {
+			for _, opened := range listeners {
+				opened.Close()
+			}
+			return err
+		}
+		listeners = append(listeners, l)
+	}
+	var grpcServer *grpc.Server
+	var grpcListener net.Listener
+	if cfg.GRPCListen != "" {
+		l, err := net.Listen("tcp", cfg.GRPCListen)
+		if err != nil {
+			for _, opened := range listeners {
+				opened.Close()
+			}
+			return err
+		}
+		grpcServer, grpcListener = NewGRPCServer(certs), l
+	}
+	if certs != nil {
+		servers[0].TLSConfig = &tls.Config{GetCertificate: certs.GetCertificate, MinVersion: tls.VersionTLS12}
+		listeners[0] = tls.NewListener(listeners[0], servers[0].TLSConfig)
+	}
+
+	// streams never go idle on their own, end them when shutdown starts
+	servers[0].RegisterOnShutdown(closeStreams)
+
+	errs := make(chan error, len(servers)+1)
+	for i, server := range servers {
+		go func(server *http.Server, l net.Listener) {
+			errs <- server.Serve(l)
+		}(server, listeners[i])
+	}
+	if grpcServer != nil {
+		go func() {
+			if err := grpcServer.Serve(grpcListener); err != nil {
+				errs <- err
+			}
+		}()
+	}
+
+	var serveErr error
+	for serveErr == nil {
+		select {
+		case serveErr = <-errs:
+		case sig := <-signals:
+			if sig != syscall.SIGHUP {
+				serveErr = http.ErrServerClosed
+			} else if certs != nil {
+				certs.reloadOrKeep("SIGHUP")
+			}
+		}
+	}
+
+	stopBackground()
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeouts.Shutdown.Duration)
+	defer cancel()
+	grpcStopped := make(chan struct{})
+	if grpcServer != nil {
+		// streams are ended by closeStreams when the first http server shuts down
+		go func() {
+			grpcServer.GracefulStop()
+			close(grpcStopped)
+		}()
+	} else {
+		close(grpcStopped)
+	}
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil && serveErr == http.ErrServerClosed {
+			serveErr = err
+		}
+	}
+	select {
+	case <-grpcStopped:
+	case <-ctx.Done():
+		grpcServer.Stop()
+		if serveErr == http.ErrServerClosed {
+			serveErr = ctx.Err()
+		}
+	}
+	if serveErr == http.ErrServerClosed {
+		return nil
+	}
+	return serveErr
+}
+
+// a server with cfg's timeouts. The write timeout is applied per request
+// rather than to the connection so that streams can stay open.
+func newHTTPServer(cfg *config.Config, addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           withWriteTimeout(handler, cfg.Timeouts.Write.Duration),
+		ReadTimeout:       cfg.Timeouts.Read.Duration,
+		ReadHeaderTimeout: cfg.Timeouts.Read.Duration,
+		IdleTimeout:       cfg.Timeouts.Idle.Duration,
+	}
+}
+
+// streamingRoutes matches the routes marked streaming, they're exempt from the write timeout
+func streamingRoutes() *httprouter.Router {
+	router := httprouter.New()
+	for _, route := range routes() {
+		if route.streaming {
+			router.Handle(route.method, route.path, route.handle)
+		}
+	}
+	return router
+}
+
+func withWriteTimeout(handler http.Handler, timeout time.Duration) http.Handler {
+	if timeout <= 0 {
+		return handler
+	}
+	limited := http.TimeoutHandler(handler, timeout, "Request timed out")
+	streaming := streamingRoutes()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if handle, _, _ := streaming.Lookup(r.Method, r.URL.Path); handle != nil {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		limited.ServeHTTP(w, r)
+	})
+}
+
+// CertReloader serves a TLS certificate that can be replaced without a
+// restart. Reload reads the files again, GetCertificate also reloads when the
+// files have been modified, checking at most every certCheckInterval.
+type CertReloader struct {
+	CertFile string
+	KeyFile  string
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modified  time.Time // latest modification time of the files when loaded
+	lastCheck time.Time
+}
+
+const certCheckInterval = 10 * time.Second
+
+func NewCertReloader(certFile string, keyFile string) (*CertReloader, error) {
+	cr := &CertReloader{CertFile: certFile, KeyFile: keyFile}
+	if err := cr.Reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// Reload the certificate from its files, the current one is kept on error
+func (cr *CertReloader) Reload() error {
+	modified, err := cr.filesModified()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(cr.CertFile, cr.KeyFile)
+	if err != nil {
+		return fmt.Errorf("Could not load TLS certificate: %v", err)
+	}
+
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	cr.cert = &cert
+	cr.modified = modified
+	return nil
+}
+
+// reloadOrKeep reloads the certificate for reason, logging a failure rather
+// than returning it since the current certificate is still served
+func (cr *CertReloader) reloadOrKeep(reason string) {
+	if err := cr.Reload(); err != nil {
+		slog.Error("Could not reload TLS certificate, keeping the current one", "reason", reason, "file", cr.CertFile, "error", err)
+	}
+}
+
+func (cr *CertReloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.Lock()
+	check := time.Since(cr.lastCheck) >= certCheckInterval
+	if check {
+		cr.lastCheck = time.Now()
+	}
+	cr.mu.Unlock()
+
+	if check {
+		if modified, err := cr.filesModified(); err == nil && modified.After(cr.loadedModified()) {
+			cr.reloadOrKeep("files modified")
+		}
+	}
+
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	return cr.cert, nil
+}
+
+func (cr *CertReloader) loadedModified() time.Time {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	return cr.modified
+}
+
+// the later of the cert and key files' modification times
+func (cr *CertReloader) filesModified() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{cr.CertFile, cr.KeyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
@@ -0,0 +1,242 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+
+	"github.com/jhurwich/trendy/config"
+	"github.com/jhurwich/trendy/rpc"
+	"github.com/jhurwich/trendy/testhelpers"
+)
+
+func TestWriteTimeout(t *testing.T) {
+	t.Parallel()
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := withWriteTimeout(slow, 10*time.Millisecond)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/stock/GOOG", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected %d for a slow response, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+
+	// streams stay open as long as they need to
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/stream", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected streams to be exempt from the write timeout, got %d", w.Code)
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := writeCert(t, dir, "first")
+	cr, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := cr.GetCertificate(nil)
+
+	// a bad certificate keeps the current one
+	ioutil.WriteFile(certFile, []byte("not a certificate"), 0644)
+	if err := cr.Reload(); err == nil {
+		t.Errorf("Expected an error reloading a bad certificate")
+	}
+	if current, _ := cr.GetCertificate(nil); current != first {
+		t.Errorf("Expected the current certificate to be kept after a failed reload")
+	}
+
+	writeCert(t, dir, "second")
+	if err := cr.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	second, _ := cr.GetCertificate(nil)
+	leaf, _ := x509.ParseCertificate(second.Certificate[0])
+	if second == first || leaf.Subject.CommonName != "second" {
+		t.Errorf("Expected the reloaded certificate, got %s", leaf.Subject.CommonName)
+	}
+}
+
+func TestServe(t *testing.T) {
+	_, discard := testhelpers.UseStore(t)
+	defer discard()
+
+	dir, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeCert(t, dir, "127.0.0.1")
+
+	cfg := config.Default()
+	cfg.Listen, cfg.RedirectListen, cfg.GRPCListen = freeAddr(t), freeAddr(t), freeAddr(t)
+	cfg.PublicHost = cfg.Listen
+	cfg.TLS = config.TLS{CertFile: certFile, KeyFile: keyFile}
+	cfg.Timeouts.Shutdown.Duration = 5 * time.Second
+
+	// a slow route to have a request in flight during shutdown
+	server := NewTrendyServer(cfg)
+	started := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.Handle("/", &server)
+
+	signals := make(chan os.Signal, 1)
+	served := make(chan error, 1)
+	background, stopBackground := context.WithCancel(context.Background())
+	go func() { served <- Serve(cfg, mux, signals, stopBackground) }()
+	waitForListener(t, cfg.Listen)
+
+	// plain http is redirected to https by the secure middleware
+	client := &http.Client{
+		Transport:     &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	r, err := client.Get("http://" + cfg.RedirectListen + "/stock/GOOG")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Body.Close()
+	if expected := "https://" + cfg.Listen + "/stock/GOOG"; r.StatusCode != http.StatusMovedPermanently || r.Header.Get("Location") != expected {
+		t.Errorf("Expected redirect to %s, got %d %s", expected, r.StatusCode, r.Header.Get("Location"))
+	}
+
+	// https is served, SIGHUP doesn't stop it
+	signals <- syscall.SIGHUP
+	r, err = client.Get("https://" + cfg.Listen + "/stock/GOOG")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Body.Close()
+	if r.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected %d from the app over https, got %d", http.StatusUnauthorized, r.StatusCode)
+	}
+
+	// gRPC is served over TLS on its own port
+	conn, err := grpc.NewClient(cfg.GRPCListen, grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{InsecureSkipVerify: true})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := rpc.NewTrendyClient(conn).GetBars(context.Background(), &rpc.GetBarsRequest{Symbol: "GOOG"}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected %s from the gRPC api, got %v", codes.Unauthenticated, err)
+	}
+
+	// SIGTERM drains the in-flight request before Serve returns
+	inFlight := make(chan int, 1)
+	go func() {
+		r, err := client.Get("https://" + cfg.Listen + "/slow")
+		if err != nil {
+			inFlight <- 0
+			return
+		}
+		r.Body.Close()
+		inFlight <- r.StatusCode
+	}()
+	<-started
+	signals <- syscall.SIGTERM
+	if status := <-inFlight; status != http.StatusOK {
+		t.Errorf("Expected the in-flight request to finish, got %d", status)
+	}
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Expected a clean shutdown, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Serve did not return after SIGTERM")
+	}
+	if background.Err() == nil {
+		t.Errorf("Expected background work canceled on shutdown")
+	}
+	for _, addr := range []string{cfg.Listen, cfg.GRPCListen} {
+		if _, err := net.Dial("tcp", addr); err == nil {
+			t.Errorf("Expected the listener on %s to be closed after shutdown", addr)
+		}
+	}
+}
+
+// a self signed certificate for name, written to cert.pem and key.pem in dir
+func writeCert(t *testing.T, dir string, name string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	return certFile, keyFile
+}
+
+// an address on localhost that was free when checked
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func waitForListener(t *testing.T, addr string) {
+	for i := 0; i < 100; i++ {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Nothing listening on %s", addr)
+}
@@ -0,0 +1,171 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package stock
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Backfill loads historic measures for many symbols over a date range. The
+// range is split into chunks small enough for one provider request and the
+// chunks are fetched by a bounded pool of workers. Each completed chunk is
+// checkpointed under Name, running a Backfill with the same Name again skips
+// the chunks already done so a killed run resumes where it stopped.
+type Backfill struct {
+	Name        string
+	Symbols     []string
+	Start       time.Time
+	End         time.Time
+	ChunkDays   int            // days per provider request
+	Workers     int            // chunks fetched at once
+	Progress    func(Progress) // optional, called as each chunk finishes
+	OverrideUrl string         // passed to ActualPopulateContext, for dependency injection in tests
+}
+
+// Chunk is an inclusive date range fetched with one provider request
+type Chunk struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Progress reports a finished chunk, Err is nil if it succeeded
+type Progress struct {
+	Symbol string
+	Chunk  Chunk
+	Done   int // chunks finished for Symbol, including previous runs
+	Total  int // chunks for Symbol
+	Err    error
+}
+
+// BackfillResult summarizes a run for one symbol
+type BackfillResult struct {
+	Symbol    string
+	Total     int
+	Completed int // includes chunks completed by previous runs
+	Failed    []Progress
+}
+
+// Checkpoint records a chunk completed by a named backfill
+type Checkpoint struct {
+	Backfill   string
+	Symbol     string
+	ChunkStart time.Time
+	ChunkEnd   time.Time
+	Finished   time.Time
+}
+
+// Chunks splits the backfill's range into inclusive chunks of ChunkDays. The
+// provider can't serve a range that starts and ends on the same day, so a
+// trailing single day is folded into the chunk before it.
+func (bf *Backfill) Chunks() []Chunk {
+	days := bf.ChunkDays
+	if days < 2 {
+		days = 2
+	}
+
+	chunks := []Chunk{}
+	for start := bf.Start; start.Before(bf.End); {
+		end := start.AddDate(0, 0, days-1)
+		if !end.AddDate(0, 0, 1).Before(bf.End) {
+			end = bf.End
+		}
+		chunks = append(chunks, Chunk{Start: start, End: end})
+		start = end.AddDate(0, 0, 1)
+	}
+	return chunks
+}
+
+// Run the backfill, returning a result for each symbol in the order given.
+// An error is only returned if checkpoints can't be read or ctx is done,
+// failed chunks are reported in the results and by Progress. Once ctx is done
+// no more chunks are started and those in flight are abandoned.
+func (bf *Backfill) Run(ctx context.Context) ([]BackfillResult, error) {
+	checkpoints, err := DB.GetCheckpoints(bf.Name)
+	if err != nil {
+		return nil, err
+	}
+	done := map[string]bool{}
+	for _, cp := range checkpoints {
+		done[cp.Symbol+TimeForSQL(cp.ChunkStart)] = true
+	}
+
+	type task struct {
+		result *BackfillResult
+		chunk  Chunk
+	}
+
+	// queue every chunk that isn't checkpointed yet
+	chunks := bf.Chunks()
+	results := make([]BackfillResult, len(bf.Symbols))
+	tasks := []task{}
+	for i, sym := range bf.Symbols {
+		results[i] = BackfillResult{Symbol: sym, Total: len(chunks)}
+		for _, chunk := range chunks {
+			if done[sym+TimeForSQL(chunk.Start)] {
+				results[i].Completed++
+				continue
+			}
+			tasks = append(tasks, task{&results[i], chunk})
+		}
+	}
+
+	workers := bf.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	queue := make(chan task)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range queue {
+				err := bf.fetch(ctx, t.result.Symbol, t.chunk)
+
+				mu.Lock()
+				progress := Progress{Symbol: t.result.Symbol, Chunk: t.chunk, Total: t.result.Total, Err: err}
+				if err != nil {
+					t.result.Failed = append(t.result.Failed, progress)
+				} else {
+					t.result.Completed++
+				}
+				progress.Done = t.result.Completed + len(t.result.Failed)
+				if bf.Progress != nil {
+					bf.Progress(progress)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+queue:
+	for _, t := range tasks {
+		select {
+		case queue <- t:
+		case <-ctx.Done():
+			break queue
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	return results, ctx.Err()
+}
+
+// fetch and store one chunk, then checkpoint it. A chunk the provider has no
+// data for, like one before a listing, is complete rather than failed.
+func (bf *Backfill) fetch(ctx context.Context, sym string, chunk Chunk) error {
+	_, err := NewStock(sym).ActualPopulateContext(ctx, chunk.Start, chunk.End, bf.OverrideUrl)
+	if err != nil && err != ErrNoData {
+		return err
+	}
+	return DB.InsertCheckpoint(&Checkpoint{
+		Backfill:   bf.Name,
+		Symbol:     sym,
+		ChunkStart: chunk.Start,
+		ChunkEnd:   chunk.End,
+		Finished:   time.Now().UTC(),
+	})
+}
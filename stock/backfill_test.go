@@ -0,0 +1,149 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package stock_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jhurwich/trendy/stock"
+	"github.com/jhurwich/trendy/testhelpers"
+)
+
+func TestBackfillChunks(t *testing.T) {
+	t.Parallel()
+	day := func(month time.Month, d int) time.Time { return time.Date(2015, month, d, 0, 0, 0, 0, time.UTC) }
+
+	var tests = []struct {
+		start     time.Time
+		end       time.Time
+		chunkDays int
+		expected  []stock.Chunk
+	}{
+		// evenly divided
+		{day(time.June, 1), day(time.June, 10), 5, []stock.Chunk{{day(time.June, 1), day(time.June, 5)}, {day(time.June, 6), day(time.June, 10)}}},
+		// short final chunk
+		{day(time.June, 1), day(time.June, 8), 5, []stock.Chunk{{day(time.June, 1), day(time.June, 5)}, {day(time.June, 6), day(time.June, 8)}}},
+		// a trailing single day is folded into the previous chunk
+		{day(time.June, 1), day(time.June, 6), 5, []stock.Chunk{{day(time.June, 1), day(time.June, 6)}}},
+		// range smaller than a chunk
+		{day(time.June, 1), day(time.June, 3), 365, []stock.Chunk{{day(time.June, 1), day(time.June, 3)}}},
+		// a single day can't be requested
+		{day(time.June, 1), day(time.June, 1), 5, []stock.Chunk{}},
+		{day(time.June, 2), day(time.June, 1), 5, []stock.Chunk{}},
+	}
+
+	for _, test := range tests {
+		bf := stock.Backfill{Start: test.start, End: test.end, ChunkDays: test.chunkDays}
+		chunks := bf.Chunks()
+		if len(chunks) != len(test.expected) {
+			t.Errorf("Expected %d chunks for %+v, got %+v", len(test.expected), test, chunks)
+			continue
+		}
+		for i := range chunks {
+			if !chunks[i].Start.Equal(test.expected[i].Start) || !chunks[i].End.Equal(test.expected[i].End) {
+				t.Errorf("Chunk %d expected %+v, got %+v", i, test.expected[i], chunks[i])
+			}
+		}
+	}
+}
+
+func TestBackfillResume(t *testing.T) {
+	_, discard := testhelpers.UseStore(t)
+	defer discard()
+	provider := &chunkProvider{failing: map[string]bool{"MSFT 2015-06-11": true, "MSFT 2015-06-21": true}}
+	defaultProvider := stock.DefaultProvider
+	stock.DefaultProvider = provider
+	defer func() { stock.DefaultProvider = defaultProvider }()
+	day := func(d int) time.Time { return time.Date(2015, time.June, d, 0, 0, 0, 0, time.UTC) }
+
+	// the first run fails partway through MSFT
+	bf := stock.Backfill{Name: "june", Symbols: []string{"GOOG", "MSFT"}, Start: day(1), End: day(30), ChunkDays: 10, Workers: 2}
+	results, err := bf.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error running backfill: %v", err)
+	}
+	if goog := results[0]; goog.Symbol != "GOOG" || goog.Total != 3 || goog.Completed != 3 || len(goog.Failed) != 0 {
+		t.Errorf("Expected every GOOG chunk completed, got %+v", goog)
+	}
+	msft := results[1]
+	if msft.Symbol != "MSFT" || msft.Total != 3 || msft.Completed != 1 || len(msft.Failed) != 2 {
+		t.Fatalf("Expected two failed MSFT chunks, got %+v", msft)
+	}
+	failed := []string{}
+	for _, progress := range msft.Failed {
+		if progress.Symbol != "MSFT" || progress.Err == nil {
+			t.Errorf("Expected a failure for MSFT with its error, got %+v", progress)
+		}
+		failed = append(failed, stock.TimeForSQL(progress.Chunk.Start))
+	}
+	sort.Strings(failed)
+	if expected := []string{"2015-06-11", "2015-06-21"}; !reflect.DeepEqual(failed, expected) {
+		t.Errorf("Expected the failed chunks %v reported, got %v", expected, failed)
+	}
+
+	// running again under the same name only fetches the failed chunks
+	provider.reset()
+	results, err = bf.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error resuming backfill: %v", err)
+	}
+	if expected := []string{"MSFT 2015-06-11", "MSFT 2015-06-21"}; !reflect.DeepEqual(provider.sortedFetches(), expected) {
+		t.Errorf("Expected only the failed chunks fetched again, expected %v got %v", expected, provider.sortedFetches())
+	}
+	for _, result := range results {
+		if result.Completed != 3 || len(result.Failed) != 0 {
+			t.Errorf("Expected every chunk completed after resuming, got %+v", result)
+		}
+	}
+
+	// a backfill under another name starts over
+	provider.reset()
+	other := bf
+	other.Name = "june-again"
+	other.Symbols = []string{"GOOG"}
+	if _, err := other.Run(context.Background()); err != nil || len(provider.sortedFetches()) != 3 {
+		t.Errorf("Expected a new backfill to fetch every chunk, got %v %v", provider.sortedFetches(), err)
+	}
+}
+
+// a provider with synthetic data that fails the chunks in failing, keyed by
+// symbol and start, and records the chunks fetched. Backfill workers share it.
+type chunkProvider struct {
+	stock.SyntheticProvider
+	mu      sync.Mutex
+	failing map[string]bool
+	fetched []string
+}
+
+func (p *chunkProvider) FetchContext(ctx context.Context, s *stock.Stock, startDate time.Time, endDate time.Time) (stock.Span, error) {
+	p.mu.Lock()
+	key := s.Symbol + " " + stock.TimeForSQL(startDate)
+	p.fetched = append(p.fetched, key)
+	fail := p.failing[key]
+	p.mu.Unlock()
+	if fail {
+		return nil, errors.New("upstream unavailable")
+	}
+	return p.SyntheticProvider.FetchContext(ctx, s, startDate, endDate)
+}
+
+// reset forgets the fetches and stops failing
+func (p *chunkProvider) reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.failing, p.fetched = nil, nil
+}
+
+func (p *chunkProvider) sortedFetches() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fetched := append([]string{}, p.fetched...)
+	sort.Strings(fetched)
+	return fetched
+}
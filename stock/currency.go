@@ -0,0 +1,221 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package stock
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultCurrency is the currency of a symbol whose provider and listing don't name one
+const DefaultCurrency = "USD"
+
+// ErrNoRate is returned converting a day without an exchange rate shortly before it
+var ErrNoRate = errors.New("No exchange rate")
+
+// how long a rate is used for the days after it without one of their own,
+// like holidays in the rate's market
+const fxStaleness = 7 * 24 * time.Hour
+
+// ValidCurrency reports whether code is formed like an ISO 4217 code, three letters
+func ValidCurrency(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// FXSymbol is the symbol an exchange rate series is stored under, its
+// measures are how much of to one from buys, EURUSD for dollars per euro
+func FXSymbol(from string, to string) string {
+	return from + to
+}
+
+// CurrencyContext returns the currency s's measures are priced in, the one its
+// provider reported if any, then its listing's, then DefaultCurrency
+func (s *Stock) CurrencyContext(ctx context.Context) (string, error) {
+	if s.Currency != "" {
+		return s.Currency, nil
+	}
+	currency, err := DB.GetCurrency(ctx, s.Symbol)
+	if err != nil {
+		return "", err
+	}
+	if currency == "" {
+		listing, err := LookupSymbol(ctx, s.Symbol)
+		if err != nil && !errors.Is(err, ErrSymbolNotFound) {
+			return "", err
+		} else if err == nil {
+			currency = listing.Currency
+		}
+	}
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+	s.Currency = currency
+	return currency, nil
+}
+
+// Convert returns span priced in to rather than from, each bar at its day's
+// exchange rate or the last one within a week before it. Volumes are shares
+// and left as they are. ErrNoRate if a day has no rate.
+func Convert(ctx context.Context, span Span, from string, to string) (Span, error) {
+	if from == to || len(span) == 0 {
+		return span, nil
+	}
+	first, last := span[0].Time, span[len(span)-1].Time
+	rates, err := fxRates(ctx, from, to, first.Add(-fxStaleness), last)
+	if errors.Is(err, ErrNoData) {
+		return nil, fmt.Errorf("%w [%s]", ErrNoRate, FXSymbol(from, to))
+	} else if err != nil {
+		return nil, err
+	}
+	sort.Sort(rates)
+
+	converted := make(Span, len(span))
+	r := -1
+	for i, m := range span {
+		for r+1 < len(rates) && TimeForSQL(rates[r+1].Time) <= TimeForSQL(m.Time) {
+			r++
+		}
+		if r < 0 || m.Time.Sub(rates[r].Time) > fxStaleness {
+			return nil, fmt.Errorf("%w on %s [%s]", ErrNoRate, TimeForSQL(m.Time), FXSymbol(from, to))
+		}
+		rate := rates[r].Value
+		m.Value *= rate
+		m.Open *= rate
+		m.High *= rate
+		m.Low *= rate
+		converted[i] = m
+	}
+	return converted, nil
+}
+
+// the from to to exchange rates over start to end, the inverse of the to to
+// from rates if only those are stored. Rates are read from the store only, the
+// provider has no exchange rates, they're loaded with LoadRates.
+func fxRates(ctx context.Context, from string, to string, start time.Time, end time.Time) (Span, error) {
+	rates, err := DB.GetRangeContext(ctx, NewStock(FXSymbol(from, to)), start, end)
+	if err != nil || len(rates) > 0 {
+		return rates, err
+	}
+	inverse, err := DB.GetRangeContext(ctx, NewStock(FXSymbol(to, from)), start, end)
+	if err != nil {
+		return nil, err
+	}
+	rates = Span{}
+	for _, m := range inverse {
+		if m.Value != 0 {
+			rates = append(rates, Measure{Time: m.Time, Value: 1 / m.Value})
+		}
+	}
+	if len(rates) == 0 {
+		return nil, ErrNoData
+	}
+	return rates, nil
+}
+
+// Rate is a day's exchange rate, how much of To one From buys
+type Rate struct {
+	From string
+	To   string
+	Date time.Time
+	Rate float32
+}
+
+// ReadRates reads a CSV exchange rate file with a header naming its from, to,
+// date and rate columns in any order, a row per currency pair and day. Dates
+// are YYYY-MM-DD, currencies are uppercased.
+func ReadRates(r io.Reader) ([]Rate, error) {
+	rates := []Rate{}
+	err := readCSV(r, "Exchange rate", []string{"from", "to", "date", "rate"}, func(line int, field func(string) string) error {
+		rate := Rate{From: strings.ToUpper(field("from")), To: strings.ToUpper(field("to"))}
+		if !ValidCurrency(rate.From) || !ValidCurrency(rate.To) || rate.From == rate.To {
+			return fmt.Errorf("Currencies must be two different ISO 4217 codes on line %d [%s %s]", line, rate.From, rate.To)
+		}
+		var err error
+		if rate.Date, err = time.Parse("2006-01-02", field("date")); err != nil {
+			return fmt.Errorf("Could not parse date on line %d, must be YYYY-MM-DD [%s]", line, field("date"))
+		}
+		value, err := strconv.ParseFloat(field("rate"), 32)
+		if err != nil || value <= 0 {
+			return fmt.Errorf("Rate must be a positive number on line %d [%s]", line, field("rate"))
+		}
+		rate.Rate = float32(value)
+		rates = append(rates, rate)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rates, nil
+}
+
+// LoadRates stores the rates under each pair's FXSymbol, replacing any stored
+// for the same days. Convert reads rates from the store only.
+func LoadRates(ctx context.Context, rates []Rate) error {
+	pairs := map[string]*Stock{}
+	for _, rate := range rates {
+		symbol := FXSymbol(rate.From, rate.To)
+		if pairs[symbol] == nil {
+			pairs[symbol] = NewStock(symbol)
+		}
+		pairs[symbol].Span = append(pairs[symbol].Span, Measure{Time: rate.Date, Value: rate.Rate})
+	}
+	for _, s := range pairs {
+		sort.Sort(s.Span)
+		if err := DB.InsertContext(ctx, s, &s.Span); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FXLastModified returns the last time rates between from and to were stored,
+// either way around since Convert may use the inverse, zero time if never
+func FXLastModified(ctx context.Context, from string, to string) (time.Time, error) {
+	modified, err := DB.LastModifiedContext(ctx, NewStock(FXSymbol(from, to)))
+	if err != nil {
+		return time.Time{}, err
+	}
+	inverse, err := DB.LastModifiedContext(ctx, NewStock(FXSymbol(to, from)))
+	if err != nil {
+		return time.Time{}, err
+	}
+	if inverse.After(modified) {
+		return inverse, nil
+	}
+	return modified, nil
+}
+
+const upsertCurrencySchema string = `INSERT INTO Currencies (Symbol, Currency) VALUES ($1, $2) ON CONFLICT (Symbol) DO UPDATE SET Currency = EXCLUDED.Currency`
+const selectCurrencySchema string = `SELECT Currency FROM Currencies WHERE Symbol = $1`
+
+// SetCurrency records the currency symbol's measures are priced in
+func (db *StockDB) SetCurrency(ctx context.Context, symbol string, currency string) (err error) {
+	defer observeQuery("set_currency", time.Now(), &err)
+	_, err = db.ExecContext(ctx, upsertCurrencySchema, strings.ToUpper(symbol), currency)
+	return err
+}
+
+// GetCurrency returns the currency symbol's measures are priced in, empty if never recorded
+func (db *StockDB) GetCurrency(ctx context.Context, symbol string) (currency string, err error) {
+	defer observeQuery("get_currency", time.Now(), &err)
+	err = db.GetContext(ctx, &currency, selectCurrencySchema, strings.ToUpper(symbol))
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return currency, err
+}
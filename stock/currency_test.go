@@ -0,0 +1,154 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package stock_test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jhurwich/trendy/stock"
+	"github.com/jhurwich/trendy/testhelpers"
+)
+
+func TestConvert(t *testing.T) {
+	_, discard := testhelpers.UseStore(t)
+	defer discard()
+	defaultProvider := stock.DefaultProvider
+	provider := &countingProvider{}
+	stock.DefaultProvider = provider
+	defer func() { stock.DefaultProvider = defaultProvider }()
+	ctx := context.Background()
+	day := func(d int) time.Time { return time.Date(2015, time.June, d, 0, 0, 0, 0, time.UTC) }
+
+	// dollars per euro, with nothing for the 3rd
+	rates := stock.NewStock(stock.FXSymbol("EUR", "USD"))
+	rates.Span = stock.Span{{Time: day(1), Value: 1.1}, {Time: day(2), Value: 1.2}, {Time: day(4), Value: 1.25}}
+	if err := stock.DB.InsertContext(ctx, rates, &rates.Span); err != nil {
+		t.Fatalf("Could not store rates: %v", err)
+	}
+
+	span := stock.Span{
+		{Time: day(1), Value: 10, Open: 9, High: 11, Low: 8, Volume: 100},
+		{Time: day(2), Value: 10},
+		{Time: day(3), Value: 10},
+		{Time: day(4), Value: 20},
+	}
+	converted, err := stock.Convert(ctx, span, "EUR", "USD")
+	if err != nil {
+		t.Fatalf("Unexpected error converting: %v", err)
+	}
+	expected := []float32{11, 12, 12, 25} // the 3rd at the 2nd's rate
+	for i, m := range converted {
+		if math.Abs(float64(m.Value-expected[i])) > 1e-4 {
+			t.Errorf("Expected %v on %s, got %v", expected[i], stock.TimeForSQL(m.Time), m.Value)
+		}
+	}
+	if first := converted[0]; math.Abs(float64(first.Open-9.9)) > 1e-4 || math.Abs(float64(first.High-12.1)) > 1e-4 || first.Volume != 100 {
+		t.Errorf("Expected the bar's prices converted and its volume kept, got %+v", first)
+	}
+	if span[0].Value != 10 {
+		t.Errorf("Expected the span converted to be left as it was, got %+v", span[0])
+	}
+
+	// the inverse series is used when there's only the other direction
+	back, err := stock.Convert(ctx, converted, "USD", "EUR")
+	if err != nil || math.Abs(float64(back[3].Value-20)) > 1e-4 {
+		t.Errorf("Expected converting back to give the original, got %v %v", back, err)
+	}
+
+	// days without a rate in the week before them can't be converted
+	late := stock.Span{{Time: day(20), Value: 10}}
+	if _, err := stock.Convert(ctx, late, "EUR", "USD"); !errors.Is(err, stock.ErrNoRate) {
+		t.Errorf("Expected ErrNoRate for a day long after the last rate, got %v", err)
+	}
+	if converted, err := stock.Convert(ctx, span, "EUR", "EUR"); err != nil || len(converted) != len(span) {
+		t.Errorf("Expected the same currency to be left as it is, got %v %v", converted, err)
+	}
+
+	// rates are only read from the store, the provider has none
+	if _, err := stock.Convert(ctx, span, "EUR", "GBP"); !errors.Is(err, stock.ErrNoRate) {
+		t.Errorf("Expected ErrNoRate for a pair without stored rates, got %v", err)
+	}
+	if provider.fetches != 0 {
+		t.Errorf("Expected rates never to be fetched, got %d fetches", provider.fetches)
+	}
+
+	for code, valid := range map[string]bool{"USD": true, "usd": false, "US": false, "EURO": false, "U1D": false} {
+		if stock.ValidCurrency(code) != valid {
+			t.Errorf("Expected ValidCurrency(%q) to be %v", code, valid)
+		}
+	}
+}
+
+func TestStockCurrency(t *testing.T) {
+	_, discard := testhelpers.UseStore(t)
+	defer discard()
+	ctx := context.Background()
+
+	// the provider's currency, then the listing's, then the default
+	stock.DB.SetCurrency(ctx, "SAP", "EUR")
+	stock.LoadListings(ctx, []stock.Listing{{Symbol: "SAP", Name: "SAP SE", Currency: "USD"}, {Symbol: "7203", Name: "Toyota Motor Corp", Currency: "JPY"}, {Symbol: "MSFT", Name: "Microsoft Corporation"}})
+	for symbol, expected := range map[string]string{"SAP": "EUR", "7203": "JPY", "MSFT": stock.DefaultCurrency} {
+		if currency, err := stock.NewStock(symbol).CurrencyContext(ctx); err != nil || currency != expected {
+			t.Errorf("Expected %s for %s, got %s %v", expected, symbol, currency, err)
+		}
+	}
+}
+
+func TestReadRates(t *testing.T) {
+	t.Parallel()
+	var invalid = []string{
+		"from,to,date\nEUR,USD,2015-06-01\n",
+		"from,to,date,rate\nEUR,EUR,2015-06-01,1\n",
+		"from,to,date,rate\nEURO,USD,2015-06-01,1.1\n",
+		"from,to,date,rate\nEUR,USD,June 1,1.1\n",
+		"from,to,date,rate\nEUR,USD,2015-06-01,-1\n",
+		"from,to,date,rate\nEUR,USD,2015-06-01,\n",
+	}
+	for _, file := range invalid {
+		if _, err := stock.ReadRates(strings.NewReader(file)); err == nil {
+			t.Errorf("Expected an error reading %q", file)
+		}
+	}
+
+	rates, err := stock.ReadRates(strings.NewReader("date,rate,from,to\n2015-06-01,1.1,eur,usd\n2015-06-01,139.5,USD,JPY\n"))
+	if err != nil || len(rates) != 2 {
+		t.Fatalf("Expected 2 rates, got %v %v", rates, err)
+	}
+	if rates[0].From != "EUR" || rates[0].To != "USD" || rates[0].Rate != 1.1 || stock.TimeForSQL(rates[0].Date) != "2015-06-01" {
+		t.Errorf("Unexpected first rate %+v", rates[0])
+	}
+}
+
+func TestLoadRates(t *testing.T) {
+	_, discard := testhelpers.UseStore(t)
+	defer discard()
+	ctx := context.Background()
+	day := func(d int) time.Time { return time.Date(2015, time.June, d, 0, 0, 0, 0, time.UTC) }
+
+	if modified, err := stock.FXLastModified(ctx, "EUR", "USD"); err != nil || !modified.IsZero() {
+		t.Errorf("Expected no modification before rates are loaded, got %v %v", modified, err)
+	}
+	rates := []stock.Rate{
+		{From: "EUR", To: "USD", Date: day(2), Rate: 1.2},
+		{From: "EUR", To: "USD", Date: day(1), Rate: 1.1},
+		{From: "USD", To: "JPY", Date: day(1), Rate: 120},
+	}
+	if err := stock.LoadRates(ctx, rates); err != nil {
+		t.Fatalf("Could not load rates: %v", err)
+	}
+	converted, err := stock.Convert(ctx, stock.Span{{Time: day(1), Value: 10}, {Time: day(2), Value: 10}}, "EUR", "USD")
+	if err != nil || math.Abs(float64(converted[0].Value-11)) > 1e-4 || math.Abs(float64(converted[1].Value-12)) > 1e-4 {
+		t.Errorf("Expected loaded rates to be used, got %v %v", converted, err)
+	}
+
+	// either direction's rates modify the pair, since Convert may use the inverse
+	modified, err := stock.FXLastModified(ctx, "JPY", "USD")
+	if err != nil || modified.IsZero() {
+		t.Errorf("Expected the inverse's modification, got %v %v", modified, err)
+	}
+}
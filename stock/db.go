@@ -3,6 +3,8 @@
 package stock
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
 	"time"
 
@@ -10,69 +12,174 @@ import (
 	_ "github.com/lib/pq"
 )
 
+// Store is where measures and the server's records are kept. StockDB keeps them
+// in Postgres, MemoryStore keeps them in process for tests and demos. Measures
+// are read and written on behalf of requests, those methods give up when ctx
+// is canceled or its deadline passes.
+type Store interface {
+	Insert(stock *Stock, span *Span) error
+	InsertContext(ctx context.Context, stock *Stock, span *Span) error
+	GetRange(stock *Stock, startDate time.Time, endDate time.Time) (Span, error)
+	GetRangeContext(ctx context.Context, stock *Stock, startDate time.Time, endDate time.Time) (Span, error)
+	LastModified(stock *Stock) (time.Time, error)
+	LastModifiedContext(ctx context.Context, stock *Stock) (time.Time, error)
+
+	InsertJob(job *Job) error
+	GetJobs(limit int) ([]Job, error)
+	InsertCheckpoint(cp *Checkpoint) error
+	GetCheckpoints(backfill string) ([]Checkpoint, error)
+
+	InsertAPIKey(k *APIKey) error
+	GetAPIKey(key string) (*APIKey, error)
+	GetAPIKeys() ([]APIKey, error)
+	UpdateAPIKeySecret(k *APIKey) error
+	UpdateAPIKeyLimits(k *APIKey) error
+	RevokeAPIKey(key string, at time.Time) error
+	GetUsage(key string, period string) (int, error)
+	IncrementUsage(key string, period string, n int) (int, error)
+
+	InsertListings(ctx context.Context, listings []Listing) error
+	GetListings(ctx context.Context) ([]Listing, error)
+	InsertSymbolChanges(ctx context.Context, changes []SymbolChange) error
+	GetSymbolChanges(ctx context.Context) ([]SymbolChange, error)
+	SetCurrency(ctx context.Context, symbol string, currency string) error
+	GetCurrency(ctx context.Context, symbol string) (string, error)
+
+	PingContext(ctx context.Context) error
+	MigrationVersion() (int, error) // compare to SchemaVersion
+}
+
 type StockDB struct {
 	sqlx.DB
 }
 
-var DB *StockDB
+// DB is the store used by the server and the stock package
+var DB Store
+
+// Connect to the Postgres database at dataSource and create any missing tables
+func Connect(dataSource string) (*StockDB, error) {
+	conn, err := sqlx.Connect("postgres", dataSource)
+	if err != nil {
+		return nil, err
+	}
+	db := &StockDB{*conn}
+	if err := db.CreateIfNotExists(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
 
-type Environment int
+const createMeasuresSchema string = `CREATE TABLE IF NOT EXISTS Measures ( Symbol varchar(255) NOT NULL, Time date NOT NULL, Value float8 NOT NULL, PRIMARY KEY (Symbol, Time))`
+const createModificationsSchema string = `CREATE TABLE IF NOT EXISTS Modifications ( Symbol varchar(255) NOT NULL, Modified timestamptz NOT NULL, PRIMARY KEY (Symbol))`
+const createCheckpointsSchema string = `CREATE TABLE IF NOT EXISTS Checkpoints ( Backfill varchar(255) NOT NULL, Symbol varchar(255) NOT NULL, ChunkStart date NOT NULL, ChunkEnd date NOT NULL, Finished timestamptz NOT NULL, PRIMARY KEY (Backfill, Symbol, ChunkStart))`
+const createAPIKeysSchema string = `CREATE TABLE IF NOT EXISTS APIKeys ( Key varchar(255) NOT NULL, SecretHash varchar(64) NOT NULL, Name varchar(255) NOT NULL, Scopes text[] NOT NULL, Created timestamptz NOT NULL, Expires timestamptz, Revoked timestamptz, PRIMARY KEY (Key))`
+const alterAPIKeysLimitsSchema string = `ALTER TABLE APIKeys ADD COLUMN IF NOT EXISTS RateLimit float8 NOT NULL DEFAULT 0, ADD COLUMN IF NOT EXISTS Burst int NOT NULL DEFAULT 0, ADD COLUMN IF NOT EXISTS DailyQuota int NOT NULL DEFAULT 0, ADD COLUMN IF NOT EXISTS MonthlyQuota int NOT NULL DEFAULT 0`
+const createUsageSchema string = `CREATE TABLE IF NOT EXISTS Usage ( Key varchar(255) NOT NULL, Period varchar(16) NOT NULL, Count int NOT NULL, PRIMARY KEY (Key, Period))`
+const alterMeasuresBarsSchema string = `ALTER TABLE Measures ADD COLUMN IF NOT EXISTS Open float8, ADD COLUMN IF NOT EXISTS High float8, ADD COLUMN IF NOT EXISTS Low float8, ADD COLUMN IF NOT EXISTS Volume bigint`
+const createSymbolsSchema string = `CREATE TABLE IF NOT EXISTS Symbols ( Symbol varchar(255) NOT NULL, Name text NOT NULL, Exchange varchar(64) NOT NULL, Currency varchar(3) NOT NULL, AssetType varchar(64) NOT NULL, Sector varchar(255) NOT NULL, Listed date, Delisted date, PRIMARY KEY (Symbol))`
+const createSymbolChangesSchema string = `CREATE TABLE IF NOT EXISTS SymbolChanges ( Symbol varchar(255) NOT NULL, NewSymbol varchar(255) NOT NULL, Date date NOT NULL, PRIMARY KEY (Symbol, Date))`
+const createCurrenciesSchema string = `CREATE TABLE IF NOT EXISTS Currencies ( Symbol varchar(255) NOT NULL, Currency varchar(3) NOT NULL, PRIMARY KEY (Symbol))`
+const createJobsSchema string = `CREATE TABLE IF NOT EXISTS Jobs ( ID serial NOT NULL, Symbol varchar(255) NOT NULL, Day date NOT NULL, Started timestamptz NOT NULL, Finished timestamptz NOT NULL, Attempts int NOT NULL, Error text NOT NULL, PRIMARY KEY (ID))`
 
-const (
-	Local Environment = iota
-	Production
-	TestLocal
-)
+// migrations in the order they're applied, a database at version n has had
+// the first n applied. Append new migrations, never edit or reorder them.
+var migrations = []string{
+	createMeasuresSchema,
+	createModificationsSchema,
+	createJobsSchema,
+	createCheckpointsSchema,
+	createAPIKeysSchema,
+	alterAPIKeysLimitsSchema,
+	createUsageSchema,
+	alterMeasuresBarsSchema,
+	createSymbolsSchema,
+	createSymbolChangesSchema,
+	createCurrenciesSchema,
+}
 
-// Returns a StockDB with all  tables, panics if can't connect to db or make tables
-// <requires> "$ createdb -Olocaluser trendydb", for Local
-// <requires> "$ createdb -Olocaluser trendytestdb", for TestLocal
-// <side effect> sets the global DB to the returned db
-func (db *StockDB) Setup(env Environment) *StockDB {
-	// TODO(jhurwich) implement user/pass/address switch based on local or prod environment
-	var dbname, password, host, user, suffix string
-	switch env {
-	case Local:
-		dbname = "trendydb"
-		password = "localpass"
-		host = "localhost"
-		user = "localuser"
-		suffix = "?sslmode=disable"
-	case Production:
-		// TODO(jhurwich) define for production environment
-	case TestLocal:
-		dbname = "trendytestdb"
-		password = "localpass"
-		host = "localhost"
-		user = "localuser"
-		suffix = "?sslmode=disable"
+// SchemaVersion is the migration version this build expects
+var SchemaVersion = len(migrations)
+
+const createMigrationsSchema string = `CREATE TABLE IF NOT EXISTS Migrations ( Version int NOT NULL, Applied timestamptz NOT NULL, PRIMARY KEY (Version))`
+const selectMigrationVersionSchema string = `SELECT COALESCE(MAX(Version), 0) FROM Migrations`
+const insertMigrationSchema string = `INSERT INTO Migrations VALUES ($1, $2)`
+
+// CreateIfNotExists applies the migrations the database hasn't had yet. The
+// migrations from before versions were recorded are idempotent, so databases
+// created then are brought up to date by applying everything.
+func (db *StockDB) CreateIfNotExists() error {
+	if _, err := db.Exec(createMigrationsSchema); err != nil {
+		return err
+	}
+	version, err := db.MigrationVersion()
+	if err != nil {
+		return err
 	}
-	dbSource := fmt.Sprintf("postgres://%s:%s@%s/%s%s", user, password, host, dbname, suffix)
 
-	// initialize the db, note that it's a global object, it is never closed
-	db = &StockDB{*(sqlx.MustConnect("postgres", dbSource))}
-	db.CreateIfNotExists()
-	DB = db // not entirely sure why we need this line with the address assignment two up, but whatever
-	return db
+	for i := version; i < len(migrations); i++ {
+		tx, err := db.Beginx()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("Migration %d failed: %v", i+1, err)
+		}
+		if _, err := tx.Exec(insertMigrationSchema, i+1, time.Now().UTC()); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-const createMeasuresSchema string = `CREATE TABLE IF NOT EXISTS Measures ( Symbol varchar(255) NOT NULL, Time date NOT NULL, Value float8 NOT NULL, PRIMARY KEY (Symbol, Time))`
-
-func (db *StockDB) CreateIfNotExists() {
-	db.MustExec(createMeasuresSchema)
+// MigrationVersion is the number of migrations applied to the database
+func (db *StockDB) MigrationVersion() (int, error) {
+	var version int
+	err := db.Get(&version, selectMigrationVersionSchema)
+	return version, err
 }
 
-const insertMeasuresSchema string = `INSERT INTO Measures VALUES ($1, $2, $3)` //$1 is symbol, $2 is date, $3 is value
+// $1 is symbol, $2 is date, $3 is value, then the rest of the bar. A measure
+// already stored is only updated if it differs, so unchanged rows aren't counted.
+const insertMeasuresSchema string = `INSERT INTO Measures (Symbol, Time, Value, Open, High, Low, Volume) VALUES ($1, $2, $3, $4, $5, $6, $7) ON CONFLICT (Symbol, Time) DO UPDATE SET Value = EXCLUDED.Value, Open = EXCLUDED.Open, High = EXCLUDED.High, Low = EXCLUDED.Low, Volume = EXCLUDED.Volume WHERE (Measures.Value, Measures.Open, Measures.High, Measures.Low, Measures.Volume) IS DISTINCT FROM (EXCLUDED.Value, EXCLUDED.Open, EXCLUDED.High, EXCLUDED.Low, EXCLUDED.Volume)`
+const upsertModificationsSchema string = `INSERT INTO Modifications VALUES ($1, $2) ON CONFLICT (Symbol) DO UPDATE SET Modified = EXCLUDED.Modified` //$1 is symbol, $2 is time
 
+// Insert the span's measures for stock and record the modification time for the symbol.
+// Measures that already exist are overwritten so overlapping spans can be inserted,
+// the modification time only changes if a measure was added or changed.
 func (db *StockDB) Insert(stock *Stock, span *Span) error {
+	return db.InsertContext(context.Background(), stock, span)
+}
+
+// InsertContext is Insert on behalf of ctx, canceling it rolls the insert back
+func (db *StockDB) InsertContext(ctx context.Context, stock *Stock, span *Span) (err error) {
+	defer observeQuery("insert", time.Now(), &err)
+
 	// new transaction
-	tx, err := db.Beginx()
+	tx, err := db.BeginTxx(ctx, nil)
 	if err != nil {
 		return err
 	}
 
+	var changed int64
 	for _, measure := range *span {
-		_, err := tx.Exec(insertMeasuresSchema, stock.Symbol, TimeForSQL(measure.Time), measure.Value)
+		result, err := tx.ExecContext(ctx, insertMeasuresSchema, stock.Symbol, TimeForSQL(measure.Time), measure.Value, measure.Open, measure.High, measure.Low, measure.Volume)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if n, err := result.RowsAffected(); err == nil {
+			changed += n
+		}
+	}
+
+	if changed > 0 {
+		_, err = tx.ExecContext(ctx, upsertModificationsSchema, stock.Symbol, time.Now().UTC())
 		if err != nil {
 			tx.Rollback()
 			return err
@@ -82,10 +189,29 @@ func (db *StockDB) Insert(stock *Stock, span *Span) error {
 	return tx.Commit()
 }
 
-const selectMeasuresRangeSchema string = `SELECT Time, Value FROM Measures where Symbol = $1 AND Time >= $2 AND TIME <= $3`
-const selectMeasuresRangeFromSchema string = `SELECT Time, Value FROM Measures where Symbol = $1 AND Time >= $2`
-const selectMeasuresRangeToSchema string = `SELECT Time, Value FROM Measures where Symbol = $1 AND Time <= $2`
-const selectMeasuresAllSchema string = `SELECT Time, Value FROM Measures where Symbol = $1`
+const selectModificationsSchema string = `SELECT Modified FROM Modifications where Symbol = $1`
+
+// LastModified returns the last time measures were stored for stock, zero time if never
+func (db *StockDB) LastModified(stock *Stock) (time.Time, error) {
+	return db.LastModifiedContext(context.Background(), stock)
+}
+
+func (db *StockDB) LastModifiedContext(ctx context.Context, stock *Stock) (modified time.Time, err error) {
+	defer observeQuery("last_modified", time.Now(), &err)
+	err = db.GetContext(ctx, &modified, selectModificationsSchema, stock.Symbol)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	return modified, err
+}
+
+// measures stored before bars were added have none. They're never refetched
+// on their own, a backfill with a new name fetches them again with their bars.
+const selectBarColumns = `COALESCE(Open, 0) AS Open, COALESCE(High, 0) AS High, COALESCE(Low, 0) AS Low, COALESCE(Volume, 0) AS Volume`
+const selectMeasuresRangeSchema string = `SELECT Time, Value, ` + selectBarColumns + ` FROM Measures where Symbol = $1 AND Time >= $2 AND TIME <= $3`
+const selectMeasuresRangeFromSchema string = `SELECT Time, Value, ` + selectBarColumns + ` FROM Measures where Symbol = $1 AND Time >= $2`
+const selectMeasuresRangeToSchema string = `SELECT Time, Value, ` + selectBarColumns + ` FROM Measures where Symbol = $1 AND Time <= $2`
+const selectMeasuresAllSchema string = `SELECT Time, Value, ` + selectBarColumns + ` FROM Measures where Symbol = $1`
 
 func TimeForSQL(time time.Time) string {
 	// YYYY-MM-DD
@@ -94,12 +220,18 @@ func TimeForSQL(time time.Time) string {
 
 // startDate and endDate inclusive
 func (db *StockDB) GetRange(stock *Stock, startDate time.Time, endDate time.Time) (Span, error) {
-	rows, err := db.Queryx(selectMeasuresRangeSchema, stock.Symbol, TimeForSQL(startDate), TimeForSQL(endDate))
+	return db.GetRangeContext(context.Background(), stock, startDate, endDate)
+}
+
+func (db *StockDB) GetRangeContext(ctx context.Context, stock *Stock, startDate time.Time, endDate time.Time) (span Span, err error) {
+	defer observeQuery("get_range", time.Now(), &err)
+	rows, err := db.QueryxContext(ctx, selectMeasuresRangeSchema, stock.Symbol, TimeForSQL(startDate), TimeForSQL(endDate))
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	span := *new(Span)
+	span = *new(Span)
 	for rows.Next() {
 		m := new(Measure)
 		err = rows.StructScan(m)
@@ -109,5 +241,41 @@ func (db *StockDB) GetRange(stock *Stock, startDate time.Time, endDate time.Time
 		span = append(span, *m)
 	}
 
-	return span, nil
+	// a query canceled part way through ends the rows early
+	return span, rows.Err()
+}
+
+const insertJobsSchema string = `INSERT INTO Jobs (Symbol, Day, Started, Finished, Attempts, Error) VALUES ($1, $2, $3, $4, $5, $6) RETURNING ID`
+const selectJobsSchema string = `SELECT ID, Symbol, Day, Started, Finished, Attempts, Error FROM Jobs ORDER BY ID DESC LIMIT $1`
+
+// InsertJob records a scheduled job in the job history, setting job.ID
+func (db *StockDB) InsertJob(job *Job) (err error) {
+	defer observeQuery("insert_job", time.Now(), &err)
+	return db.QueryRowx(insertJobsSchema, job.Symbol, TimeForSQL(job.Day), job.Started, job.Finished, job.Attempts, job.Error).Scan(&job.ID)
+}
+
+// GetJobs returns up to limit of the most recent jobs, newest first
+func (db *StockDB) GetJobs(limit int) (jobs []Job, err error) {
+	defer observeQuery("get_jobs", time.Now(), &err)
+	jobs = []Job{}
+	err = db.Select(&jobs, selectJobsSchema, limit)
+	return jobs, err
+}
+
+const insertCheckpointsSchema string = `INSERT INTO Checkpoints VALUES ($1, $2, $3, $4, $5) ON CONFLICT (Backfill, Symbol, ChunkStart) DO UPDATE SET ChunkEnd = EXCLUDED.ChunkEnd, Finished = EXCLUDED.Finished`
+const selectCheckpointsSchema string = `SELECT Backfill, Symbol, ChunkStart, ChunkEnd, Finished FROM Checkpoints WHERE Backfill = $1`
+
+// InsertCheckpoint records a chunk completed by a backfill
+func (db *StockDB) InsertCheckpoint(cp *Checkpoint) (err error) {
+	defer observeQuery("insert_checkpoint", time.Now(), &err)
+	_, err = db.Exec(insertCheckpointsSchema, cp.Backfill, cp.Symbol, TimeForSQL(cp.ChunkStart), TimeForSQL(cp.ChunkEnd), cp.Finished)
+	return err
+}
+
+// GetCheckpoints returns every chunk completed by the named backfill
+func (db *StockDB) GetCheckpoints(backfill string) (checkpoints []Checkpoint, err error) {
+	defer observeQuery("get_checkpoints", time.Now(), &err)
+	checkpoints = []Checkpoint{}
+	err = db.Select(&checkpoints, selectCheckpointsSchema, backfill)
+	return checkpoints, err
 }
@@ -3,6 +3,7 @@
 package stock_test
 
 import (
+	"context"
 	"sort"
 	"strings"
 	"testing"
@@ -12,49 +13,60 @@ import (
 	"github.com/jhurwich/trendy/testhelpers"
 )
 
-// Confirm that DB.Setup completes with a functioning db and tables to populate
+// Confirm that a new store starts empty with functioning tables to populate
 func TestSetup(t *testing.T) {
+	t.Parallel()
 	var tests = []struct {
-		table string // the name for each table that should be created in Setup
+		table string // the name for each table that should be created by Connect
 	}{
 		{"measures"},
+		{"modifications"},
+		{"jobs"},
+		{"checkpoints"},
+		{"apikeys"},
+		{"usage"},
+		{"migrations"},
 	}
 
-	// test that a new db can be created, panics if fails
-	tdb := stock.DB.Setup(stock.TestLocal)
+	store, discard := testhelpers.NewStore(t)
+	defer discard()
 
-	// record the changes we make so they can be reversed
-	td := testhelpers.TearDown{}
-	for _, test := range tests {
-		td = append(td, (testhelpers.Change{Table: test.table, Action: testhelpers.CREATE}))
-	}
-	defer func() {
-		err := td.TearDown(tdb, t)
-		if err != nil {
-			t.Error(err)
-		}
-	}()
-
-	// and ensure it has all the tables that we expect
-	for _, test := range tests {
-		testQuery := strings.Join([]string{"SELECT * FROM ", test.table}, "")
-		rows, err := tdb.Query(testQuery)
-		if err != nil {
-			// if the table does not exist we'll get an error
-			t.Error(err)
-		} else {
+	// ensure Postgres has all the tables that we expect
+	if tdb, ok := store.(*stock.StockDB); ok {
+		for _, test := range tests {
+			testQuery := strings.Join([]string{"SELECT * FROM ", test.table}, "")
+			rows, err := tdb.Query(testQuery)
+			if err != nil {
+				// if the table does not exist we'll get an error
+				t.Error(err)
+				continue
+			}
 			// we'll get rows if the table exists, but won't be able to access any data
-			hasNext := rows.Next()
-			if hasNext {
+			if rows.Next() {
 				// if we have data something is wrong
 				t.Errorf("Expected empty table but got results with query `%s`", testQuery)
 			}
+			rows.Close()
 		}
 	}
 
+	// every migration has been applied
+	if version, err := store.MigrationVersion(); err != nil || version != stock.SchemaVersion {
+		t.Errorf("Expected migration version %d, got %d, %v", stock.SchemaVersion, version, err)
+	}
+
+	// and that any store starts empty
+	span, err := store.GetRange(stock.NewStock("GOOG"), time.Time{}, time.Now())
+	if err != nil || len(span) > 0 {
+		t.Errorf("Expected an empty store, got %+v, %v", span, err)
+	}
+	if keys, err := store.GetAPIKeys(); err != nil || len(keys) > 0 {
+		t.Errorf("Expected no keys in a new store, got %+v, %v", keys, err)
+	}
 }
 
 func TestInsert(t *testing.T) {
+	t.Parallel()
 	var tests = []struct {
 		symbol string
 		span   stock.Span
@@ -62,101 +74,223 @@ func TestInsert(t *testing.T) {
 		{"GOOG", testSpan1},
 	}
 
-	// create test db connection
-	tdb := stock.DB.Setup(stock.TestLocal)
+	store, discard := testhelpers.NewStore(t)
+	defer discard()
 
-	// record the changes we make so they can be reversed, all measures in span will be inserted
-	td := testhelpers.TearDown{}
 	for _, test := range tests {
-		td = td.TrackSpanInsert(test.symbol, &test.span, tdb, t)
-	}
-	defer func() {
-		err := td.TearDown(tdb, t)
-		if err != nil {
-			t.Error(err)
+		s := stock.NewStock(test.symbol)
+		if modified, _ := store.LastModified(s); !modified.IsZero() {
+			t.Errorf("Expected no modification time before insert, got %v", modified)
 		}
-	}()
 
-	for _, test := range tests {
 		// do the DB.Insert()
-		err := tdb.Insert(stock.NewStock(test.symbol), &test.span)
+		err := store.Insert(s, &test.span)
 		if err != nil {
 			t.Error(err)
 		}
 
 		// query for all data for test.symbol and compare against provided span
-		// use a direct Queryx here, we'll test GetRange separately
-		rows, err := tdb.Queryx(`SELECT Time, Value FROM Measures where Symbol = $1`, test.symbol)
+		spanToCheck, err := store.GetRange(s, time.Time{}, time.Now())
 		if err != nil {
 			t.Error(err)
 		}
 
-		spanToCheck := *new(stock.Span)
-		for rows.Next() {
-			m := new(stock.Measure)
-			err = rows.StructScan(m)
-			if err != nil {
-				t.Error(err)
-			}
-			spanToCheck = append(spanToCheck, *m)
-		}
-
 		// compare retrieved and expected spans
 		if !test.span.Equal(spanToCheck) {
 			t.Errorf("Error on DB.Insert(), database did not respond with expected number of results after insert -\nexpected:\n%+v\ngot:\n%+v\n", test.span, spanToCheck)
 		}
-	}
+		if modified, _ := store.LastModified(s); modified.IsZero() {
+			t.Errorf("Expected a modification time after insert")
+		}
 
+		// inserting overlapping measures overwrites them
+		changed := stock.Span{{Time: test.span[0].Time, Value: 1}}
+		if err := store.Insert(s, &changed); err != nil {
+			t.Error(err)
+		}
+		spanToCheck, _ = store.GetRange(s, test.span[0].Time, test.span[0].Time)
+		if !changed.Equal(spanToCheck) {
+			t.Errorf("Expected insert to overwrite the measure, got %+v", spanToCheck)
+		}
+	}
 }
 
-// test getting data after DB.Insert(), same as above but we use the GetRange()
-// method where we talked directly to the database before
+// test getting data after DB.Insert(), only the measures in range are returned
 func TestGetRange(t *testing.T) {
+	t.Parallel()
 	var tests = []struct {
 		symbol string
 		span   stock.Span
+		first  int // index in span of the first measure to get
+		last   int
 	}{
-		{"GOOG", testSpan1},
+		{"GOOG", testSpan1, 0, len(testSpan1) - 1},
+		{"GOOG", testSpan1, 3, 7},
+		{"GOOG", testSpan1, 5, 5},
 	}
 
-	// create test db connection
-	tdb := stock.DB.Setup(stock.TestLocal)
+	store, discard := testhelpers.NewStore(t)
+	defer discard()
 
-	// record the changes we make so they can be reversed, all measures in span will be inserted
-	td := testhelpers.TearDown{}
-	for _, test := range tests {
-		td = td.TrackSpanInsert(test.symbol, &test.span, tdb, t)
+	s := stock.NewStock("GOOG")
+	span := append(stock.Span{}, testSpan1...)
+	sort.Sort(span)
+	if err := store.Insert(s, &span); err != nil {
+		t.Fatal(err)
+	}
+	// other symbols aren't included
+	if err := store.Insert(stock.NewStock("AAPL"), &span); err != nil {
+		t.Fatal(err)
 	}
-	defer func() {
-		err := td.TearDown(tdb, t)
-		if err != nil {
-			t.Error(err)
-		}
-	}()
 
 	for _, test := range tests {
-		s := stock.NewStock(test.symbol)
-
-		// do the DB.Insert()
-		err := tdb.Insert(s, &test.span)
+		got, err := store.GetRange(stock.NewStock(test.symbol), span[test.first].Time, span[test.last].Time)
 		if err != nil {
 			t.Error(err)
 		}
+		sort.Sort(got)
 
-		// now get the range we just inserted
-		sort.Sort(testSpan1)
-		startDate := testSpan1[0].Time
-		endDate := testSpan1[len(testSpan1)-1].Time
-		span, err := tdb.GetRange(s, startDate, endDate)
-		if err != nil {
-			t.Error(err)
+		// compare retrieved and expected spans
+		if expected := span[test.first : test.last+1]; !expected.Equal(got) {
+			t.Errorf("Error on DB.GetRange(), database did not respond with expected number of results after insert -\nexpected:\n%+v\ngot:\n%+v\n", expected, got)
 		}
+	}
+}
 
-		// compare retrieved and expected spans
-		if !test.span.Equal(span) {
-			t.Errorf("Error on DB.GetRange(), database did not respond with expected number of results after insert -\nexpected:\n%+v\ngot:\n%+v\n", test.span, span)
+func TestStoreAPIKeys(t *testing.T) {
+	t.Parallel()
+	store, discard := testhelpers.NewStore(t)
+	defer discard()
+
+	apiKey, _, err := stock.NewAPIKey("test", []string{stock.ScopeRead}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.InsertAPIKey(apiKey); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := store.GetAPIKey(apiKey.Key)
+	if err != nil || got.SecretHash != apiKey.SecretHash || !got.HasScope(stock.ScopeRead) {
+		t.Errorf("Expected the inserted key, got %+v, %v", got, err)
+	}
+	if _, err := store.GetAPIKey("missing"); err != stock.ErrKeyNotFound {
+		t.Errorf("Expected ErrKeyNotFound for a missing key, got %v", err)
+	}
+
+	// rotating stores the new secret
+	secret, _ := apiKey.Rotate()
+	if err := store.UpdateAPIKeySecret(apiKey); err != nil {
+		t.Error(err)
+	}
+	if got, _ := store.GetAPIKey(apiKey.Key); got.Check(secret, time.Now()) != nil {
+		t.Errorf("Expected the rotated secret to be stored")
+	}
+
+	// updating limits stores them
+	apiKey.RateLimit, apiKey.Burst, apiKey.DailyQuota, apiKey.MonthlyQuota = 2.5, 5, 100, 1000
+	if err := store.UpdateAPIKeyLimits(apiKey); err != nil {
+		t.Error(err)
+	}
+	if got, _ := store.GetAPIKey(apiKey.Key); got.RateLimit != 2.5 || got.Burst != 5 || got.DailyQuota != 100 || got.MonthlyQuota != 1000 {
+		t.Errorf("Expected the updated limits to be stored, got %+v", got)
+	}
+	if err := store.UpdateAPIKeyLimits(&stock.APIKey{Key: "missing"}); err != stock.ErrKeyNotFound {
+		t.Errorf("Expected ErrKeyNotFound updating a missing key, got %v", err)
+	}
+
+	// revoking keeps the first revocation time
+	first := time.Date(2015, time.June, 1, 0, 0, 0, 0, time.UTC)
+	store.RevokeAPIKey(apiKey.Key, first)
+	store.RevokeAPIKey(apiKey.Key, first.AddDate(0, 0, 1))
+	if got, _ := store.GetAPIKey(apiKey.Key); got.Revoked == nil || !got.Revoked.Equal(first) {
+		t.Errorf("Expected key revoked at %v, got %v", first, got.Revoked)
+	}
+	if err := store.RevokeAPIKey("missing", first); err != stock.ErrKeyNotFound {
+		t.Errorf("Expected ErrKeyNotFound revoking a missing key, got %v", err)
+	}
+
+	if keys, err := store.GetAPIKeys(); err != nil || len(keys) != 1 {
+		t.Errorf("Expected one key, got %+v, %v", keys, err)
+	}
+}
+
+// a canceled context is refused by the context variants, the others are unaffected
+func TestStoreContext(t *testing.T) {
+	t.Parallel()
+	store, discard := testhelpers.NewStore(t)
+	defer discard()
+	s := stock.NewStock("GOOG")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := store.InsertContext(ctx, s, &testSpan1); err != context.Canceled {
+		t.Errorf("Expected context.Canceled inserting, got %v", err)
+	}
+	if _, err := store.GetRangeContext(ctx, s, time.Time{}, time.Now()); err != context.Canceled {
+		t.Errorf("Expected context.Canceled getting a range, got %v", err)
+	}
+	if _, err := store.LastModifiedContext(ctx, s); err != context.Canceled {
+		t.Errorf("Expected context.Canceled getting the modification time, got %v", err)
+	}
+
+	if err := store.Insert(s, &testSpan1); err != nil {
+		t.Fatal(err)
+	}
+	if span, err := store.GetRange(s, time.Time{}, time.Now()); err != nil || len(span) != len(testSpan1) {
+		t.Errorf("Expected the inserted span without a context, got %d measures, %v", len(span), err)
+	}
+}
+
+func TestStoreUsage(t *testing.T) {
+	t.Parallel()
+	store, discard := testhelpers.NewStore(t)
+	defer discard()
+
+	for i := 1; i <= 3; i++ {
+		if count, err := store.IncrementUsage("key", "2015-06", 1); err != nil || count != i {
+			t.Errorf("Expected count %d, got %d, %v", i, count, err)
+		}
+	}
+	if count, _ := store.IncrementUsage("key", "2015-07", 1); count != 1 {
+		t.Errorf("Expected periods to be counted separately, got %d", count)
+	}
+	if count, err := store.IncrementUsage("key", "2015-06", 4); err != nil || count != 7 {
+		t.Errorf("Expected a count of 7 after counting 4 more, got %d, %v", count, err)
+	}
+	if count, err := store.GetUsage("key", "2015-06"); err != nil || count != 7 {
+		t.Errorf("Expected usage of 7, got %d, %v", count, err)
+	}
+	if count, err := store.GetUsage("key", "2015-08"); err != nil || count != 0 {
+		t.Errorf("Expected no usage for a period without requests, got %d, %v", count, err)
+	}
+}
+
+func TestStoreJobsAndCheckpoints(t *testing.T) {
+	t.Parallel()
+	store, discard := testhelpers.NewStore(t)
+	defer discard()
+
+	day := time.Date(2015, time.June, 1, 0, 0, 0, 0, time.UTC)
+	for _, symbol := range []string{"GOOG", "AAPL", "MSFT"} {
+		job := &stock.Job{Symbol: symbol, Day: day, Started: day, Finished: day, Attempts: 1}
+		if err := store.InsertJob(job); err != nil || job.ID == 0 {
+			t.Errorf("Expected job to be inserted with an ID, got %d, %v", job.ID, err)
 		}
 	}
+	jobs, err := store.GetJobs(2)
+	if err != nil || len(jobs) != 2 || jobs[0].Symbol != "MSFT" || jobs[1].Symbol != "AAPL" {
+		t.Errorf("Expected the two newest jobs, got %+v, %v", jobs, err)
+	}
+
+	cp := &stock.Checkpoint{Backfill: "test", Symbol: "GOOG", ChunkStart: day, ChunkEnd: day.AddDate(0, 0, 30), Finished: day}
+	store.InsertCheckpoint(cp)
+	store.InsertCheckpoint(cp) // completing a chunk twice keeps one checkpoint
+	store.InsertCheckpoint(&stock.Checkpoint{Backfill: "other", Symbol: "GOOG", ChunkStart: day, ChunkEnd: day, Finished: day})
+	checkpoints, err := store.GetCheckpoints("test")
+	if err != nil || len(checkpoints) != 1 || !checkpoints[0].ChunkEnd.Equal(cp.ChunkEnd) {
+		t.Errorf("Expected one checkpoint for the backfill, got %+v, %v", checkpoints, err)
+	}
 }
 
 /* Utils */
@@ -164,19 +298,19 @@ func TestGetRange(t *testing.T) {
 /* Global Constants and Vars */
 
 var testSpan1 stock.Span = (stock.Span)([]stock.Measure{
-	{time.Date(2015, time.June, 1, 12, 0, 0, 0, time.UTC), 0.012345},
-	{time.Date(2015, time.June, 2, 12, 0, 0, 0, time.UTC), 0.012346},
-	{time.Date(2015, time.June, 3, 12, 0, 0, 0, time.UTC), 0.012347},
-	{time.Date(2015, time.June, 4, 12, 0, 0, 0, time.UTC), 0.012348},
-	{time.Date(2015, time.June, 5, 12, 0, 0, 0, time.UTC), 0.012349},
-	{time.Date(2015, time.June, 6, 12, 0, 0, 0, time.UTC), 0.012350},
-	{time.Date(2015, time.June, 7, 12, 0, 0, 0, time.UTC), 0.012351},
-	{time.Date(2015, time.June, 8, 12, 0, 0, 0, time.UTC), 0.012352},
-	{time.Date(2015, time.June, 9, 12, 0, 0, 0, time.UTC), 0.012353},
-	{time.Date(2015, time.June, 10, 12, 0, 0, 0, time.UTC), 0.012354},
-	{time.Date(2015, time.June, 11, 12, 0, 0, 0, time.UTC), 0.012355},
-	{time.Date(2015, time.June, 12, 12, 0, 0, 0, time.UTC), 0.012354},
-	{time.Date(2015, time.June, 13, 12, 0, 0, 0, time.UTC), 0.012353},
-	{time.Date(2015, time.June, 14, 12, 0, 0, 0, time.UTC), 0.012352},
-	{time.Date(2015, time.June, 15, 12, 0, 0, 0, time.UTC), 0.012351},
+	{Time: time.Date(2015, time.June, 1, 12, 0, 0, 0, time.UTC), Value: 0.012345},
+	{Time: time.Date(2015, time.June, 2, 12, 0, 0, 0, time.UTC), Value: 0.012346},
+	{Time: time.Date(2015, time.June, 3, 12, 0, 0, 0, time.UTC), Value: 0.012347},
+	{Time: time.Date(2015, time.June, 4, 12, 0, 0, 0, time.UTC), Value: 0.012348},
+	{Time: time.Date(2015, time.June, 5, 12, 0, 0, 0, time.UTC), Value: 0.012349},
+	{Time: time.Date(2015, time.June, 6, 12, 0, 0, 0, time.UTC), Value: 0.012350},
+	{Time: time.Date(2015, time.June, 7, 12, 0, 0, 0, time.UTC), Value: 0.012351},
+	{Time: time.Date(2015, time.June, 8, 12, 0, 0, 0, time.UTC), Value: 0.012352},
+	{Time: time.Date(2015, time.June, 9, 12, 0, 0, 0, time.UTC), Value: 0.012353},
+	{Time: time.Date(2015, time.June, 10, 12, 0, 0, 0, time.UTC), Value: 0.012354},
+	{Time: time.Date(2015, time.June, 11, 12, 0, 0, 0, time.UTC), Value: 0.012355},
+	{Time: time.Date(2015, time.June, 12, 12, 0, 0, 0, time.UTC), Value: 0.012354},
+	{Time: time.Date(2015, time.June, 13, 12, 0, 0, 0, time.UTC), Value: 0.012353},
+	{Time: time.Date(2015, time.June, 14, 12, 0, 0, 0, time.UTC), Value: 0.012352},
+	{Time: time.Date(2015, time.June, 15, 12, 0, 0, 0, time.UTC), Value: 0.012351},
 })
@@ -0,0 +1,215 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package stock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrDelisted is returned for a range starting after its symbol was delisted
+var ErrDelisted = errors.New("Delisted")
+
+// SymbolChange is a company changing ticker, it traded as Symbol until the
+// day before Date and as NewSymbol from Date on. Measures stay stored under
+// the ticker they traded as, a range across the change is served from both.
+type SymbolChange struct {
+	Symbol    string
+	NewSymbol string
+	Date      time.Time
+}
+
+// ReadSymbolChanges reads a CSV symbol change file with a header naming its
+// symbol, new_symbol and date columns in any order. Dates are YYYY-MM-DD,
+// symbols are uppercased.
+func ReadSymbolChanges(r io.Reader) ([]SymbolChange, error) {
+	changes := []SymbolChange{}
+	err := readCSV(r, "Symbol change", []string{"symbol", "new_symbol", "date"}, func(line int, field func(string) string) error {
+		change := SymbolChange{
+			Symbol:    strings.ToUpper(field("symbol")),
+			NewSymbol: strings.ToUpper(field("new_symbol")),
+		}
+		if change.Symbol == "" || change.NewSymbol == "" {
+			return fmt.Errorf("Missing symbol on line %d", line)
+		}
+		if change.Symbol == change.NewSymbol {
+			return fmt.Errorf("Symbol changes to itself on line %d [%s]", line, change.Symbol)
+		}
+		var err error
+		if change.Date, err = time.Parse("2006-01-02", field("date")); err != nil {
+			return fmt.Errorf("Could not parse date on line %d, must be YYYY-MM-DD [%s]", line, field("date"))
+		}
+		changes = append(changes, change)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// LoadSymbolChanges adds the changes to the registry, replacing any of the
+// same symbol on the same date
+func LoadSymbolChanges(ctx context.Context, changes []SymbolChange) error {
+	if err := DB.InsertSymbolChanges(ctx, changes); err != nil {
+		return err
+	}
+	registry.invalidate()
+	return nil
+}
+
+// order changes by date, then symbol
+func sortSymbolChanges(changes []SymbolChange) {
+	sort.Slice(changes, func(i, j int) bool {
+		if !changes[i].Date.Equal(changes[j].Date) {
+			return changes[i].Date.Before(changes[j].Date)
+		}
+		return changes[i].Symbol < changes[j].Symbol
+	})
+}
+
+// a part of a range served under one ticker, a zero end is open as for Range
+type segment struct {
+	symbol     string
+	start, end time.Time
+}
+
+// symbolSegments resolves the company symbol refers to into the tickers it
+// traded as from start to end, oldest first. An old ticker follows the
+// company to its new one unless it was listed again after the change. The
+// range is cut off at a delisting, ErrDelisted if it starts after one.
+func symbolSegments(ctx context.Context, symbol string, start time.Time, end time.Time) ([]segment, error) {
+	listings, changes, err := registry.history(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(changes) == 0 && len(listings) == 0 {
+		return []segment{{symbol, start, end}}, nil
+	}
+
+	current := strings.ToUpper(symbol)
+	for hops := 0; hops < len(changes); hops++ {
+		change, ok := lastChange(changes, func(c SymbolChange) bool { return c.Symbol == current })
+		if !ok {
+			break
+		}
+		if listing, ok := listings[current]; ok && listing.Listed != nil && !listing.Listed.Before(change.Date) {
+			break // reused by another company
+		}
+		current = change.NewSymbol
+	}
+
+	if listing, ok := listings[current]; ok && listing.Delisted != nil {
+		delisted := *listing.Delisted
+		if start.After(delisted) {
+			return nil, fmt.Errorf("%w on %s [%s]", ErrDelisted, TimeForSQL(delisted), symbol)
+		}
+		if end.IsZero() || end.After(delisted) {
+			end = delisted
+		}
+	}
+
+	// walk back through the changes into each ticker until one predates start
+	segments := []segment{}
+	segmentEnd := end
+	for hops := 0; hops <= len(changes); hops++ {
+		into, ok := lastChange(changes, func(c SymbolChange) bool {
+			return c.NewSymbol == current && (segmentEnd.IsZero() || !c.Date.After(segmentEnd))
+		})
+		if !ok {
+			// the rest of the range may predate the ticker entirely
+			later, ok := firstChange(changes, func(c SymbolChange) bool { return c.NewSymbol == current })
+			if !ok || len(segments) > 0 {
+				break
+			}
+			current = later.Symbol
+			continue
+		}
+		if !into.Date.After(start) {
+			break
+		}
+		segments = append(segments, segment{current, into.Date, segmentEnd})
+		current, segmentEnd = into.Symbol, into.Date.AddDate(0, 0, -1)
+	}
+	segments = append(segments, segment{current, start, segmentEnd})
+
+	for i, j := 0, len(segments)-1; i < j; i, j = i+1, j-1 {
+		segments[i], segments[j] = segments[j], segments[i]
+	}
+	return segments, nil
+}
+
+// the latest of changes, which are ordered by date, matching
+func lastChange(changes []SymbolChange, matching func(SymbolChange) bool) (SymbolChange, bool) {
+	for i := len(changes) - 1; i >= 0; i-- {
+		if matching(changes[i]) {
+			return changes[i], true
+		}
+	}
+	return SymbolChange{}, false
+}
+
+// the earliest of changes, which are ordered by date, matching
+func firstChange(changes []SymbolChange, matching func(SymbolChange) bool) (SymbolChange, bool) {
+	for _, change := range changes {
+		if matching(change) {
+			return change, true
+		}
+	}
+	return SymbolChange{}, false
+}
+
+// rangeSegments is the continuous span of each segment's range in turn. A
+// ticker without data for its part isn't an error as long as another has some.
+func (s *Stock) rangeSegments(ctx context.Context, segments []segment, overrideUrl string, started time.Time) (Span, error) {
+	span := Span{}
+	for _, seg := range segments {
+		ticker := s
+		if !strings.EqualFold(seg.symbol, s.Symbol) {
+			ticker = NewStock(seg.symbol)
+		}
+		part, err := ticker.storedRange(ctx, seg.start, seg.end, overrideUrl, started)
+		if errors.Is(err, ErrNoData) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		span = mergeSpans(span, part)
+	}
+	if len(span) == 0 {
+		return nil, ErrNoData
+	}
+	return span, nil
+}
+
+const upsertSymbolChangesSchema string = `INSERT INTO SymbolChanges (Symbol, NewSymbol, Date) VALUES ($1, $2, $3) ON CONFLICT (Symbol, Date) DO UPDATE SET NewSymbol = EXCLUDED.NewSymbol`
+const selectSymbolChangesSchema string = `SELECT Symbol, NewSymbol, Date FROM SymbolChanges ORDER BY Date, Symbol`
+
+// InsertSymbolChanges adds or replaces symbol changes in one transaction
+func (db *StockDB) InsertSymbolChanges(ctx context.Context, changes []SymbolChange) (err error) {
+	defer observeQuery("insert_symbol_changes", time.Now(), &err)
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, c := range changes {
+		if _, err := tx.ExecContext(ctx, upsertSymbolChangesSchema, c.Symbol, c.NewSymbol, TimeForSQL(c.Date)); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetSymbolChanges returns every symbol change ordered by date
+func (db *StockDB) GetSymbolChanges(ctx context.Context) (changes []SymbolChange, err error) {
+	defer observeQuery("get_symbol_changes", time.Now(), &err)
+	changes = []SymbolChange{}
+	err = db.SelectContext(ctx, &changes, selectSymbolChangesSchema)
+	return changes, err
+}
@@ -0,0 +1,125 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package stock_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jhurwich/trendy/stock"
+	"github.com/jhurwich/trendy/testhelpers"
+)
+
+func TestReadSymbolChanges(t *testing.T) {
+	t.Parallel()
+	changes, err := stock.ReadSymbolChanges(strings.NewReader("date,symbol,new_symbol\n2022-06-09,fb,META\n"))
+	if err != nil {
+		t.Fatalf("Unexpected error reading symbol changes: %v", err)
+	}
+	if len(changes) != 1 || changes[0].Symbol != "FB" || changes[0].NewSymbol != "META" || stock.TimeForSQL(changes[0].Date) != "2022-06-09" {
+		t.Errorf("Unexpected symbol changes %+v", changes)
+	}
+
+	for _, invalid := range []string{
+		"",
+		"symbol,new_symbol\nFB,META\n",
+		"symbol,new_symbol,date\n,META,2022-06-09\n",
+		"symbol,new_symbol,date\nFB,FB,2022-06-09\n",
+		"symbol,new_symbol,date\nFB,META,June 2022\n",
+	} {
+		if _, err := stock.ReadSymbolChanges(strings.NewReader(invalid)); err == nil {
+			t.Errorf("Expected an error reading %q", invalid)
+		}
+	}
+}
+
+func TestSymbolHistory(t *testing.T) {
+	_, discard := testhelpers.UseStore(t)
+	defer discard()
+	defaultProvider := stock.DefaultProvider
+	provider := &recordingProvider{}
+	stock.DefaultProvider = provider
+	defer func() { stock.DefaultProvider = defaultProvider }()
+	ctx := context.Background()
+
+	listings, err := stock.ReadListings(strings.NewReader(`symbol,name,listed,delisted
+FB,Facebook Inc,2012-05-18,2022-06-08
+META,Meta Platforms Inc,2022-06-09,
+OLD,Old Co,2021-01-04,
+NEW,Formerly Old Co,2020-01-02,
+GONE,Gone Inc,2000-01-03,2019-12-31
+`))
+	if err != nil {
+		t.Fatalf("Could not read listings: %v", err)
+	}
+	changes, _ := stock.ReadSymbolChanges(strings.NewReader("symbol,new_symbol,date\nFB,META,2022-06-09\nOLD,NEW,2020-01-02\n"))
+	if err := stock.LoadListings(ctx, listings); err != nil {
+		t.Fatalf("Could not load listings: %v", err)
+	}
+	if err := stock.LoadSymbolChanges(ctx, changes); err != nil {
+		t.Fatalf("Could not load symbol changes: %v", err)
+	}
+	date := func(s string) time.Time {
+		d, _ := time.Parse("2006-01-02", s)
+		return d
+	}
+
+	// one continuous span across the change, each part fetched under its ticker
+	meta, err := stock.NewStock("META").RangeContext(ctx, date("2022-05-02"), date("2022-06-30"))
+	if err != nil {
+		t.Fatalf("Unexpected error across a symbol change: %v", err)
+	}
+	if len(meta) < 40 || stock.TimeForSQL(meta[0].Time) != "2022-05-02" || stock.TimeForSQL(meta[len(meta)-1].Time) != "2022-06-30" {
+		t.Errorf("Expected a span from 2022-05-02 to 2022-06-30, got %d measures %v", len(meta), meta)
+	}
+	for i := 1; i < len(meta); i++ {
+		if !meta[i].Time.After(meta[i-1].Time) {
+			t.Errorf("Expected measures in order, got %v then %v", meta[i-1].Time, meta[i].Time)
+		}
+	}
+	if strings.Join(provider.fetched, ",") != "FB 2022-05-02 2022-06-08,META 2022-06-09 2022-06-30" {
+		t.Errorf("Expected each ticker fetched for its part, got %v", provider.fetched)
+	}
+
+	// the old ticker follows the company and the new one reaches back before it
+	fb, err := stock.NewStock("fb").RangeContext(ctx, date("2022-05-02"), date("2022-06-30"))
+	if err != nil || len(fb) != len(meta) {
+		t.Errorf("Expected FB to serve META's span, got %d measures %v", len(fb), err)
+	}
+	provider.fetched = nil
+	if _, err := stock.NewStock("META").RangeContext(ctx, date("2015-06-01"), date("2015-06-30")); err != nil || strings.Join(provider.fetched, ",") != "FB 2015-06-01 2015-06-30" {
+		t.Errorf("Expected META before its listing to be served as FB, got %v %v", provider.fetched, err)
+	}
+
+	// a ticker listed again after a change is the new company's and isn't followed
+	provider.fetched = nil
+	stock.NewStock("OLD").RangeContext(ctx, date("2021-02-01"), date("2021-02-26"))
+	stock.NewStock("NEW").RangeContext(ctx, date("2019-12-02"), date("2020-01-31"))
+	if strings.Join(provider.fetched, ",") != "OLD 2021-02-01 2021-02-26,OLD 2019-12-02 2020-01-01,NEW 2020-01-02 2020-01-31" {
+		t.Errorf("Expected OLD fetched as itself and before NEW, got %v", provider.fetched)
+	}
+
+	// delisted symbols are cut off, and refused after
+	gone, err := stock.NewStock("GONE").RangeContext(ctx, date("2019-12-02"), date("2020-01-31"))
+	if err != nil || len(gone) == 0 || gone[len(gone)-1].Time.After(date("2019-12-31")) {
+		t.Errorf("Expected GONE's span to end by its delisting, got %v %v", gone, err)
+	}
+	_, err = stock.NewStock("GONE").RangeContext(ctx, date("2020-02-03"), date("2020-02-28"))
+	if !errors.Is(err, stock.ErrDelisted) || !strings.Contains(err.Error(), "2019-12-31") {
+		t.Errorf("Expected ErrDelisted after GONE's delisting, got %v", err)
+	}
+}
+
+// a SyntheticProvider that records each symbol and range it fetches
+type recordingProvider struct {
+	stock.SyntheticProvider
+	fetched []string
+}
+
+func (p *recordingProvider) FetchContext(ctx context.Context, s *stock.Stock, startDate time.Time, endDate time.Time) (stock.Span, error) {
+	p.fetched = append(p.fetched, strings.Join([]string{s.Symbol, stock.TimeForSQL(startDate), stock.TimeForSQL(endDate)}, " "))
+	return p.SyntheticProvider.FetchContext(ctx, s, startDate, endDate)
+}
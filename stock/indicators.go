@@ -0,0 +1,131 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package stock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ErrWindow is returned for an indicator window that isn't positive
+var ErrWindow = errors.New("Indicator window must be positive")
+
+// the indicators a Stock can compute over a range
+const (
+	IndicatorSMA = "SMA"
+	IndicatorEMA = "EMA"
+)
+
+// IndicatorLookback is the calendar days before a range's start to fetch so
+// that an indicator has window trading days before it, allowing for weekends
+// and holidays
+func IndicatorLookback(window int) int {
+	return window*7/5 + 10
+}
+
+// Indicator is the named indicator at each measure between startDate and
+// endDate, computed with the window before startDate so the points start with
+// the range
+func (s *Stock) Indicator(kind string, window int, startDate time.Time, endDate time.Time) (Span, error) {
+	return s.IndicatorContext(context.Background(), kind, window, startDate, endDate)
+}
+
+// IndicatorContext is Indicator on behalf of ctx, ranges are looked up with RangeContext
+func (s *Stock) IndicatorContext(ctx context.Context, kind string, window int, startDate time.Time, endDate time.Time) (Span, error) {
+	compute := map[string]func(Span, int) (Span, error){IndicatorSMA: Span.SMA, IndicatorEMA: Span.EMA}[kind]
+	if compute == nil {
+		return nil, fmt.Errorf("Unknown indicator [%s]", kind)
+	}
+	if window <= 0 {
+		return nil, ErrWindow
+	}
+
+	// the lookback is its own range, a stored range that includes startDate
+	// isn't extended back to cover it. Without it, say before the symbol
+	// listed, the points start a window into the range.
+	lookback, err := s.RangeContext(ctx, startDate.AddDate(0, 0, -IndicatorLookback(window)), startDate.AddDate(0, 0, -1))
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	span, err := s.RangeContext(ctx, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+	first := TimeForSQL(startDate)
+	measures := Span{}
+	for _, measure := range lookback {
+		if TimeForSQL(measure.Time) < first {
+			measures = append(measures, measure)
+		}
+	}
+	measures = append(measures, span...)
+
+	points, err := compute(measures, window)
+	if err != nil {
+		return nil, err
+	}
+	for len(points) > 0 && TimeForSQL(points[0].Time) < first {
+		points = points[1:]
+	}
+	return points, nil
+}
+
+// SMA is the simple moving average of the last window values at each measure,
+// starting from the window'th since there aren't enough values before it
+func (s Span) SMA(window int) (Span, error) {
+	if window <= 0 {
+		return nil, ErrWindow
+	}
+	s = sorted(s)
+	averages := Span{}
+	var sum float64
+	for i, measure := range s {
+		sum += float64(measure.Value)
+		if i >= window {
+			sum -= float64(s[i-window].Value)
+		}
+		if i >= window-1 {
+			averages = append(averages, Measure{Time: measure.Time, Value: float32(sum / float64(window))})
+		}
+	}
+	return averages, nil
+}
+
+// EMA is the exponential moving average with a smoothing of 2/(window+1),
+// seeded with the SMA of the first window values
+func (s Span) EMA(window int) (Span, error) {
+	if window <= 0 {
+		return nil, ErrWindow
+	}
+	s = sorted(s)
+	averages := Span{}
+	if len(s) < window {
+		return averages, nil
+	}
+	var ema float64
+	for _, measure := range s[:window] {
+		ema += float64(measure.Value)
+	}
+	ema /= float64(window)
+	averages = append(averages, Measure{Time: s[window-1].Time, Value: float32(ema)})
+
+	alpha := 2 / float64(window+1)
+	for _, measure := range s[window:] {
+		ema += alpha * (float64(measure.Value) - ema)
+		averages = append(averages, Measure{Time: measure.Time, Value: float32(ema)})
+	}
+	return averages, nil
+}
+
+// s, or a sorted copy of s if it isn't
+func sorted(s Span) Span {
+	if sort.IsSorted(s) {
+		return s
+	}
+	s = append(Span{}, s...)
+	sort.Sort(s)
+	return s
+}
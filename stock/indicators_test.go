@@ -0,0 +1,101 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package stock_test
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/jhurwich/trendy/stock"
+	"github.com/jhurwich/trendy/testhelpers"
+)
+
+func TestIndicators(t *testing.T) {
+	t.Parallel()
+	day := func(d int) time.Time { return time.Date(2015, time.June, d, 0, 0, 0, 0, time.UTC) }
+	span := stock.Span{}
+	for i, value := range []float32{1, 2, 3, 4, 5, 6} {
+		span = append(span, stock.Measure{Time: day(i + 1), Value: value})
+	}
+
+	var tests = []struct {
+		name      string
+		indicator func(int) (stock.Span, error)
+		window    int
+		times     []int
+		values    []float32
+	}{
+		{"sma 3", span.SMA, 3, []int{3, 4, 5, 6}, []float32{2, 3, 4, 5}},
+		{"sma 1", span.SMA, 1, []int{1, 2, 3, 4, 5, 6}, []float32{1, 2, 3, 4, 5, 6}},
+		{"sma longer than span", span.SMA, 7, []int{}, []float32{}},
+		{"ema 3", span.EMA, 3, []int{3, 4, 5, 6}, []float32{2, 3, 4, 5}},
+		{"ema longer than span", span.EMA, 7, []int{}, []float32{}},
+	}
+	for _, test := range tests {
+		averages, err := test.indicator(test.window)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+		if len(averages) != len(test.values) {
+			t.Errorf("%s: expected %d values, got %v", test.name, len(test.values), averages)
+			continue
+		}
+		for i, measure := range averages {
+			if !measure.Time.Equal(day(test.times[i])) || math.Abs(float64(measure.Value-test.values[i])) > 1e-5 {
+				t.Errorf("%s: expected %v on day %d, got %+v", test.name, test.values[i], test.times[i], measure)
+			}
+		}
+	}
+
+	// the ema weights recent values more than the sma
+	jump := append(stock.Span{}, span...)
+	jump = append(jump, stock.Measure{Time: day(7), Value: 20})
+	sma, _ := jump.SMA(3)
+	ema, _ := jump.EMA(3)
+	if last := len(sma) - 1; ema[last].Value <= sma[last].Value {
+		t.Errorf("Expected the ema to follow a jump more closely, sma %v ema %v", sma[last].Value, ema[last].Value)
+	}
+
+	if _, err := span.SMA(0); err != stock.ErrWindow {
+		t.Errorf("Expected ErrWindow for a window of 0, got %v", err)
+	}
+	if _, err := span.EMA(-1); err != stock.ErrWindow {
+		t.Errorf("Expected ErrWindow for a negative window, got %v", err)
+	}
+}
+
+func TestStockIndicator(t *testing.T) {
+	_, discard := testhelpers.UseStore(t)
+	defer discard()
+	defaultProvider := stock.DefaultProvider
+	stock.DefaultProvider = stock.SyntheticProvider{}
+	defer func() { stock.DefaultProvider = defaultProvider }()
+
+	start, end := time.Date(2015, time.June, 1, 0, 0, 0, 0, time.UTC), time.Date(2015, time.June, 30, 0, 0, 0, 0, time.UTC)
+	s := stock.NewStock("INDC")
+	span, err := s.Range(start, end)
+	if err != nil {
+		t.Fatalf("Unexpected error getting range: %v", err)
+	}
+	// the window before start is fetched, even with the range already stored
+	for _, kind := range []string{stock.IndicatorSMA, stock.IndicatorEMA} {
+		points, err := s.IndicatorContext(context.Background(), kind, 20, start, end)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", kind, err)
+			continue
+		}
+		if len(points) != len(span) || !points[0].Time.Equal(span[0].Time) {
+			t.Errorf("%s: expected a point for each of %d measures, got %d", kind, len(span), len(points))
+		}
+	}
+
+	if _, err := s.Indicator("RSI", 20, start, end); err == nil {
+		t.Errorf("Expected an error for an unknown indicator")
+	}
+	if _, err := s.Indicator(stock.IndicatorSMA, 0, start, end); err != stock.ErrWindow {
+		t.Errorf("Expected ErrWindow for a window of 0, got %v", err)
+	}
+}
@@ -0,0 +1,205 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package stock
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// scopes that can be granted to an APIKey
+const (
+	ScopeRead    = "read"    // access stock data
+	ScopeAdmin   = "admin"   // manage api keys
+	ScopeMetrics = "metrics" // scrape prometheus metrics
+)
+
+var (
+	ErrKeyNotFound = errors.New("API key not found")
+	ErrKeyRevoked  = errors.New("API key revoked")
+	ErrKeyExpired  = errors.New("API key expired")
+	ErrBadSecret   = errors.New("API secret does not match")
+)
+
+// APIKey is a credential sent as X-Auth-Key and X-Auth-Secret. Only a hash of
+// the secret is stored, the secret itself is shown once when generated.
+type APIKey struct {
+	Key        string
+	SecretHash string `json:"-"`
+	Name       string
+	Scopes     pq.StringArray
+	Created    time.Time
+	Expires    *time.Time // nil never expires
+	Revoked    *time.Time // nil is active
+
+	// limits for this key, zero uses the server's defaults
+	RateLimit    float64 // requests per second
+	Burst        int     // requests allowed at once
+	DailyQuota   int     // requests per UTC day
+	MonthlyQuota int     // requests per UTC month
+}
+
+// NewAPIKey generates a key and secret for name with scopes, it is not stored
+func NewAPIKey(name string, scopes []string, expires *time.Time) (*APIKey, string, error) {
+	key, err := randomString(12)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := randomString(32)
+	if err != nil {
+		return nil, "", err
+	}
+	return &APIKey{
+		Key:        key,
+		SecretHash: HashSecret(secret),
+		Name:       name,
+		Scopes:     scopes,
+		Created:    time.Now().UTC(),
+		Expires:    expires,
+	}, secret, nil
+}
+
+// Rotate replaces the key's secret, returning the new secret
+func (k *APIKey) Rotate() (string, error) {
+	secret, err := randomString(32)
+	if err != nil {
+		return "", err
+	}
+	k.SecretHash = HashSecret(secret)
+	return secret, nil
+}
+
+// HashSecret is the stored form of a secret. Secrets are long and random so a
+// fast hash is enough, there's nothing to gain from a password hash here.
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Check that secret belongs to the key and that the key is usable at now
+func (k *APIKey) Check(secret string, now time.Time) error {
+	if subtle.ConstantTimeCompare([]byte(HashSecret(secret)), []byte(k.SecretHash)) != 1 {
+		return ErrBadSecret
+	}
+	if k.Revoked != nil && !now.Before(*k.Revoked) {
+		return ErrKeyRevoked
+	}
+	if k.Expires != nil && !now.Before(*k.Expires) {
+		return ErrKeyExpired
+	}
+	return nil
+}
+
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+const insertAPIKeysSchema string = `INSERT INTO APIKeys (Key, SecretHash, Name, Scopes, Created, Expires, Revoked, RateLimit, Burst, DailyQuota, MonthlyQuota) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
+const selectAPIKeySchema string = `SELECT Key, SecretHash, Name, Scopes, Created, Expires, Revoked, RateLimit, Burst, DailyQuota, MonthlyQuota FROM APIKeys WHERE Key = $1`
+const selectAPIKeysSchema string = `SELECT Key, SecretHash, Name, Scopes, Created, Expires, Revoked, RateLimit, Burst, DailyQuota, MonthlyQuota FROM APIKeys ORDER BY Created`
+const updateAPIKeySecretSchema string = `UPDATE APIKeys SET SecretHash = $2 WHERE Key = $1`
+const updateAPIKeyLimitsSchema string = `UPDATE APIKeys SET RateLimit = $2, Burst = $3, DailyQuota = $4, MonthlyQuota = $5 WHERE Key = $1`
+const updateAPIKeyRevokedSchema string = `UPDATE APIKeys SET Revoked = $2 WHERE Key = $1 AND Revoked IS NULL`
+
+func (db *StockDB) InsertAPIKey(k *APIKey) (err error) {
+	defer observeQuery("insert_api_key", time.Now(), &err)
+	_, err = db.Exec(insertAPIKeysSchema, k.Key, k.SecretHash, k.Name, k.Scopes, k.Created, k.Expires, k.Revoked, k.RateLimit, k.Burst, k.DailyQuota, k.MonthlyQuota)
+	return err
+}
+
+// GetAPIKey returns ErrKeyNotFound if there is no such key
+func (db *StockDB) GetAPIKey(key string) (k *APIKey, err error) {
+	defer observeQuery("get_api_key", time.Now(), &err)
+	k = new(APIKey)
+	err = db.Get(k, selectAPIKeySchema, key)
+	if err == sql.ErrNoRows {
+		return nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// GetAPIKeys returns every key, including revoked and expired ones, oldest first
+func (db *StockDB) GetAPIKeys() (keys []APIKey, err error) {
+	defer observeQuery("get_api_keys", time.Now(), &err)
+	keys = []APIKey{}
+	err = db.Select(&keys, selectAPIKeysSchema)
+	return keys, err
+}
+
+// UpdateAPIKeySecret stores a rotated secret for the key
+func (db *StockDB) UpdateAPIKeySecret(k *APIKey) (err error) {
+	defer observeQuery("update_api_key_secret", time.Now(), &err)
+	result, err := db.Exec(updateAPIKeySecretSchema, k.Key, k.SecretHash)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return ErrKeyNotFound
+	}
+	return nil
+}
+
+// UpdateAPIKeyLimits stores the key's rate limit, burst and quotas
+func (db *StockDB) UpdateAPIKeyLimits(k *APIKey) (err error) {
+	defer observeQuery("update_api_key_limits", time.Now(), &err)
+	result, err := db.Exec(updateAPIKeyLimitsSchema, k.Key, k.RateLimit, k.Burst, k.DailyQuota, k.MonthlyQuota)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return ErrKeyNotFound
+	}
+	return nil
+}
+
+// RevokeAPIKey revokes the key as of at, revoking twice keeps the first time
+func (db *StockDB) RevokeAPIKey(key string, at time.Time) (err error) {
+	defer observeQuery("revoke_api_key", time.Now(), &err)
+	_, err = db.GetAPIKey(key)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(updateAPIKeyRevokedSchema, key, at)
+	return err
+}
+
+const selectUsageSchema string = `SELECT COALESCE((SELECT Count FROM Usage WHERE Key = $1 AND Period = $2), 0)`
+const incrementUsageSchema string = `INSERT INTO Usage VALUES ($1, $2, $3) ON CONFLICT (Key, Period) DO UPDATE SET Count = Usage.Count + EXCLUDED.Count RETURNING Count`
+
+// GetUsage returns the requests counted for key in period, zero if none
+func (db *StockDB) GetUsage(key string, period string) (count int, err error) {
+	defer observeQuery("get_usage", time.Now(), &err)
+	err = db.QueryRowx(selectUsageSchema, key, period).Scan(&count)
+	return count, err
+}
+
+// IncrementUsage counts n requests by key in period, returning the new count
+func (db *StockDB) IncrementUsage(key string, period string, n int) (count int, err error) {
+	defer observeQuery("increment_usage", time.Now(), &err)
+	err = db.QueryRowx(incrementUsageSchema, key, period, n).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,62 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package stock_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jhurwich/trendy/stock"
+)
+
+func TestAPIKeyCheck(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2015, time.June, 1, 12, 0, 0, 0, time.UTC)
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+
+	apiKey, secret, err := stock.NewAPIKey("test", []string{stock.ScopeRead}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if apiKey.Key == "" || secret == "" || apiKey.SecretHash == secret {
+		t.Fatalf("Expected generated key and secret with only the hash stored: %+v", apiKey)
+	}
+	if !apiKey.HasScope(stock.ScopeRead) || apiKey.HasScope(stock.ScopeAdmin) {
+		t.Errorf("Unexpected scopes for key: %v", apiKey.Scopes)
+	}
+
+	var tests = []struct {
+		secret   string
+		expires  *time.Time
+		revoked  *time.Time
+		expected error
+	}{
+		{secret, nil, nil, nil},
+		{"wrong", nil, nil, stock.ErrBadSecret},
+		{"", nil, nil, stock.ErrBadSecret},
+		{secret, &future, nil, nil},
+		{secret, &past, nil, stock.ErrKeyExpired},
+		{secret, nil, &future, nil},
+		{secret, nil, &past, stock.ErrKeyRevoked},
+		{"wrong", nil, &past, stock.ErrBadSecret}, // don't reveal state without the secret
+	}
+	for _, test := range tests {
+		apiKey.Expires, apiKey.Revoked = test.expires, test.revoked
+		if err := apiKey.Check(test.secret, now); err != test.expected {
+			t.Errorf("Check expected %v got %v for %+v", test.expected, err, test)
+		}
+	}
+
+	// rotating invalidates the old secret
+	apiKey.Expires, apiKey.Revoked = nil, nil
+	newSecret, err := apiKey.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := apiKey.Check(secret, now); err != stock.ErrBadSecret {
+		t.Errorf("Expected old secret to be rejected after rotation, got %v", err)
+	}
+	if err := apiKey.Check(newSecret, now); err != nil {
+		t.Errorf("Expected new secret to be accepted after rotation, got %v", err)
+	}
+}
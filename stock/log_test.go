@@ -0,0 +1,33 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package stock_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/jhurwich/trendy/stock"
+)
+
+func TestContextHandler(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	logger := slog.New(stock.NewContextHandler(slog.NewTextHandler(&buf, nil))).With("symbol", "GOOG")
+
+	logger.InfoContext(stock.WithRequestID(context.Background(), "abc"), "with")
+	logger.InfoContext(context.Background(), "without")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 records, got %q", buf.String())
+	}
+	if !strings.Contains(lines[0], "request_id=abc") || !strings.Contains(lines[0], "symbol=GOOG") {
+		t.Errorf("Expected the request id and attributes, got %s", lines[0])
+	}
+	if strings.Contains(lines[1], "request_id") {
+		t.Errorf("Expected no request id without one in the context, got %s", lines[1])
+	}
+}
@@ -4,9 +4,11 @@ package stock
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"strings"
 	"time"
@@ -14,6 +16,37 @@ import (
 
 const markitChartAPIURL string = "http://dev.markitondemand.com/Api/v2/InteractiveChart/json"
 
+// ErrNoData is returned when Markit has no measures for the requested range
+var ErrNoData = errors.New("No data")
+
+// MarkitProvider fetches measures with the chart api, from Url if set
+type MarkitProvider struct {
+	Url string
+}
+
+func (p MarkitProvider) Fetch(s *Stock, startDate time.Time, endDate time.Time) (Span, error) {
+	return p.FetchContext(context.Background(), s, startDate, endDate)
+}
+
+func (p MarkitProvider) FetchContext(ctx context.Context, s *Stock, startDate time.Time, endDate time.Time) (Span, error) {
+	request, err := NewMarkitChartAPIRequest(s, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+	if p.Url != "" {
+		request.Url = p.Url
+	}
+
+	response, err := request.RequestContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if currency := response.GetCurrency(); currency != "" {
+		s.Currency = currency
+	}
+	return response.GetSpan(), nil
+}
+
 // Constructor for MarkitChartAPIRequests
 func NewMarkitChartAPIRequest(s *Stock, start time.Time, end time.Time) (*MarkitChartAPIRequest, error) {
 	loc, err := time.LoadLocation("UTC")
@@ -49,20 +82,39 @@ func NewMarkitChartAPIRequest(s *Stock, start time.Time, end time.Time) (*Markit
 }
 
 func (request *MarkitChartAPIRequest) Request() (*MarkitChartAPIResponse, error) {
-	r, err := http.Get(request.Url)
-	if err != nil {
-		return nil, err
+	return request.RequestContext(context.Background())
+}
+
+// RequestContext is Request for ctx, it's abandoned when ctx is canceled or
+// its deadline passes
+func (request *MarkitChartAPIRequest) RequestContext(ctx context.Context) (*MarkitChartAPIResponse, error) {
+	start := time.Now()
+	response, errorType, err := request.request(ctx)
+	observeProvider("markit", start, errorType)
+	return response, err
+}
+
+// make the request, returning the type of error for metrics along with it
+func (request *MarkitChartAPIRequest) request(ctx context.Context) (*MarkitChartAPIResponse, string, error) {
+	r, err := MarkitClient.GetContext(ctx, request.Url)
+	if err == ErrCircuitOpen {
+		return nil, errorCircuitOpen, err
+	} else if err == context.Canceled {
+		return nil, errorCanceled, err
+	} else if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return nil, errorTimeout, err
+	} else if err != nil {
+		return nil, errorNetwork, err
 	}
 	defer r.Body.Close()
 	if r.StatusCode != http.StatusOK {
-		return nil, errors.New(r.Status)
+		return nil, errorStatus, errors.New(r.Status)
 	}
 
 	response := new(MarkitChartAPIResponse)
 	err = json.NewDecoder(r.Body).Decode(response)
 	if err != nil {
-		fmt.Printf("Could not decode response from Markit to JSON: %v", err)
-		return nil, err
+		return nil, errorDecode, fmt.Errorf("Could not decode response from Markit to JSON: %w", err)
 	}
 
 	// return any error that might have been provided by Markit in the response
@@ -76,14 +128,42 @@ func (request *MarkitChartAPIRequest) Request() (*MarkitChartAPIResponse, error)
 			response.Details = strings.Join([]string{`"`, response.Details, `"`}, "")
 			str = strings.Join([]string{str, response.Details}, " - ")
 		}
-		return nil, errors.New(str)
+		return nil, errorException, errors.New(str)
 	}
 
 	if response.Positions == nil {
-		return nil, errors.New("No data")
+		return nil, errorNoData, ErrNoData
+	}
+
+	if err := response.validate(); err != nil {
+		return nil, errorMalformed, err
 	}
 
-	return response, nil
+	return response, "", nil
+}
+
+// validate that every series in the response has a value for each date
+func (response *MarkitChartAPIResponse) validate() error {
+	malformed := func(what string, n int) error {
+		return fmt.Errorf("Malformed response from MarkitChartAPI: %d %s for %d dates", n, what, len(response.Dates))
+	}
+	if len(response.Positions) != len(response.Dates) {
+		return malformed("positions", len(response.Positions))
+	}
+	for _, elem := range response.Elements {
+		if elem.Dataseries == nil {
+			return malformed(elem.Type+" series", 0)
+		}
+		for _, data := range []*Data{elem.Dataseries.Open, elem.Dataseries.High, elem.Dataseries.Low, elem.Dataseries.Close} {
+			if data != nil && len(data.Values) != len(response.Dates) {
+				return malformed(elem.Type+" values", len(data.Values))
+			}
+		}
+		if volume := elem.Dataseries.Volume; volume != nil && len(volume.Values) != len(response.Dates) {
+			return malformed(elem.Type+" values", len(volume.Values))
+		}
+	}
+	return nil
 }
 
 // Markit API request format and supporting structs
@@ -110,11 +190,11 @@ type Element struct {
 	Dataseries *Dataseries `json:"DataSeries,omitempty"`
 }
 type Dataseries struct {
-	Open   *Data `json:"open,omitempty"`
-	High   *Data `json:"high,omitempty"`
-	Low    *Data `json:"low,omitempty"`
-	Close  *Data `json:"close,omitempty"`
-	Volume *Data `json:"volume,omitempty"`
+	Open   *Data       `json:"open,omitempty"`
+	High   *Data       `json:"high,omitempty"`
+	Low    *Data       `json:"low,omitempty"`
+	Close  *Data       `json:"close,omitempty"`
+	Volume *VolumeData `json:"volume,omitempty"`
 }
 type Data struct {
 	Min     float32   `json:"min,omitempty"`
@@ -124,6 +204,16 @@ type Data struct {
 	Values  []float32 `json:"values,omitempty"`
 }
 
+// VolumeData is Data for the volume series, as whole shares since float32
+// loses the last digits of volumes above 2^24
+type VolumeData struct {
+	Min     int64    `json:"min,omitempty"`
+	Max     int64    `json:"max,omitempty"`
+	MaxDate *ISOTime `json:"maxDate,omitempty"`
+	MinDate *ISOTime `json:"minDate,omitempty"`
+	Values  []int64  `json:"values,omitempty"`
+}
+
 func (ds *Dataseries) String() string {
 	str := "{"
 	if ds.Open != nil {
@@ -178,6 +268,33 @@ func (d *Data) String() string {
 	return fmt.Sprintf(`%s}`, str)
 }
 
+func (d *VolumeData) String() string {
+	str := "{"
+	if d.Max != 0 {
+		str = fmt.Sprintf(`%smax:%d, `, str, d.Max)
+	}
+	if d.Min != 0 {
+		str = fmt.Sprintf(`%smin:%d, `, str, d.Min)
+	}
+	if d.MaxDate != nil {
+		str = fmt.Sprintf(`%smaxDate:%s, `, str, d.MaxDate)
+	}
+	if d.MinDate != nil {
+		str = fmt.Sprintf(`%sminDate:%s, `, str, d.MinDate)
+	}
+	if d.Values != nil {
+		str = fmt.Sprintf(`%svalues:%v, `, str, d.Values)
+	}
+
+	// return empty string if no data has been added
+	if len(str) <= 1 {
+		return ""
+	}
+	str = str[:len(str)-2] // remove trailing comma and space
+
+	return fmt.Sprintf(`%s}`, str)
+}
+
 // Markit API response format
 type MarkitChartAPIResponse struct {
 	Labels         *MarkitChartAPIResponseLabels
@@ -204,47 +321,89 @@ const (
 	High
 	Low
 	Close
-	// Volume
+	Volume
 )
 
+// GetCurrency returns the currency the response's prices are in, empty if it doesn't say
+func (response *MarkitChartAPIResponse) GetCurrency() string {
+	for _, elem := range response.Elements {
+		if elem.Type == "price" && elem.Currency != "" {
+			return strings.ToUpper(elem.Currency)
+		}
+	}
+	return ""
+}
+
+// GetSpan returns the daily bars, the closes with their open, high, low and volume
 func (response *MarkitChartAPIResponse) GetSpan() Span {
-	def := Close
-	return response.GetSpanForDataType(def)
+	span := response.GetSpanForDataType(Close)
+	fields := map[DataType]func(m *Measure, from Measure){
+		Open:   func(m *Measure, from Measure) { m.Open = from.Value },
+		High:   func(m *Measure, from Measure) { m.High = from.Value },
+		Low:    func(m *Measure, from Measure) { m.Low = from.Value },
+		Volume: func(m *Measure, from Measure) { m.Volume = from.Volume },
+	}
+	for dt, set := range fields {
+		// each series is indexed by the response's dates, as the closes are
+		for i, m := range response.GetSpanForDataType(dt) {
+			if i < len(span) {
+				set(&span[i], m)
+			}
+		}
+	}
+	return span
 }
 
+// GetSpanForDataType returns one series as a span, each measure's Value from
+// it. The Volume series also sets each measure's Volume, which is exact where
+// Value may not be.
 func (response *MarkitChartAPIResponse) GetSpanForDataType(dt DataType) Span {
-	foundPrice := false
-	var priceElem Element
-	for _, elem := range response.Elements {
-		// we only care about the price element
-		if elem.Type != "price" {
-			continue
+	// volume has its own element, the rest are in the price element
+	elemType := "price"
+	if dt == Volume {
+		elemType = "volume"
+	}
+	var found *Element
+	for i, elem := range response.Elements {
+		if elem.Type == elemType {
+			found = &response.Elements[i]
 		}
-		priceElem = elem
-		foundPrice = true
 	}
 
-	if !foundPrice {
-		// price element not found, return empty span
+	if found == nil || found.Dataseries == nil {
+		// element not found, return empty span
 		return Span{}
 	}
 
+	span := Span{}
+	if dt == Volume {
+		volume := found.Dataseries.Volume
+		if volume == nil {
+			return span
+		}
+		for i := 0; i < len(volume.Values) && i < len(response.Dates); i++ {
+			span = append(span, Measure{Time: response.Dates[i].UTC(), Value: float32(volume.Values[i]), Volume: volume.Values[i]})
+		}
+		return span
+	}
+
 	var data *Data
 	switch dt {
 	case Open:
-		data = priceElem.Dataseries.Open
+		data = found.Dataseries.Open
 	case High:
-		data = priceElem.Dataseries.High
+		data = found.Dataseries.High
 	case Low:
-		data = priceElem.Dataseries.Low
+		data = found.Dataseries.Low
 	case Close:
-		data = priceElem.Dataseries.Close
-		// case Volume:
-		// 	data := priceElem.Dataseries.Volume // I'm not sure this one works, I think we need the elem.Type = "volume"
+		data = found.Dataseries.Close
 	}
 
-	span := Span{}
-	for i := 0; i < len(data.Values); i++ {
+	if data == nil {
+		return span
+	}
+	// responses are validated by Request, but never index past the shorter of the two
+	for i := 0; i < len(data.Values) && i < len(response.Dates); i++ {
 		value := data.Values[i]
 		time := response.Dates[i].UTC() // not sure this is kosher, but it converts to time.Time type...
 		m := Measure{Time: time, Value: value}
@@ -287,7 +446,7 @@ func (it *ISOTime) UnmarshalJSON(data []byte) error {
 	}
 
 	// "-00" is often truncated by Markit. Make sure it's added if not present
-	if string(s[len(s)-3]) != "-" {
+	if len(s) < 3 || string(s[len(s)-3]) != "-" {
 		s = fmt.Sprintf("%s-00", s)
 	}
 
@@ -3,6 +3,7 @@
 package stock_test
 
 import (
+	"encoding/json"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -112,6 +113,9 @@ func TestMarkitChartAPIIntegration(t *testing.T) {
 			if !CompareMarkitChartAPIResponses(&test.ExpectedMarkitResponse, response) {
 				t.Errorf("Response from Markit differs from expected, expected:\n%v\ngot:\n%v\n", test.ExpectedMarkitResponse, response)
 			}
+			if currency := response.GetCurrency(); currency != "USD" {
+				t.Errorf("Expected the response's prices in USD, got %q", currency)
+			}
 		}
 
 		t.Logf("Test complete: %s, %s to %s", test.Sym, test.StartDate.String(), test.EndDate.String())
@@ -138,3 +142,141 @@ func CompareMarkitChartAPIResponses(r *stock.MarkitChartAPIResponse, l *stock.Ma
 	}
 	return false
 }
+
+// replay saved Markit exchanges through the real http client
+func TestMarkitChartAPICassette(t *testing.T) {
+	defer testhelpers.UseCassette("markit", t)()
+
+	for _, test := range testhelpers.MarkitTestData {
+		request, err := stock.NewMarkitChartAPIRequest(stock.NewStock(test.Sym), test.StartDate, test.EndDate)
+		if err != nil {
+			t.Errorf("Could not create a MarkitChartAPIRequest: %v", err)
+		}
+
+		response, err := request.Request()
+		if test.ExpectError {
+			if err == nil {
+				t.Errorf("Expected error response but got success: %v", response)
+			}
+		} else if err != nil {
+			t.Errorf("Expected successful response but got error: %v", err)
+		} else if !CompareMarkitChartAPIResponses(&test.ExpectedMarkitResponse, response) {
+			t.Errorf("Response from cassette differs from expected, expected:\n%v\ngot:\n%v\n", test.ExpectedMarkitResponse, response)
+		}
+	}
+
+	// when replaying, anything not on the cassette is an error rather than a network request
+	if testhelpers.CassetteModeFromEnv() != testhelpers.Replay {
+		return
+	}
+	request, _ := stock.NewMarkitChartAPIRequest(stock.NewStock("NOTRECORDED"), testhelpers.MarkitTestData[0].StartDate, testhelpers.MarkitTestData[0].EndDate)
+	if _, err := request.Request(); err == nil || !strings.Contains(err.Error(), "No interaction in cassette") {
+		t.Errorf("Expected missing interaction error, got %v", err)
+	}
+}
+
+// every upstream failure we've seen should be an error, never a panic or bad data
+func TestMarkitChartAPIFaults(t *testing.T) {
+	testdata := testhelpers.MarkitTestData
+	for _, test := range testdata {
+		if test.ExpectError {
+			continue // already an error without faults
+		}
+		request, err := stock.NewMarkitChartAPIRequest(stock.NewStock(test.Sym), test.StartDate, test.EndDate)
+		if err != nil {
+			t.Errorf("Could not create a MarkitChartAPIRequest: %v", err)
+		}
+		actualUrl := request.Url
+
+		tsParams := testhelpers.TestServer{Status: http.StatusOK, RequestUrl: actualUrl, TestData: testdata, T: t}
+		ts := httptest.NewServer(&tsParams)
+		request.Url = ts.URL
+
+		for _, fault := range testhelpers.Faults {
+			tsParams = testhelpers.TestServer{Status: http.StatusOK, RequestUrl: actualUrl, TestData: testdata, T: t, Fault: fault}
+			response, err := request.Request()
+			if err == nil {
+				t.Errorf("Expected an error for %s but got success, response:\n%v", fault, response)
+			}
+		}
+		ts.Close()
+	}
+}
+
+func TestMarkitChartAPILatency(t *testing.T) {
+	test := testhelpers.MarkitTestData[0]
+	request, err := stock.NewMarkitChartAPIRequest(stock.NewStock(test.Sym), test.StartDate, test.EndDate)
+	if err != nil {
+		t.Errorf("Could not create a MarkitChartAPIRequest: %v", err)
+	}
+	tsParams := testhelpers.TestServer{Status: http.StatusOK, RequestUrl: request.Url, TestData: testhelpers.MarkitTestData, T: t, Latency: 200 * time.Millisecond}
+	ts := httptest.NewServer(&tsParams)
+	defer ts.Close()
+	request.Url = ts.URL
+
+	// a slow provider within the timeout is fine
+	if _, err := request.Request(); err != nil {
+		t.Errorf("Expected success from a slow provider, got %v", err)
+	}
+
+	// one beyond the timeout is an error
+	client := stock.MarkitClient.HTTPClient
+	timeout, retries := client.Timeout, stock.MarkitClient.MaxRetries
+	client.Timeout, stock.MarkitClient.MaxRetries = 50*time.Millisecond, 0
+	defer func() { client.Timeout, stock.MarkitClient.MaxRetries = timeout, retries }()
+	if _, err := request.Request(); err == nil {
+		t.Errorf("Expected timeout error from a provider slower than the client timeout")
+	}
+	stock.MarkitClient.Breaker.Success() // don't leave a failure counted against other tests
+}
+
+// GetSpanForDataType is given unvalidated responses too, it must not panic on them
+func TestGetSpanForDataTypeMalformed(t *testing.T) {
+	t.Parallel()
+	dates := []stock.ISOTime{{testSpan1[0].Time}, {testSpan1[1].Time}}
+	var tests = []stock.MarkitChartAPIResponse{
+		{},
+		{Dates: dates, Elements: []stock.Element{{Type: "price"}}},
+		{Dates: dates, Elements: []stock.Element{{Type: "price", Dataseries: &stock.Dataseries{}}}},
+		{Dates: dates, Elements: []stock.Element{{Type: "price", Dataseries: &stock.Dataseries{Close: &stock.Data{Values: []float32{1, 2, 3}}}}}},
+		{Dates: dates[:1], Elements: []stock.Element{{Type: "price", Dataseries: &stock.Dataseries{Close: &stock.Data{Values: []float32{1, 2}}}}}},
+	}
+	for _, response := range tests {
+		span := response.GetSpanForDataType(stock.Close)
+		if len(span) > len(response.Dates) {
+			t.Errorf("Span longer than the response's dates: %+v", span)
+		}
+	}
+}
+
+// volumes are decoded whole, above float32's 2^24 as well
+func TestGetSpanBars(t *testing.T) {
+	t.Parallel()
+	for _, test := range testhelpers.MarkitTestData {
+		if test.Sym != "MSFT" {
+			continue
+		}
+		var response stock.MarkitChartAPIResponse
+		if err := json.Unmarshal([]byte(test.ExpectedResponseBody), &response); err != nil {
+			t.Fatal(err)
+		}
+		for _, measure := range response.GetSpan() {
+			if !measure.HasBar() || measure.Volume <= 0 {
+				t.Errorf("Expected a bar with volume, got %+v", measure)
+			}
+			if stock.TimeForSQL(measure.Time) == "2012-01-20" && measure.Volume != 165902897 {
+				t.Errorf("Expected a volume of 165902897 on 2012-01-20, got %d", measure.Volume)
+			}
+		}
+	}
+}
+
+func TestISOTimeUnmarshalMalformed(t *testing.T) {
+	t.Parallel()
+	for _, str := range []string{`""`, `"1"`, `"20110520"`, `"2011-13-45T00:00:00"`, `5`} {
+		var it stock.ISOTime
+		if err := it.UnmarshalJSON([]byte(str)); err == nil {
+			t.Errorf("Expected error unmarshaling %s as ISOTime, got %s", str, it)
+		}
+	}
+}
@@ -0,0 +1,318 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package stock
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a Store kept in process, for tests and demos that shouldn't
+// need Postgres. It behaves as StockDB does, measures are kept by date and
+// returned at midnight UTC as a Postgres date column would be, and a done
+// context is an error.
+type MemoryStore struct {
+	mu            sync.Mutex
+	measures      map[string]map[time.Time]Measure // symbol to date to measure
+	modifications map[string]time.Time
+	jobs          []Job
+	checkpoints   map[string]map[string]Checkpoint // backfill to symbol and chunk start to checkpoint
+	keys          map[string]APIKey
+	usage         map[string]int // key and period to count
+	listings      map[string]Listing
+	changes       map[string]SymbolChange // old symbol and date to change
+	currencies    map[string]string
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		measures:      map[string]map[time.Time]Measure{},
+		modifications: map[string]time.Time{},
+		checkpoints:   map[string]map[string]Checkpoint{},
+		keys:          map[string]APIKey{},
+		usage:         map[string]int{},
+		listings:      map[string]Listing{},
+		changes:       map[string]SymbolChange{},
+		currencies:    map[string]string{},
+	}
+}
+
+// the date of t as Postgres stores it
+func dateOf(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func (m *MemoryStore) Insert(stock *Stock, span *Span) error {
+	return m.InsertContext(context.Background(), stock, span)
+}
+
+func (m *MemoryStore) InsertContext(ctx context.Context, stock *Stock, span *Span) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(*span) == 0 {
+		return nil
+	}
+	if m.measures[stock.Symbol] == nil {
+		m.measures[stock.Symbol] = map[time.Time]Measure{}
+	}
+	changed := false
+	for _, measure := range *span {
+		measure.Time = dateOf(measure.Time)
+		if stored, ok := m.measures[stock.Symbol][measure.Time]; !ok || stored != measure {
+			m.measures[stock.Symbol][measure.Time] = measure
+			changed = true
+		}
+	}
+	if changed {
+		m.modifications[stock.Symbol] = time.Now().UTC()
+	}
+	return nil
+}
+
+// startDate and endDate inclusive
+func (m *MemoryStore) GetRange(stock *Stock, startDate time.Time, endDate time.Time) (Span, error) {
+	return m.GetRangeContext(context.Background(), stock, startDate, endDate)
+}
+
+func (m *MemoryStore) GetRangeContext(ctx context.Context, stock *Stock, startDate time.Time, endDate time.Time) (Span, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	start, end := dateOf(startDate), dateOf(endDate)
+	span := Span{}
+	for date, measure := range m.measures[stock.Symbol] {
+		if !date.Before(start) && !date.After(end) {
+			span = append(span, measure)
+		}
+	}
+	sort.Sort(span)
+	return span, nil
+}
+
+func (m *MemoryStore) LastModified(stock *Stock) (time.Time, error) {
+	return m.LastModifiedContext(context.Background(), stock)
+}
+
+func (m *MemoryStore) LastModifiedContext(ctx context.Context, stock *Stock) (time.Time, error) {
+	if err := ctx.Err(); err != nil {
+		return time.Time{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.modifications[stock.Symbol], nil
+}
+
+func (m *MemoryStore) InsertJob(job *Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job.ID = len(m.jobs) + 1
+	stored := *job
+	stored.Day = dateOf(job.Day)
+	m.jobs = append(m.jobs, stored)
+	return nil
+}
+
+func (m *MemoryStore) GetJobs(limit int) ([]Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	jobs := []Job{}
+	for i := len(m.jobs) - 1; i >= 0 && len(jobs) < limit; i-- {
+		jobs = append(jobs, m.jobs[i])
+	}
+	return jobs, nil
+}
+
+func (m *MemoryStore) InsertCheckpoint(cp *Checkpoint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.checkpoints[cp.Backfill] == nil {
+		m.checkpoints[cp.Backfill] = map[string]Checkpoint{}
+	}
+	stored := *cp
+	stored.ChunkStart, stored.ChunkEnd = dateOf(cp.ChunkStart), dateOf(cp.ChunkEnd)
+	m.checkpoints[cp.Backfill][cp.Symbol+"/"+TimeForSQL(cp.ChunkStart)] = stored
+	return nil
+}
+
+func (m *MemoryStore) GetCheckpoints(backfill string) ([]Checkpoint, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	checkpoints := []Checkpoint{}
+	for _, cp := range m.checkpoints[backfill] {
+		checkpoints = append(checkpoints, cp)
+	}
+	return checkpoints, nil
+}
+
+func (m *MemoryStore) InsertAPIKey(k *APIKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys[k.Key] = *k
+	return nil
+}
+
+// GetAPIKey returns ErrKeyNotFound if there is no such key
+func (m *MemoryStore) GetAPIKey(key string) (*APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k, ok := m.keys[key]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return &k, nil
+}
+
+// GetAPIKeys returns every key, including revoked and expired ones, oldest first
+func (m *MemoryStore) GetAPIKeys() ([]APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := []APIKey{}
+	for _, k := range m.keys {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Created.Before(keys[j].Created) })
+	return keys, nil
+}
+
+func (m *MemoryStore) UpdateAPIKeySecret(k *APIKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.keys[k.Key]
+	if !ok {
+		return ErrKeyNotFound
+	}
+	stored.SecretHash = k.SecretHash
+	m.keys[k.Key] = stored
+	return nil
+}
+
+func (m *MemoryStore) UpdateAPIKeyLimits(k *APIKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.keys[k.Key]
+	if !ok {
+		return ErrKeyNotFound
+	}
+	stored.RateLimit, stored.Burst, stored.DailyQuota, stored.MonthlyQuota = k.RateLimit, k.Burst, k.DailyQuota, k.MonthlyQuota
+	m.keys[k.Key] = stored
+	return nil
+}
+
+// RevokeAPIKey revokes the key as of at, revoking twice keeps the first time
+func (m *MemoryStore) RevokeAPIKey(key string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.keys[key]
+	if !ok {
+		return ErrKeyNotFound
+	}
+	if stored.Revoked == nil {
+		stored.Revoked = &at
+		m.keys[key] = stored
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetUsage(key string, period string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.usage[key+"/"+period], nil
+}
+
+func (m *MemoryStore) IncrementUsage(key string, period string, n int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.usage[key+"/"+period] += n
+	return m.usage[key+"/"+period], nil
+}
+
+func (m *MemoryStore) InsertListings(ctx context.Context, listings []Listing) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, listing := range listings {
+		m.listings[listing.Symbol] = listing
+	}
+	return nil
+}
+
+// GetListings returns every listing ordered by symbol
+func (m *MemoryStore) GetListings(ctx context.Context) ([]Listing, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	listings := []Listing{}
+	for _, listing := range m.listings {
+		listings = append(listings, listing)
+	}
+	sort.Slice(listings, func(i, j int) bool { return listings[i].Symbol < listings[j].Symbol })
+	return listings, nil
+}
+
+func (m *MemoryStore) InsertSymbolChanges(ctx context.Context, changes []SymbolChange) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, change := range changes {
+		change.Date = dateOf(change.Date)
+		m.changes[change.Symbol+"/"+change.Date.Format("2006-01-02")] = change
+	}
+	return nil
+}
+
+// GetSymbolChanges returns every symbol change ordered by date
+func (m *MemoryStore) GetSymbolChanges(ctx context.Context) ([]SymbolChange, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	changes := []SymbolChange{}
+	for _, change := range m.changes {
+		changes = append(changes, change)
+	}
+	sortSymbolChanges(changes)
+	return changes, nil
+}
+
+func (m *MemoryStore) SetCurrency(ctx context.Context, symbol string, currency string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.currencies[strings.ToUpper(symbol)] = currency
+	return nil
+}
+
+func (m *MemoryStore) GetCurrency(ctx context.Context, symbol string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.currencies[strings.ToUpper(symbol)], nil
+}
+
+func (m *MemoryStore) PingContext(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// MigrationVersion is always current, there's nothing to migrate
+func (m *MemoryStore) MigrationVersion() (int, error) {
+	return SchemaVersion, nil
+}
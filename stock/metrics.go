@@ -0,0 +1,79 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package stock
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metrics for the provider, the span cache and the database, registered with
+// the default prometheus registry and served by the app on /metrics
+var (
+	providerRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "trendy",
+		Subsystem: "provider",
+		Name:      "request_duration_seconds",
+		Help:      "Duration of requests to data providers, including retries, by outcome.",
+		Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"provider", "outcome"})
+
+	providerErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "trendy",
+		Subsystem: "provider",
+		Name:      "errors_total",
+		Help:      "Failed requests to data providers by type of error.",
+	}, []string{"provider", "type"})
+
+	cacheRanges = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "trendy",
+		Subsystem: "cache",
+		Name:      "ranges_total",
+		Help:      "Ranges served by where they came from: memoized, stored, refreshed, stale or fetched. Everything but fetched is a hit.",
+	}, []string{"source"})
+
+	dbQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "trendy",
+		Subsystem: "db",
+		Name:      "query_duration_seconds",
+		Help:      "Duration of database operations by operation and outcome.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"query", "outcome"})
+)
+
+func init() {
+	prometheus.MustRegister(providerRequestDuration, providerErrors, cacheRanges, dbQueryDuration)
+}
+
+// types of provider error, an empty type is success
+const (
+	errorCircuitOpen = "circuit_open"
+	errorCanceled    = "canceled"
+	errorTimeout     = "timeout"
+	errorNetwork     = "network"
+	errorStatus      = "status"
+	errorDecode      = "decode"
+	errorException   = "exception"
+	errorNoData      = "no_data"
+	errorMalformed   = "malformed"
+)
+
+func observeProvider(provider string, start time.Time, errorType string) {
+	outcome := "ok"
+	if errorType != "" {
+		outcome = "error"
+		providerErrors.WithLabelValues(provider, errorType).Inc()
+	}
+	providerRequestDuration.WithLabelValues(provider, outcome).Observe(time.Since(start).Seconds())
+}
+
+// observeQuery is deferred at the start of StockDB operations with a pointer
+// to the operation's returned error
+func observeQuery(query string, start time.Time, err *error) {
+	outcome := "ok"
+	if *err != nil {
+		outcome = "error"
+	}
+	dbQueryDuration.WithLabelValues(query, outcome).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,89 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package stock_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/jhurwich/trendy/stock"
+	"github.com/jhurwich/trendy/testhelpers"
+)
+
+func TestProviderMetrics(t *testing.T) {
+	test := testhelpers.MarkitTestData[0]
+	request, err := stock.NewMarkitChartAPIRequest(stock.NewStock(test.Sym), test.StartDate, test.EndDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a request refused by an open circuit is counted as that type of error
+	labels := map[string]string{"provider": "markit", "type": "circuit_open"}
+	before := metricValue(t, "trendy_provider_errors_total", labels)
+	breaker := stock.MarkitClient.Breaker
+	stock.MarkitClient.Breaker = &stock.Breaker{Threshold: 1, Cooldown: time.Hour}
+	stock.MarkitClient.Breaker.Failure(time.Now())
+	_, err = request.Request()
+	stock.MarkitClient.Breaker = breaker
+	if err != stock.ErrCircuitOpen {
+		t.Fatalf("Expected ErrCircuitOpen, got %v", err)
+	}
+	if after := metricValue(t, "trendy_provider_errors_total", labels); after != before+1 {
+		t.Errorf("Expected one more circuit_open error, got %v from %v", after, before)
+	}
+}
+
+func TestCacheMetrics(t *testing.T) {
+	_, discard := testhelpers.UseStore(t)
+	defer discard()
+	defaultProvider := stock.DefaultProvider
+	stock.DefaultProvider = stock.SyntheticProvider{}
+	defer func() { stock.DefaultProvider = defaultProvider }()
+
+	fetched, memoized := map[string]string{"source": "fetched"}, map[string]string{"source": "memoized"}
+	beforeFetched, beforeMemoized := metricValue(t, "trendy_cache_ranges_total", fetched), metricValue(t, "trendy_cache_ranges_total", memoized)
+
+	// a miss then a hit
+	start, end := time.Date(2015, time.June, 1, 0, 0, 0, 0, time.UTC), time.Date(2015, time.June, 30, 0, 0, 0, 0, time.UTC)
+	s := stock.NewStock("METRICS")
+	for i := 0; i < 2; i++ {
+		if _, err := s.Range(start, end); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if metricValue(t, "trendy_cache_ranges_total", fetched) != beforeFetched+1 || metricValue(t, "trendy_cache_ranges_total", memoized) != beforeMemoized+1 {
+		t.Errorf("Expected one fetched and one memoized range")
+	}
+}
+
+// the value of the counter, or the sample count of the histogram, with
+// exactly these labels in the default registry, 0 if it hasn't been observed
+func metricValue(t *testing.T, name string, labels map[string]string) float64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, metric := range family.GetMetric() {
+			if len(metric.GetLabel()) != len(labels) {
+				continue
+			}
+			for _, label := range metric.GetLabel() {
+				if labels[label.GetName()] != label.GetValue() {
+					continue metrics
+				}
+			}
+			if metric.Counter != nil {
+				return metric.Counter.GetValue()
+			}
+			return float64(metric.Histogram.GetSampleCount())
+		}
+	}
+	return 0
+}
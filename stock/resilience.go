@@ -0,0 +1,217 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package stock
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jhurwich/trendy/limit"
+)
+
+// ErrCircuitOpen is returned without contacting the provider after it has failed repeatedly
+var ErrCircuitOpen = errors.New("Provider unavailable, circuit open")
+
+// ProviderClient makes http requests to a data provider. Each request waits its
+// turn with the client side rate limiter, retries server errors and throttling
+// with exponential backoff and jitter, and is refused outright while the
+// circuit breaker is open.
+type ProviderClient struct {
+	HTTPClient  *http.Client
+	MaxRetries  int           // attempts after the first
+	BaseBackoff time.Duration // backoff before the first retry, doubled for each after
+	MaxBackoff  time.Duration
+	Limiter     *limit.Bucket
+	Breaker     *Breaker
+}
+
+// MarkitClient is used for all requests to Markit. Markit doesn't publish a
+// limit but blocks clients that exceed roughly ten requests a second. The
+// server replaces it with one built from its configuration.
+var MarkitClient = &ProviderClient{
+	HTTPClient:  &http.Client{Timeout: 10 * time.Second},
+	MaxRetries:  2,
+	BaseBackoff: 100 * time.Millisecond,
+	MaxBackoff:  5 * time.Second,
+	Limiter:     limit.NewBucket(10, 10),
+	Breaker:     &Breaker{Threshold: 5, Cooldown: 30 * time.Second},
+}
+
+// Get url from the provider. The response for the final attempt is returned
+// even if it isn't a success so the caller can report its status.
+func (c *ProviderClient) Get(url string) (*http.Response, error) {
+	return c.GetContext(context.Background(), url)
+}
+
+// GetContext is Get for ctx, canceling ctx or its deadline passing abandons
+// the request, including any wait for the rate limiter or to retry. An
+// abandoned request isn't counted against the provider by the breaker.
+func (c *ProviderClient) GetContext(ctx context.Context, url string) (*http.Response, error) {
+	if !c.Breaker.Allow(time.Now()) {
+		return nil, ErrCircuitOpen
+	}
+	request, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		c.Breaker.Abandon()
+		return nil, err
+	}
+
+	backoff := c.BaseBackoff
+	for attempt := 0; ; attempt++ {
+		if c.Limiter != nil {
+			if err := sleep(ctx, c.Limiter.Reserve(time.Now())); err != nil {
+				c.Breaker.Abandon()
+				return nil, err
+			}
+		}
+
+		r, err := c.HTTPClient.Do(request)
+		if ctx.Err() != nil {
+			if r != nil {
+				r.Body.Close()
+			}
+			c.Breaker.Abandon()
+			return nil, ctx.Err()
+		}
+		if !retryable(r, err) {
+			// the provider answered, even a client error means it's up
+			c.Breaker.Success()
+			return r, err
+		}
+		if attempt >= c.MaxRetries {
+			// a throttled client isn't a sign the provider is down
+			if err != nil || r.StatusCode != http.StatusTooManyRequests {
+				c.Breaker.Failure(time.Now())
+			}
+			return r, err
+		}
+
+		// full jitter, sleep a random time up to the backoff, unless told how long to wait
+		wait := time.Duration(rand.Int63n(int64(backoff) + 1))
+		if r != nil {
+			if retryAfter, err := strconv.Atoi(r.Header.Get("Retry-After")); err == nil {
+				wait = time.Duration(retryAfter) * time.Second
+			}
+			r.Body.Close()
+		}
+		if wait > c.MaxBackoff {
+			wait = c.MaxBackoff
+		}
+		if err := sleep(ctx, wait); err != nil {
+			c.Breaker.Abandon()
+			return nil, err
+		}
+
+		backoff *= 2
+		if backoff > c.MaxBackoff {
+			backoff = c.MaxBackoff
+		}
+	}
+}
+
+// sleep for d unless ctx is done first, then its error is returned
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// network errors, timeouts, server errors and throttling are worth retrying
+func retryable(r *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return r.StatusCode >= 500 || r.StatusCode == http.StatusTooManyRequests
+}
+
+// Breaker is a circuit breaker. After Threshold consecutive failures it opens
+// and refuses requests for Cooldown, then lets a single trial request through.
+// The trial's success closes the breaker, its failure opens it again.
+type Breaker struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	trial    bool // a half open trial request is in flight
+}
+
+// breaker states as reported by State
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// Allow reports whether a request may be made at now
+func (b *Breaker) Allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state(now) {
+	case BreakerClosed:
+		return true
+	case BreakerHalfOpen:
+		if !b.trial {
+			b.trial = true
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.openedAt = time.Time{}
+	b.trial = false
+}
+
+func (b *Breaker) Failure(now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.trial || b.failures >= b.Threshold {
+		b.openedAt = now
+	}
+	b.trial = false
+}
+
+// Abandon a request that was allowed but never answered by the provider, it
+// counts as neither a success nor a failure
+func (b *Breaker) Abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+}
+
+// State of the breaker at now, one of BreakerClosed, BreakerOpen or BreakerHalfOpen
+func (b *Breaker) State(now time.Time) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state(now)
+}
+
+func (b *Breaker) state(now time.Time) string {
+	if b.openedAt.IsZero() {
+		return BreakerClosed
+	}
+	if now.Sub(b.openedAt) < b.Cooldown {
+		return BreakerOpen
+	}
+	return BreakerHalfOpen
+}
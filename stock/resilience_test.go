@@ -0,0 +1,246 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package stock_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jhurwich/trendy/limit"
+	"github.com/jhurwich/trendy/stock"
+	"github.com/jhurwich/trendy/testhelpers"
+)
+
+func TestBreaker(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2015, time.June, 1, 12, 0, 0, 0, time.UTC)
+	b := &stock.Breaker{Threshold: 3, Cooldown: time.Minute}
+
+	// failures below the threshold keep it closed, a success resets the count
+	b.Failure(now)
+	b.Failure(now)
+	b.Success()
+	b.Failure(now)
+	b.Failure(now)
+	if state := b.State(now); state != stock.BreakerClosed || !b.Allow(now) {
+		t.Errorf("Expected closed breaker below threshold, got %s", state)
+	}
+
+	// reaching the threshold opens it until the cooldown passes
+	b.Failure(now)
+	if state := b.State(now); state != stock.BreakerOpen || b.Allow(now.Add(59*time.Second)) {
+		t.Errorf("Expected open breaker at threshold, got %s", state)
+	}
+
+	// after the cooldown only one trial is allowed, its failure reopens the breaker
+	later := now.Add(time.Minute)
+	if state := b.State(later); state != stock.BreakerHalfOpen {
+		t.Errorf("Expected half-open breaker after cooldown, got %s", state)
+	}
+	if !b.Allow(later) || b.Allow(later) {
+		t.Errorf("Expected exactly one trial request when half-open")
+	}
+	b.Failure(later)
+	if state := b.State(later); state != stock.BreakerOpen {
+		t.Errorf("Expected failed trial to reopen breaker, got %s", state)
+	}
+
+	// a successful trial closes it
+	later = later.Add(time.Minute)
+	if !b.Allow(later) {
+		t.Errorf("Expected trial request after second cooldown")
+	}
+	b.Success()
+	if state := b.State(later); state != stock.BreakerClosed || !b.Allow(later) || !b.Allow(later) {
+		t.Errorf("Expected successful trial to close breaker, got %s", state)
+	}
+}
+
+func TestProviderClient(t *testing.T) {
+	t.Parallel()
+	var tests = []struct {
+		statuses         []int // status for each attempt, the last repeats
+		expectedStatus   int
+		expectedAttempts int32
+	}{
+		{[]int{200}, 200, 1},
+		{[]int{404}, 404, 1},                // client errors aren't retried
+		{[]int{500, 502, 200}, 200, 3},      // server errors are
+		{[]int{429, 200}, 200, 2},           // so is throttling
+		{[]int{503}, 503, 3},                // until retries run out
+		{[]int{500, 500, 500, 200}, 500, 3}, // which includes the first attempt
+	}
+
+	for _, test := range tests {
+		var attempts int32
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := int(atomic.AddInt32(&attempts, 1)) - 1
+			if n >= len(test.statuses) {
+				n = len(test.statuses) - 1
+			}
+			w.WriteHeader(test.statuses[n])
+		}))
+
+		client := newTestProviderClient()
+		r, err := client.Get(ts.URL)
+		if err != nil {
+			t.Errorf("Unexpected error for %v: %v", test.statuses, err)
+		} else {
+			r.Body.Close()
+			if r.StatusCode != test.expectedStatus {
+				t.Errorf("Expected final status %d for %v, got %d", test.expectedStatus, test.statuses, r.StatusCode)
+			}
+		}
+		if attempts != test.expectedAttempts {
+			t.Errorf("Expected %d attempts for %v, got %d", test.expectedAttempts, test.statuses, attempts)
+		}
+		ts.Close()
+	}
+}
+
+func TestProviderClientCircuitOpen(t *testing.T) {
+	t.Parallel()
+	var attempts int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	client := newTestProviderClient()
+	client.Breaker.Threshold = 2
+	for i := 0; i < 2; i++ {
+		r, err := client.Get(ts.URL)
+		if err == nil {
+			r.Body.Close()
+		}
+	}
+	if attempts != 6 {
+		t.Errorf("Expected 6 attempts before the circuit opened, got %d", attempts)
+	}
+
+	// the provider isn't contacted while the circuit is open
+	if _, err := client.Get(ts.URL); err != stock.ErrCircuitOpen {
+		t.Errorf("Expected ErrCircuitOpen, got %v", err)
+	}
+	if attempts != 6 {
+		t.Errorf("Expected no attempts while the circuit is open, got %d", attempts-6)
+	}
+}
+
+func TestProviderClientTimeout(t *testing.T) {
+	t.Parallel()
+	block := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}))
+	defer ts.Close()
+	defer close(block)
+
+	client := newTestProviderClient()
+	client.HTTPClient.Timeout = 10 * time.Millisecond
+	client.MaxRetries = 0
+	if _, err := client.Get(ts.URL); err == nil {
+		t.Errorf("Expected timeout error from a provider that never responds")
+	}
+}
+
+func TestProviderClientContext(t *testing.T) {
+	t.Parallel()
+	block := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		<-block
+	}))
+	defer ts.Close()
+	defer close(block)
+
+	client := newTestProviderClient()
+	client.Breaker.Threshold = 1
+
+	// a deadline abandons a request the provider hasn't answered
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := client.GetContext(ctx, ts.URL); err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+
+	// canceling abandons the wait to retry
+	client.BaseBackoff, client.MaxBackoff = time.Hour, time.Hour
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	started := time.Now()
+	if _, err := client.GetContext(ctx, ts.URL+"/fail"); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("Expected the backoff to be abandoned, took %v", elapsed)
+	}
+
+	// neither counts against the provider
+	if state := client.Breaker.State(time.Now()); state != stock.BreakerClosed {
+		t.Errorf("Expected abandoned requests to leave the breaker closed, got %s", state)
+	}
+}
+
+// while the provider is unavailable stored data is served even though it's missing recent closes
+func TestStaleFallback(t *testing.T) {
+	_, discard := testhelpers.UseStore(t)
+	defer discard()
+	var attempts int32
+	markit := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+	}))
+	defer markit.Close()
+	defaultProvider, markitClient := stock.DefaultProvider, stock.MarkitClient
+	stock.DefaultProvider = stock.MarkitProvider{Url: markit.URL}
+	defer func() { stock.DefaultProvider, stock.MarkitClient = defaultProvider, markitClient }()
+
+	// the circuit is open
+	stock.MarkitClient = newTestProviderClient()
+	stock.MarkitClient.Breaker.Threshold = 1
+	stock.MarkitClient.Breaker.Failure(time.Now())
+
+	// closes stored up to a month ago
+	end := time.Now().UTC().AddDate(0, -1, 0)
+	stored, _ := stock.SyntheticProvider{}.Fetch(stock.NewStock("GOOG"), end.AddDate(0, 0, -14), end)
+	if err := stock.DB.Insert(stock.NewStock("GOOG"), &stored); err != nil {
+		t.Fatalf("Could not store span: %v", err)
+	}
+
+	before := stock.ReadCacheStats()
+	span, err := stock.NewStock("GOOG").Range(end.AddDate(0, 0, -14), time.Now().UTC())
+	if err != nil || !span.Equal(stored) {
+		t.Errorf("Expected the stored span while the circuit is open, got %v %v", span, err)
+	}
+	if stale := stock.ReadCacheStats().Stale - before.Stale; stale != 1 {
+		t.Errorf("Expected the range to be counted as stale, got %d", stale)
+	}
+
+	// without stored data the provider's failure is returned
+	if _, err := stock.NewStock("MSFT").Range(end.AddDate(0, 0, -14), time.Now().UTC()); !errors.Is(err, stock.ErrCircuitOpen) {
+		t.Errorf("Expected ErrCircuitOpen without stored data, got %v", err)
+	}
+	if attempts != 0 {
+		t.Errorf("Expected no requests to the provider while the circuit is open, got %d", attempts)
+	}
+}
+
+func newTestProviderClient() *stock.ProviderClient {
+	return &stock.ProviderClient{
+		HTTPClient:  &http.Client{Timeout: time.Second},
+		MaxRetries:  2,
+		BaseBackoff: time.Millisecond,
+		MaxBackoff:  5 * time.Millisecond,
+		Limiter:     limit.NewBucket(1000, 10),
+		Breaker:     &stock.Breaker{Threshold: 5, Cooldown: time.Minute},
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package stock
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// markets close at 16:00 in New York, end of day data is available shortly after
+const marketCloseHour = 16
+
+var marketLocation, _ = time.LoadLocation("America/New_York")
+
+// Scheduler refreshes a watchlist of symbols after market close every trading
+// day so that their measures are in the database before anyone asks for them.
+// Each refresh is recorded as a Job in the database.
+type Scheduler struct {
+	Watchlist   []string
+	Concurrency int           // symbols refreshed at once
+	Retries     int           // additional attempts after a failed refresh
+	RetryDelay  time.Duration // doubled after each failed attempt
+	Delay       time.Duration // how long after market close to run
+	Lookback    time.Duration // how far back each refresh requests, should cover weekends and holidays
+
+	mu     sync.Mutex
+	status SchedulerStatus
+}
+
+// SchedulerStatus is what a Scheduler is doing, for diagnostics
+type SchedulerStatus struct {
+	Running      bool      // a refresh is in progress
+	NextRun      time.Time // zero until Run is called
+	LastRun      time.Time // when the last refresh finished, zero before the first
+	LastFailures []string  // symbols the last refresh couldn't refresh
+}
+
+func (sch *Scheduler) Status() SchedulerStatus {
+	sch.mu.Lock()
+	defer sch.mu.Unlock()
+	return sch.status
+}
+
+// Job is the record of one scheduled refresh of a symbol
+type Job struct {
+	ID       int
+	Symbol   string
+	Day      time.Time
+	Started  time.Time
+	Finished time.Time
+	Attempts int
+	Error    string
+}
+
+// Run refreshes the watchlist after each market close until ctx is done,
+// which also abandons a refresh in progress
+func (sch *Scheduler) Run(ctx context.Context) {
+	for {
+		next := NextRun(time.Now(), sch.Delay)
+		sch.mu.Lock()
+		sch.status.NextRun = next
+		sch.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(next.Sub(time.Now())):
+			sch.RunOnce(ctx, next)
+		}
+	}
+}
+
+// RunOnce refreshes every symbol in the watchlist for day, returning the jobs run
+func (sch *Scheduler) RunOnce(ctx context.Context, day time.Time) []Job {
+	concurrency := sch.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	sch.mu.Lock()
+	sch.status.Running = true
+	sch.mu.Unlock()
+
+	jobs := make([]Job, len(sch.Watchlist))
+	sem := make(chan bool, concurrency)
+	var wg sync.WaitGroup
+	for i, sym := range sch.Watchlist {
+		wg.Add(1)
+		sem <- true
+		go func(i int, sym string) {
+			defer func() { <-sem; wg.Done() }()
+			jobs[i] = sch.refresh(ctx, sym, day)
+		}(i, sym)
+	}
+	wg.Wait()
+
+	failures := []string{}
+	for _, job := range jobs {
+		if job.Error != "" {
+			failures = append(failures, job.Symbol)
+		}
+	}
+	sch.mu.Lock()
+	sch.status.Running = false
+	sch.status.LastRun = time.Now().UTC()
+	sch.status.LastFailures = failures
+	sch.mu.Unlock()
+	return jobs
+}
+
+func (sch *Scheduler) refresh(ctx context.Context, sym string, day time.Time) Job {
+	job := Job{Symbol: sym, Day: day, Started: time.Now().UTC()}
+
+	delay := sch.RetryDelay
+attempts:
+	for job.Attempts = 1; ; job.Attempts++ {
+		_, err := NewStock(sym).PopulateContext(ctx, day.Add(-sch.Lookback), day)
+		if err == nil {
+			job.Error = ""
+			break
+		}
+		job.Error = err.Error()
+		if job.Attempts > sch.Retries || errors.Is(err, ErrSymbolNotFound) {
+			break
+		}
+		select {
+		case <-ctx.Done(): // shutting down, don't wait out the backoff
+			break attempts
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+
+	job.Finished = time.Now().UTC()
+	DB.InsertJob(&job) // history is best effort, a failed insert shouldn't fail the refresh
+	return job
+}
+
+// NextRun is the first time after now that is delay past a trading day's close.
+// Only weekends are skipped, on holidays the refresh finds no new data.
+func NextRun(now time.Time, delay time.Duration) time.Time {
+	local := now.In(marketLocation)
+	y, m, d := local.Date()
+	for run := time.Date(y, m, d, marketCloseHour, 0, 0, 0, marketLocation).Add(delay); ; run = run.AddDate(0, 0, 1) {
+		if run.After(now) && IsTradingDay(run) {
+			return run
+		}
+	}
+}
+
+// IsTradingDay reports whether markets are open on t's day in New York
+func IsTradingDay(t time.Time) bool {
+	weekday := t.In(marketLocation).Weekday()
+	return weekday != time.Saturday && weekday != time.Sunday
+}
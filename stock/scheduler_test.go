@@ -0,0 +1,177 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package stock_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jhurwich/trendy/stock"
+	"github.com/jhurwich/trendy/testhelpers"
+)
+
+func TestNextRun(t *testing.T) {
+	t.Parallel()
+	ny, _ := time.LoadLocation("America/New_York")
+	delay := 30 * time.Minute
+
+	var tests = []struct {
+		now      time.Time
+		expected time.Time
+	}{
+		// before close on a weekday runs that evening
+		{time.Date(2015, time.June, 1, 9, 0, 0, 0, ny), time.Date(2015, time.June, 1, 16, 30, 0, 0, ny)},
+		// after the run on a weekday waits for the next day
+		{time.Date(2015, time.June, 1, 17, 0, 0, 0, ny), time.Date(2015, time.June, 2, 16, 30, 0, 0, ny)},
+		// exactly at the run time waits for the next day
+		{time.Date(2015, time.June, 1, 16, 30, 0, 0, ny), time.Date(2015, time.June, 2, 16, 30, 0, 0, ny)},
+		// friday evening skips the weekend
+		{time.Date(2015, time.June, 5, 18, 0, 0, 0, ny), time.Date(2015, time.June, 8, 16, 30, 0, 0, ny)},
+		{time.Date(2015, time.June, 6, 12, 0, 0, 0, ny), time.Date(2015, time.June, 8, 16, 30, 0, 0, ny)},
+		// other time zones are converted to New York
+		{time.Date(2015, time.June, 1, 21, 0, 0, 0, time.UTC), time.Date(2015, time.June, 2, 16, 30, 0, 0, ny)},
+		{time.Date(2015, time.June, 1, 20, 0, 0, 0, time.UTC), time.Date(2015, time.June, 1, 16, 30, 0, 0, ny)},
+	}
+
+	for _, test := range tests {
+		if next := stock.NextRun(test.now, delay); !next.Equal(test.expected) {
+			t.Errorf("NextRun(%s) expected %s got %s", test.now, test.expected, next)
+		}
+	}
+}
+
+func TestIsTradingDay(t *testing.T) {
+	t.Parallel()
+	ny, _ := time.LoadLocation("America/New_York")
+
+	var tests = []struct {
+		date     time.Time
+		expected bool
+	}{
+		{time.Date(2015, time.June, 1, 12, 0, 0, 0, time.UTC), true},  // Monday
+		{time.Date(2015, time.June, 5, 12, 0, 0, 0, time.UTC), true},  // Friday
+		{time.Date(2015, time.June, 6, 12, 0, 0, 0, time.UTC), false}, // Saturday
+		{time.Date(2015, time.June, 7, 12, 0, 0, 0, time.UTC), false}, // Sunday
+		// the day is taken in New York, late Friday there is already Saturday in UTC
+		{time.Date(2015, time.June, 5, 22, 0, 0, 0, ny), true},
+		{time.Date(2015, time.June, 8, 2, 0, 0, 0, time.UTC), false},
+		// holidays aren't known, only weekends are skipped
+		{time.Date(2015, time.July, 3, 12, 0, 0, 0, time.UTC), true},
+	}
+
+	for _, test := range tests {
+		if stock.IsTradingDay(test.date) != test.expected {
+			t.Errorf("IsTradingDay(%s) expected %t", test.date, test.expected)
+		}
+	}
+}
+
+func TestSchedulerRunOnce(t *testing.T) {
+	_, discard := testhelpers.UseStore(t)
+	defer discard()
+	defaultProvider := stock.DefaultProvider
+	stock.DefaultProvider = stock.SyntheticProvider{}
+	defer func() { stock.DefaultProvider = defaultProvider }()
+
+	sch := &stock.Scheduler{Watchlist: []string{"GOOG", "AAPL"}, Concurrency: 2, Lookback: 7 * 24 * time.Hour}
+	day := time.Date(2015, time.June, 1, 20, 0, 0, 0, time.UTC)
+	jobs := sch.RunOnce(context.Background(), day)
+	if len(jobs) != 2 || jobs[0].Error != "" || jobs[1].Error != "" {
+		t.Errorf("Expected two successful jobs, got %+v", jobs)
+	}
+	status := sch.Status()
+	if status.Running || status.LastRun.IsZero() || len(status.LastFailures) != 0 {
+		t.Errorf("Unexpected status after a successful refresh: %+v", status)
+	}
+	if history, _ := stock.DB.GetJobs(10); len(history) != 2 {
+		t.Errorf("Expected the jobs to be recorded, got %+v", history)
+	}
+
+	// days before the synthetic epoch have no data and fail
+	jobs = sch.RunOnce(context.Background(), time.Date(1990, time.June, 1, 20, 0, 0, 0, time.UTC))
+	if status := sch.Status(); len(status.LastFailures) != 2 || jobs[0].Attempts != 1 {
+		t.Errorf("Expected both symbols to fail without retries, got %+v", status)
+	}
+}
+
+func TestSchedulerRetry(t *testing.T) {
+	_, discard := testhelpers.UseStore(t)
+	defer discard()
+	defaultProvider := stock.DefaultProvider
+	stock.DefaultProvider = &flakyProvider{failures: 2}
+	defer func() { stock.DefaultProvider = defaultProvider }()
+
+	day := time.Date(2015, time.June, 1, 20, 0, 0, 0, time.UTC)
+	sch := &stock.Scheduler{Watchlist: []string{"GOOG"}, Retries: 2, RetryDelay: time.Millisecond, Lookback: 7 * 24 * time.Hour}
+	jobs := sch.RunOnce(context.Background(), day)
+	if len(jobs) != 1 || jobs[0].Attempts != 3 || jobs[0].Error != "" {
+		t.Errorf("Expected the refresh to succeed on the third attempt, got %+v", jobs)
+	}
+
+	// out of retries the last error is kept
+	stock.DefaultProvider = &flakyProvider{failures: 3}
+	sch = &stock.Scheduler{Watchlist: []string{"GOOG"}, Retries: 1, RetryDelay: time.Millisecond, Lookback: 7 * 24 * time.Hour}
+	jobs = sch.RunOnce(context.Background(), day)
+	if len(jobs) != 1 || jobs[0].Attempts != 2 || jobs[0].Error == "" {
+		t.Errorf("Expected the refresh to fail after two attempts, got %+v", jobs)
+	}
+
+	// both refreshes are in the history, newest first
+	history, err := stock.DB.GetJobs(10)
+	if err != nil || len(history) != 2 {
+		t.Fatalf("Expected two jobs in the history, got %+v %v", history, err)
+	}
+	for i, expected := range []stock.Job{{Symbol: "GOOG", Attempts: 2, Error: jobs[0].Error}, {Symbol: "GOOG", Attempts: 3}} {
+		job := history[i]
+		if job.ID == 0 || job.Symbol != expected.Symbol || job.Attempts != expected.Attempts || job.Error != expected.Error {
+			t.Errorf("Expected job %+v in the history, got %+v", expected, job)
+		}
+		if stock.TimeForSQL(job.Day) != stock.TimeForSQL(day) || job.Finished.Before(job.Started) {
+			t.Errorf("Expected the job's day and times recorded, got %+v", job)
+		}
+	}
+}
+
+func TestSchedulerCancel(t *testing.T) {
+	_, discard := testhelpers.UseStore(t)
+	defer discard()
+	defaultProvider := stock.DefaultProvider
+	stock.DefaultProvider = hungProvider{}
+	defer func() { stock.DefaultProvider = defaultProvider }()
+
+	// shutting down abandons the fetch in flight and the retries after it
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	sch := &stock.Scheduler{Watchlist: []string{"GOOG"}, Retries: 3, RetryDelay: time.Hour, Lookback: 7 * 24 * time.Hour}
+	jobs := sch.RunOnce(ctx, time.Date(2015, time.June, 1, 20, 0, 0, 0, time.UTC))
+	if len(jobs) != 1 || jobs[0].Attempts != 1 || !strings.Contains(jobs[0].Error, context.DeadlineExceeded.Error()) {
+		t.Errorf("Expected the refresh abandoned on its first attempt, got %+v", jobs)
+	}
+}
+
+// a provider whose upstream never answers
+type hungProvider struct {
+	stock.SyntheticProvider
+}
+
+func (p hungProvider) FetchContext(ctx context.Context, s *stock.Stock, startDate time.Time, endDate time.Time) (stock.Span, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// a provider that fails a number of times before returning synthetic data
+type flakyProvider struct {
+	stock.SyntheticProvider
+	failures int
+}
+
+func (p *flakyProvider) FetchContext(ctx context.Context, s *stock.Stock, startDate time.Time, endDate time.Time) (stock.Span, error) {
+	if p.failures > 0 {
+		p.failures--
+		return nil, errors.New("upstream unavailable")
+	}
+	return p.SyntheticProvider.FetchContext(ctx, s, startDate, endDate)
+}
@@ -3,14 +3,23 @@
 package stock
 
 import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"math"
 	"sort"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
 // Stock object manages all data accesses for a specific stock symbol
 type Stock struct {
-	Symbol string
-	Span   Span
+	Symbol   string
+	Currency string `json:",omitempty"` // of Span's prices, empty until known
+	Span     Span
 }
 
 func NewStock(sym string) *Stock {
@@ -23,37 +32,154 @@ func NewStock(sym string) *Stock {
 // Range calls ActualRange with empty string for overrideUrl to get default url,
 // which should be Markit's. This separation exists for dependency injection in tests.
 func (s *Stock) Range(startDate time.Time, endDate time.Time) (Span, error) {
-	return s.ActualRange(startDate, endDate, "")
+	return s.ActualRange(context.Background(), startDate, endDate, "")
 }
-func (s *Stock) ActualRange(startDate time.Time, endDate time.Time, overrideUrl string) (Span, error) {
+
+// RangeContext is Range on behalf of the request ctx, whose id is logged with
+// each layer the range passes through. Canceling ctx or its deadline passing
+// abandons the database query and any fetch from the provider.
+func (s *Stock) RangeContext(ctx context.Context, startDate time.Time, endDate time.Time) (Span, error) {
+	return s.ActualRange(ctx, startDate, endDate, "")
+}
+func (s *Stock) ActualRange(ctx context.Context, startDate time.Time, endDate time.Time, overrideUrl string) (Span, error) {
+	started := time.Now()
+
 	// Check if data is memoized in s.Span, if so return that subslice.
 	if s.Span.Covers(startDate) && s.Span.Covers(endDate) {
 		// Find the first date after startDate in Span. The smallest range that
 		// includes startDate begins at that date - 1
 		start := sort.Search(len(s.Span), func(i int) bool { return s.Span[i].Time.After(startDate) }) - 1
 		end := sort.Search(len(s.Span), func(i int) bool { return s.Span[i].Time.After(endDate) })
+		s.served(ctx, &cacheStats.Memoized, "memoized", started)
 		return s.Span[start:end], nil
 	}
 
+	// unknown symbols are refused before the store or provider is asked
+	if err := CheckSymbol(ctx, s.Symbol); err != nil {
+		return nil, err
+	}
+
+	// a range across a ticker change or past a delisting is served by ticker
+	segments, err := symbolSegments(ctx, s.Symbol, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+	if len(segments) > 1 || !strings.EqualFold(segments[0].symbol, s.Symbol) || !segments[0].end.Equal(endDate) {
+		return s.rangeSegments(ctx, segments, overrideUrl, started)
+	}
+	return s.storedRange(ctx, startDate, endDate, overrideUrl, started)
+}
+
+// storedRange is the range from the store, fetching what's missing from it
+func (s *Stock) storedRange(ctx context.Context, startDate time.Time, endDate time.Time, overrideUrl string, started time.Time) (Span, error) {
 	// all or part of the data is missing from what is memoized, check the database
-	dbSpan, err := DB.GetRange(s, startDate, endDate)
+	lookup := time.Now()
+	dbSpan, err := DB.GetRangeContext(ctx, s, startDate, endDate)
 	if err != nil {
+		slog.ErrorContext(ctx, "Store lookup failed", "symbol", s.Symbol, "duration", time.Since(lookup), "error", err)
 		return nil, err
 	}
+	slog.DebugContext(ctx, "Store lookup", "symbol", s.Symbol, "measures", len(dbSpan), "duration", time.Since(lookup))
 
 	if len(dbSpan) > 0 {
-		// information was stored in the database, return it
-		return dbSpan, nil
+		// information was stored in the database, return it. If the range
+		// reaches past the last stored close try to fetch what's missing, but
+		// while the provider is unavailable the stored data is served as is.
+		sort.Sort(dbSpan)
+		last := dbSpan[len(dbSpan)-1].Time
+		if !MissingRecentCloses(last, endDate, time.Now()) {
+			s.served(ctx, &cacheStats.Stored, "stored", started)
+			return dbSpan, nil
+		}
+		newSpan, err := s.ActualPopulateContext(ctx, last, endDate, overrideUrl)
+		if ctx.Err() != nil {
+			// nobody is waiting for the stale data
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			// the failure was logged by ActualPopulateContext
+			s.served(ctx, &cacheStats.Stale, "stale", started)
+			return dbSpan, nil
+		}
+		s.served(ctx, &cacheStats.Refreshed, "refreshed", started)
+		return mergeSpans(dbSpan, newSpan), nil
 	} else {
 		// data wasn't in database, populate it
-		newSpan, err := s.ActualPopulate(startDate, endDate, overrideUrl)
+		newSpan, err := s.ActualPopulateContext(ctx, startDate, endDate, overrideUrl)
 		if err != nil {
 			return nil, err
 		}
+		s.served(ctx, &cacheStats.Fetched, "fetched", started)
 		return newSpan, nil
 	}
 }
 
+// CacheStats counts where the ranges served by Range came from
+type CacheStats struct {
+	Memoized  int64 // the stock's memoized span
+	Stored    int64 // the store, which was current
+	Refreshed int64 // the store, with recent closes fetched from the provider
+	Stale     int64 // the store, the provider couldn't be reached for recent closes
+	Fetched   int64 // the provider, nothing was stored
+}
+
+var cacheStats CacheStats
+
+// count where a range came from and log how long it took to serve
+func (s *Stock) served(ctx context.Context, count *int64, source string, started time.Time) {
+	atomic.AddInt64(count, 1)
+	cacheRanges.WithLabelValues(source).Inc()
+	slog.DebugContext(ctx, "Range served", "symbol", s.Symbol, "source", source, "duration", time.Since(started))
+}
+
+// ReadCacheStats returns the counts since the process started
+func ReadCacheStats() CacheStats {
+	return CacheStats{
+		Memoized:  atomic.LoadInt64(&cacheStats.Memoized),
+		Stored:    atomic.LoadInt64(&cacheStats.Stored),
+		Refreshed: atomic.LoadInt64(&cacheStats.Refreshed),
+		Stale:     atomic.LoadInt64(&cacheStats.Stale),
+		Fetched:   atomic.LoadInt64(&cacheStats.Fetched),
+	}
+}
+
+// MissingRecentCloses reports whether a range ending at endDate should include
+// closes after last, the latest stored, as of now. Only the most recent close
+// is considered, older gaps are weekends and holidays rather than missing data.
+func MissingRecentCloses(last time.Time, endDate time.Time, now time.Time) bool {
+	latest := LastClose(now)
+	return TimeForSQL(last) < TimeForSQL(latest) && (endDate.IsZero() || TimeForSQL(endDate) >= TimeForSQL(latest))
+}
+
+// LastClose is the day of the most recent market close at or before now
+func LastClose(now time.Time) time.Time {
+	local := now.In(marketLocation)
+	day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.UTC)
+	if local.Hour() < marketCloseHour {
+		day = day.AddDate(0, 0, -1)
+	}
+	for !IsTradingDay(day.Add(12 * time.Hour)) {
+		day = day.AddDate(0, 0, -1)
+	}
+	return day
+}
+
+// combine spans into one sorted span, measures in r replace those on the same day in l
+func mergeSpans(l Span, r Span) Span {
+	byDay := map[string]Measure{}
+	for _, span := range []Span{l, r} {
+		for _, measure := range span {
+			byDay[TimeForSQL(measure.Time)] = measure
+		}
+	}
+	merged := Span{}
+	for _, measure := range byDay {
+		merged = append(merged, measure)
+	}
+	sort.Sort(merged)
+	return merged
+}
+
 // func (s *Stock) RangeAll() (Span, error) {
 // 	// zero time is used as sentinel to query all data available
 // 	return Span{}, nil // TODO implement
@@ -70,32 +196,82 @@ func (s *Stock) ActualRange(startDate time.Time, endDate time.Time, overrideUrl
 // 	return s.Span[:end], nil
 // }
 
+// Provider is a source of daily measures, Markit unless the server is
+// configured for demo data
+type Provider interface {
+	Fetch(s *Stock, startDate time.Time, endDate time.Time) (Span, error)
+}
+
+// ContextProvider is a Provider that can abandon a fetch when ctx is canceled
+// or its deadline passes. It's used in place of Fetch by ActualPopulateContext.
+type ContextProvider interface {
+	Provider
+	FetchContext(ctx context.Context, s *Stock, startDate time.Time, endDate time.Time) (Span, error)
+}
+
+// fetch from provider with ctx if it can take one
+func fetch(ctx context.Context, provider Provider, s *Stock, startDate time.Time, endDate time.Time) (Span, error) {
+	if cp, ok := provider.(ContextProvider); ok {
+		return cp.FetchContext(ctx, s, startDate, endDate)
+	}
+	return provider.Fetch(s, startDate, endDate)
+}
+
+// DefaultProvider is used by Populate
+var DefaultProvider Provider = MarkitProvider{}
+
 // Populate daily measure data (value field) between times provided.
 // Populate calls ActualPopulate with empty string for overrideUrl to get default url,
 // which should be Markit's. This separation exists for dependency injection in tests.
 func (s *Stock) Populate(startDate time.Time, endDate time.Time) (Span, error) {
-	return s.ActualPopulate(startDate, endDate, "")
+	return s.ActualPopulateContext(context.Background(), startDate, endDate, "")
+}
+
+// PopulateContext is Populate on behalf of ctx, canceling it abandons the
+// fetch from the provider and the insert
+func (s *Stock) PopulateContext(ctx context.Context, startDate time.Time, endDate time.Time) (Span, error) {
+	return s.ActualPopulateContext(ctx, startDate, endDate, "")
 }
 func (s *Stock) ActualPopulate(startDate time.Time, endDate time.Time, overrideUrl string) (Span, error) {
+	return s.ActualPopulateContext(context.Background(), startDate, endDate, overrideUrl)
+}
 
-	request, err := NewMarkitChartAPIRequest(s, startDate, endDate)
-	if err != nil {
+// ActualPopulateContext is ActualPopulate on behalf of ctx
+func (s *Stock) ActualPopulateContext(ctx context.Context, startDate time.Time, endDate time.Time, overrideUrl string) (Span, error) {
+	// the scheduler, backfills and streams populate without a range, unknown
+	// symbols are refused here too before the provider is asked
+	if err := CheckSymbol(ctx, s.Symbol); err != nil {
 		return nil, err
 	}
 
-	// if there's an overrideUrl specified, set the request to that url
+	// if there's an overrideUrl specified, request from Markit at that url
+	provider := DefaultProvider
 	if overrideUrl != "" {
-		request.Url = overrideUrl
+		provider = MarkitProvider{Url: overrideUrl}
 	}
+	log := slog.With("symbol", s.Symbol, "provider", fmt.Sprintf("%T", provider))
 
-	response, err := request.Request()
+	fetched := time.Now()
+	span, err := fetch(ctx, provider, s, startDate, endDate)
 	if err != nil {
+		log.WarnContext(ctx, "Provider fetch failed", "duration", time.Since(fetched), "error", err)
 		return nil, err
 	}
+	log.DebugContext(ctx, "Provider fetch", "measures", len(span), "duration", time.Since(fetched))
 
-	s.Span = response.GetSpan()
+	s.Span = span
 
-	DB.Insert(s, &s.Span)
+	insert := time.Now()
+	err = DB.InsertContext(ctx, s, &s.Span)
+	if err == nil && s.Currency != "" {
+		// as reported by the provider
+		err = DB.SetCurrency(ctx, s.Symbol, s.Currency)
+	}
+	if err != nil {
+		log.ErrorContext(ctx, "Store insert failed", "duration", time.Since(insert), "error", err)
+		return nil, err
+	}
+	log.DebugContext(ctx, "Store insert", "measures", len(s.Span), "duration", time.Since(insert))
 
 	return s.Span, nil
 }
@@ -106,9 +282,17 @@ func (s *Stock) ActualPopulate(startDate time.Time, endDate time.Time, overrideU
 // }
 
 // calculate trend for measures between times provided
-func (s *Stock) Analyze(startDate time.Time, endDate time.Time) error {
+func (s *Stock) Analyze(startDate time.Time, endDate time.Time) (Trend, error) {
+	return s.AnalyzeContext(context.Background(), startDate, endDate)
+}
 
-	return nil
+// AnalyzeContext is Analyze on behalf of ctx, the range is looked up with RangeContext
+func (s *Stock) AnalyzeContext(ctx context.Context, startDate time.Time, endDate time.Time) (Trend, error) {
+	span, err := s.RangeContext(ctx, startDate, endDate)
+	if err != nil {
+		return Trend{}, err
+	}
+	return span.Trend()
 }
 
 // func (s *Stock) AnalyzeAll() error {
@@ -120,8 +304,18 @@ func (s *Stock) Analyze(startDate time.Time, endDate time.Time) error {
 type Span []Measure
 type Measure struct {
 	Time  time.Time
-	Value float32
-	// trend float32 TODO(jhurwich) figure out where this belongs,, Trend with Start and End might be best
+	Value float32 // the close
+	// the rest of the day's bar, zero if the provider didn't give it or it was
+	// stored before bars were added, until its range is backfilled again
+	Open   float32 `json:",omitempty"`
+	High   float32 `json:",omitempty"`
+	Low    float32 `json:",omitempty"`
+	Volume int64   `json:",omitempty"`
+}
+
+// HasBar reports whether the measure has its open, high and low
+func (m Measure) HasBar() bool {
+	return m.Open != 0 && m.High != 0 && m.Low != 0
 }
 
 // implement an equals function for both span and measure
@@ -144,6 +338,17 @@ func (l Measure) Equal(r Measure) bool {
 		l.Time.YearDay() == r.Time.YearDay()
 }
 
+// Hash is a hex digest of the span's dates and values, it changes whenever the
+// data that would be served for the span changes
+func (s Span) Hash() string {
+	h := sha1.New()
+	for _, measure := range s {
+		fmt.Fprintf(h, "%s:%x:%x:%x:%x:%d;", measure.Time.Format("2006-01-02"), math.Float32bits(measure.Value),
+			math.Float32bits(measure.Open), math.Float32bits(measure.High), math.Float32bits(measure.Low), measure.Volume)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
 // implement sort.Interface on Span
 func (s Span) Len() int {
 	return len(s)
@@ -167,7 +372,7 @@ func (s *Span) Covers(t time.Time) bool {
 	}
 	// get times for comparison with only hours, everything else can get messy
 	compareTime := t.Truncate(time.Hour)
-	firstDate, lastDate := (*s)[0].Time.Truncate(time.Hour), (*s)[len(*s)].Time.Truncate(time.Hour)
+	firstDate, lastDate := (*s)[0].Time.Truncate(time.Hour), (*s)[len(*s)-1].Time.Truncate(time.Hour)
 
 	return ((firstDate.Before(compareTime) || firstDate.Equal(compareTime)) &&
 		(lastDate.After(compareTime) || lastDate.Equal(compareTime)))
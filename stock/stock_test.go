@@ -3,9 +3,11 @@
 package stock_test
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/jhurwich/trendy/stock"
 	"github.com/jhurwich/trendy/testhelpers"
@@ -31,13 +33,82 @@ func TestStockConstruction(t *testing.T) {
 	}
 }
 
-func TestRangeIntegration(t *testing.T) {
-	// test has db impact, setup test db
-	tdb := stock.DB.Setup(stock.TestLocal)
+func TestSpanHash(t *testing.T) {
+	t.Parallel()
+	same := append(stock.Span{}, testSpan1...)
+	changed := append(stock.Span{}, testSpan1...)
+	changed[3].Value += 1
+
+	if testSpan1.Hash() != same.Hash() {
+		t.Errorf("Expected identical spans to hash the same: %s vs %s", testSpan1.Hash(), same.Hash())
+	}
+	if testSpan1.Hash() == changed.Hash() {
+		t.Errorf("Expected a changed value to change the hash: %s", changed.Hash())
+	}
+	if testSpan1.Hash() == testSpan1[1:].Hash() {
+		t.Errorf("Expected a shorter span to change the hash: %s", testSpan1.Hash())
+	}
+}
+
+func TestSpanCovers(t *testing.T) {
+	t.Parallel()
+	span := append(stock.Span{}, testSpan1[:3]...)
+	first, last := span[0].Time, span[2].Time
 
+	for _, covered := range []time.Time{first, span[1].Time, last} {
+		if !span.Covers(covered) {
+			t.Errorf("Expected the span to cover %v", covered)
+		}
+	}
+	for _, uncovered := range []time.Time{first.AddDate(0, 0, -1), last.AddDate(0, 0, 1)} {
+		if span.Covers(uncovered) {
+			t.Errorf("Expected the span not to cover %v", uncovered)
+		}
+	}
+	if empty := (stock.Span{}); empty.Covers(first) {
+		t.Errorf("Expected an empty span to cover nothing")
+	}
+}
+
+func TestMissingRecentCloses(t *testing.T) {
+	t.Parallel()
+	ny, _ := time.LoadLocation("America/New_York")
+	day := func(d int) time.Time { return time.Date(2015, time.June, d, 0, 0, 0, 0, time.UTC) }
+
+	var tests = []struct {
+		last     time.Time
+		end      time.Time
+		now      time.Time
+		expected bool
+	}{
+		// wednesday after close, tuesday stored, range ends today
+		{day(2), day(3), time.Date(2015, time.June, 3, 17, 0, 0, 0, ny), true},
+		// wednesday before close, tuesday's close is the latest
+		{day(2), day(3), time.Date(2015, time.June, 3, 10, 0, 0, 0, ny), false},
+		// ranges that end before the latest close don't need it
+		{day(1), day(2), time.Date(2015, time.June, 3, 17, 0, 0, 0, ny), false},
+		// open ended ranges do
+		{day(2), time.Time{}, time.Date(2015, time.June, 3, 17, 0, 0, 0, ny), true},
+		// on the weekend friday is the latest close
+		{day(5), day(7), time.Date(2015, time.June, 7, 12, 0, 0, 0, ny), false},
+		{day(4), day(7), time.Date(2015, time.June, 7, 12, 0, 0, 0, ny), true},
+		// monday before close, friday is still the latest
+		{day(5), day(8), time.Date(2015, time.June, 8, 9, 0, 0, 0, ny), false},
+	}
+	for _, test := range tests {
+		if missing := stock.MissingRecentCloses(test.last, test.end, test.now); missing != test.expected {
+			t.Errorf("MissingRecentCloses expected %t for %+v", test.expected, test)
+		}
+	}
+}
+
+func TestRangeIntegration(t *testing.T) {
 	// run default test set for Markit
 	testdata := testhelpers.MarkitTestData
 	for _, test := range testdata {
+		// test has db impact, each case uses a store of its own
+		tdb, discard := testhelpers.UseStore(t)
+
 		s := stock.NewStock(test.Sym)
 
 		// initialize a request to get the URL so that we can inform the test server
@@ -64,7 +135,7 @@ func TestRangeIntegration(t *testing.T) {
 				Status: errorCode, RequestUrl: targetUrl, TestData: testdata, T: t,
 			}
 
-			span, err := s.ActualRange(test.StartDate, test.EndDate, ts.URL)
+			span, err := s.ActualRange(context.Background(), test.StartDate, test.EndDate, ts.URL)
 			if err == nil || len(span) > 0 {
 				t.Errorf("Expected an error but got success: %+v\n", span)
 			}
@@ -79,7 +150,7 @@ func TestRangeIntegration(t *testing.T) {
 		}
 
 		// get the span here
-		span, err := s.ActualRange(test.StartDate, test.EndDate, ts.URL)
+		span, err := s.ActualRange(context.Background(), test.StartDate, test.EndDate, ts.URL)
 		if test.ExpectError {
 			if err == nil {
 				t.Errorf("Expected error but got success")
@@ -87,16 +158,14 @@ func TestRangeIntegration(t *testing.T) {
 				// checkMemoryAndDatabase are empty and then continue, no teardown
 				checkMemoryAndDatabase(s, &stock.Span{}, tdb, t)
 				t.Logf("Test complete: %s, %s to %s", test.Sym, test.StartDate.String(), test.EndDate.String())
+				ts.Close()
+				discard()
 				continue
 			}
 		} else if err != nil {
 			t.Error(err)
 		}
 
-		// record the changes we make so they can be reversed, all measures in span will be inserted
-		td := testhelpers.TearDown{}
-		td = td.TrackSpanInsert(s.Symbol, &span, tdb, t)
-
 		// ensure that the returned span equals the expected
 		expectedSpan := test.ExpectedMarkitResponse.GetSpan()
 		if !span.Equal(expectedSpan) {
@@ -105,19 +174,79 @@ func TestRangeIntegration(t *testing.T) {
 
 		// make sure database and memory also reflect the expected
 		checkMemoryAndDatabase(s, &expectedSpan, tdb, t)
+		ts.Close()
+		discard()
 
-		// teardown changes (not deferred because we want to do for each loop - not sure that's necessary though)
-		err = td.TearDown(tdb, t)
-		if err != nil {
-			t.Error(err)
+		t.Logf("Test complete: %s, %s to %s", test.Sym, test.StartDate.String(), test.EndDate.String())
+	}
+}
+
+// Range returns an error and stores nothing when the provider misbehaves
+func TestRangeFaults(t *testing.T) {
+	// test has db impact, use a store of its own
+	tdb, discard := testhelpers.UseStore(t)
+	defer discard()
+
+	test := testhelpers.MarkitTestData[0]
+	unusedRequest, err := stock.NewMarkitChartAPIRequest(stock.NewStock(test.Sym), test.StartDate, test.EndDate)
+	if err != nil {
+		t.Errorf("Could not create a MarkitChartAPIRequest: %v", err)
+	}
+	tsParams := testhelpers.TestServer{Status: http.StatusOK, RequestUrl: unusedRequest.Url, TestData: testhelpers.MarkitTestData, T: t}
+	ts := httptest.NewServer(&tsParams)
+	defer ts.Close()
+
+	for _, fault := range testhelpers.Faults {
+		tsParams = testhelpers.TestServer{Status: http.StatusOK, RequestUrl: unusedRequest.Url, TestData: testhelpers.MarkitTestData, T: t, Fault: fault}
+
+		s := stock.NewStock(test.Sym)
+		span, err := s.ActualRange(context.Background(), test.StartDate, test.EndDate, ts.URL)
+		if err == nil || len(span) > 0 {
+			t.Errorf("Expected an error for %s but got success: %+v\n", fault, span)
 		}
+		checkMemoryAndDatabase(s, &stock.Span{}, tdb, t)
+	}
+}
 
-		t.Logf("Test complete: %s, %s to %s", test.Sym, test.StartDate.String(), test.EndDate.String())
+// a deadline abandons the fetch from a slow provider and nothing is stored
+func TestRangeContext(t *testing.T) {
+	tdb, discard := testhelpers.UseStore(t)
+	defer discard()
+
+	test := testhelpers.MarkitTestData[0]
+	unusedRequest, err := stock.NewMarkitChartAPIRequest(stock.NewStock(test.Sym), test.StartDate, test.EndDate)
+	if err != nil {
+		t.Errorf("Could not create a MarkitChartAPIRequest: %v", err)
+	}
+	tsParams := testhelpers.TestServer{Status: http.StatusOK, RequestUrl: unusedRequest.Url, TestData: testhelpers.MarkitTestData, T: t, Latency: 500 * time.Millisecond}
+	ts := httptest.NewServer(&tsParams)
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	s := stock.NewStock(test.Sym)
+	started := time.Now()
+	span, err := s.ActualRange(ctx, test.StartDate, test.EndDate, ts.URL)
+	if err != context.DeadlineExceeded || len(span) > 0 {
+		t.Errorf("Expected context.DeadlineExceeded, got %v and %+v", err, span)
+	}
+	if elapsed := time.Since(started); elapsed > 400*time.Millisecond {
+		t.Errorf("Expected the fetch to be abandoned at the deadline, took %v", elapsed)
+	}
+	checkMemoryAndDatabase(s, &stock.Span{}, tdb, t)
+
+	// a context that's already done doesn't reach the store
+	if _, err := s.ActualRange(ctx, test.StartDate, test.EndDate, ts.URL); err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded from the store, got %v", err)
 	}
 }
 
-func checkMemoryAndDatabase(st *stock.Stock, sp *stock.Span, tdb *stock.StockDB, t *testing.T) {
-	selectSchema := `SELECT Time, Value FROM Measures where Symbol = $1`
+func checkMemoryAndDatabase(st *stock.Stock, sp *stock.Span, tdb stock.Store, t *testing.T) {
+	// everything stored for the stock
+	dbSpan, e := tdb.GetRange(st, time.Time{}, time.Now())
+	if e != nil {
+		t.Error(e)
+	}
 
 	if len(*sp) == 0 {
 		// sp is empty, we should expect the stock to be unpopulated
@@ -127,16 +256,8 @@ func checkMemoryAndDatabase(st *stock.Stock, sp *stock.Span, tdb *stock.StockDB,
 			t.Errorf("Expected empty stock.Span but had memoized data, stock.Span:\n%+v\n", st.Span)
 		}
 		// Confirm database does not include data for this stock
-		r, e := tdb.Queryx(selectSchema, st.Symbol)
-		if e != nil {
-			t.Error(e)
-		} else {
-			// we'll get rows if the table exists, but won't be able to access any data
-			hasNext := r.Next()
-			if hasNext {
-				// if we have data something is wrong
-				t.Errorf("Expected nothing in database, but found data for stock.Symbol: %s\n", st.Symbol)
-			}
+		if len(dbSpan) > 0 {
+			t.Errorf("Expected nothing in database, but found data for stock.Symbol: %s\n", st.Symbol)
 		}
 	} else {
 		// sp is the expected results
@@ -144,23 +265,38 @@ func checkMemoryAndDatabase(st *stock.Stock, sp *stock.Span, tdb *stock.StockDB,
 			t.Errorf("Expected span to be memoized. Expected:\n%+v\n Got:\n%+v\n", st.Span, sp)
 		}
 
-		r, e := tdb.Queryx(selectSchema, st.Symbol)
-		if e != nil {
-			t.Error(e)
-		}
-		dbSpan := *new(stock.Span)
-		for r.Next() {
-			m := new(stock.Measure)
-			e = r.StructScan(m)
-			if e != nil {
-				t.Error(e)
-			}
-			dbSpan = append(dbSpan, *m)
-		}
-
 		// sp is the expected results
 		if !st.Span.Equal(dbSpan) {
 			t.Errorf("Expected span to be in the database. Expected:\n%+v\n Got:\n%+v\n", st.Span, dbSpan)
 		}
 	}
 }
+
+// ranges are counted by where they were served from
+func TestCacheStats(t *testing.T) {
+	_, discard := testhelpers.UseStore(t)
+	defer discard()
+	defaultProvider := stock.DefaultProvider
+	stock.DefaultProvider = stock.SyntheticProvider{}
+	defer func() { stock.DefaultProvider = defaultProvider }()
+
+	start, end := time.Date(2015, time.June, 1, 0, 0, 0, 0, time.UTC), time.Date(2015, time.June, 30, 0, 0, 0, 0, time.UTC)
+	before := stock.ReadCacheStats()
+
+	// fetched from the provider, then memoized by the stock, then stored for new stocks
+	s := stock.NewStock("CACHE")
+	if _, err := s.Range(start, end); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Range(start.AddDate(0, 0, 1), end.AddDate(0, 0, -1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stock.NewStock("CACHE").Range(start, end); err != nil {
+		t.Fatal(err)
+	}
+
+	after := stock.ReadCacheStats()
+	if after.Fetched-before.Fetched != 1 || after.Memoized-before.Memoized != 1 || after.Stored-before.Stored != 1 {
+		t.Errorf("Expected one range from each of the provider, memory and store, before %+v after %+v", before, after)
+	}
+}
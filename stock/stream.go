@@ -0,0 +1,225 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package stock
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Update is a new measure for a symbol as published to subscribers
+type Update struct {
+	Symbol  string
+	Measure Measure
+}
+
+// Broker fans out Updates to the Subscriptions interested in each symbol
+type Broker struct {
+	mu   sync.Mutex
+	subs map[string]map[*Subscription]bool
+}
+
+func NewBroker() *Broker {
+	return &Broker{subs: map[string]map[*Subscription]bool{}}
+}
+
+// Subscription receives Updates for its symbols on C. Each subscription has its
+// own buffer, when a subscriber falls behind and the buffer fills the oldest
+// update is dropped so that a slow reader never blocks the publisher.
+type Subscription struct {
+	C       <-chan Update
+	c       chan Update
+	symbols []string
+	broker  *Broker
+	dropped int
+	closed  bool
+}
+
+// Subscribe to updates for symbols with a buffer of the given size
+func (b *Broker) Subscribe(symbols []string, buffer int) *Subscription {
+	if buffer < 1 {
+		buffer = 1
+	}
+	c := make(chan Update, buffer)
+	sub := &Subscription{C: c, c: c, symbols: symbols, broker: b}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, sym := range symbols {
+		if b.subs[sym] == nil {
+			b.subs[sym] = map[*Subscription]bool{}
+		}
+		b.subs[sym][sub] = true
+	}
+	return sub
+}
+
+// Close unsubscribes, C is closed once no more updates can be delivered
+func (sub *Subscription) Close() {
+	b := sub.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	for _, sym := range sub.symbols {
+		delete(b.subs[sym], sub)
+		if len(b.subs[sym]) == 0 {
+			delete(b.subs, sym)
+		}
+	}
+	close(sub.c)
+}
+
+// Dropped returns and resets the number of updates dropped for this subscriber
+// since the last call
+func (sub *Subscription) Dropped() int {
+	sub.broker.mu.Lock()
+	defer sub.broker.mu.Unlock()
+	dropped := sub.dropped
+	sub.dropped = 0
+	return dropped
+}
+
+// Publish delivers u to every subscriber of u.Symbol without blocking
+func (b *Broker) Publish(u Update) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subs[u.Symbol] {
+		select {
+		case sub.c <- u:
+			continue
+		default:
+		}
+
+		// buffer is full, make room by discarding the oldest update. Only the
+		// publisher sends and it holds the lock, so there is room after this.
+		select {
+		case <-sub.c:
+			sub.dropped++
+		default:
+		}
+		sub.c <- u
+	}
+}
+
+// Symbols returns the sorted symbols that currently have subscribers
+func (b *Broker) Symbols() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	symbols := make([]string, 0, len(b.subs))
+	for sym := range b.subs {
+		symbols = append(symbols, sym)
+	}
+	sort.Strings(symbols)
+	return symbols
+}
+
+// Feed produces Updates for the symbols subscribed on a Broker until ctx is done
+type Feed interface {
+	Run(ctx context.Context, b *Broker)
+}
+
+// Poller is a Feed that periodically populates each subscribed symbol from the
+// provider and publishes any measures newer than those it has already seen.
+// Only closes after the last seen are fetched, and nothing is fetched while
+// the latest close has been seen, so known data isn't requested again.
+type Poller struct {
+	Interval time.Duration
+	Lookback time.Duration // how far back a symbol's first poll requests when nothing is stored, should cover weekends and holidays
+	last     map[string]time.Time
+}
+
+func (p *Poller) Run(ctx context.Context, b *Broker) {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			p.Poll(ctx, b, now)
+		}
+	}
+}
+
+// Poll fetches and publishes the new closes of each subscribed symbol as of
+// now. A symbol's first poll starts from its latest stored close, or fetches
+// the lookback without publishing it, so subscribers aren't sent history.
+func (p *Poller) Poll(ctx context.Context, b *Broker, now time.Time) {
+	if p.last == nil {
+		p.last = map[string]time.Time{}
+	}
+	for _, sym := range b.Symbols() {
+		s := NewStock(sym)
+		last, seen := p.last[sym]
+		if !seen {
+			stored, err := DB.GetRangeContext(ctx, s, now.Add(-p.Lookback), now)
+			if err != nil {
+				continue // try again next tick
+			}
+			if len(stored) > 0 {
+				sort.Sort(stored)
+				last, seen = stored[len(stored)-1].Time, true
+				p.last[sym] = last
+			}
+		}
+		if seen && !MissingRecentCloses(last, now, now) {
+			continue
+		}
+
+		start := now.Add(-p.Lookback)
+		if seen {
+			start = last
+		}
+		span, err := s.PopulateContext(ctx, start, now)
+		if err != nil {
+			continue // try again next tick
+		}
+		sort.Sort(span)
+		for _, measure := range span {
+			if seen && TimeForSQL(measure.Time) <= TimeForSQL(last) {
+				continue
+			}
+			p.last[sym] = measure.Time
+			if seen {
+				b.Publish(Update{Symbol: sym, Measure: measure})
+			}
+		}
+	}
+}
+
+// SimulatedFeed is a Feed for local development that publishes a random walk
+// for every subscribed symbol, no provider or database is involved
+type SimulatedFeed struct {
+	Interval time.Duration
+	Seed     int64
+}
+
+func (f *SimulatedFeed) Run(ctx context.Context, b *Broker) {
+	rng := rand.New(rand.NewSource(f.Seed))
+	prices := map[string]float64{}
+	ticker := time.NewTicker(f.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, sym := range b.Symbols() {
+				price, ok := prices[sym]
+				if !ok {
+					price = 100
+				}
+				price *= math.Exp(rng.NormFloat64() * 0.01) // ~1% moves per tick
+				prices[sym] = price
+				b.Publish(Update{Symbol: sym, Measure: Measure{Time: now.UTC(), Value: float32(price)}})
+			}
+		}
+	}
+}
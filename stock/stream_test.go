@@ -0,0 +1,161 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package stock_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jhurwich/trendy/stock"
+	"github.com/jhurwich/trendy/testhelpers"
+)
+
+func TestBrokerPublish(t *testing.T) {
+	t.Parallel()
+	b := stock.NewBroker()
+	goog := b.Subscribe([]string{"GOOG"}, 4)
+	both := b.Subscribe([]string{"GOOG", "MSFT"}, 4)
+
+	if symbols := b.Symbols(); !reflect.DeepEqual(symbols, []string{"GOOG", "MSFT"}) {
+		t.Errorf("Expected subscribed symbols [GOOG MSFT], got %v", symbols)
+	}
+
+	b.Publish(stock.Update{Symbol: "GOOG", Measure: testSpan1[0]})
+	b.Publish(stock.Update{Symbol: "MSFT", Measure: testSpan1[1]})
+	b.Publish(stock.Update{Symbol: "AAPL", Measure: testSpan1[2]}) // no subscribers
+
+	if u := <-goog.C; u.Symbol != "GOOG" || !u.Measure.Equal(testSpan1[0]) {
+		t.Errorf("Unexpected update for GOOG subscriber: %+v", u)
+	}
+	if len(goog.C) != 0 {
+		t.Errorf("GOOG subscriber received updates for other symbols")
+	}
+	if u := <-both.C; u.Symbol != "GOOG" {
+		t.Errorf("Expected GOOG update first, got %+v", u)
+	}
+	if u := <-both.C; u.Symbol != "MSFT" {
+		t.Errorf("Expected MSFT update second, got %+v", u)
+	}
+
+	// closing removes the subscription and closes the channel
+	goog.Close()
+	goog.Close() // closing twice is harmless
+	if _, ok := <-goog.C; ok {
+		t.Errorf("Expected closed subscription channel")
+	}
+	both.Close()
+	if symbols := b.Symbols(); len(symbols) != 0 {
+		t.Errorf("Expected no subscribed symbols after close, got %v", symbols)
+	}
+	b.Publish(stock.Update{Symbol: "GOOG", Measure: testSpan1[0]}) // must not panic on closed subscribers
+}
+
+// a subscriber that doesn't read keeps only the newest updates and never blocks the publisher
+func TestBrokerBackpressure(t *testing.T) {
+	t.Parallel()
+	b := stock.NewBroker()
+	slow := b.Subscribe([]string{"GOOG"}, 3)
+	defer slow.Close()
+
+	for _, measure := range testSpan1 {
+		b.Publish(stock.Update{Symbol: "GOOG", Measure: measure})
+	}
+
+	if dropped := slow.Dropped(); dropped != len(testSpan1)-3 {
+		t.Errorf("Expected %d dropped updates, got %d", len(testSpan1)-3, dropped)
+	}
+	if dropped := slow.Dropped(); dropped != 0 {
+		t.Errorf("Expected dropped count to reset, got %d", dropped)
+	}
+	for _, expected := range testSpan1[len(testSpan1)-3:] {
+		if u := <-slow.C; !u.Measure.Equal(expected) {
+			t.Errorf("Expected newest updates to be kept, expected %+v got %+v", expected, u.Measure)
+		}
+	}
+}
+
+func TestSimulatedFeed(t *testing.T) {
+	t.Parallel()
+	b := stock.NewBroker()
+	sub := b.Subscribe([]string{"GOOG", "MSFT"}, 16)
+	defer sub.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go (&stock.SimulatedFeed{Interval: time.Millisecond, Seed: 1}).Run(ctx, b)
+	defer cancel()
+
+	seen := map[string]bool{}
+	timeout := time.After(5 * time.Second)
+	for len(seen) < 2 {
+		select {
+		case u := <-sub.C:
+			if u.Measure.Value <= 0 {
+				t.Errorf("Simulated price should be positive, got %+v", u)
+			}
+			seen[u.Symbol] = true
+		case <-timeout:
+			t.Fatalf("Timed out waiting for simulated updates, saw %v", seen)
+		}
+	}
+}
+
+func TestPoller(t *testing.T) {
+	_, discard := testhelpers.UseStore(t)
+	defer discard()
+	provider := &recordingProvider{}
+	defaultProvider := stock.DefaultProvider
+	stock.DefaultProvider = provider
+	defer func() { stock.DefaultProvider = defaultProvider }()
+	ctx := context.Background()
+	day := func(d int) time.Time { return time.Date(2015, time.June, d, 0, 0, 0, 0, time.UTC) }
+	now := time.Date(2015, time.June, 5, 22, 0, 0, 0, time.UTC) // Friday, after the close
+
+	// MSFT has history up to Wednesday stored, GOOG has nothing
+	msft := stock.NewStock("MSFT")
+	stored, err := provider.SyntheticProvider.FetchContext(ctx, msft, day(1), day(3))
+	if err != nil {
+		t.Fatalf("Could not make stored span: %v", err)
+	}
+	if err := stock.DB.InsertContext(ctx, msft, &stored); err != nil {
+		t.Fatalf("Could not store span: %v", err)
+	}
+
+	b := stock.NewBroker()
+	sub := b.Subscribe([]string{"GOOG", "MSFT"}, 16)
+	defer sub.Close()
+	p := &stock.Poller{Interval: time.Minute, Lookback: 7 * 24 * time.Hour}
+	p.Poll(ctx, b, now)
+
+	// only the closes after the stored history are fetched and published
+	if expected := []string{"GOOG 2015-05-29 2015-06-05", "MSFT 2015-06-03 2015-06-05"}; !reflect.DeepEqual(provider.fetched, expected) {
+		t.Errorf("Expected MSFT fetched from its last stored close and GOOG over the lookback, expected %v got %v", expected, provider.fetched)
+	}
+	published := []string{}
+	for len(sub.C) > 0 {
+		u := <-sub.C
+		published = append(published, u.Symbol+" "+stock.TimeForSQL(u.Measure.Time))
+	}
+	if expected := []string{"MSFT 2015-06-04", "MSFT 2015-06-05"}; !reflect.DeepEqual(published, expected) {
+		t.Errorf("Expected only new closes published and no history, expected %v got %v", expected, published)
+	}
+
+	// nothing is fetched again until there's a new close
+	p.Poll(ctx, b, now.Add(time.Minute))
+	if len(provider.fetched) != 2 {
+		t.Errorf("Expected no fetches without a new close, got %v", provider.fetched)
+	}
+	if len(sub.C) != 0 {
+		t.Errorf("Expected nothing published without a new close")
+	}
+
+	// storing measures that are already stored doesn't change the modification time
+	modified, _ := stock.DB.LastModifiedContext(ctx, msft)
+	if err := stock.DB.InsertContext(ctx, msft, &stored); err != nil {
+		t.Fatalf("Could not store span: %v", err)
+	}
+	if again, _ := stock.DB.LastModifiedContext(ctx, msft); !again.Equal(modified) {
+		t.Errorf("Expected unchanged measures to keep the modification time %v, got %v", modified, again)
+	}
+}
@@ -0,0 +1,362 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package stock
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrSymbolNotFound is returned for a symbol that isn't in the registry
+var ErrSymbolNotFound = errors.New("Unknown symbol")
+
+// Listing is a symbol's entry in the registry
+type Listing struct {
+	Symbol    string
+	Name      string
+	Exchange  string
+	Currency  string
+	AssetType string     // like stock, etf or fund
+	Sector    string     // empty for assets without one
+	Listed    *time.Time // nil if unknown
+	Delisted  *time.Time // nil while listed
+}
+
+// listing file columns, Symbol and Name are required and the rest may be left out
+var listingColumns = []string{"symbol", "name", "exchange", "currency", "asset_type", "sector", "listed", "delisted"}
+
+// ReadListings reads a CSV listing file with a header naming its columns,
+// any of symbol, name, exchange, currency, asset_type, sector, listed and
+// delisted in any order. Dates are YYYY-MM-DD, symbols are uppercased.
+func ReadListings(r io.Reader) ([]Listing, error) {
+	listings := []Listing{}
+	err := readCSV(r, "Listing", listingColumns[:2], func(line int, field func(string) string) error {
+		date := func(name string) (*time.Time, error) {
+			value := field(name)
+			if value == "" {
+				return nil, nil
+			}
+			t, err := time.Parse("2006-01-02", value)
+			if err != nil {
+				return nil, fmt.Errorf("Could not parse %s on line %d, must be YYYY-MM-DD [%s]", name, line, value)
+			}
+			return &t, nil
+		}
+
+		listing := Listing{
+			Symbol:    strings.ToUpper(field("symbol")),
+			Name:      field("name"),
+			Exchange:  field("exchange"),
+			Currency:  strings.ToUpper(field("currency")),
+			AssetType: strings.ToLower(field("asset_type")),
+			Sector:    field("sector"),
+		}
+		if listing.Symbol == "" {
+			return fmt.Errorf("Missing symbol on line %d", line)
+		}
+		var err error
+		if listing.Listed, err = date("listed"); err != nil {
+			return err
+		}
+		if listing.Delisted, err = date("delisted"); err != nil {
+			return err
+		}
+		listings = append(listings, listing)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return listings, nil
+}
+
+// readCSV reads a CSV file of kind with a header naming its columns in any
+// order, calling fn for each record after it with the record's line and its
+// fields by column name. Names are matched without case and fields are
+// trimmed, a column that isn't in the header is empty. The file must have the
+// required columns, reading stops at the first error from fn.
+func readCSV(r io.Reader, kind string, required []string, fn func(line int, field func(string) string) error) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("Could not read %s header: %v", strings.ToLower(kind), err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range required {
+		if _, ok := columns[name]; !ok {
+			return fmt.Errorf("%s file must have a %s column", kind, name)
+		}
+	}
+
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		if err := fn(line, field); err != nil {
+			return err
+		}
+	}
+}
+
+// LoadListings adds the listings to the registry, replacing any with the same symbol
+func LoadListings(ctx context.Context, listings []Listing) error {
+	if err := DB.InsertListings(ctx, listings); err != nil {
+		return err
+	}
+	registry.invalidate()
+	return nil
+}
+
+// how long the registry is cached, listings loaded by another process are
+// seen after at most this long
+const registryTTL = 5 * time.Minute
+
+// the store's listings, cached since every range checks its symbol
+type listingCache struct {
+	mu       sync.Mutex
+	store    Store
+	loaded   time.Time
+	bySymbol map[string]Listing
+	all      []Listing
+	changes  []SymbolChange
+}
+
+var registry listingCache
+
+func (c *listingCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.store = nil
+}
+
+// the cached listings, reloaded from DB once stale or when DB is replaced
+func (c *listingCache) get(ctx context.Context) (map[string]Listing, []Listing, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.refresh(ctx); err != nil {
+		return nil, nil, err
+	}
+	return c.bySymbol, c.all, nil
+}
+
+// the cached listings by symbol and symbol changes by date
+func (c *listingCache) history(ctx context.Context) (map[string]Listing, []SymbolChange, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.refresh(ctx); err != nil {
+		return nil, nil, err
+	}
+	return c.bySymbol, c.changes, nil
+}
+
+// reload from DB once stale or when DB is replaced, c.mu must be held
+func (c *listingCache) refresh(ctx context.Context) error {
+	if c.store == DB && time.Since(c.loaded) <= registryTTL {
+		return nil
+	}
+	listings, err := DB.GetListings(ctx)
+	if err != nil {
+		return err
+	}
+	changes, err := DB.GetSymbolChanges(ctx)
+	if err != nil {
+		return err
+	}
+	c.bySymbol = make(map[string]Listing, len(listings))
+	for _, listing := range listings {
+		c.bySymbol[listing.Symbol] = listing
+	}
+	c.all, c.changes, c.store, c.loaded = listings, changes, DB, time.Now()
+	return nil
+}
+
+// ListingsLoaded reports whether the registry has any listings
+func ListingsLoaded(ctx context.Context) (bool, error) {
+	bySymbol, _, err := registry.get(ctx)
+	return len(bySymbol) > 0, err
+}
+
+// LookupSymbol returns the symbol's listing, ErrSymbolNotFound if it isn't registered
+func LookupSymbol(ctx context.Context, symbol string) (*Listing, error) {
+	bySymbol, _, err := registry.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	listing, ok := bySymbol[strings.ToUpper(symbol)]
+	if !ok {
+		return nil, fmt.Errorf("%w [%s]", ErrSymbolNotFound, symbol)
+	}
+	return &listing, nil
+}
+
+// AllowUnlisted allows every symbol while the registry is empty, so a server
+// can run without a listing file. Otherwise every symbol is refused until
+// listings are loaded.
+var AllowUnlisted bool
+
+// CheckSymbol returns ErrSymbolNotFound for a symbol that isn't registered,
+// see AllowUnlisted for an empty registry
+func CheckSymbol(ctx context.Context, symbol string) error {
+	bySymbol, _, err := registry.get(ctx)
+	if err != nil || (len(bySymbol) == 0 && AllowUnlisted) {
+		return err
+	}
+	if _, ok := bySymbol[strings.ToUpper(symbol)]; !ok {
+		return fmt.Errorf("%w [%s]", ErrSymbolNotFound, symbol)
+	}
+	return nil
+}
+
+// SearchSymbols returns up to limit listings matching q, best first. Symbols
+// equal to q come first, then symbols starting with it, then names with a
+// word starting with it, then symbols and name words within an edit or two of
+// it for typos. Listed symbols come before delisted ones that match as well.
+func SearchSymbols(ctx context.Context, q string, limit int) ([]Listing, error) {
+	_, all, err := registry.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	q = strings.ToUpper(strings.TrimSpace(q))
+	if q == "" {
+		return []Listing{}, nil
+	}
+	type match struct {
+		listing Listing
+		score   int
+	}
+	matches := []match{}
+	for _, listing := range all {
+		if score, ok := matchScore(q, listing); ok {
+			matches = append(matches, match{listing, score})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if a.score != b.score {
+			return a.score < b.score
+		}
+		if (a.listing.Delisted == nil) != (b.listing.Delisted == nil) {
+			return a.listing.Delisted == nil
+		}
+		if len(a.listing.Symbol) != len(b.listing.Symbol) {
+			return len(a.listing.Symbol) < len(b.listing.Symbol)
+		}
+		return a.listing.Symbol < b.listing.Symbol
+	})
+
+	results := []Listing{}
+	for i := 0; i < len(matches) && i < limit; i++ {
+		results = append(results, matches[i].listing)
+	}
+	return results, nil
+}
+
+// how well q, uppercased, matches listing, lower is better
+func matchScore(q string, listing Listing) (int, bool) {
+	switch {
+	case listing.Symbol == q:
+		return 0, true
+	case strings.HasPrefix(listing.Symbol, q):
+		return 1, true
+	}
+	words := strings.Fields(strings.ToUpper(listing.Name))
+	for _, word := range words {
+		if strings.HasPrefix(word, q) {
+			return 2, true
+		}
+	}
+	if strings.HasPrefix(strings.ToUpper(listing.Name), q) {
+		return 2, true // a prefix spanning words
+	}
+
+	// typos, fewer allowed for short queries since everything is close to them
+	allowed := 0
+	switch {
+	case len(q) >= 6:
+		allowed = 2
+	case len(q) >= 3:
+		allowed = 1
+	}
+	best := allowed + 1
+	if d := editDistance(q, listing.Symbol); d < best {
+		best = d
+	}
+	for _, word := range words {
+		// compared as a prefix so a partly typed word still matches
+		if len(word) > len(q) {
+			word = word[:len(q)]
+		}
+		if d := editDistance(q, word); d < best {
+			best = d
+		}
+	}
+	return 2 + best, best <= allowed
+}
+
+// the Levenshtein distance between a and b
+func editDistance(a string, b string) int {
+	previous, current := make([]int, len(b)+1), make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+const upsertListingsSchema string = `INSERT INTO Symbols (Symbol, Name, Exchange, Currency, AssetType, Sector, Listed, Delisted) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) ON CONFLICT (Symbol) DO UPDATE SET Name = EXCLUDED.Name, Exchange = EXCLUDED.Exchange, Currency = EXCLUDED.Currency, AssetType = EXCLUDED.AssetType, Sector = EXCLUDED.Sector, Listed = EXCLUDED.Listed, Delisted = EXCLUDED.Delisted`
+const selectListingsSchema string = `SELECT Symbol, Name, Exchange, Currency, AssetType, Sector, Listed, Delisted FROM Symbols ORDER BY Symbol`
+
+// InsertListings adds or replaces listings in one transaction
+func (db *StockDB) InsertListings(ctx context.Context, listings []Listing) (err error) {
+	defer observeQuery("insert_listings", time.Now(), &err)
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, l := range listings {
+		if _, err := tx.ExecContext(ctx, upsertListingsSchema, l.Symbol, l.Name, l.Exchange, l.Currency, l.AssetType, l.Sector, l.Listed, l.Delisted); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetListings returns every listing ordered by symbol
+func (db *StockDB) GetListings(ctx context.Context) (listings []Listing, err error) {
+	defer observeQuery("get_listings", time.Now(), &err)
+	listings = []Listing{}
+	err = db.SelectContext(ctx, &listings, selectListingsSchema)
+	return listings, err
+}
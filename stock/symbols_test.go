@@ -0,0 +1,164 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package stock_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jhurwich/trendy/stock"
+	"github.com/jhurwich/trendy/testhelpers"
+)
+
+const testListings = `symbol,name,exchange,currency,asset_type,sector,listed,delisted
+GOOG,Alphabet Inc Class C,NASDAQ,usd,Stock,Communication Services,2014-04-03,
+googl, Alphabet Inc Class A,NASDAQ,USD,stock,Communication Services,2004-08-19,
+MSFT,Microsoft Corporation,NASDAQ,USD,stock,Technology,1986-03-13,
+MS,Morgan Stanley,NYSE,USD,stock,Financials,1993-02-23,
+SPY,SPDR S&P 500 ETF Trust,NYSE Arca,USD,etf,,1993-01-22,
+FB,Facebook Inc,NASDAQ,USD,stock,Communication Services,2012-05-18,2022-06-08
+`
+
+func TestReadListings(t *testing.T) {
+	t.Parallel()
+	listings, err := stock.ReadListings(strings.NewReader(testListings))
+	if err != nil {
+		t.Fatalf("Unexpected error reading listings: %v", err)
+	}
+	if len(listings) != 6 {
+		t.Fatalf("Expected 6 listings, got %d", len(listings))
+	}
+	googl := listings[1]
+	if googl.Symbol != "GOOGL" || googl.Name != "Alphabet Inc Class A" || googl.Currency != "USD" || googl.AssetType != "stock" ||
+		googl.Listed == nil || stock.TimeForSQL(*googl.Listed) != "2004-08-19" || googl.Delisted != nil {
+		t.Errorf("Unexpected listing %+v", googl)
+	}
+	if fb := listings[5]; fb.Delisted == nil || stock.TimeForSQL(*fb.Delisted) != "2022-06-08" {
+		t.Errorf("Expected FB to be delisted, got %+v", fb)
+	}
+
+	// columns in any order, any but symbol and name left out
+	listings, err = stock.ReadListings(strings.NewReader("Name,Symbol\nApple Inc,aapl\n"))
+	if err != nil || len(listings) != 1 || listings[0].Symbol != "AAPL" || listings[0].Listed != nil {
+		t.Errorf("Expected a listing with only a name and symbol, got %+v %v", listings, err)
+	}
+
+	for _, invalid := range []string{
+		"",
+		"symbol,exchange\nGOOG,NASDAQ\n",
+		"symbol,name\n,Nameless\n",
+		"symbol,name,listed\nGOOG,Alphabet,April 2014\n",
+	} {
+		if _, err := stock.ReadListings(strings.NewReader(invalid)); err == nil {
+			t.Errorf("Expected an error reading %q", invalid)
+		}
+	}
+}
+
+func TestSearchSymbols(t *testing.T) {
+	_, discard := testhelpers.UseStore(t)
+	defer discard()
+	listings, _ := stock.ReadListings(strings.NewReader(testListings))
+	if err := stock.LoadListings(context.Background(), listings); err != nil {
+		t.Fatalf("Could not load listings: %v", err)
+	}
+
+	var tests = []struct {
+		q        string
+		expected []string
+	}{
+		{"goog", []string{"GOOG", "GOOGL"}},
+		{"MS", []string{"MS", "MSFT"}},
+		{"alphabet", []string{"GOOG", "GOOGL"}},
+		{"micro", []string{"MSFT"}},
+		{"s&p", []string{"SPY"}},
+		{"microsft", []string{"MSFT"}}, // a typo
+		{"GOOF", []string{"GOOG"}},     // one edit from GOOG, two from GOOGL
+		{"x", []string{}},
+		{"  ", []string{}},
+	}
+	for _, test := range tests {
+		results, err := stock.SearchSymbols(context.Background(), test.q, 10)
+		if err != nil {
+			t.Fatalf("Unexpected error searching: %v", err)
+		}
+		symbols := []string{}
+		for _, listing := range results {
+			symbols = append(symbols, listing.Symbol)
+		}
+		if strings.Join(symbols, ",") != strings.Join(test.expected, ",") {
+			t.Errorf("Expected %v for %q, got %v", test.expected, test.q, symbols)
+		}
+	}
+
+	// delisted symbols come after listed ones that match as well
+	stock.LoadListings(context.Background(), []stock.Listing{{Symbol: "FBX", Name: "Other"}})
+	if results, _ := stock.SearchSymbols(context.Background(), "FB", 10); len(results) != 2 || results[0].Symbol != "FB" {
+		t.Errorf("Expected the exact match first, got %v", results)
+	}
+	if results, _ := stock.SearchSymbols(context.Background(), "G", 1); len(results) != 1 {
+		t.Errorf("Expected results to be limited, got %v", results)
+	}
+}
+
+func TestCheckSymbol(t *testing.T) {
+	_, discard := testhelpers.UseStore(t)
+	defer discard()
+	defaultProvider := stock.DefaultProvider
+	provider := &countingProvider{}
+	stock.DefaultProvider = provider
+	defer func() { stock.DefaultProvider = defaultProvider }()
+	ctx := context.Background()
+	start, end := time.Date(2015, time.June, 1, 0, 0, 0, 0, time.UTC), time.Date(2015, time.June, 30, 0, 0, 0, 0, time.UTC)
+
+	// without a registry every symbol is allowed, if unlisted symbols are
+	if err := stock.CheckSymbol(ctx, "ANYTHING"); err != nil {
+		t.Errorf("Expected symbols to be allowed before listings are loaded, got %v", err)
+	}
+	stock.AllowUnlisted = false
+	err := stock.CheckSymbol(ctx, "ANYTHING")
+	stock.AllowUnlisted = true
+	if !errors.Is(err, stock.ErrSymbolNotFound) {
+		t.Errorf("Expected symbols to be refused before listings are loaded unless allowed, got %v", err)
+	}
+
+	if err := stock.LoadListings(ctx, []stock.Listing{{Symbol: "GOOG", Name: "Alphabet Inc Class C"}}); err != nil {
+		t.Fatalf("Could not load listings: %v", err)
+	}
+	if err := stock.CheckSymbol(ctx, "goog"); err != nil {
+		t.Errorf("Expected GOOG to be allowed, got %v", err)
+	}
+	if listing, err := stock.LookupSymbol(ctx, "GOOG"); err != nil || listing.Name != "Alphabet Inc Class C" {
+		t.Errorf("Expected GOOG's listing, got %v %v", listing, err)
+	}
+
+	// unknown symbols are refused before the provider is asked
+	_, err = stock.NewStock("NOPE").RangeContext(ctx, start, end)
+	if !errors.Is(err, stock.ErrSymbolNotFound) {
+		t.Errorf("Expected ErrSymbolNotFound for an unknown symbol, got %v", err)
+	}
+	// populating without a range, as the scheduler, backfills and streams do, as well
+	if _, err := stock.NewStock("NOPE").Populate(start, end); !errors.Is(err, stock.ErrSymbolNotFound) {
+		t.Errorf("Expected ErrSymbolNotFound populating an unknown symbol, got %v", err)
+	}
+	if provider.fetches != 0 {
+		t.Errorf("Expected no fetches for an unknown symbol, got %d", provider.fetches)
+	}
+	if _, err := stock.NewStock("GOOG").RangeContext(ctx, start, end); err != nil || provider.fetches != 1 {
+		t.Errorf("Expected GOOG to be fetched, got %v after %d fetches", err, provider.fetches)
+	}
+}
+
+// a SyntheticProvider that counts its fetches
+type countingProvider struct {
+	stock.SyntheticProvider
+	fetches int
+}
+
+func (p *countingProvider) FetchContext(ctx context.Context, s *stock.Stock, startDate time.Time, endDate time.Time) (stock.Span, error) {
+	p.fetches++
+	return p.SyntheticProvider.FetchContext(ctx, s, startDate, endDate)
+}
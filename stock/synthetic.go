@@ -0,0 +1,169 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package stock
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// trading days in a year, used to scale annualized parameters to daily steps
+const tradingDaysPerYear = 252
+
+// Process moves a price forward by one step of dt years
+type Process interface {
+	Step(price float64, dt float64, rng *rand.Rand) float64
+}
+
+// GBM is geometric Brownian motion with annualized Drift and Volatility
+type GBM struct {
+	Drift      float64
+	Volatility float64
+}
+
+func (p GBM) Step(price float64, dt float64, rng *rand.Rand) float64 {
+	return price * math.Exp((p.Drift-p.Volatility*p.Volatility/2)*dt+p.Volatility*math.Sqrt(dt)*rng.NormFloat64())
+}
+
+// OrnsteinUhlenbeck reverts the price towards Mean at Speed per year
+type OrnsteinUhlenbeck struct {
+	Mean       float64
+	Speed      float64
+	Volatility float64
+}
+
+func (p OrnsteinUhlenbeck) Step(price float64, dt float64, rng *rand.Rand) float64 {
+	next := price + p.Speed*(p.Mean-price)*dt + p.Volatility*math.Sqrt(dt)*rng.NormFloat64()
+	return math.Max(next, 0.01) // prices stay positive
+}
+
+// JumpDiffusion is GBM with jumps, Intensity jumps per year on average each
+// scaling the price by exp of a normal draw with JumpMean and JumpStdDev
+type JumpDiffusion struct {
+	GBM
+	Intensity  float64
+	JumpMean   float64
+	JumpStdDev float64
+}
+
+func (p JumpDiffusion) Step(price float64, dt float64, rng *rand.Rand) float64 {
+	price = p.GBM.Step(price, dt, rng)
+	if rng.Float64() < p.Intensity*dt {
+		price *= math.Exp(p.JumpMean + p.JumpStdDev*rng.NormFloat64())
+	}
+	return price
+}
+
+// Regime is a Process that takes over from Day, the index of a trading day
+type Regime struct {
+	Day     int
+	Process Process
+}
+
+// Generator produces realistic, reproducible Spans for tests, benchmarks and
+// demos. Measures are made for every weekday from Start, the same Seed always
+// gives the same Span. Regimes change the process at known breakpoints.
+type Generator struct {
+	Seed    int64
+	Start   time.Time
+	Price   float64  // price on the first day
+	Regimes []Regime // sorted by Day, the first should start at Day 0
+}
+
+// Generate a span of days trading days, with the dates each regime after the first began
+func (g *Generator) Generate(days int) (Span, []time.Time) {
+	rng := rand.New(rand.NewSource(g.Seed))
+	// bars have their own source so the closes don't depend on them
+	bars := rand.New(rand.NewSource(g.Seed + 1))
+	regimes := append([]Regime{}, g.Regimes...)
+	sort.SliceStable(regimes, func(i, j int) bool { return regimes[i].Day < regimes[j].Day })
+
+	span := make(Span, 0, days)
+	breakpoints := []time.Time{}
+	price, day, regime := g.Price, tradingDay(g.Start), -1
+	for i := 0; i < days; i++ {
+		for regime+1 < len(regimes) && regimes[regime+1].Day <= i {
+			regime++
+			if regime > 0 {
+				breakpoints = append(breakpoints, day)
+			}
+		}
+		previous := price
+		if i > 0 && regime >= 0 {
+			price = regimes[regime].Process.Step(price, 1.0/tradingDaysPerYear, rng)
+		}
+		span = append(span, bar(day, previous, price, bars))
+		day = tradingDay(day.AddDate(0, 0, 1))
+	}
+	return span, breakpoints
+}
+
+// a day's bar closing at price, opening near the previous close with the high
+// and low beyond both and a volume that's larger on bigger moves
+func bar(day time.Time, previous float64, price float64, rng *rand.Rand) Measure {
+	open := previous * math.Exp(0.005*rng.NormFloat64())
+	high := math.Max(open, price) * (1 + 0.01*math.Abs(rng.NormFloat64()))
+	low := math.Min(open, price) * (1 - 0.01*math.Abs(rng.NormFloat64()))
+	move := math.Abs(math.Log(price / previous))
+	volume := 1e6 * (1 + 50*move) * math.Exp(0.3*rng.NormFloat64())
+	return Measure{Time: day, Value: float32(price), Open: float32(open), High: float32(high), Low: float32(low), Volume: int64(volume)}
+}
+
+// the first weekday at or after t, as a date in UTC
+func tradingDay(t time.Time) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	for day.Weekday() == time.Saturday || day.Weekday() == time.Sunday {
+		day = day.AddDate(0, 0, 1)
+	}
+	return day
+}
+
+// SyntheticProvider is a Provider for demos that needs no external data. Each
+// symbol gets its own generated history from SyntheticEpoch, seeded by the
+// symbol so repeated requests agree.
+type SyntheticProvider struct{}
+
+var SyntheticEpoch = time.Date(2000, time.January, 3, 0, 0, 0, 0, time.UTC)
+
+func (p SyntheticProvider) Fetch(s *Stock, startDate time.Time, endDate time.Time) (Span, error) {
+	return p.FetchContext(context.Background(), s, startDate, endDate)
+}
+
+func (p SyntheticProvider) FetchContext(ctx context.Context, s *Stock, startDate time.Time, endDate time.Time) (Span, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if !startDate.Before(endDate) || endDate.Before(SyntheticEpoch) {
+		return nil, ErrNoData
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(s.Symbol))
+	seed := int64(h.Sum64())
+	rng := rand.New(rand.NewSource(seed))
+
+	// a few regimes a decade with parameters drawn for the symbol
+	g := Generator{Seed: seed, Start: SyntheticEpoch, Price: 10 + rng.Float64()*190}
+	days := int(endDate.Sub(SyntheticEpoch).Hours()/24) + 1
+	for day := 0; day < days; day += 500 + rng.Intn(500) {
+		g.Regimes = append(g.Regimes, Regime{Day: day, Process: JumpDiffusion{
+			GBM:        GBM{Drift: rng.NormFloat64() * 0.15, Volatility: 0.15 + rng.Float64()*0.3},
+			Intensity:  2,
+			JumpMean:   -0.02,
+			JumpStdDev: 0.05,
+		}})
+	}
+	span, _ := g.Generate(days)
+
+	// keep the requested days, the span is sorted so search for the bounds
+	start := sort.Search(len(span), func(i int) bool { return !span[i].Time.Before(tradingDay(startDate)) })
+	end := sort.Search(len(span), func(i int) bool { return span[i].Time.After(endDate) })
+	if start >= end {
+		return nil, ErrNoData
+	}
+	return span[start:end], nil
+}
@@ -0,0 +1,210 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package stock_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/jhurwich/trendy/stock"
+)
+
+var syntheticStart = time.Date(2010, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+func TestGeneratorReproducible(t *testing.T) {
+	t.Parallel()
+	generator := func(seed int64) *stock.Generator {
+		return &stock.Generator{
+			Seed:    seed,
+			Start:   syntheticStart,
+			Price:   100,
+			Regimes: []stock.Regime{{Day: 0, Process: stock.GBM{Drift: 0.05, Volatility: 0.2}}},
+		}
+	}
+
+	first, _ := generator(1).Generate(500)
+	second, _ := generator(1).Generate(500)
+	other, _ := generator(2).Generate(500)
+	if !first.Equal(second) {
+		t.Errorf("Same seed generated different spans")
+	}
+	if first.Equal(other) {
+		t.Errorf("Different seeds generated the same span")
+	}
+
+	if len(first) != 500 {
+		t.Fatalf("Generated %d measures, expected 500", len(first))
+	}
+	if first[0].Value != 100 {
+		t.Errorf("First measure %v, expected the starting price 100", first[0].Value)
+	}
+	for i, m := range first {
+		if m.Time.Weekday() == time.Saturday || m.Time.Weekday() == time.Sunday {
+			t.Errorf("Measure on a weekend: %v", m.Time)
+		}
+		if i > 0 && !m.Time.After(first[i-1].Time) {
+			t.Errorf("Measures out of order: %v then %v", first[i-1].Time, m.Time)
+		}
+		if m.Value <= 0 {
+			t.Errorf("Non-positive price %v on %v", m.Value, m.Time)
+		}
+	}
+}
+
+func TestGeneratorProcesses(t *testing.T) {
+	t.Parallel()
+	const days = 252 * 40
+
+	// mean daily log return of GBM is (drift - volatility^2/2) / 252
+	gbm := stock.GBM{Drift: 0.1, Volatility: 0.2}
+	span, _ := (&stock.Generator{Seed: 7, Start: syntheticStart, Price: 100,
+		Regimes: []stock.Regime{{Day: 0, Process: gbm}}}).Generate(days)
+	returns := logReturns(span)
+	expected := (gbm.Drift - gbm.Volatility*gbm.Volatility/2) / 252
+	if got := mean(returns); math.Abs(got-expected) > 0.0005 {
+		t.Errorf("GBM mean daily log return %v, expected about %v", got, expected)
+	}
+	if got, expected := stddev(returns), gbm.Volatility/math.Sqrt(252); math.Abs(got-expected) > 0.001 {
+		t.Errorf("GBM daily volatility %v, expected about %v", got, expected)
+	}
+
+	// Ornstein-Uhlenbeck started far from its mean ends up around it
+	ou := stock.OrnsteinUhlenbeck{Mean: 50, Speed: 5, Volatility: 5}
+	span, _ = (&stock.Generator{Seed: 7, Start: syntheticStart, Price: 150,
+		Regimes: []stock.Regime{{Day: 0, Process: ou}}}).Generate(days)
+	values := []float64{}
+	for _, m := range span[252:] {
+		values = append(values, float64(m.Value))
+	}
+	if got := mean(values); math.Abs(got-ou.Mean) > 1 {
+		t.Errorf("Ornstein-Uhlenbeck mean %v, expected about %v", got, ou.Mean)
+	}
+
+	// jumps show up as returns far outside what the diffusion could produce
+	jumps := stock.JumpDiffusion{GBM: stock.GBM{Volatility: 0.1}, Intensity: 10, JumpMean: -0.2, JumpStdDev: 0.01}
+	span, _ = (&stock.Generator{Seed: 7, Start: syntheticStart, Price: 100,
+		Regimes: []stock.Regime{{Day: 0, Process: jumps}}}).Generate(days)
+	count := 0
+	for _, r := range logReturns(span) {
+		if r < -0.1 {
+			count++
+		}
+	}
+	if expected := jumps.Intensity * days / 252; math.Abs(float64(count)-expected) > expected/4 {
+		t.Errorf("Found %d jumps, expected about %v", count, expected)
+	}
+}
+
+func TestGeneratorRegimes(t *testing.T) {
+	t.Parallel()
+	g := &stock.Generator{
+		Seed:  3,
+		Start: syntheticStart,
+		Price: 100,
+		Regimes: []stock.Regime{
+			{Day: 0, Process: stock.GBM{Drift: 0.5, Volatility: 0.05}},
+			{Day: 250, Process: stock.GBM{Drift: -0.5, Volatility: 0.05}},
+			{Day: 400, Process: stock.OrnsteinUhlenbeck{Mean: 80, Speed: 10, Volatility: 1}},
+		},
+	}
+	span, breakpoints := g.Generate(600)
+
+	if len(breakpoints) != 2 {
+		t.Fatalf("Found %d breakpoints, expected 2: %v", len(breakpoints), breakpoints)
+	}
+	if !breakpoints[0].Equal(span[250].Time) || !breakpoints[1].Equal(span[400].Time) {
+		t.Errorf("Breakpoints %v, expected %v and %v", breakpoints, span[250].Time, span[400].Time)
+	}
+
+	// rising, then falling, then settled around the mean
+	if span[249].Value <= span[0].Value {
+		t.Errorf("Price fell during the rising regime: %v to %v", span[0].Value, span[249].Value)
+	}
+	if span[399].Value >= span[250].Value {
+		t.Errorf("Price rose during the falling regime: %v to %v", span[250].Value, span[399].Value)
+	}
+	if math.Abs(float64(span[599].Value)-80) > 5 {
+		t.Errorf("Price %v at the end, expected about 80", span[599].Value)
+	}
+}
+
+func TestSyntheticProvider(t *testing.T) {
+	t.Parallel()
+	provider := stock.SyntheticProvider{}
+	start := time.Date(2014, time.March, 3, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2014, time.June, 30, 0, 0, 0, 0, time.UTC)
+
+	span, err := provider.Fetch(stock.NewStock("GOOG"), start, end)
+	if err != nil {
+		t.Fatalf("Error fetching synthetic span: %v", err)
+	}
+	if span[0].Time.Before(start) || span[len(span)-1].Time.After(end) {
+		t.Errorf("Span from %v to %v is outside %v to %v", span[0].Time, span[len(span)-1].Time, start, end)
+	}
+
+	// the same symbol agrees with itself over overlapping ranges, other symbols differ
+	later, err := provider.Fetch(stock.NewStock("GOOG"), start.AddDate(0, 1, 0), end.AddDate(1, 0, 0))
+	if err != nil {
+		t.Fatalf("Error fetching synthetic span: %v", err)
+	}
+	last := span[len(span)-1]
+	if i := indexOf(later, last.Time); i < 0 || !later[i].Equal(last) {
+		t.Errorf("Synthetic spans for the same symbol disagree")
+	}
+	other, _ := provider.Fetch(stock.NewStock("AAPL"), start, end)
+	if span.Equal(other) {
+		t.Errorf("Synthetic spans for different symbols are the same")
+	}
+
+	if _, err := provider.Fetch(stock.NewStock("GOOG"), time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(1991, 1, 1, 0, 0, 0, 0, time.UTC)); err != stock.ErrNoData {
+		t.Errorf("Expected ErrNoData before the synthetic epoch, got %v", err)
+	}
+}
+
+func BenchmarkGenerate(b *testing.B) {
+	g := &stock.Generator{
+		Seed:  1,
+		Start: syntheticStart,
+		Price: 100,
+		Regimes: []stock.Regime{
+			{Day: 0, Process: stock.JumpDiffusion{GBM: stock.GBM{Drift: 0.05, Volatility: 0.2}, Intensity: 2, JumpMean: -0.02, JumpStdDev: 0.05}},
+		},
+	}
+	for i := 0; i < b.N; i++ {
+		g.Generate(252 * 10)
+	}
+}
+
+func logReturns(span stock.Span) []float64 {
+	returns := []float64{}
+	for i := 1; i < len(span); i++ {
+		returns = append(returns, math.Log(float64(span[i].Value)/float64(span[i-1].Value)))
+	}
+	return returns
+}
+
+func mean(values []float64) float64 {
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+func stddev(values []float64) float64 {
+	m, sum := mean(values), 0.0
+	for _, v := range values {
+		sum += (v - m) * (v - m)
+	}
+	return math.Sqrt(sum / float64(len(values)))
+}
+
+func indexOf(span stock.Span, t time.Time) int {
+	for i, m := range span {
+		if m.Time.Equal(t) {
+			return i
+		}
+	}
+	return -1
+}
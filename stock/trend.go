@@ -0,0 +1,66 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package stock
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrTooFewMeasures is returned when a span has fewer than two measures to fit a trend to
+var ErrTooFewMeasures = errors.New("At least two measures are needed for a trend")
+
+// Trend is the least squares line through a span's values, with days since
+// Start as x. Change is the difference between the last and first values.
+type Trend struct {
+	Start       time.Time
+	End         time.Time
+	SlopePerDay float64
+	Intercept   float64 // the fitted value at Start
+	RSquared    float64 // 1 for a perfect fit, 0 for one no better than the mean
+	Change      float64
+}
+
+// Trend fits a line to the span's measures
+func (s Span) Trend() (Trend, error) {
+	if len(s) < 2 {
+		return Trend{}, ErrTooFewMeasures
+	}
+	s = sorted(s)
+
+	start, end := s[0].Time, s[len(s)-1].Time
+	n := float64(len(s))
+	var sumX, sumY, sumXY, sumXX float64
+	for _, measure := range s {
+		x, y := measure.Time.Sub(start).Hours()/24, float64(measure.Value)
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+
+	trend := Trend{Start: start, End: end, Change: float64(s[len(s)-1].Value - s[0].Value)}
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		// every measure is on the same day
+		return Trend{}, ErrTooFewMeasures
+	}
+	trend.SlopePerDay = (n*sumXY - sumX*sumY) / denominator
+	trend.Intercept = (sumY - trend.SlopePerDay*sumX) / n
+
+	mean := sumY / n
+	var residual, total float64
+	for _, measure := range s {
+		x, y := measure.Time.Sub(start).Hours()/24, float64(measure.Value)
+		fitted := trend.Intercept + trend.SlopePerDay*x
+		residual += (y - fitted) * (y - fitted)
+		total += (y - mean) * (y - mean)
+	}
+	if total > 0 {
+		trend.RSquared = 1 - residual/total
+	} else {
+		// a flat span is fit perfectly by a flat line
+		trend.RSquared = 1
+	}
+	return trend, nil
+}
@@ -0,0 +1,79 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package stock_test
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/jhurwich/trendy/stock"
+	"github.com/jhurwich/trendy/testhelpers"
+)
+
+func TestSpanTrend(t *testing.T) {
+	t.Parallel()
+	day := func(d int) time.Time { return time.Date(2015, time.June, d, 0, 0, 0, 0, time.UTC) }
+	span := func(values ...float32) stock.Span {
+		s := stock.Span{}
+		for i, value := range values {
+			s = append(s, stock.Measure{Time: day(i + 1), Value: value})
+		}
+		return s
+	}
+
+	var tests = []struct {
+		name  string
+		span  stock.Span
+		trend stock.Trend
+	}{
+		{"rising line", span(10, 12, 14, 16), stock.Trend{Start: day(1), End: day(4), SlopePerDay: 2, Intercept: 10, RSquared: 1, Change: 6}},
+		{"flat", span(5, 5, 5), stock.Trend{Start: day(1), End: day(3), SlopePerDay: 0, Intercept: 5, RSquared: 1, Change: 0}},
+		{"noisy fall", span(4, 2, 3, 1), stock.Trend{Start: day(1), End: day(4), SlopePerDay: -0.8, Intercept: 3.7, RSquared: 0.64, Change: -3}},
+		{"unsorted", stock.Span{{Time: day(3), Value: 3}, {Time: day(1), Value: 1}}, stock.Trend{Start: day(1), End: day(3), SlopePerDay: 1, Intercept: 1, RSquared: 1, Change: 2}},
+	}
+
+	near := func(a float64, b float64) bool { return math.Abs(a-b) < 1e-6 }
+	for _, test := range tests {
+		trend, err := test.span.Trend()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if !trend.Start.Equal(test.trend.Start) || !trend.End.Equal(test.trend.End) ||
+			!near(trend.SlopePerDay, test.trend.SlopePerDay) || !near(trend.Intercept, test.trend.Intercept) ||
+			!near(trend.RSquared, test.trend.RSquared) || !near(trend.Change, test.trend.Change) {
+			t.Errorf("%s: expected trend %+v, got %+v", test.name, test.trend, trend)
+		}
+	}
+
+	for _, short := range []stock.Span{nil, span(1), {{Time: day(1), Value: 1}, {Time: day(1), Value: 2}}} {
+		if _, err := short.Trend(); err != stock.ErrTooFewMeasures {
+			t.Errorf("Expected ErrTooFewMeasures for %v, got %v", short, err)
+		}
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	_, discard := testhelpers.UseStore(t)
+	defer discard()
+	defaultProvider := stock.DefaultProvider
+	stock.DefaultProvider = stock.SyntheticProvider{}
+	defer func() { stock.DefaultProvider = defaultProvider }()
+
+	start, end := time.Date(2015, time.June, 1, 0, 0, 0, 0, time.UTC), time.Date(2015, time.June, 30, 0, 0, 0, 0, time.UTC)
+	s := stock.NewStock("TRND")
+	trend, err := s.AnalyzeContext(context.Background(), start, end)
+	if err != nil {
+		t.Fatalf("Unexpected error analyzing: %v", err)
+	}
+	span, err := s.Range(start, end)
+	if err != nil {
+		t.Fatalf("Unexpected error getting range: %v", err)
+	}
+	expected, _ := span.Trend()
+	if trend != expected {
+		t.Errorf("Expected Analyze to fit the range %+v, got %+v", expected, trend)
+	}
+}
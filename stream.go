@@ -0,0 +1,122 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/jhurwich/trendy/stock"
+)
+
+// broker shared by all streaming connections, feeds started in main publish to it
+var streamBroker = stock.NewBroker()
+
+// closed by closeStreams when the server shuts down, ending the streams open
+// at the time, streams opened after get a new channel
+var streams = struct {
+	sync.Mutex
+	closed chan struct{}
+}{closed: make(chan struct{})}
+
+func streamsClosed() <-chan struct{} {
+	streams.Lock()
+	defer streams.Unlock()
+	return streams.closed
+}
+
+func closeStreams() {
+	streams.Lock()
+	defer streams.Unlock()
+	close(streams.closed)
+	streams.closed = make(chan struct{})
+}
+
+const (
+	streamBuffer    = 64               // updates queued per connection before the oldest are dropped
+	streamHeartbeat = 15 * time.Second // keeps idle connections from being closed by proxies
+)
+
+// queryValues must include "symbols", comma separated
+// responds with a server-sent event stream, each "measure" event holds one update
+// as JSON and a "dropped" event reports updates discarded because the client fell behind
+func GetStream(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	symbols := parseSymbols(r.URL.Query().Get("symbols"))
+	if len(symbols) == 0 {
+		http.Error(w, "Must provide symbols to stream, comma separated [symbols=]", http.StatusBadRequest)
+		return
+	}
+	for _, symbol := range symbols {
+		if err := stock.CheckSymbol(r.Context(), symbol); errors.Is(err, stock.ErrSymbolNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, "Could not check symbols", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported on this connection", http.StatusInternalServerError)
+		return
+	}
+
+	sub := streamBroker.Subscribe(symbols, streamBuffer)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	closed := streamsClosed()
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-closed:
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		case update, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if dropped := sub.Dropped(); dropped > 0 {
+				fmt.Fprintf(w, "event: dropped\ndata: %d\n\n", dropped)
+			}
+			data, err := json.Marshal(newSymbolMeasure(update.Symbol, update.Measure))
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: measure\ndata: %s\n\n", data)
+		}
+		flusher.Flush()
+	}
+}
+
+// split a comma separated symbol list, dropping blanks and duplicates
+func parseSymbols(str string) []string {
+	symbols := []string{}
+	seen := map[string]bool{}
+	for _, sym := range strings.Split(str, ",") {
+		sym = strings.TrimSpace(sym)
+		if sym == "" || seen[sym] {
+			continue
+		}
+		seen[sym] = true
+		symbols = append(symbols, sym)
+	}
+	return symbols
+}
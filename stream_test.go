@@ -0,0 +1,116 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/jhurwich/trendy/stock"
+	"github.com/jhurwich/trendy/testhelpers"
+)
+
+func TestParseSymbols(t *testing.T) {
+	t.Parallel()
+	var tests = []struct {
+		str      string
+		expected []string
+	}{
+		{"", []string{}},
+		{"GOOG", []string{"GOOG"}},
+		{"GOOG,MSFT", []string{"GOOG", "MSFT"}},
+		{" GOOG , ,MSFT,GOOG", []string{"GOOG", "MSFT"}},
+	}
+	for _, test := range tests {
+		if symbols := parseSymbols(test.str); !reflect.DeepEqual(symbols, test.expected) {
+			t.Errorf("parseSymbols(%q) expected %v got %v", test.str, test.expected, symbols)
+		}
+	}
+}
+
+func TestGetStream(t *testing.T) {
+	_, discard := testhelpers.UseStore(t)
+	defer discard()
+	router := httprouter.New()
+	router.GET("/stream", GetStream)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	// symbols are required
+	r, err := http.Get(ts.URL + "/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Body.Close()
+	if r.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected %d without symbols, got %d", http.StatusBadRequest, r.StatusCode)
+	}
+
+	// unknown symbols are refused once listings are loaded
+	stock.LoadListings(context.Background(), []stock.Listing{{Symbol: "STREAMTEST", Name: "Stream Test"}})
+	r, err = http.Get(ts.URL + "/stream?symbols=STREAMTEST,BOGUS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Body.Close()
+	if r.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected %d for an unknown symbol, got %d", http.StatusNotFound, r.StatusCode)
+	}
+
+	r, err = http.Get(ts.URL + "/stream?symbols=STREAMTEST")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK || r.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Expected event stream, got %d %s", r.StatusCode, r.Header.Get("Content-Type"))
+	}
+
+	// the subscription is registered once headers are flushed, publish until it's seen
+	measure := stock.Measure{Time: time.Date(2015, time.June, 1, 12, 0, 0, 0, time.UTC), Value: 1.5}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(10 * time.Millisecond):
+				streamBroker.Publish(stock.Update{Symbol: "STREAMTEST", Measure: measure})
+			}
+		}
+	}()
+
+	lines := bufio.NewScanner(r.Body)
+	var event, data string
+	for lines.Scan() {
+		line := lines.Text()
+		if strings.HasPrefix(line, "event: ") {
+			event = strings.TrimPrefix(line, "event: ")
+		} else if strings.HasPrefix(line, "data: ") {
+			data = strings.TrimPrefix(line, "data: ")
+		} else if line == "" && event == "measure" {
+			break
+		}
+	}
+	expected := `{"Symbol":"STREAMTEST","Time":"2015-06-01T12:00:00Z","Value":1.5}`
+	if data != expected {
+		t.Errorf("Unexpected measure event data, expected:\n%s\ngot:\n%s\n", expected, data)
+	}
+
+	// shutting down ends the stream
+	closeStreams()
+	for lines.Scan() {
+	}
+	if err := lines.Err(); err != nil {
+		t.Errorf("Expected the stream to end cleanly on shutdown, got %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/jhurwich/trendy/stock"
+)
+
+const (
+	defaultSearchLimit = 10
+	maxSearchLimit     = 100
+)
+
+// LoadSymbols adds the listings in the CSV file at path to the symbol registry
+func LoadSymbols(ctx context.Context, path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	listings, err := stock.ReadListings(f)
+	if err != nil {
+		return 0, fmt.Errorf("Could not read listings from %s: %v", path, err)
+	}
+	return len(listings), stock.LoadListings(ctx, listings)
+}
+
+// LoadSymbolChanges adds the symbol changes in the CSV file at path to the symbol registry
+func LoadSymbolChanges(ctx context.Context, path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	changes, err := stock.ReadSymbolChanges(f)
+	if err != nil {
+		return 0, fmt.Errorf("Could not read symbol changes from %s: %v", path, err)
+	}
+	return len(changes), stock.LoadSymbolChanges(ctx, changes)
+}
+
+// LoadFX stores the exchange rates in the CSV file at path, for converting prices
+func LoadFX(ctx context.Context, path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	rates, err := stock.ReadRates(f)
+	if err != nil {
+		return 0, fmt.Errorf("Could not read exchange rates from %s: %v", path, err)
+	}
+	return len(rates), stock.LoadRates(ctx, rates)
+}
+
+// GetSymbolSearch responds with the listings best matching q, for autocomplete.
+// queryValues include "q" and may include "limit".
+func GetSymbolSearch(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	queryValues := r.URL.Query()
+	q := queryValues.Get("q")
+	if q == "" {
+		http.Error(w, "Must provide q to search for", http.StatusBadRequest)
+		return
+	}
+	limit := defaultSearchLimit
+	if value := queryValues.Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxSearchLimit {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d [%s]", maxSearchLimit, value), http.StatusBadRequest)
+			return
+		}
+	}
+
+	listings, err := stock.SearchSymbols(r.Context(), q, limit)
+	if err != nil {
+		http.Error(w, "Could not search symbols", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, listings)
+}
@@ -0,0 +1,97 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jhurwich/trendy/config"
+	"github.com/jhurwich/trendy/stock"
+	"github.com/jhurwich/trendy/testhelpers"
+)
+
+func TestSymbols(t *testing.T) {
+	_, discard := testhelpers.UseStore(t)
+	defer discard()
+	defaultProvider := stock.DefaultProvider
+	stock.DefaultProvider = stock.SyntheticProvider{}
+	defer func() { stock.DefaultProvider = defaultProvider }()
+	server := NewTrendyServer(&config.Config{Local: true})
+
+	path := filepath.Join(t.TempDir(), "listings.csv")
+	os.WriteFile(path, []byte("symbol,name,exchange,currency\nGOOG,Alphabet Inc Class C,NASDAQ,USD\nGOOGL,Alphabet Inc Class A,NASDAQ,USD\nMSFT,Microsoft Corporation,NASDAQ,USD\nFB,Facebook Inc,NASDAQ,USD\nMETA,Meta Platforms Inc,NASDAQ,USD\nGONE,Gone Inc,NYSE,USD\n"), 0600)
+	if n, err := LoadSymbols(context.Background(), path); err != nil || n != 6 {
+		t.Fatalf("Expected 6 listings loaded, got %d %v", n, err)
+	}
+	if _, err := LoadSymbols(context.Background(), filepath.Join(t.TempDir(), "missing.csv")); err == nil {
+		t.Errorf("Expected an error loading a missing file")
+	}
+
+	changesPath := filepath.Join(t.TempDir(), "changes.csv")
+	os.WriteFile(changesPath, []byte("symbol,new_symbol,date\nFB,META,2022-06-09\n"), 0600)
+	if n, err := LoadSymbolChanges(context.Background(), changesPath); err != nil || n != 1 {
+		t.Fatalf("Expected 1 symbol change loaded, got %d %v", n, err)
+	}
+	fxPath := filepath.Join(t.TempDir(), "fx.csv")
+	os.WriteFile(fxPath, []byte("from,to,date,rate\nEUR,USD,2015-06-01,1.1\nEUR,USD,2015-06-02,1.2\n"), 0600)
+	if n, err := LoadFX(context.Background(), fxPath); err != nil || n != 2 {
+		t.Fatalf("Expected 2 exchange rates loaded, got %d %v", n, err)
+	}
+	if rates, _ := stock.DB.GetRange(stock.NewStock(stock.FXSymbol("EUR", "USD")), time.Time{}, time.Now()); len(rates) != 2 {
+		t.Errorf("Expected the loaded rates stored, got %v", rates)
+	}
+	delisted := time.Date(2015, time.June, 30, 0, 0, 0, 0, time.UTC)
+	stock.LoadListings(context.Background(), []stock.Listing{{Symbol: "GONE", Name: "Gone Inc", Delisted: &delisted}})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		r.Header.Set(authKeyHeader, devKey)
+		r.Header.Set(authSecretHeader, devSecret)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get("/symbols/search?q=alphabet&limit=1")
+	listings := []stock.Listing{}
+	if err := json.Unmarshal(w.Body.Bytes(), &listings); w.Code != http.StatusOK || err != nil {
+		t.Fatalf("Expected listings, got %d %v", w.Code, err)
+	}
+	if len(listings) != 1 || listings[0].Symbol != "GOOG" || listings[0].Exchange != "NASDAQ" {
+		t.Errorf("Expected GOOG's listing, got %+v", listings)
+	}
+	for _, path := range []string{"/symbols/search", "/symbols/search?q=goog&limit=0", "/symbols/search?q=goog&limit=many"} {
+		if w := get(path); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected %d for %s, got %d", http.StatusBadRequest, path, w.Code)
+		}
+	}
+
+	// registered symbols are served, unknown ones are not found
+	if w := get("/stock/MSFT?start=2015-06-01&end=2015-06-30"); w.Code != http.StatusOK {
+		t.Errorf("Expected %d for a registered symbol, got %d", http.StatusOK, w.Code)
+	}
+	for _, path := range []string{"/stock/NOPE?start=2015-06-01&end=2015-06-30", "/stock/NOPE/chart.svg"} {
+		if w := get(path); w.Code != http.StatusNotFound {
+			t.Errorf("Expected %d for %s, got %d", http.StatusNotFound, path, w.Code)
+		}
+	}
+
+	// a range across a symbol change is served, one after a delisting is gone
+	w = get("/stock/META?start=2022-06-01&end=2022-06-30")
+	body := struct{ Span stock.Span }{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); w.Code != http.StatusOK || err != nil || len(body.Span) == 0 || body.Span[0].Time.After(time.Date(2022, time.June, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected META's span to reach back before the change, got %d %v", w.Code, err)
+	}
+	for _, path := range []string{"/stock/GONE?start=2015-07-01&end=2015-07-31", "/stock/GONE/chart.svg?start=2015-07-01&end=2015-07-31"} {
+		if w := get(path); w.Code != http.StatusGone {
+			t.Errorf("Expected %d for %s, got %d", http.StatusGone, path, w.Code)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package testhelpers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/jhurwich/trendy/stock"
+)
+
+// Cassettes record real provider exchanges to fixture files and replay them
+// through the provider's http client, so regression cases for new symbols or
+// providers are a recording rather than hand written responses.
+type CassetteMode int
+
+const (
+	Replay CassetteMode = iota // serve saved exchanges, fail on anything not saved
+	Record                     // pass requests to the provider and save the exchanges
+)
+
+// set TRENDY_CASSETTES=record to re-record cassettes against the real providers
+const cassetteModeEnv = "TRENDY_CASSETTES"
+
+// directory cassettes are saved in, relative to the test's package
+const CassetteDir = "testdata/cassettes"
+
+// Interaction is one saved request and its response
+type Interaction struct {
+	Method      string
+	URL         string
+	Status      int
+	ContentType string `json:",omitempty"`
+	Body        string
+}
+
+// Cassette is an http.RoundTripper that records or replays Interactions
+type Cassette struct {
+	Path         string
+	Mode         CassetteMode
+	Interactions []Interaction
+	Transport    http.RoundTripper // used to reach the provider when recording
+
+	mu sync.Mutex
+}
+
+// CassetteModeFromEnv is Record if TRENDY_CASSETTES=record, otherwise Replay
+func CassetteModeFromEnv() CassetteMode {
+	if os.Getenv(cassetteModeEnv) == "record" {
+		return Record
+	}
+	return Replay
+}
+
+// LoadCassette reads the cassette at path for replay, or starts an empty one to record
+func LoadCassette(path string, mode CassetteMode) (*Cassette, error) {
+	c := &Cassette{Path: path, Mode: mode, Transport: http.DefaultTransport}
+	if mode == Record {
+		return c, nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, &c.Interactions)
+	return c, err
+}
+
+func (c *Cassette) RoundTrip(req *http.Request) (*http.Response, error) {
+	if c.Mode == Replay {
+		return c.replay(req)
+	}
+
+	r, err := c.Transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.Interactions = append(c.Interactions, Interaction{
+		Method:      req.Method,
+		URL:         req.URL.String(),
+		Status:      r.StatusCode,
+		ContentType: r.Header.Get("Content-Type"),
+		Body:        string(body),
+	})
+	c.mu.Unlock()
+
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return r, nil
+}
+
+func (c *Cassette) replay(req *http.Request) (*http.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, interaction := range c.Interactions {
+		// compare parsed urls so that hand edited cassettes needn't match escaping
+		u, err := url.Parse(interaction.URL)
+		if err != nil || interaction.Method != req.Method || u.String() != req.URL.String() {
+			continue
+		}
+		r := &http.Response{
+			Status:        fmt.Sprintf("%d %s", interaction.Status, http.StatusText(interaction.Status)),
+			StatusCode:    interaction.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        http.Header{},
+			Body:          ioutil.NopCloser(bytes.NewBufferString(interaction.Body)),
+			ContentLength: int64(len(interaction.Body)),
+			Request:       req,
+		}
+		if interaction.ContentType != "" {
+			r.Header.Set("Content-Type", interaction.ContentType)
+		}
+		return r, nil
+	}
+	return nil, fmt.Errorf("No interaction in cassette %s for %s %s, record it with %s=record", c.Path, req.Method, req.URL, cassetteModeEnv)
+}
+
+// Save writes the recorded interactions, it does nothing when replaying
+func (c *Cassette) Save() error {
+	if c.Mode != Record {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, err := json.MarshalIndent(c.Interactions, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.Path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(c.Path, b, 0644)
+}
+
+// UseCassette routes requests through stock.MarkitClient to the named cassette
+// in CassetteDir, in the mode from the environment. The returned function
+// restores the client and saves any recording, defer it. Tests using cassettes
+// must not run in parallel since the client is shared.
+func UseCassette(name string, t *testing.T) func() {
+	c, err := LoadCassette(filepath.Join(CassetteDir, name+".json"), CassetteModeFromEnv())
+	if err != nil {
+		t.Fatalf("Could not load cassette %s: %v", name, err)
+	}
+
+	client := stock.MarkitClient.HTTPClient
+	original := client.Transport
+	if original != nil {
+		c.Transport = original
+	}
+	client.Transport = c
+
+	return func() {
+		client.Transport = original
+		if err := c.Save(); err != nil {
+			t.Errorf("Could not save cassette %s: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2015 Jordan Hurwich - no license granted
+
+package testhelpers_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jhurwich/trendy/testhelpers"
+)
+
+// record exchanges with a provider, save them, then replay them with the provider gone
+func TestCassetteRecordReplay(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "cassettes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "nested", "provider.json")
+
+	var requests int
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Query().Get("symbol") == "MISSING" {
+			http.Error(w, "no such symbol", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"symbol": "` + r.URL.Query().Get("symbol") + `"}`))
+	}))
+
+	get := func(c *testhelpers.Cassette, symbol string) (*http.Response, string, error) {
+		r, err := (&http.Client{Transport: c}).Get(provider.URL + "/chart?symbol=" + symbol)
+		if err != nil {
+			return nil, "", err
+		}
+		defer r.Body.Close()
+		body, err := ioutil.ReadAll(r.Body)
+		return r, string(body), err
+	}
+
+	// recording passes requests to the provider and hands back its responses
+	recorder, err := testhelpers.LoadCassette(path, testhelpers.Record)
+	if err != nil {
+		t.Fatalf("Could not start recording: %v", err)
+	}
+	expected := map[string]string{}
+	for _, symbol := range []string{"GOOG", "MISSING"} {
+		r, body, err := get(recorder, symbol)
+		if err != nil {
+			t.Fatalf("Could not record %s: %v", symbol, err)
+		}
+		expected[symbol] = body
+		if symbol == "GOOG" && (r.StatusCode != http.StatusOK || body != `{"symbol": "GOOG"}`) {
+			t.Errorf("Expected the provider's response while recording, got %d %s", r.StatusCode, body)
+		}
+	}
+	if requests != 2 || len(recorder.Interactions) != 2 {
+		t.Fatalf("Expected 2 requests recorded, got %d requests and %+v", requests, recorder.Interactions)
+	}
+	if err := recorder.Save(); err != nil {
+		t.Fatalf("Could not save cassette: %v", err)
+	}
+	provider.Close()
+
+	// replaying serves the saved responses without reaching the provider
+	player, err := testhelpers.LoadCassette(path, testhelpers.Replay)
+	if err != nil {
+		t.Fatalf("Could not load the saved cassette: %v", err)
+	}
+	r, body, err := get(player, "GOOG")
+	if err != nil || r.StatusCode != http.StatusOK || body != expected["GOOG"] || r.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("Expected the recorded response replayed, got %v %q %v", r, body, err)
+	}
+	r, body, err = get(player, "MISSING")
+	if err != nil || r.StatusCode != http.StatusNotFound || body != expected["MISSING"] {
+		t.Errorf("Expected the recorded failure replayed, got %v %q %v", r, body, err)
+	}
+	if _, _, err := get(player, "MSFT"); err == nil || !strings.Contains(err.Error(), "record it with") {
+		t.Errorf("Expected an error for a request that wasn't recorded, got %v", err)
+	}
+	if requests != 2 {
+		t.Errorf("Expected no requests to the provider while replaying, got %d", requests-2)
+	}
+
+	// saving while replaying leaves the cassette alone
+	if err := player.Save(); err != nil {
+		t.Errorf("Unexpected error saving a replayed cassette: %v", err)
+	}
+}